@@ -0,0 +1,213 @@
+// Package authtest provides a fake data store and helpers for testing handlers
+// protected by the middlewares of package auth.
+package authtest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/codegangsta/negroni"
+	"golang.org/x/crypto/bcrypt"
+
+	auth "github.com/nabeken/negroni-auth"
+)
+
+// FakeStore is an in-memory data store whose lookups can be made to fail or to be slow.
+// Passwords are hashed with the minimal bcrypt cost so tests stay fast.
+// This struct implement Datastore, ErrDatastore, Lister and Counted interface of package
+// datastore and is safe for concurrent use.
+type FakeStore struct {
+	mu       sync.Mutex
+	hashes   map[string][]byte
+	err      error
+	userErrs map[string]error
+	latency  time.Duration
+	calls    map[string]int
+}
+
+// NewFakeStore returns *FakeStore holding the userids and passwords of creds.
+func NewFakeStore(creds map[string]string) *FakeStore {
+	s := &FakeStore{
+		hashes:   make(map[string][]byte, len(creds)),
+		userErrs: make(map[string]error),
+		calls:    make(map[string]int),
+	}
+	for userId, password := range creds {
+		s.Add(userId, password)
+	}
+	return s
+}
+
+// FakeStore.Add adds userId with password, replacing its password if it exists.
+func (s *FakeStore) Add(userId, password string) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		panic("authtest: " + err.Error())
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hashes[userId] = hashedPassword
+}
+
+// FakeStore.Remove removes userId.
+func (s *FakeStore) Remove(userId string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.hashes, userId)
+}
+
+// FakeStore.SetErr makes all lookups fail with err, e.g. to test the http.StatusServiceUnavailable
+// of an outage. A nil err restores them.
+func (s *FakeStore) SetErr(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+// FakeStore.SetUserErr makes the lookups of userId fail with err. A nil err restores them.
+func (s *FakeStore) SetUserErr(userId string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil {
+		delete(s.userErrs, userId)
+		return
+	}
+	s.userErrs[userId] = err
+}
+
+// FakeStore.SetLatency delays all lookups by d, unless their context is done first,
+// e.g. to test Options.AuthTimeout.
+func (s *FakeStore) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = d
+}
+
+// FakeStore.Calls returns the number of lookups of userId so far.
+func (s *FakeStore) Calls(userId string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[userId]
+}
+
+// FakeStore.TotalCalls returns the number of lookups so far, of any userid.
+func (s *FakeStore) TotalCalls() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	total := 0
+	for _, n := range s.calls {
+		total += n
+	}
+	return total
+}
+
+// FakeStore.Get returns the hashed password of key. Failing lookups aren't found.
+func (s *FakeStore) Get(key string) ([]byte, bool) {
+	value, found, err := s.GetContext(context.Background(), key)
+	return value, found && err == nil
+}
+
+// FakeStore.GetContext returns the hashed password of key after the latency, or the
+// programmed error.
+func (s *FakeStore) GetContext(ctx context.Context, key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	s.calls[key]++
+	latency := s.latency
+	s.mu.Unlock()
+
+	if latency > 0 {
+		timer := time.NewTimer(latency)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, false, s.err
+	}
+	if err := s.userErrs[key]; err != nil {
+		return nil, false, err
+	}
+	value, found := s.hashes[key]
+	return value, found, nil
+}
+
+// FakeStore.UserIds returns the sorted userids.
+func (s *FakeStore) UserIds() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	userIds := make([]string, 0, len(s.hashes))
+	for userId := range s.hashes {
+		userIds = append(userIds, userId)
+	}
+	sort.Strings(userIds)
+	return userIds
+}
+
+// FakeStore.Len returns the number of userids.
+func (s *FakeStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.hashes)
+}
+
+// NewRequest returns a request to target with the Basic credential of userId and password.
+func NewRequest(method, target, userId, password string) *http.Request {
+	req := httptest.NewRequest(method, target, nil)
+	req.SetBasicAuth(userId, password)
+	return req
+}
+
+// Result is the outcome of a request served by Serve.
+type Result struct {
+	*httptest.ResponseRecorder
+	// Called is true if the middleware called the next handler.
+	Called bool
+	// UserId is the userid in the context of the request passed to the next handler.
+	UserId string
+}
+
+// Serve serves req with mw in front of a handler recording the authenticated userid.
+func Serve(mw negroni.Handler, req *http.Request) *Result {
+	r := &Result{ResponseRecorder: httptest.NewRecorder()}
+	mw.ServeHTTP(r.ResponseRecorder, req, func(w http.ResponseWriter, req *http.Request) {
+		r.Called = true
+		r.UserId, _ = auth.UserIdFromContext(req.Context())
+	})
+	return r
+}
+
+// Result.AssertAuthenticated fails t unless the request reached the next handler as userId.
+func (r *Result) AssertAuthenticated(t testing.TB, userId string) {
+	t.Helper()
+	if !r.Called {
+		t.Errorf("Expected %q to be authenticated, got %d", userId, r.Code)
+		return
+	}
+	if r.UserId != userId {
+		t.Errorf("Expected %q to be authenticated, got %q", userId, r.UserId)
+	}
+}
+
+// Result.AssertRejected fails t unless the request was rejected with code, e.g.
+// http.StatusUnauthorized for wrong credentials or http.StatusServiceUnavailable for an outage.
+func (r *Result) AssertRejected(t testing.TB, code int) {
+	t.Helper()
+	if r.Called {
+		t.Errorf("Expected a rejection with %d, got authenticated %q", code, r.UserId)
+		return
+	}
+	if r.Code != code {
+		t.Errorf("Expected a rejection with %d, got %d", code, r.Code)
+	}
+}
@@ -0,0 +1,78 @@
+package authtest
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	auth "github.com/nabeken/negroni-auth"
+	"github.com/nabeken/negroni-auth/datastore"
+)
+
+var (
+	_ datastore.Datastore    = (*FakeStore)(nil)
+	_ datastore.ErrDatastore = (*FakeStore)(nil)
+	_ datastore.Lister       = (*FakeStore)(nil)
+	_ datastore.Counted      = (*FakeStore)(nil)
+)
+
+func Test_FakeStore(t *testing.T) {
+	store := NewFakeStore(map[string]string{"foo": "bar"})
+	mw := auth.NewBasicWithOptions(store, auth.Options{Logger: discardLogger{}})
+
+	var faketests = []struct {
+		userId   string
+		password string
+		storeErr error
+		userErr  error
+		code     int
+	}{
+		{"foo", "bar", nil, nil, http.StatusOK},
+		{"foo", "baz", nil, nil, http.StatusUnauthorized},
+		{"qux", "bar", nil, nil, http.StatusUnauthorized},
+		{"foo", "bar", errors.New("unavailable"), nil, http.StatusServiceUnavailable},
+		{"foo", "bar", nil, errors.New("unavailable"), http.StatusServiceUnavailable},
+	}
+	for _, tt := range faketests {
+		store.SetErr(tt.storeErr)
+		store.SetUserErr(tt.userId, tt.userErr)
+		r := Serve(mw, NewRequest("GET", "/", tt.userId, tt.password))
+		if tt.code == http.StatusOK {
+			r.AssertAuthenticated(t, tt.userId)
+		} else {
+			r.AssertRejected(t, tt.code)
+		}
+	}
+	store.SetErr(nil)
+	store.SetUserErr("foo", nil)
+
+	if n := store.Calls("foo"); n != 4 {
+		t.Errorf("Expected 4 lookups of foo, got %d", n)
+	}
+	if n := store.TotalCalls(); n != 5 {
+		t.Errorf("Expected 5 lookups, got %d", n)
+	}
+
+	store.Add("qux", "quux")
+	store.Remove("foo")
+	if got := store.UserIds(); len(got) != 1 || got[0] != "qux" || store.Len() != 1 {
+		t.Errorf("Expected [qux], got %q", got)
+	}
+}
+
+func Test_FakeStoreLatency(t *testing.T) {
+	store := NewFakeStore(map[string]string{"foo": "bar"})
+	store.SetLatency(time.Second)
+	mw := auth.NewBasicWithOptions(store, auth.Options{AuthTimeout: 10 * time.Millisecond, Logger: discardLogger{}})
+
+	start := time.Now()
+	Serve(mw, NewRequest("GET", "/", "foo", "bar")).AssertRejected(t, http.StatusServiceUnavailable)
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected the lookup to give up with the context, took %v", elapsed)
+	}
+}
+
+type discardLogger struct{}
+
+func (discardLogger) Printf(format string, v ...interface{}) {}
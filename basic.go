@@ -2,26 +2,55 @@
 package auth
 
 import (
+	"context"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/codegangsta/negroni"
-	"github.com/pmylund/go-cache"
 	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/text/unicode/norm"
 
 	"github.com/nabeken/negroni-auth/datastore"
 )
 
 const (
-	defaultCacheExpireTime = 10 * time.Minute
-	defaultCachePurseTime  = 60 * time.Second
-	bcryptCost             = 12
+	bcryptCost   = 12
+	defaultRealm = "Authorization Required"
+
+	// maxShadowVerifications bounds the shadow verifications running in the background.
+	maxShadowVerifications = 4
 )
 
 // NewSimpleBasic returns *datastore.Simple built from userid, password.
+// The password may contain colons; a userid containing one can never authenticate.
 func NewSimpleBasic(userId, password string) (*datastore.Simple, error) {
+	return NewSimpleBasicWithPolicy(userId, password, nil)
+}
+
+// NewSimpleBasicWithPolicy returns *datastore.Simple built from userid, password.
+// Returns an error if password doesn't meet policy. A nil policy accepts any password.
+func NewSimpleBasicWithPolicy(userId, password string, policy *PasswordPolicy) (*datastore.Simple, error) {
+	if policy != nil {
+		if err := policy.Check(password); err != nil {
+			return nil, err
+		}
+	}
+
 	hashedPassword, err := Hash(password)
 	if err != nil {
 		return nil, err
@@ -33,34 +62,321 @@ func NewSimpleBasic(userId, password string) (*datastore.Simple, error) {
 	}, nil
 }
 
+// NewSimpleBasicFromFiles returns *datastore.Simple built from the userid and the password
+// read from files, e.g. secrets projected by a secret manager. The password file holds
+// either the plaintext password or its bcrypt hash. A trailing newline is ignored.
+func NewSimpleBasicFromFiles(userIdFile, passwordFile string) (*datastore.Simple, error) {
+	return NewSimpleBasicFromFilesWithPolicy(userIdFile, passwordFile, nil)
+}
+
+// NewSimpleBasicFromFilesWithPolicy is like NewSimpleBasicFromFiles but returns an error if
+// a plaintext password doesn't meet policy. A bcrypt hash can't be checked and is used as is.
+// A nil policy accepts any password.
+func NewSimpleBasicFromFilesWithPolicy(userIdFile, passwordFile string, policy *PasswordPolicy) (*datastore.Simple, error) {
+	userId, err := os.ReadFile(userIdFile)
+	if err != nil {
+		return nil, fmt.Errorf("auth: reading userid file: %w", err)
+	}
+	password, err := os.ReadFile(passwordFile)
+	if err != nil {
+		return nil, fmt.Errorf("auth: reading password file: %w", err)
+	}
+
+	id := strings.TrimSpace(string(userId))
+	if id == "" {
+		return nil, fmt.Errorf("auth: userid file %s is empty", userIdFile)
+	}
+	pw := strings.TrimRight(string(password), "\r\n")
+	if pw == "" {
+		return nil, fmt.Errorf("auth: password file %s is empty", passwordFile)
+	}
+
+	// Use a bcrypt hash as is.
+	if _, err := bcrypt.Cost([]byte(pw)); err == nil {
+		return &datastore.Simple{
+			Key:   id,
+			Value: []byte(pw),
+		}, nil
+	}
+
+	return NewSimpleBasicWithPolicy(id, pw, policy)
+}
+
+// NewMultiSimpleBasic returns *datastore.Map built from pairs of userid and password,
+// hashing each password. Returns an error naming the first invalid userid (in sorted order)
+// if a userid is empty or contains a colon, or a password is empty.
+// Hashing takes a while per user, so this suits a handful of users; see NewMapStoreParallel.
+func NewMultiSimpleBasic(pairs map[string]string) (*datastore.Map, error) {
+	return NewMapStoreParallel(pairs, 1)
+}
+
+// NewMapStoreParallel is like NewMultiSimpleBasic but hashes up to concurrency passwords
+// at a time, to speed up building large stores. A non-positive concurrency uses GOMAXPROCS.
+// If hashing fails, the error names the failed userid; the first one in sorted order is reported.
+func NewMapStoreParallel(creds map[string]string, concurrency int) (*datastore.Map, error) {
+	return NewMapStoreWithCost(creds, nil, concurrency)
+}
+
+// NewMapStoreWithCost is like NewMapStoreParallel but hashes the password of each userid with
+// the bcrypt cost returned by costFor, e.g. a high cost for people and a lower one for
+// high-QPS service accounts; a non-positive cost or a nil costFor uses the default of 12.
+// Verification takes the cost of the stored hash, so nothing else needs configuring, except
+// that unknown userids should be verified at the highest cost: set Options.DummyCost to it so
+// their timing doesn't tell which class a userid would belong to.
+func NewMapStoreWithCost(creds map[string]string, costFor func(userId string) int, concurrency int) (*datastore.Map, error) {
+	return NewMapStoreWithOptions(creds, MapStoreOptions{CostFor: costFor, Concurrency: concurrency})
+}
+
+// MapStoreOptions configures NewMapStoreWithOptions.
+type MapStoreOptions struct {
+	// CostFor returns the bcrypt cost of the password of userId; 12 if nil or non-positive.
+	CostFor func(userId string) int
+	// Concurrency is the number of passwords hashed at a time; GOMAXPROCS if non-positive.
+	Concurrency int
+	// Policy, if set, is the policy every password must meet.
+	Policy *PasswordPolicy
+}
+
+// NewMapStoreWithOptions returns *datastore.Map built from pairs of userid and password as
+// NewMapStoreWithCost does, configured by opts. Returns an error naming the first userid
+// (in sorted order) whose password doesn't meet opts.Policy.
+func NewMapStoreWithOptions(creds map[string]string, opts MapStoreOptions) (*datastore.Map, error) {
+	if len(creds) == 0 {
+		return nil, errors.New("auth: no users")
+	}
+
+	userIds := make([]string, 0, len(creds))
+	for userId := range creds {
+		userIds = append(userIds, userId)
+	}
+	sort.Strings(userIds)
+
+	for _, userId := range userIds {
+		switch {
+		case userId == "":
+			return nil, errors.New("auth: empty userid")
+		case strings.Contains(userId, ":"):
+			return nil, fmt.Errorf("auth: userid %q contains a colon", userId)
+		case creds[userId] == "":
+			return nil, fmt.Errorf("auth: empty password for userid %q", userId)
+		}
+		if opts.Policy != nil {
+			if err := opts.Policy.Check(creds[userId]); err != nil {
+				return nil, fmt.Errorf("auth: userid %q: %w", userId, err)
+			}
+		}
+	}
+
+	values, err := hashPasswords(creds, userIds, func(userId string) int {
+		if opts.CostFor != nil {
+			if cost := opts.CostFor(userId); cost > 0 {
+				return cost
+			}
+		}
+		return bcryptCost
+	}, opts.Concurrency)
+	if err != nil {
+		return nil, err
+	}
+	return datastore.NewMap(values), nil
+}
+
+// hashPasswords returns the bcrypt hashes with costFor of the passwords of userIds in creds,
+// hashing up to concurrency passwords at a time. If hashing fails, the error names the
+// first failed userid in userIds.
+func hashPasswords(creds map[string]string, userIds []string, costFor func(userId string) int, concurrency int) (map[string][]byte, error) {
+	if concurrency <= 0 {
+		concurrency = runtime.GOMAXPROCS(0)
+	}
+	if concurrency > len(userIds) {
+		concurrency = len(userIds)
+	}
+
+	// Each worker writes only the slots of the indexes it receives.
+	hashes := make([][]byte, len(userIds))
+	errs := make([]error, len(userIds))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				hashes[i], errs[i] = bcrypt.GenerateFromPassword([]byte(creds[userIds[i]]), costFor(userIds[i]))
+			}
+		}()
+	}
+	for i := range userIds {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	values := make(map[string][]byte, len(userIds))
+	for i, userId := range userIds {
+		if errs[i] != nil {
+			return nil, fmt.Errorf("auth: hashing password of userid %q: %w", userId, errs[i])
+		}
+		values[userId] = hashes[i]
+	}
+	return values, nil
+}
+
 // requireAuth writes error to client which initiates the authentication process
 // or requires reauthentication.
-func requireAuth(w http.ResponseWriter) {
-	w.Header().Set("WWW-Authenticate", "Basic realm=\"Authorization Required\"")
+// If the response has already been written by an upstream handler, the challenge
+// is not sent since the status and headers can no longer be changed.
+// If loginURL is set, it's sent in the X-Login-URL header and the login_url field of a JSON body.
+// If emptyBody is true, the response has no body.
+func requireAuth(w http.ResponseWriter, req *http.Request, kind ChallengeKind, realm, loginURL string, logger Logger, emptyBody bool) {
+	if r, ok := w.(negroni.ResponseWriter); ok && r.Written() {
+		logContext(logger, req.Context(), "negroni-auth: response already written (status %d), skipping authentication challenge", r.Status())
+		return
+	}
+	if loginURL != "" {
+		w.Header().Set("X-Login-URL", loginURL)
+	}
+
+	switch kind {
+	case ChallengeJSON:
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(http.StatusUnauthorized)
+		if loginURL != "" {
+			u, _ := json.Marshal(loginURL)
+			io.WriteString(w, `{"error":"unauthorized","message":"Not Authorized","login_url":`+string(u)+"}\n")
+			return
+		}
+		io.WriteString(w, `{"error":"unauthorized","message":"Not Authorized"}`+"\n")
+		return
+	case ChallengeNone:
+		emptyBody = true
+	default:
+		w.Header().Set("WWW-Authenticate", challenge("Basic", "realm", realm))
+	}
+	if emptyBody {
+		// Drop headers set for a body by upstream handlers.
+		w.Header().Del("Content-Type")
+		w.Header().Set("Content-Length", "0")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
 	http.Error(w, "Not Authorized", http.StatusUnauthorized)
 }
 
+// ChallengeKind is the kind of response to a request which failed authentication.
+type ChallengeKind int
+
+const (
+	// ChallengeBrowser sends the WWW-Authenticate header, which makes browsers prompt for credentials.
+	ChallengeBrowser ChallengeKind = iota
+	// ChallengeJSON sends a JSON error body without WWW-Authenticate, e.g. for XHR clients which
+	// must not trigger the browser prompt.
+	ChallengeJSON
+	// ChallengeNone sends neither WWW-Authenticate nor a body.
+	ChallengeNone
+)
+
+// SPAChallengeDecision is a ChallengeDecision answering XHR and fetch requests of single-page
+// apps, i.e. those with "X-Requested-With: XMLHttpRequest" or accepting JSON but not HTML,
+// with ChallengeJSON, so they can route to Options.LoginURL instead of triggering the browser
+// prompt. Other requests get ChallengeBrowser.
+func SPAChallengeDecision(req *http.Request) ChallengeKind {
+	if req.Header.Get("X-Requested-With") == "XMLHttpRequest" {
+		return ChallengeJSON
+	}
+	accept := req.Header.Get("Accept")
+	if strings.Contains(accept, "application/json") && !strings.Contains(accept, "text/html") {
+		return ChallengeJSON
+	}
+	return ChallengeBrowser
+}
+
+// denied returns true if a failure status (4xx or 5xx) has already been written to w, e.g. a 403
+// or 407 by an upstream handler, so the request must not reach the next handler.
+func denied(w http.ResponseWriter) bool {
+	r, ok := w.(negroni.ResponseWriter)
+	return ok && r.Written() && r.Status() >= http.StatusBadRequest
+}
+
+// Reason describes why no credential could be extracted from a request.
+type Reason int
+
+const (
+	// ReasonNone means a credential was extracted.
+	ReasonNone Reason = iota
+	// ReasonMissing means the request has no Authorization header.
+	ReasonMissing
+	// ReasonUnsupportedScheme means the Authorization header uses another scheme.
+	ReasonUnsupportedScheme
+	// ReasonMalformed means the credential could not be decoded.
+	ReasonMalformed
+)
+
+func (r Reason) String() string {
+	switch r {
+	case ReasonNone:
+		return "none"
+	case ReasonMissing:
+		return "missing"
+	case ReasonUnsupportedScheme:
+		return "unsupported-scheme"
+	case ReasonMalformed:
+		return "malformed"
+	}
+	return "unknown"
+}
+
+// maxAuthorizationLength bounds the Authorization header we are willing to decode.
+const maxAuthorizationLength = 8 << 10
+
 // getCred get userid, password from request.
-func getCred(req *http.Request) (string, string) {
+// Returns empty userid, password and the reason if no valid credential is found.
+// As in RFC 7617, the first colon delimits the userid, so passwords may contain colons
+// but userids can't.
+func getCred(req *http.Request) (string, string, Reason) {
+	header, present := authorization(req, "Basic")
+	if !present {
+		return "", "", ReasonMissing
+	}
+	if header == "" {
+		return "", "", ReasonUnsupportedScheme
+	}
+
 	// Split authorization header.
-	s := strings.SplitN(req.Header.Get("Authorization"), " ", 2)
-	if len(s) != 2 || s[0] != "Basic" {
-		return "", ""
+	_, credentials := splitAuthorization(header)
+	if credentials == "" || len(credentials) > maxAuthorizationLength {
+		return "", "", ReasonMalformed
 	}
 
 	// Decode credential.
-	cred, err := base64.StdEncoding.DecodeString(s[1])
+	cred, err := base64.StdEncoding.DecodeString(credentials)
 	if err != nil {
-		return "", ""
+		return "", "", ReasonMalformed
 	}
 
 	// Split credential into userid, password.
 	pair := strings.SplitN(string(cred), ":", 2)
-	if len(pair) != 2 {
-		return "", ""
+	if len(pair) != 2 || pair[0] == "" {
+		return "", "", ReasonMalformed
+	}
+
+	// RFC 7617 doesn't allow control characters in the credential.
+	if strings.IndexFunc(string(cred), isControl) >= 0 {
+		return "", "", ReasonMalformed
 	}
 
-	return pair[0], pair[1]
+	return pair[0], pair[1], ReasonNone
+}
+
+func isControl(r rune) bool {
+	return r < 0x20 || r == 0x7f
+}
+
+// SHA256PreHash returns the hex encoded SHA-256 of password, for use as Options.PreHash.
+func SHA256PreHash(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
 }
 
 // Hash returns a hashed password.
@@ -68,40 +384,602 @@ func Hash(password string) ([]byte, error) {
 	return bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
 }
 
-// NewBasic returns a negroni.HandlerFunc that authenticates via Basic auth using data store.
-// Writes a http.StatusUnauthorized if authentication fails.
-func NewBasic(datastore datastore.Datastore) negroni.HandlerFunc {
-	return func(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
-		// Extract userid, password from request.
-		userId, password := getCred(req)
+// HashPassword returns the bcrypt hash of password with cost, e.g. to put in a config file or
+// a data store. Returns an error if password is empty or cost is out of bcrypt's range.
+// Unlike bcrypt.GenerateFromPassword, a too low cost is rejected rather than raised silently.
+func HashPassword(password string, cost int) (string, error) {
+	if password == "" {
+		return "", errors.New("auth: empty password")
+	}
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		return "", fmt.Errorf("auth: bcrypt cost must be between %d and %d, got %d", bcrypt.MinCost, bcrypt.MaxCost, cost)
+	}
 
-		if userId == "" {
-			requireAuth(w)
-			return
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashedPassword), nil
+}
+
+// NewBasic returns a negroni.HandlerFunc that authenticates via Basic auth using data store
+// configured by opts. Writes a http.StatusUnauthorized if authentication fails.
+// It panics if an option is invalid; use NewBasicValidated to get an error instead.
+func NewBasic(datastore datastore.Datastore, opts ...Option) negroni.HandlerFunc {
+	o, err := Options{}.apply(opts)
+	if err != nil {
+		panic(err)
+	}
+	return NewBasicWithOptions(datastore, o)
+}
+
+// NewBasicWithOptions returns a negroni.HandlerFunc that authenticates via Basic auth using data store
+// configured by opts. Writes a http.StatusUnauthorized if authentication fails, or a
+// http.StatusServiceUnavailable if the data store or the password verifier fails.
+// The data store may be nil if opts.Verifier doesn't need stored hashes.
+// It panics if opts are invalid, e.g. opts.SessionCookie has a key shorter than 32 bytes.
+func NewBasicWithOptions(datastore datastore.Datastore, opts Options) negroni.HandlerFunc {
+	return newBasicAuth(datastore, opts).ServeHTTP
+}
+
+// errUnauthenticated is returned by basicAuth.authenticate when the request has no
+// valid credential or the userid is unknown.
+var errUnauthenticated = errors.New("auth: unauthenticated")
+
+// errMalformed is returned by basicAuth.authenticate when the Basic credential of the
+// request can't be decoded. It wraps errUnauthenticated.
+var errMalformed = fmt.Errorf("auth: malformed credential: %w", errUnauthenticated)
+
+// errUnsupportedScheme is returned by basicAuth.authenticate when the Authorization header of
+// the request uses another scheme than Basic. It wraps errUnauthenticated.
+var errUnsupportedScheme = fmt.Errorf("auth: unsupported authorization scheme: %w", errUnauthenticated)
+
+// basicAuth authenticates requests via Basic auth using data store.
+type basicAuth struct {
+	shadowDropped uint64 // first for 64-bit alignment of atomic operations
+
+	datastore datastore.Datastore
+	verifier  PasswordVerifier
+	opts      Options
+	limiter   *rateLimiter
+	lockout   *lockout
+	logins    *loginQueue
+	slots     *verifySlots
+	failures  *failureCache
+	shadows   chan struct{}
+
+	// Unknown userids are verified against a dummy hash so they take as long as known ones.
+	// Its cost is the most common cost among the stored bcrypt hashes verified so far, or
+	// dummyCost before any was seen. In a store mixing costs, e.g. during a migration, users
+	// whose cost differs from the most common one remain distinguishable by timing.
+	dummyCost   int
+	costMu      sync.Mutex
+	costCounts  map[int]int
+	dummyHashes map[int][]byte
+}
+
+// newBasicAuth returns *basicAuth configured by opts. It panics if opts are invalid; see Options.validate.
+func newBasicAuth(datastore datastore.Datastore, opts Options) *basicAuth {
+	if err := opts.validate(); err != nil {
+		panic(err)
+	}
+	var verifier = opts.Verifier
+	if verifier == nil {
+		verifier = BcryptVerifier{}
+	}
+	if emptyStore(datastore) {
+		opts.logger().Printf("negroni-auth: data store has no users, every request will be rejected")
+	}
+
+	return &basicAuth{
+		datastore:   datastore,
+		verifier:    verifier,
+		opts:        opts,
+		limiter:     newRateLimiter(),
+		lockout:     opts.lockout(),
+		logins:      newLoginQueue(opts.LoginRecorder, opts.logger()),
+		slots:       newVerifySlots(opts.MaxConcurrentVerifications, opts.VerificationWait),
+		failures:    newFailureCache(opts.FailureCacheTTL),
+		shadows:     make(chan struct{}, maxShadowVerifications),
+		dummyCost:   opts.cost(),
+		costCounts:  make(map[int]int),
+		dummyHashes: make(map[int][]byte),
+	}
+}
+
+// Close stops the background work of a, i.e. the recording of logins.
+func (a *basicAuth) Close() error {
+	a.logins.Close()
+	return nil
+}
+
+// dummy returns a hash to verify against when the userid is unknown.
+// The hash of a new cost is generated without holding costMu, so it doesn't hold up the others.
+func (a *basicAuth) dummy(ctx context.Context) []byte {
+	a.costMu.Lock()
+	cost, overridden := a.opts.targetCost(ctx)
+	switch {
+	case overridden:
+	case a.opts.DummyCost > 0:
+		cost = a.opts.DummyCost
+	default:
+		cost = a.dummyCost
+		seen := 0
+		for c, n := range a.costCounts {
+			if n > seen || (n == seen && c > cost) {
+				cost, seen = c, n
+			}
+		}
+	}
+
+	hash, found := a.dummyHashes[cost]
+	a.costMu.Unlock()
+	if found {
+		return hash
+	}
+
+	hash, _ = bcrypt.GenerateFromPassword([]byte("negroni-auth dummy password"), cost)
+	a.costMu.Lock()
+	defer a.costMu.Unlock()
+	// Another request may have generated one meanwhile; keep a single hash per cost.
+	if existing, found := a.dummyHashes[cost]; found {
+		return existing
+	}
+	a.dummyHashes[cost] = hash
+	return hash
+}
+
+// observeCost records the cost of hashedPassword if it's a bcrypt hash.
+func (a *basicAuth) observeCost(hashedPassword []byte) {
+	cost, err := bcrypt.Cost(hashedPassword)
+	if err != nil {
+		return
+	}
+	a.costMu.Lock()
+	a.costCounts[cost]++
+	a.costMu.Unlock()
+}
+
+// authenticate checks the credential of req and returns the userid and the matched hashed password.
+// Returns errUnauthenticated or ErrPasswordMismatch if authentication fails, or another error
+// if the data store or the password verifier fails or opts.AuthTimeout is exceeded.
+func (a *basicAuth) authenticate(req *http.Request) (userId string, hashedPassword []byte, err error) {
+	ctx, span := a.opts.startSpan(req.Context(), SpanAuthenticate)
+	span.SetAttribute(AttrScheme, "basic")
+	defer func() {
+		span.SetAttribute(AttrOutcome, outcome(err))
+		span.End()
+	}()
+
+	if a.opts.AuthTimeout <= 0 {
+		return a.authenticateContext(ctx, req)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, a.opts.AuthTimeout)
+	defer cancel()
+
+	type result struct {
+		userId         string
+		hashedPassword []byte
+		err            error
+	}
+	done := make(chan result, 1)
+	// bcrypt can't be interrupted; its goroutine finishes in the background after a timeout.
+	go func() {
+		userId, hashedPassword, err := a.authenticateContext(ctx, req)
+		done <- result{userId, hashedPassword, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.userId, r.hashedPassword, r.err
+	case <-ctx.Done():
+		return "", nil, fmt.Errorf("auth: authentication timed out: %w", ctx.Err())
+	}
+}
+
+func (a *basicAuth) authenticateContext(ctx context.Context, req *http.Request) (string, []byte, error) {
+	// Extract userid, password from request.
+	userId, password, reason := getCred(req)
+
+	if reason == ReasonMalformed {
+		return "", nil, errMalformed
+	}
+	if reason == ReasonUnsupportedScheme {
+		return "", nil, errUnsupportedScheme
+	}
+	if a.opts.NormalizeUnicode {
+		if !utf8.ValidString(userId) || !utf8.ValidString(password) {
+			return "", nil, errMalformed
+		}
+		userId, password = norm.NFC.String(userId), norm.NFC.String(password)
+	}
+	if userId == "" {
+		return "", nil, errUnauthenticated
+	}
+
+	// Locked out userids and IPs fail without verification.
+	tenant := a.opts.tenant(req)
+	if a.lockout.locked(req, tenantUserId(tenant, userId)) {
+		return "", nil, errUnauthenticated
+	}
+
+	// Errors of the data store and the verifier must not leak the credential.
+	header, _ := authorization(req, "Basic")
+	_, credentials := splitAuthorization(header)
+	secrets := []string{header, credentials, password}
+
+	// A credential which failed recently fails again without lookup nor verification.
+	var failureKey [sha256.Size]byte
+	if a.failures != nil {
+		failureKey = a.failures.key(a.datastore, tenant, userId, header)
+		if a.failures.failed(failureKey) {
+			return "", nil, errUnauthenticated
 		}
+	}
 
-		// Extract hashed passwor from credentials.
-		hashedPassword, found := datastore.Get(userId)
+	// Extract hashed passwor from credentials.
+	var hashedPassword []byte
+	if a.datastore != nil {
+		var found bool
+		var err error
+		hashedPassword, found, err = a.lookup(ctx, tenant, userId)
+		if err != nil {
+			return "", nil, mask(err, secrets...)
+		}
 		if !found {
-			requireAuth(w)
-			return
+			// Spend the same time as for a known userid so that
+			// userids can't be enumerated by timing.
+			if err := a.slots.acquire(ctx); err != nil {
+				return "", nil, err
+			}
+			a.verify(ctx, tenantUserId(tenant, userId), a.dummy(ctx), []byte(password))
+			a.slots.release()
+			a.shadow(ctx, tenant, userId, password, false)
+			a.lockout.fail(req, tenantUserId(tenant, userId))
+			a.failures.fail(failureKey)
+			return "", nil, errUnauthenticated
 		}
+		if hashedPassword, err = a.opts.decodeHash(hashedPassword); err != nil {
+			return "", nil, mask(err, secrets...)
+		}
+		a.observeCost(hashedPassword)
+	}
+
+	rawPassword := password
+	if a.opts.PreHash != nil {
+		password = a.opts.PreHash(password)
+		secrets = append(secrets, password)
+	}
+
+	// Check if the password is correct.
+	if err := a.slots.acquire(ctx); err != nil {
+		return "", nil, err
+	}
+	start := time.Now()
+	identity, err := a.verify(ctx, tenantUserId(tenant, userId), hashedPassword, []byte(password))
+	a.slots.release()
+	if elapsed := time.Since(start); a.opts.SlowThreshold > 0 && elapsed > a.opts.SlowThreshold {
+		a.opts.logf(ctx, "negroni-auth: slow authentication of %q took %v", userId, elapsed)
+	}
+	if err != nil {
+		if !errors.Is(err, ErrPasswordMismatch) {
+			return "", nil, mask(fmt.Errorf("auth: password verifier error: %w", err), secrets...)
+		}
+		a.shadow(ctx, tenant, userId, rawPassword, false)
+		a.lockout.fail(req, tenantUserId(tenant, userId))
+		a.failures.fail(failureKey)
+		return "", nil, mask(err, secrets...)
+	}
 
-		// Check if the password is correct.
-		err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
-		// Password not correct. Fail.
+	a.shadow(ctx, tenant, userId, rawPassword, true)
+	a.lockout.reset(tenantUserId(tenant, userId))
+
+	// A single-use credential is only valid for the request consuming it.
+	if ds, ok := a.datastore.(datastore.Consumable); ok && !ds.Consume(tenantUserId(tenant, userId), hashedPassword) {
+		return "", nil, errUnauthenticated
+	}
+	if identity != "" {
+		userId = identity
+	}
+	return userId, hashedPassword, nil
+}
+
+// verify checks password, returning the identity resolved by an IdentityVerifier if any.
+func (a *basicAuth) verify(ctx context.Context, userId string, hashedPassword, password []byte) (identity string, err error) {
+	ctx, span := a.opts.startSpan(ctx, SpanVerify)
+	defer func() {
+		span.SetAttribute(AttrOutcome, outcome(err))
+		span.End()
+	}()
+
+	if v, ok := a.verifier.(IdentityVerifier); ok {
+		return v.VerifyIdentity(ctx, userId, hashedPassword, password)
+	}
+	return "", a.verifier.Verify(ctx, userId, hashedPassword, password)
+}
+
+// shadow verifies password against the shadow store of the data store, if any, in the
+// background and reports whether the decision differs from authOK. reqCtx is the context
+// of the request, which is only used for logging since the request may be done first.
+// At most maxShadowVerifications run at once; the comparison is dropped when none is free,
+// so a slow shadow store can't pile up goroutines.
+func (a *basicAuth) shadow(reqCtx context.Context, tenant, userId, password string, authOK bool) {
+	ds, ok := a.datastore.(datastore.Shadowed)
+	if !ok {
+		return
+	}
+	select {
+	case a.shadows <- struct{}{}:
+	default:
+		a.dropShadow(reqCtx)
+		return
+	}
+
+	go func() {
+		defer func() { <-a.shadows }()
+		defer func() {
+			if r := recover(); r != nil {
+				a.logPanic(reqCtx, "shadow store")
+			}
+		}()
+
+		// The request may be done before the shadow verification is.
+		ctx := context.Background()
+		if a.opts.AuthTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, a.opts.AuthTimeout)
+			defer cancel()
+		}
+
+		key := tenantUserId(tenant, userId)
+		shadowOK := false
+		if hashedPassword, found, err := ds.ShadowGetContext(ctx, key); err == nil && found {
+			if hashedPassword, err = a.opts.decodeHash(hashedPassword); err == nil {
+				if a.opts.PreHash != nil {
+					password = a.opts.PreHash(password)
+				}
+				shadowOK = a.verifier.Verify(ctx, key, hashedPassword, []byte(password)) == nil
+			}
+		}
+		if shadowOK != authOK {
+			ds.Mismatch(key, authOK, shadowOK)
+		}
+	}()
+}
+
+// dropShadow logs the first and then every 1000th shadow verification dropped.
+func (a *basicAuth) dropShadow(ctx context.Context) {
+	if n := atomic.AddUint64(&a.shadowDropped, 1); n == 1 || n%1000 == 0 {
+		a.opts.logf(ctx, "negroni-auth: shadow store too slow, dropped %d shadow verifications so far", n)
+	}
+}
+
+// lookup returns the hashed password of userId within tenant.
+// A panicking data store fails the lookup rather than the request or the process.
+func (a *basicAuth) lookup(ctx context.Context, tenant, userId string) (hashedPassword []byte, found bool, err error) {
+	ctx, span := a.opts.startSpan(ctx, SpanLookup)
+	defer func() {
+		switch {
+		case err != nil:
+			span.SetAttribute(AttrOutcome, "error")
+		case found:
+			span.SetAttribute(AttrOutcome, "found")
+		default:
+			span.SetAttribute(AttrOutcome, "not-found")
+		}
+		span.End()
+	}()
+	defer func() {
+		if r := recover(); r != nil {
+			a.logPanic(ctx, "data store")
+			hashedPassword, found, err = nil, false, fmt.Errorf("auth: data store panicked: %v", r)
+		}
+	}()
+
+	// The common single-user store skips the checks for optional interfaces.
+	if ds, ok := a.datastore.(*datastore.Simple); ok && tenant == "" {
+		hashedPassword, found = ds.Get(userId)
+		return hashedPassword, found, nil
+	}
+
+	if tenant != "" {
+		if ds, ok := a.datastore.(datastore.TenantDatastore); ok {
+			hashedPassword, found = ds.GetTenant(tenant, userId)
+			return hashedPassword, found, nil
+		}
+	}
+
+	key := tenantUserId(tenant, userId)
+	if ds, ok := a.datastore.(datastore.ErrDatastore); ok {
+		hashedPassword, found, err = ds.GetContext(ctx, key)
 		if err != nil {
-			requireAuth(w)
+			return nil, false, fmt.Errorf("auth: data store error: %w", err)
+		}
+		return hashedPassword, found, nil
+	}
+
+	hashedPassword, found = a.datastore.Get(key)
+	return hashedPassword, found, nil
+}
+
+// logPanic logs the stack of a recovered panic of component. The stack holds no argument values,
+// so it never leaks the credential; the panic value is logged, masked, with the resulting error.
+func (a *basicAuth) logPanic(ctx context.Context, component string) {
+	a.opts.logf(ctx, "negroni-auth: %s panicked:\n%s", component, debug.Stack())
+}
+
+// tenantUserId returns the key of userId within tenant for stores which aren't tenant-aware.
+func tenantUserId(tenant, userId string) string {
+	if tenant == "" {
+		return userId
+	}
+	return tenant + ":" + userId
+}
+
+// cookieUserId returns the userid of a valid session cookie on a request without credential.
+func (a *basicAuth) cookieUserId(req *http.Request) (string, bool) {
+	if a.opts.SessionCookie == nil || req.Header.Get("Authorization") != "" {
+		return "", false
+	}
+	return a.opts.SessionCookie.userId(req, a.opts.tenant(req))
+}
+
+// proceed returns true if the authenticated userId may reach next handler, i.e. the request
+// hasn't already been denied and the rate limit of userId isn't exhausted.
+func (a *basicAuth) proceed(w http.ResponseWriter, req *http.Request, userId string) bool {
+	if denied(w) {
+		a.opts.logf(req.Context(), "negroni-auth: response already written with a failure status, not calling next handler")
+		return false
+	}
+	return !a.rateLimited(w, req, userId)
+}
+
+// succeed calls next handler for the authenticated userId, issuing its session cookie, unless
+// proceed refuses it.
+func (a *basicAuth) succeed(w http.ResponseWriter, req *http.Request, userId string, next http.HandlerFunc) {
+	if !a.proceed(w, req, userId) {
+		return
+	}
+	if a.opts.SessionCookie != nil {
+		a.opts.SessionCookie.set(w, userId, a.opts.tenant(req))
+	}
+	a.logins.record(req, tenantUserId(a.opts.tenant(req), userId))
+	a.admit(w, req, userId, next)
+}
+
+// resume calls next handler for userId of a valid session cookie unless proceed refuses it.
+// The cookie isn't issued again, so it expires MaxAge after the credential was checked.
+func (a *basicAuth) resume(w http.ResponseWriter, req *http.Request, userId string, next http.HandlerFunc) {
+	if a.proceed(w, req, userId) {
+		a.admit(w, req, userId, next)
+	}
+}
+
+// admit calls next handler with the request of the authenticated userId, carrying its scopes
+// if the data store grants any, unless Options.Authorize denies it.
+func (a *basicAuth) admit(w http.ResponseWriter, req *http.Request, userId string, next http.HandlerFunc) {
+	if ds, ok := a.datastore.(datastore.Scoped); ok {
+		req = withScopes(req, ds.Scopes(tenantUserId(a.opts.tenant(req), userId)))
+	}
+	req = a.opts.authenticated(w, req, userId)
+	if a.opts.Authorize != nil && !a.opts.Authorize(req, userId) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if UpgradeRecommended(req.Context()) {
+		w.Header().Set("X-Auth-Advisory", "password-upgrade-recommended")
+	}
+	next(w, req)
+}
+
+// advise returns req marked for the advisory of Options.UpgradeAdvisory if hashedPassword is weak.
+func (a *basicAuth) advise(req *http.Request, hashedPassword []byte) (*http.Request, bool) {
+	if !a.opts.UpgradeAdvisory || !a.opts.weakHash(req.Context(), hashedPassword) {
+		return req, false
+	}
+	return withUpgradeRecommended(req), true
+}
+
+// fail writes the response for err returned by authenticate.
+func (a *basicAuth) fail(w http.ResponseWriter, req *http.Request, err error) {
+	rejectUpgrade(w, req)
+
+	// A client bug rather than wrong credentials, if so configured.
+	if err == errMalformed && a.opts.MalformedStatus != 0 && a.opts.MalformedStatus != http.StatusUnauthorized {
+		http.Error(w, http.StatusText(a.opts.MalformedStatus), a.opts.MalformedStatus)
+		return
+	}
+
+	// Password not correct. Fail.
+	if errors.Is(err, errUnauthenticated) || errors.Is(err, ErrPasswordMismatch) {
+		if a.opts.EmptyStoreReason && emptyStore(a.datastore) {
+			w.Header().Set("X-Auth-Reason", "no-users-configured")
+		}
+		if err == errUnsupportedScheme && a.opts.UnsupportedSchemeReason {
+			// Whatever the challenge kind, tell the client which scheme to fall back to.
+			w.Header().Set("X-Auth-Reason", ReasonUnsupportedScheme.String())
+			w.Header().Set("WWW-Authenticate", challenge("Basic", "realm", a.opts.realm(req)))
+		}
+		a.opts.failureDelay(req)
+		if a.opts.UnauthorizedHandler != nil {
+			a.opts.unauthorized(w, req)
 			return
 		}
+		requireAuth(w, req, a.opts.challengeKind(req), a.opts.realm(req), a.opts.LoginURL, a.opts.logger(), a.opts.EmptyUnauthorizedBody)
+		return
+	}
 
-		r := w.(negroni.ResponseWriter)
+	// Shed load without logging every request.
+	if errors.Is(err, ErrOverloaded) {
+		serviceUnavailable(w, a.opts.RetryAfter)
+		return
+	}
+
+	// Data store or verifier failed. The error must not leak the credential.
+	a.opts.logf(req.Context(), "negroni-auth: authentication backend error: %v", err)
+	serviceUnavailable(w, a.opts.RetryAfter)
+}
 
-		// Password correct.
-		if r.Status() != http.StatusUnauthorized {
-			next(w, req)
+// basicAuth.ServeHTTP implements negroni.Handler.
+func (a *basicAuth) ServeHTTP(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+	if a.opts.AllowPreflight && isPreflight(req) {
+		next(w, req)
+		return
+	}
+	if a.opts.insecure(w, req) || a.opts.Lockdown.reject(w, req, a.opts.RetryAfter) {
+		return
+	}
+	a.opts.vary(w)
+
+	// A valid session cookie substitutes for the credential.
+	if userId, ok := a.cookieUserId(req); ok {
+		a.resume(w, req, userId, next)
+		return
+	}
+
+	userId, hashedPassword, err := a.authenticate(req)
+	if err != nil {
+		a.fail(w, req, err)
+		return
+	}
+
+	// Password correct.
+	req, _ = a.advise(req, hashedPassword)
+	a.succeed(w, req, userId, next)
+}
+
+// ErrNoUsers is returned by NewBasicValidated for a datastore.Counted data store without users.
+var ErrNoUsers = errors.New("auth: data store has no users")
+
+// emptyStore returns true if ds is known to hold no users. The other constructors only log a
+// warning for such a store, since it may be filled later.
+func emptyStore(ds datastore.Datastore) bool {
+	c, ok := ds.(datastore.Counted)
+	return ok && c.Len() == 0
+}
+
+// NewBasicValidated returns a negroni.HandlerFunc like NewBasic after validating opts and the
+// data store if it implements datastore.Validatable, so misconfiguration is reported at construction.
+// It returns ErrNoUsers for a data store implementing datastore.Counted without users.
+func NewBasicValidated(ds datastore.Datastore, opts ...Option) (negroni.HandlerFunc, error) {
+	o, err := Options{}.apply(opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	if emptyStore(ds) {
+		return nil, ErrNoUsers
+	}
+	if v, ok := ds.(datastore.Validatable); ok {
+		if err := v.Validate(); err != nil {
+			return nil, err
 		}
 	}
+
+	return NewBasicWithOptions(ds, o), nil
 }
 
 // Basic returns a negroni.HandlerFunc that authenticates via Basic Auth.
@@ -114,36 +992,3 @@ func Basic(userid, password string) negroni.HandlerFunc {
 
 	return NewBasic(datastore)
 }
-
-// CacheBasic returns a negroni.HandlerFunc that authenticates via Basic auth using cache.
-// Writes a http.StatusUnauthorized if authentication fails.
-func CacheBasic(datastore datastore.Datastore, cacheExpireTime, cachePurseTime time.Duration) negroni.HandlerFunc {
-	var basic = NewBasic(datastore)
-	var c = cache.New(cacheExpireTime, cachePurseTime)
-
-	return func(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
-		// Get credential from request header.
-		credential := req.Header.Get("Authorization")
-		// Get authentication status by credential.
-		authenticated, found := c.Get(credential)
-
-		// Cache hit
-		if found && (authenticated == "true") {
-			next(w, req)
-		} else { // Cache miss. Unauthenticated.
-			basic(w, req, next)
-			r := w.(negroni.ResponseWriter)
-
-			// Password correct.
-			if r.Status() != http.StatusUnauthorized {
-				c.Set(credential, "true", cache.DefaultExpiration)
-			}
-		}
-	}
-}
-
-// CacheBasicDefault returns a negroni.HandlerFunc that authenticates via Basic auth using cache.
-// with default cache configuration. Writes a http.StatusUnauthorized if authentication fails.
-func CacheBasicDefault(datastore datastore.Datastore) negroni.HandlerFunc {
-	return CacheBasic(datastore, defaultCacheExpireTime, defaultCachePurseTime)
-}
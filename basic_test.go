@@ -1,13 +1,26 @@
 package auth
 
 import (
+	"bufio"
+	"context"
 	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/codegangsta/negroni"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/nabeken/negroni-auth/datastore"
 )
 
 func Test_BasicAuth(t *testing.T) {
@@ -67,9 +80,9 @@ func Test_CacheBasic(t *testing.T) {
 		res.Write([]byte("hello"))
 	})
 	cacheExpireTime := 50 * time.Microsecond
-	cachePurseTime := 20 * time.Microsecond
+	cachePurgeTime := 20 * time.Microsecond
 	m := negroni.New()
-	m.Use(CacheBasic(dataStore, cacheExpireTime, cachePurseTime))
+	m.Use(CacheBasic(dataStore, cacheExpireTime, cachePurgeTime))
 	m.UseHandler(h)
 
 	// Test request fails without credential.
@@ -160,3 +173,1083 @@ func Test_CacheBasic(t *testing.T) {
 		t.Error("Auth failed, got: ", recorder.Body.String())
 	}
 }
+
+func Test_BasicAuthResponseAlreadyWritten(t *testing.T) {
+	h := http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte("hello"))
+	})
+	m := negroni.New()
+	m.Use(negroni.HandlerFunc(func(res http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+		res.WriteHeader(http.StatusTeapot)
+		next(res, req)
+	}))
+	m.Use(Basic("foo", "bar"))
+	m.UseHandler(h)
+
+	r, _ := http.NewRequest("GET", "foo", nil)
+	recorder := httptest.NewRecorder()
+	m.ServeHTTP(recorder, r)
+
+	if recorder.Code != http.StatusTeapot {
+		t.Error("Response status overwritten, got: ", recorder.Code)
+	}
+
+	if recorder.Header().Get("WWW-Authenticate") != "" {
+		t.Error("Challenge sent after response was written")
+	}
+
+	if recorder.Body.String() != "" {
+		t.Error("Unexpected body, got: ", recorder.Body.String())
+	}
+}
+
+func Test_BasicAuthAlreadyDenied(t *testing.T) {
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("bar"), bcrypt.MinCost)
+	ds := mockMapDataStore{"foo": hashedPassword}
+
+	var deniedtests = []struct {
+		status int
+		body   string
+	}{
+		{http.StatusOK, "hello"},
+		{http.StatusUnauthorized, ""},
+		{http.StatusForbidden, ""},
+		{http.StatusProxyAuthRequired, ""},
+		{http.StatusServiceUnavailable, ""},
+	}
+	for _, tt := range deniedtests {
+		for _, handler := range []negroni.HandlerFunc{NewBasic(ds), CacheBasic(ds, time.Minute, 0)} {
+			m := negroni.New()
+			m.Use(negroni.HandlerFunc(func(res http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+				res.WriteHeader(tt.status)
+				next(res, req)
+			}))
+			m.Use(handler)
+			m.UseHandler(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+				res.Write([]byte("hello"))
+			}))
+
+			// The second request hits the cache.
+			for i := 0; i < 2; i++ {
+				r, _ := http.NewRequest("GET", "foo", nil)
+				r.SetBasicAuth("foo", "bar")
+				recorder := httptest.NewRecorder()
+				m.ServeHTTP(recorder, r)
+
+				if recorder.Body.String() != tt.body {
+					t.Errorf("Expected body %q after status %d, got %q", tt.body, tt.status, recorder.Body.String())
+				}
+			}
+		}
+	}
+}
+
+func Test_CacheBasicCacheTTL(t *testing.T) {
+	auth := "Basic " + base64.StdEncoding.EncodeToString([]byte("foo:bar"))
+	hashedPassword, err := Hash("bar")
+	if err != nil {
+		t.Error("Hashing password failed")
+	}
+	dataStore := &MockDataStore{hashedPassword}
+
+	h := http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte("hello"))
+	})
+	cacheExpireTime := 50 * time.Millisecond
+	cachePurgeTime := 20 * time.Millisecond
+	opts := Options{
+		CacheTTL: func(req *http.Request, userId string) time.Duration {
+			if req.Header.Get("X-Remember-Me") != "" {
+				return time.Hour
+			}
+			return 0
+		},
+		MaxCacheTTL: 10 * cacheExpireTime,
+	}
+	m := negroni.New()
+	m.Use(CacheBasicWithOptions(dataStore, cacheExpireTime, cachePurgeTime, opts))
+	m.UseHandler(h)
+
+	// Cache a successful authentication with the extended TTL.
+	r, _ := http.NewRequest("GET", "foo", nil)
+	r.Header.Set("Authorization", auth)
+	r.Header.Set("X-Remember-Me", "1")
+	recorder := httptest.NewRecorder()
+	m.ServeHTTP(recorder, r)
+
+	if recorder.Code == 401 {
+		t.Error("Response is 401")
+	}
+
+	// Entry outlives the default expire time but not the maximum.
+	dataStore.HashedPassword = nil
+	time.Sleep(2 * cacheExpireTime)
+
+	recorder = httptest.NewRecorder()
+	m.ServeHTTP(recorder, r)
+
+	if recorder.Code == 401 {
+		t.Error("Cache entry expired before the extended TTL")
+	}
+
+	time.Sleep(10 * cacheExpireTime)
+
+	recorder = httptest.NewRecorder()
+	m.ServeHTTP(recorder, r)
+
+	if recorder.Code != 401 {
+		t.Error("Cache entry outlived the maximum TTL")
+	}
+}
+
+func Test_NewBasicValidated(t *testing.T) {
+	if _, err := NewBasicValidated(&datastore.Simple{Key: "foo"}); err == nil {
+		t.Error("Expected invalid data store to be rejected")
+	}
+
+	ds, err := NewSimpleBasic("foo", "bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewBasicValidated(ds); err != nil {
+		t.Error("Expected valid data store to be accepted: ", err)
+	}
+
+	// Data stores without validation are accepted as is.
+	if _, err := NewBasicValidated(&MockDataStore{}); err != nil {
+		t.Error("Expected data store without validation to be accepted: ", err)
+	}
+}
+
+func Test_BasicEmptyStore(t *testing.T) {
+	if _, err := NewBasicValidated(&datastore.Map{}); !errors.Is(err, ErrNoUsers) {
+		t.Errorf("Expected ErrNoUsers, got %v", err)
+	}
+
+	ds, err := NewSimpleBasic("foo", "bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var emptystoretests = []struct {
+		ds     datastore.Datastore
+		warned bool
+		reason string
+	}{
+		{&datastore.Map{}, true, "no-users-configured"},
+		{datastore.NewMap(map[string][]byte{"foo": ds.Value}), false, ""},
+	}
+	for _, tt := range emptystoretests {
+		logger := &recordingLogger{}
+		m := negroni.New(NewBasicWithOptions(tt.ds, Options{Logger: logger, EmptyStoreReason: true}))
+		if warned := len(logger.lines) > 0; warned != tt.warned {
+			t.Errorf("Expected warning %v for %T, got %q", tt.warned, tt.ds, logger.lines)
+		}
+
+		r, _ := http.NewRequest("GET", "foo", nil)
+		r.SetBasicAuth("foo", "baz")
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, r)
+		if reason := recorder.Header().Get("X-Auth-Reason"); recorder.Code != http.StatusUnauthorized || reason != tt.reason {
+			t.Errorf("Expected 401 with reason %q, got %d %q", tt.reason, recorder.Code, reason)
+		}
+	}
+}
+
+var getcredtests = []struct {
+	header   string
+	userId   string
+	password string
+	reason   Reason
+}{
+	{"", "", "", ReasonMissing},
+	{"Bearer foo", "", "", ReasonUnsupportedScheme},
+	{"Basic", "", "", ReasonMalformed},
+	{"Basic \t ", "", "", ReasonMalformed},
+	{"basic " + base64.StdEncoding.EncodeToString([]byte("foo:bar")), "foo", "bar", ReasonNone},
+	{"BASIC\t" + base64.StdEncoding.EncodeToString([]byte("foo:bar")) + "  ", "foo", "bar", ReasonNone},
+	{"  Basic   " + base64.StdEncoding.EncodeToString([]byte("foo:bar")), "foo", "bar", ReasonNone},
+	{"Basicx " + base64.StdEncoding.EncodeToString([]byte("foo:bar")), "", "", ReasonUnsupportedScheme},
+	{"Basic !!!", "", "", ReasonMalformed},
+	{"Basic " + base64.StdEncoding.EncodeToString([]byte("foobar")), "", "", ReasonMalformed},
+	{"Basic " + base64.StdEncoding.EncodeToString([]byte(":bar")), "", "", ReasonMalformed},
+	{"Basic " + base64.StdEncoding.EncodeToString([]byte("fo\x00o:bar")), "", "", ReasonMalformed},
+	{"Basic " + base64.StdEncoding.EncodeToString([]byte("foo:b\nar")), "", "", ReasonMalformed},
+	{"Basic " + base64.StdEncoding.EncodeToString(make([]byte, maxAuthorizationLength)), "", "", ReasonMalformed},
+	{"Basic " + base64.StdEncoding.EncodeToString([]byte("foo:bar")), "foo", "bar", ReasonNone},
+	{"Basic " + base64.StdEncoding.EncodeToString([]byte("foo:")), "foo", "", ReasonNone},
+	{"Basic " + base64.StdEncoding.EncodeToString([]byte("ユーザー:パスワード")), "ユーザー", "パスワード", ReasonNone},
+	// The first colon delimits the userid; the password may contain colons.
+	{"Basic " + base64.StdEncoding.EncodeToString([]byte("foo:p:a:s:s")), "foo", "p:a:s:s", ReasonNone},
+	{"Basic " + base64.StdEncoding.EncodeToString([]byte("foo::")), "foo", ":", ReasonNone},
+	{"Basic " + base64.StdEncoding.EncodeToString([]byte("foo:bar:")), "foo", "bar:", ReasonNone},
+}
+
+func Test_BasicColonPassword(t *testing.T) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte("p:a:s:s"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := negroni.New(NewBasic(mockMapDataStore{"foo": hashedPassword}))
+	var userId string
+	m.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userId, _ = UserIdFromContext(r.Context())
+	}))
+
+	var colontests = []struct {
+		userId   string
+		password string
+		code     int
+	}{
+		{"foo", "p:a:s:s", http.StatusOK},
+		{"foo", "p", http.StatusUnauthorized},
+		// A userid can't contain a colon: this is the same credential as foo, "p:a:s:s".
+		{"foo:p", "a:s:s", http.StatusOK},
+	}
+	for _, tt := range colontests {
+		userId = ""
+		r, _ := http.NewRequest("GET", "foo", nil)
+		r.SetBasicAuth(tt.userId, tt.password)
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, r)
+
+		if recorder.Code != tt.code {
+			t.Errorf("Expected %d for %q, %q, got %d", tt.code, tt.userId, tt.password, recorder.Code)
+		}
+		if tt.code == http.StatusOK && userId != "foo" {
+			t.Errorf("Expected foo to be authenticated for %q, %q, got %q", tt.userId, tt.password, userId)
+		}
+	}
+}
+
+func Test_GetCred(t *testing.T) {
+	for _, tt := range getcredtests {
+		r, _ := http.NewRequest("GET", "foo", nil)
+		r.Header.Set("Authorization", tt.header)
+		userId, password, reason := getCred(r)
+		if userId != tt.userId || password != tt.password || reason != tt.reason {
+			t.Errorf("Expected getCred(%q) to return %q, %q, %v but got %q, %q, %v",
+				tt.header, tt.userId, tt.password, tt.reason, userId, password, reason)
+		}
+	}
+}
+
+func FuzzGetCred(f *testing.F) {
+	for _, tt := range getcredtests {
+		f.Add(tt.header)
+	}
+	f.Add("Basic " + base64.StdEncoding.EncodeToString([]byte("\x00:\x00")))
+	f.Add("Basic =")
+	f.Add("Basic  " + base64.StdEncoding.EncodeToString([]byte("foo:bar")))
+
+	f.Fuzz(func(t *testing.T, header string) {
+		r, _ := http.NewRequest("GET", "foo", nil)
+		r.Header["Authorization"] = []string{header}
+		userId, password, reason := getCred(r)
+
+		if reason != ReasonNone {
+			if userId != "" || password != "" {
+				t.Errorf("Expected empty credential with reason %v, got %q, %q", reason, userId, password)
+			}
+			return
+		}
+		if userId == "" || strings.Contains(userId, ":") {
+			t.Errorf("Invalid userid %q", userId)
+		}
+		if strings.IndexFunc(userId+password, isControl) >= 0 {
+			t.Errorf("Control character in credential %q, %q", userId, password)
+		}
+	})
+}
+
+type mockTenantDataStore map[string]map[string][]byte
+
+func (ds mockTenantDataStore) Get(key string) ([]byte, bool) {
+	return nil, false
+}
+
+func (ds mockTenantDataStore) GetTenant(tenant, key string) ([]byte, bool) {
+	v, found := ds[tenant][key]
+	return v, found
+}
+
+type mockMapDataStore map[string][]byte
+
+func (ds mockMapDataStore) Get(key string) ([]byte, bool) {
+	v, found := ds[key]
+	return v, found
+}
+
+func Test_BasicTenant(t *testing.T) {
+	acme, _ := bcrypt.GenerateFromPassword([]byte("acme-pw"), bcrypt.MinCost)
+	globex, _ := bcrypt.GenerateFromPassword([]byte("globex-pw"), bcrypt.MinCost)
+
+	h := http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		userId, _ := UserIdFromContext(req.Context())
+		tenant, _ := TenantFromContext(req.Context())
+		res.Write([]byte(tenant + "/" + userId))
+	})
+	opts := Options{
+		Tenant: func(req *http.Request) string { return req.Host },
+	}
+
+	var stores = []datastore.Datastore{
+		mockTenantDataStore{"acme": {"foo": acme}, "globex": {"foo": globex}},
+		mockMapDataStore{"acme:foo": acme, "globex:foo": globex},
+	}
+	var tenanttests = []struct {
+		host     string
+		password string
+		code     int
+	}{
+		{"acme", "acme-pw", 200},
+		{"globex", "globex-pw", 200},
+		{"globex", "acme-pw", 401},
+		{"acme", "globex-pw", 401},
+		{"initech", "acme-pw", 401},
+	}
+	for _, ds := range stores {
+		for _, handler := range []negroni.Handler{
+			NewBasicWithOptions(ds, opts),
+			CacheBasicWithOptions(ds, time.Minute, 0, opts),
+		} {
+			m := negroni.New(handler)
+			m.UseHandler(h)
+
+			for _, tt := range tenanttests {
+				r, _ := http.NewRequest("GET", "/", nil)
+				r.Host = tt.host
+				r.SetBasicAuth("foo", tt.password)
+				recorder := httptest.NewRecorder()
+				m.ServeHTTP(recorder, r)
+
+				if recorder.Code != tt.code {
+					t.Errorf("%T: expected %d for %s with %s, got %d", ds, tt.code, tt.host, tt.password, recorder.Code)
+				}
+				if tt.code == 200 && recorder.Body.String() != tt.host+"/foo" {
+					t.Errorf("Expected tenant and userid in context, got %q", recorder.Body.String())
+				}
+			}
+		}
+	}
+}
+
+func Test_BasicPreHash(t *testing.T) {
+	// The stored hash is bcrypt of the pre-hashed password.
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte(SHA256PreHash("bar")), bcrypt.MinCost)
+	ds := &MockDataStore{hashedPassword}
+
+	var prehashtests = []struct {
+		preHash  func(string) string
+		password string
+		code     int
+	}{
+		// Server applies the pre-hash.
+		{SHA256PreHash, "bar", 200},
+		{SHA256PreHash, SHA256PreHash("bar"), 401},
+		// Client applies the pre-hash.
+		{nil, SHA256PreHash("bar"), 200},
+		{nil, "bar", 401},
+	}
+	for _, tt := range prehashtests {
+		m := negroni.New(NewBasicWithOptions(ds, Options{PreHash: tt.preHash}))
+
+		r, _ := http.NewRequest("GET", "foo", nil)
+		r.SetBasicAuth("foo", tt.password)
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, r)
+
+		if recorder.Code != tt.code {
+			t.Errorf("Expected %d for %q (server pre-hash %v), got %d", tt.code, tt.password, tt.preHash != nil, recorder.Code)
+		}
+	}
+
+	if SHA256PreHash("bar") != "fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04fae5511b68fbf8fb9" {
+		t.Error("Unexpected SHA256PreHash: ", SHA256PreHash("bar"))
+	}
+}
+
+// mockErrDataStore is a datastore.ErrDatastore returning HashedPassword after Delay,
+// or Err if set.
+type mockErrDataStore struct {
+	HashedPassword []byte
+	Delay          time.Duration
+	Err            error
+	cancelled      chan struct{}
+}
+
+func (ds *mockErrDataStore) Get(key string) ([]byte, bool) {
+	panic("Get called on an ErrDatastore")
+}
+
+func (ds *mockErrDataStore) GetContext(ctx context.Context, key string) ([]byte, bool, error) {
+	select {
+	case <-time.After(ds.Delay):
+	case <-ctx.Done():
+		if ds.cancelled != nil {
+			close(ds.cancelled)
+		}
+		return nil, false, ctx.Err()
+	}
+	if ds.Err != nil {
+		return nil, false, ds.Err
+	}
+	return ds.HashedPassword, true, nil
+}
+
+func Test_BasicErrDatastore(t *testing.T) {
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("bar"), bcrypt.MinCost)
+
+	var errdatastoretests = []struct {
+		ds   *mockErrDataStore
+		code int
+	}{
+		{&mockErrDataStore{HashedPassword: hashedPassword}, 200},
+		{&mockErrDataStore{Err: errors.New("connection refused")}, 503},
+	}
+	for _, tt := range errdatastoretests {
+		m := negroni.New(NewBasic(tt.ds))
+
+		r, _ := http.NewRequest("GET", "foo", nil)
+		r.SetBasicAuth("foo", "bar")
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, r)
+
+		if recorder.Code != tt.code {
+			t.Errorf("Expected %d, got %d", tt.code, recorder.Code)
+		}
+		if tt.code == 503 && recorder.Header().Get("WWW-Authenticate") != "" {
+			t.Error("Expected no challenge on backend error")
+		}
+	}
+}
+
+func Test_BasicFailureHeaders(t *testing.T) {
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("bar"), bcrypt.MinCost)
+
+	var failureheadertests = []struct {
+		ds         *mockErrDataStore
+		retryAfter time.Duration
+		code       int
+		challenge  bool
+		want       string
+	}{
+		{&mockErrDataStore{HashedPassword: hashedPassword}, 0, 401, true, ""},
+		{&mockErrDataStore{Err: errors.New("connection refused")}, 0, 503, false, "5"},
+		{&mockErrDataStore{Err: errors.New("connection refused")}, 1500 * time.Millisecond, 503, false, "2"},
+	}
+	for _, tt := range failureheadertests {
+		m := negroni.New(NewBasicWithOptions(tt.ds, Options{RetryAfter: tt.retryAfter}))
+
+		r, _ := http.NewRequest("GET", "foo", nil)
+		r.SetBasicAuth("foo", "wrong")
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, r)
+
+		if recorder.Code != tt.code {
+			t.Errorf("Expected %d, got %d", tt.code, recorder.Code)
+		}
+		if got := recorder.Header().Get("WWW-Authenticate") != ""; got != tt.challenge {
+			t.Errorf("Expected challenge %v with status %d, got %v", tt.challenge, tt.code, got)
+		}
+		if got := recorder.Header().Get("Retry-After"); got != tt.want {
+			t.Errorf("Expected Retry-After %q with status %d, got %q", tt.want, tt.code, got)
+		}
+	}
+}
+
+// panickingDataStore panics on every lookup.
+type panickingDataStore struct{}
+
+func (panickingDataStore) Get(key string) ([]byte, bool) {
+	var m map[string][]byte
+	m[key] = nil
+	return nil, false
+}
+
+func Test_BasicPanickingDataStore(t *testing.T) {
+	logger := &recordingLogger{}
+	srv := httptest.NewServer(negroni.New(NewBasicWithOptions(panickingDataStore{}, Options{Logger: logger})))
+	defer srv.Close()
+
+	// The server survives and keeps answering.
+	for i := 0; i < 2; i++ {
+		r, _ := http.NewRequest("GET", srv.URL, nil)
+		r.SetBasicAuth("foo", "s3cret-pw")
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("Expected 503 on request %d, got %d", i, resp.StatusCode)
+		}
+	}
+
+	if len(logger.lines) == 0 || !strings.Contains(strings.Join(logger.lines, "\n"), "data store panicked") {
+		t.Errorf("Expected the panic to be logged, got %q", logger.lines)
+	}
+	for _, line := range logger.lines {
+		if strings.Contains(line, "s3cret-pw") {
+			t.Errorf("Log leaked the password: %s", line)
+		}
+	}
+}
+
+// echoVerifier fails with an error echoing its input.
+type echoVerifier struct{}
+
+func (echoVerifier) Verify(ctx context.Context, userId string, hashedPassword, password []byte) error {
+	return fmt.Errorf("cannot verify %s with %s", userId, password)
+}
+
+func Test_BasicMasksCredentials(t *testing.T) {
+	logger := &recordingLogger{}
+	opts := Options{Verifier: echoVerifier{}, Logger: logger}
+	m := negroni.New(NewBasicWithOptions(mockMapDataStore{"foo": []byte("hash")}, opts))
+
+	r, _ := http.NewRequest("GET", "foo", nil)
+	r.SetBasicAuth("foo", "s3cret-pw")
+	authorization := r.Header.Get("Authorization")
+	recorder := httptest.NewRecorder()
+	m.ServeHTTP(recorder, r)
+
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503, got %d", recorder.Code)
+	}
+	if len(logger.lines) == 0 {
+		t.Fatal("Expected the backend error to be logged")
+	}
+	for _, line := range append(logger.lines, recorder.Body.String()) {
+		if strings.Contains(line, "s3cret-pw") || strings.Contains(line, strings.TrimPrefix(authorization, "Basic ")) {
+			t.Errorf("Credential leaked: %s", line)
+		}
+	}
+
+	_, _, err := newBasicAuth(mockMapDataStore{"foo": []byte("hash")}, opts).authenticate(r)
+	if err == nil || strings.Contains(err.Error(), "s3cret-pw") {
+		t.Errorf("Expected a masked error, got %v", err)
+	}
+}
+
+type mockCtxMapDataStore map[string][]byte
+
+func (ds mockCtxMapDataStore) GetContext(ctx context.Context, key string) ([]byte, bool, error) {
+	value, found := ds[key]
+	return value, found, nil
+}
+
+func Test_BasicShadowStore(t *testing.T) {
+	bar, _ := bcrypt.GenerateFromPassword([]byte("bar"), bcrypt.MinCost)
+	baz, _ := bcrypt.GenerateFromPassword([]byte("baz"), bcrypt.MinCost)
+	authoritative := mockCtxMapDataStore{"foo": bar, "old": bar, "same": bar}
+	shadow := mockCtxMapDataStore{"foo": baz, "new": bar, "same": bar}
+
+	type mismatch struct {
+		userId           string
+		authOK, shadowOK bool
+	}
+	mismatches := make(chan mismatch, 1)
+	ds := datastore.NewShadowStore(authoritative, shadow, func(userId string, authOK, shadowOK bool) {
+		mismatches <- mismatch{userId, authOK, shadowOK}
+	})
+	m := negroni.New(NewBasic(ds))
+
+	var shadowtests = []struct {
+		userId   string
+		password string
+		code     int
+		mismatch *mismatch
+	}{
+		{"same", "bar", http.StatusOK, nil},
+		{"foo", "bar", http.StatusOK, &mismatch{"foo", true, false}},
+		{"foo", "baz", http.StatusUnauthorized, &mismatch{"foo", false, true}},
+		{"old", "bar", http.StatusOK, &mismatch{"old", true, false}},
+		{"new", "bar", http.StatusUnauthorized, &mismatch{"new", false, true}},
+		{"unknown", "bar", http.StatusUnauthorized, nil},
+	}
+	for _, tt := range shadowtests {
+		r, _ := http.NewRequest("GET", "foo", nil)
+		r.SetBasicAuth(tt.userId, tt.password)
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, r)
+
+		if recorder.Code != tt.code {
+			t.Errorf("Expected %d for %s:%s, got %d", tt.code, tt.userId, tt.password, recorder.Code)
+		}
+		select {
+		case got := <-mismatches:
+			if tt.mismatch == nil || got != *tt.mismatch {
+				t.Errorf("Expected mismatch %v for %s:%s, got %v", tt.mismatch, tt.userId, tt.password, got)
+			}
+		case <-time.After(100 * time.Millisecond):
+			if tt.mismatch != nil {
+				t.Errorf("Expected mismatch %v for %s:%s", *tt.mismatch, tt.userId, tt.password)
+			}
+		}
+	}
+}
+
+// blockingCtxDataStore is a data store whose lookups block until release is closed.
+type blockingCtxDataStore struct {
+	release chan struct{}
+
+	mu       sync.Mutex
+	inflight int
+	max      int
+}
+
+func (ds *blockingCtxDataStore) GetContext(ctx context.Context, key string) ([]byte, bool, error) {
+	ds.mu.Lock()
+	ds.inflight++
+	if ds.inflight > ds.max {
+		ds.max = ds.inflight
+	}
+	ds.mu.Unlock()
+
+	<-ds.release
+
+	ds.mu.Lock()
+	ds.inflight--
+	ds.mu.Unlock()
+	return nil, false, nil
+}
+
+func Test_BasicShadowStoreBounded(t *testing.T) {
+	bar, _ := bcrypt.GenerateFromPassword([]byte("bar"), bcrypt.MinCost)
+	shadow := &blockingCtxDataStore{release: make(chan struct{})}
+	defer close(shadow.release)
+	ds := datastore.NewShadowStore(mockCtxMapDataStore{"foo": bar}, shadow, func(string, bool, bool) {})
+	logger := &recordingLogger{}
+	m := negroni.New(NewBasicWithOptions(ds, Options{Logger: logger}))
+
+	for i := 0; i < 3*maxShadowVerifications; i++ {
+		r, _ := http.NewRequest("GET", "foo", nil)
+		r.SetBasicAuth("foo", "bar")
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, r)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("Expected 200 while the shadow store blocks, got %d", recorder.Code)
+		}
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		shadow.mu.Lock()
+		inflight, max := shadow.inflight, shadow.max
+		shadow.mu.Unlock()
+		if inflight == maxShadowVerifications || time.Now().After(deadline) {
+			if max > maxShadowVerifications {
+				t.Errorf("Expected at most %d shadow verifications at once, got %d", maxShadowVerifications, max)
+			}
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if len(logger.lines) != 1 || !strings.Contains(logger.lines[0], "dropped 1 shadow verifications") {
+		t.Errorf("Expected the first drop to be logged, got %q", logger.lines)
+	}
+}
+
+func Test_BasicOneTimeStore(t *testing.T) {
+	token, _ := bcrypt.GenerateFromPassword([]byte("t0ken"), bcrypt.MinCost)
+	ds := datastore.NewOneTimeStore()
+
+	for _, handler := range []negroni.Handler{
+		NewBasic(ds),
+		CacheBasic(ds, time.Minute, 0),
+	} {
+		m := negroni.New(handler)
+		ds.Add("foo", token, time.Minute)
+
+		const concurrency = 8
+		codes := make(chan int, concurrency)
+		var wg sync.WaitGroup
+		for i := 0; i < concurrency; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				r, _ := http.NewRequest("GET", "foo", nil)
+				r.SetBasicAuth("foo", "t0ken")
+				recorder := httptest.NewRecorder()
+				m.ServeHTTP(recorder, r)
+				codes <- recorder.Code
+			}()
+		}
+		wg.Wait()
+		close(codes)
+
+		succeeded := 0
+		for code := range codes {
+			if code == http.StatusOK {
+				succeeded++
+			}
+		}
+		if succeeded != 1 {
+			t.Errorf("Expected exactly one success, got %d", succeeded)
+		}
+	}
+}
+
+func Test_BasicAuthTimeout(t *testing.T) {
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("bar"), bcrypt.MinCost)
+	ds := &mockErrDataStore{HashedPassword: hashedPassword, Delay: time.Hour, cancelled: make(chan struct{})}
+	m := negroni.New(NewBasicWithOptions(ds, Options{AuthTimeout: 20 * time.Millisecond}))
+
+	r, _ := http.NewRequest("GET", "foo", nil)
+	r.SetBasicAuth("foo", "bar")
+	recorder := httptest.NewRecorder()
+	start := time.Now()
+	m.ServeHTTP(recorder, r)
+
+	if recorder.Code != 503 {
+		t.Error("Expected 503 on timeout, got: ", recorder.Code)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Error("Authentication not bounded by AuthTimeout: ", elapsed)
+	}
+	select {
+	case <-ds.cancelled:
+	case <-time.After(time.Second):
+		t.Error("Store context not cancelled")
+	}
+}
+
+func Test_HashPassword(t *testing.T) {
+	var hashpasswordtests = []struct {
+		password string
+		cost     int
+		valid    bool
+	}{
+		{"bar", bcrypt.MinCost, true},
+		{"bar", bcrypt.MinCost - 1, false},
+		{"bar", bcrypt.MaxCost + 1, false},
+		{"", bcrypt.MinCost, false},
+	}
+	for _, tt := range hashpasswordtests {
+		hashedPassword, err := HashPassword(tt.password, tt.cost)
+		if (err == nil) != tt.valid {
+			t.Errorf("Expected valid %v for cost %d, got %v", tt.valid, tt.cost, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if cost, _ := bcrypt.Cost([]byte(hashedPassword)); cost != tt.cost {
+			t.Errorf("Expected cost %d, got %d", tt.cost, cost)
+		}
+		if err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(tt.password)); err != nil {
+			t.Error("Hash doesn't match password: ", err)
+		}
+	}
+}
+
+func Test_NewMultiSimpleBasic(t *testing.T) {
+	ds, err := NewMultiSimpleBasic(map[string]string{"foo": "bar", "baz": "qux"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := negroni.New(NewBasic(ds))
+	var multitests = []struct {
+		userId   string
+		password string
+		code     int
+	}{
+		{"foo", "bar", http.StatusOK},
+		{"baz", "qux", http.StatusOK},
+		{"foo", "qux", http.StatusUnauthorized},
+		{"quux", "bar", http.StatusUnauthorized},
+	}
+	for _, tt := range multitests {
+		r, _ := http.NewRequest("GET", "foo", nil)
+		r.SetBasicAuth(tt.userId, tt.password)
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, r)
+
+		if recorder.Code != tt.code {
+			t.Errorf("Expected %d for %s:%s, got %d", tt.code, tt.userId, tt.password, recorder.Code)
+		}
+	}
+
+	for _, pairs := range []map[string]string{
+		nil,
+		{"": "bar"},
+		{"foo:bar": "baz"},
+		{"foo": ""},
+	} {
+		if _, err := NewMultiSimpleBasic(pairs); err == nil {
+			t.Errorf("Expected an error for %v", pairs)
+		}
+	}
+}
+
+func Test_NewMapStoreParallel(t *testing.T) {
+	creds := map[string]string{}
+	for i := 0; i < 4; i++ {
+		creds[fmt.Sprintf("user%d", i)] = fmt.Sprintf("pass%d", i)
+	}
+	ds, err := NewMapStoreParallel(creds, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for userId, password := range creds {
+		hashedPassword, found := ds.Get(userId)
+		if !found || bcrypt.CompareHashAndPassword(hashedPassword, []byte(password)) != nil {
+			t.Errorf("Expected a hash of %s for %s", password, userId)
+		}
+	}
+
+	// Passwords bcrypt can't hash are reported with their userid.
+	long := strings.Repeat("x", 73)
+	_, err = NewMapStoreParallel(map[string]string{"user3": long, "user5": long}, 0)
+	if err == nil || !strings.Contains(err.Error(), `"user3"`) {
+		t.Errorf("Expected an error naming user3, got %v", err)
+	}
+}
+
+func Test_NewMapStoreWithCost(t *testing.T) {
+	costFor := func(userId string) int {
+		if strings.HasPrefix(userId, "svc-") {
+			return bcrypt.MinCost
+		}
+		return bcrypt.MinCost + 1
+	}
+	ds, err := NewMapStoreWithCost(map[string]string{"svc-ci": "pass0", "alice": "pass1"}, costFor, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var costtests = []struct {
+		userId string
+		cost   int
+	}{
+		{"svc-ci", bcrypt.MinCost},
+		{"alice", bcrypt.MinCost + 1},
+	}
+	for _, tt := range costtests {
+		hashedPassword, _ := ds.Get(tt.userId)
+		if cost, err := bcrypt.Cost(hashedPassword); err != nil || cost != tt.cost {
+			t.Errorf("Expected cost %d for %s, got %d, %v", tt.cost, tt.userId, cost, err)
+		}
+	}
+
+	// Unknown userids are verified at DummyCost however many service accounts authenticate.
+	a := newBasicAuth(ds, Options{DummyCost: bcrypt.MinCost + 1})
+	for i := 0; i < 3; i++ {
+		r, _ := http.NewRequest("GET", "foo", nil)
+		r.SetBasicAuth("svc-ci", "pass0")
+		a.authenticate(r)
+	}
+	if cost, _ := bcrypt.Cost(a.dummy(context.Background())); cost != bcrypt.MinCost+1 {
+		t.Errorf("Expected dummy cost %d, got %d", bcrypt.MinCost+1, cost)
+	}
+}
+
+func Test_NewSimpleBasicFromFiles(t *testing.T) {
+	dir := t.TempDir()
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("bar"), bcrypt.MinCost)
+	files := map[string]string{
+		"userid":   "foo\n",
+		"password": "bar\n",
+		"hash":     string(hashedPassword) + "\n",
+		"empty":    "",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, passwordFile := range []string{"password", "hash"} {
+		ds, err := NewSimpleBasicFromFiles(filepath.Join(dir, "userid"), filepath.Join(dir, passwordFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ds.Key != "foo" {
+			t.Errorf("Expected userid foo, got %q", ds.Key)
+		}
+		if err := bcrypt.CompareHashAndPassword(ds.Value, []byte("bar")); err != nil {
+			t.Errorf("Expected hash of bar from %s file: %v", passwordFile, err)
+		}
+	}
+
+	var invalidtests = []struct {
+		userIdFile, passwordFile string
+	}{
+		{"missing", "password"},
+		{"userid", "missing"},
+		{"empty", "password"},
+		{"userid", "empty"},
+	}
+	for _, tt := range invalidtests {
+		if _, err := NewSimpleBasicFromFiles(filepath.Join(dir, tt.userIdFile), filepath.Join(dir, tt.passwordFile)); err == nil {
+			t.Errorf("Expected error for %s, %s", tt.userIdFile, tt.passwordFile)
+		}
+	}
+}
+
+// medianDuration returns the median time of n runs of f.
+func medianDuration(n int, f func()) time.Duration {
+	durations := make([]time.Duration, n)
+	for i := range durations {
+		start := time.Now()
+		f()
+		durations[i] = time.Since(start)
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	return durations[n/2]
+}
+
+func Test_BasicTimingUnknownUserId(t *testing.T) {
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("bar"), bcrypt.MinCost)
+	a := newBasicAuth(mockMapDataStore{"foo": hashedPassword}, Options{})
+	// Keep the test fast; the dummy hash has the same cost as the stored ones.
+	a.dummyCost = bcrypt.MinCost
+
+	authenticate := func(userId string) func() {
+		r, _ := http.NewRequest("GET", "foo", nil)
+		r.SetBasicAuth(userId, "wrong")
+		return func() {
+			if _, _, err := a.authenticate(r); err == nil {
+				t.Fatal("Unexpected authentication")
+			}
+		}
+	}
+	// Warm up the lazily generated dummy hash.
+	authenticate("unknown")()
+
+	const iterations = 200
+	known := medianDuration(iterations, authenticate("foo"))
+	unknown := medianDuration(iterations, authenticate("unknown"))
+
+	// Without the dummy verification an unknown userid returns in microseconds,
+	// orders of magnitude faster than a bcrypt comparison.
+	if unknown < known/2 || unknown > known*2 {
+		t.Errorf("Timing differs between known (%v) and unknown (%v) userids", known, unknown)
+	}
+}
+
+func Test_BasicDummyCost(t *testing.T) {
+	weak, _ := bcrypt.GenerateFromPassword([]byte("bar"), bcrypt.MinCost)
+	strong, _ := bcrypt.GenerateFromPassword([]byte("bar"), bcrypt.MinCost+1)
+	a := newBasicAuth(mockMapDataStore{"weak1": weak, "weak2": weak, "strong": strong}, Options{})
+	a.dummyCost = bcrypt.MinCost + 2
+
+	var dummycosttests = []struct {
+		userId string
+		cost   int
+	}{
+		{"unknown", bcrypt.MinCost + 2},
+		{"strong", bcrypt.MinCost + 1},
+		{"weak1", bcrypt.MinCost + 1},
+		{"weak2", bcrypt.MinCost},
+	}
+	for _, tt := range dummycosttests {
+		r, _ := http.NewRequest("GET", "foo", nil)
+		r.SetBasicAuth(tt.userId, "bar")
+		a.authenticate(r)
+
+		if cost, _ := bcrypt.Cost(a.dummy(context.Background())); cost != tt.cost {
+			t.Errorf("Expected dummy cost %d after %s, got %d", tt.cost, tt.userId, cost)
+		}
+	}
+}
+
+func Test_BasicTargetCostOverride(t *testing.T) {
+	ctx := WithTargetCost(context.Background(), bcrypt.MinCost)
+
+	var overridetests = []struct {
+		allow bool
+		ctx   context.Context
+		cost  int
+	}{
+		{false, ctx, bcrypt.MinCost + 1},
+		{true, ctx, bcrypt.MinCost},
+		{true, context.Background(), bcrypt.MinCost + 1},
+		{true, WithTargetCost(context.Background(), bcrypt.MinCost+3), bcrypt.MinCost + 3},
+		// Invalid costs and costs more than 2 above Cost are ignored.
+		{true, WithTargetCost(context.Background(), 1), bcrypt.MinCost + 1},
+		{true, WithTargetCost(context.Background(), bcrypt.MinCost+4), bcrypt.MinCost + 1},
+		{true, WithTargetCost(context.Background(), bcrypt.MaxCost), bcrypt.MinCost + 1},
+	}
+	for _, tt := range overridetests {
+		a := newBasicAuth(mockMapDataStore{}, Options{Cost: bcrypt.MinCost + 1, AllowCostOverride: tt.allow})
+		if cost, _ := bcrypt.Cost(a.dummy(tt.ctx)); cost != tt.cost {
+			t.Errorf("Expected dummy cost %d with AllowCostOverride %v, got %d", tt.cost, tt.allow, cost)
+		}
+	}
+}
+
+func Test_BasicWebSocketUpgrade(t *testing.T) {
+	m := negroni.New()
+	m.Use(Basic("foo", "bar"))
+	m.UseHandler(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		userId, _ := UserIdFromContext(req.Context())
+		conn, buf, err := res.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error("Hijack failed: ", err)
+			return
+		}
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		buf.WriteString("hello " + userId + "\n")
+		buf.Flush()
+	}))
+	srv := httptest.NewServer(m)
+	defer srv.Close()
+
+	handshake := func(auth bool) (*http.Response, *bufio.Reader) {
+		conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+
+		r, _ := http.NewRequest("GET", srv.URL, nil)
+		r.Header.Set("Connection", "Upgrade")
+		r.Header.Set("Upgrade", "websocket")
+		if auth {
+			r.SetBasicAuth("foo", "bar")
+		}
+		r.Write(conn)
+
+		br := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(br, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, br
+	}
+
+	resp, _ := handshake(false)
+	if resp.StatusCode != 401 || !resp.Close {
+		t.Errorf("Expected 401 closing the connection, got %d (close %v)", resp.StatusCode, resp.Close)
+	}
+
+	resp, br := handshake(true)
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatal("Expected upgrade, got: ", resp.StatusCode)
+	}
+	if line, _ := br.ReadString('\n'); line != "hello foo\n" {
+		t.Errorf("Expected userid on the hijacked connection, got %q", line)
+	}
+}
+
+func Benchmark_SimpleBasicLookup(b *testing.B) {
+	ds, err := NewSimpleBasic("foo", "bar")
+	if err != nil {
+		b.Fatal(err)
+	}
+	a := newBasicAuth(ds, Options{})
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, found, _ := a.lookup(ctx, "", "foo"); !found {
+			b.Fatal("Expected foo to be found")
+		}
+	}
+}
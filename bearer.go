@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/codegangsta/negroni"
+)
+
+var (
+	// ErrInvalidToken is returned by a TokenStore when a token is not valid.
+	ErrInvalidToken = errors.New("auth: invalid token")
+
+	// ErrTokenExpired is returned by a TokenStore when a token has expired.
+	ErrTokenExpired = errors.New("auth: token expired")
+)
+
+// TokenStore is an interface for resolving a bearer token to a userid.
+// Get returns ErrInvalidToken or ErrTokenExpired (possibly wrapped) when the
+// token must be rejected. Any other error is treated as a backend failure.
+type TokenStore interface {
+	Get(ctx context.Context, token string) (userId string, err error)
+}
+
+// requireBearer writes error to client which initiates the bearer token
+// authentication process. err is the RFC 6750 error code of the rejected token
+// and description its fixed, human readable explanation; both are omitted if the
+// request carried no token.
+func requireBearer(w http.ResponseWriter, req *http.Request, opts *Options, err, description string) {
+	if r, ok := w.(negroni.ResponseWriter); ok && r.Written() {
+		opts.logf(req.Context(), "negroni-auth: response already written (status %d), skipping authentication challenge", r.Status())
+		return
+	}
+
+	params := []string{"realm", opts.realm(req)}
+	if err != "" {
+		params = append(params, "error", err, "error_description", description)
+	}
+	w.Header().Set("WWW-Authenticate", challenge("Bearer", params...))
+	http.Error(w, "Not Authorized", http.StatusUnauthorized)
+}
+
+// getBearerToken get token from request. It returns "" if there is no Bearer token.
+func getBearerToken(req *http.Request) string {
+	// Split authorization header.
+	header, _ := authorization(req, "Bearer")
+	_, token := splitAuthorization(header)
+	return token
+}
+
+// NewBearer returns a negroni.HandlerFunc that authenticates via Bearer token using token store.
+// Writes a http.StatusUnauthorized if authentication fails, or a
+// http.StatusServiceUnavailable if the token store fails.
+// Responses are marked with "Vary: Authorization".
+func NewBearer(store TokenStore) negroni.HandlerFunc {
+	return NewBearerWithOptions(store, Options{})
+}
+
+// NewBearerWithOptions returns a negroni.HandlerFunc like NewBearer configured by opts.
+// It uses opts.Realm, opts.RetryAfter and opts.Logger; the options of Basic auth are ignored.
+func NewBearerWithOptions(store TokenStore, opts Options) negroni.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+		addVary(w.Header(), "Authorization")
+
+		// Extract token from request.
+		token := getBearerToken(req)
+		if token == "" {
+			rejectUpgrade(w, req)
+			requireBearer(w, req, &opts, "", "")
+			return
+		}
+
+		userId, err := store.Get(req.Context(), token)
+		switch {
+		case errors.Is(err, ErrTokenExpired):
+			rejectUpgrade(w, req)
+			requireBearer(w, req, &opts, "invalid_token", "The access token expired")
+			return
+		case errors.Is(err, ErrInvalidToken):
+			rejectUpgrade(w, req)
+			requireBearer(w, req, &opts, "invalid_token", "The access token is invalid")
+			return
+		case err != nil:
+			opts.logf(req.Context(), "negroni-auth: token store error: %v", mask(err, token))
+			rejectUpgrade(w, req)
+			serviceUnavailable(w, opts.RetryAfter)
+			return
+		case userId == "":
+			rejectUpgrade(w, req)
+			requireBearer(w, req, &opts, "invalid_token", "The access token is invalid")
+			return
+		}
+
+		pass(w, withUserId(req, userId), opts.logger(), next)
+	}
+}
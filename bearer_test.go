@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codegangsta/negroni"
+)
+
+type MockTokenStore map[string]string
+
+func (ts MockTokenStore) Get(ctx context.Context, token string) (string, error) {
+	switch token {
+	case "expired":
+		return "", ErrTokenExpired
+	case "broken":
+		return "", errors.New("backend down")
+	}
+	userId, found := ts[token]
+	if !found {
+		return "", ErrInvalidToken
+	}
+	return userId, nil
+}
+
+var bearertests = []struct {
+	header    string
+	code      int
+	body      string
+	challenge string
+}{
+	{"", http.StatusUnauthorized, "Not Authorized\n", `Bearer realm="Authorization Required"`},
+	{"Basic Zm9vOmJhcg==", http.StatusUnauthorized, "Not Authorized\n", `Bearer realm="Authorization Required"`},
+	{"Bearer unknown", http.StatusUnauthorized, "Not Authorized\n", `Bearer realm="Authorization Required", error="invalid_token", error_description="The access token is invalid"`},
+	{"Bearer expired", http.StatusUnauthorized, "Not Authorized\n", `Bearer realm="Authorization Required", error="invalid_token", error_description="The access token expired"`},
+	{"Bearer broken", http.StatusServiceUnavailable, "Service Unavailable\n", ""},
+	{"Bearer t0ken", http.StatusOK, "hello foo", ""},
+}
+
+var getbearertokentests = []struct {
+	header string
+	token  string
+}{
+	{"Bearer t0ken", "t0ken"},
+	{"bearer t0ken", "t0ken"},
+	{"BEARER t0ken", "t0ken"},
+	{"Bearer\tt0ken", "t0ken"},
+	{"Bearer  \t t0ken", "t0ken"},
+	{"Bearer t0ken  ", "t0ken"},
+	{" Bearer t0ken\t", "t0ken"},
+	{"Bearer", ""},
+	{"Bearer   ", ""},
+	{"Bearert0ken", ""},
+	{"Basic Zm9vOmJhcg==", ""},
+}
+
+func Test_GetBearerToken(t *testing.T) {
+	for _, tt := range getbearertokentests {
+		r, _ := http.NewRequest("GET", "foo", nil)
+		r.Header.Set("Authorization", tt.header)
+		if got := getBearerToken(r); got != tt.token {
+			t.Errorf("Expected getBearerToken(%q) to return %q, got %q", tt.header, tt.token, got)
+		}
+	}
+}
+
+func Test_Bearer(t *testing.T) {
+	h := http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		userId, _ := UserIdFromContext(req.Context())
+		res.Write([]byte("hello " + userId))
+	})
+	m := negroni.New()
+	m.Use(NewBearer(MockTokenStore{"t0ken": "foo"}))
+	m.UseHandler(h)
+
+	for _, tt := range bearertests {
+		r, _ := http.NewRequest("GET", "foo", nil)
+		if tt.header != "" {
+			r.Header.Set("Authorization", tt.header)
+		}
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, r)
+
+		if recorder.Code != tt.code {
+			t.Errorf("Expected %d for %q, got %d", tt.code, tt.header, recorder.Code)
+		}
+		if recorder.Body.String() != tt.body {
+			t.Errorf("Expected body %q for %q, got %q", tt.body, tt.header, recorder.Body.String())
+		}
+		if got := recorder.Header().Get("WWW-Authenticate"); got != tt.challenge {
+			t.Errorf("Expected challenge %s for %q, got %s", tt.challenge, tt.header, got)
+		}
+		if got := recorder.Header().Get("Retry-After") != ""; got != (tt.code == http.StatusServiceUnavailable) {
+			t.Errorf("Expected Retry-After only on 503 for %q, got %q", tt.header, recorder.Header().Get("Retry-After"))
+		}
+	}
+}
+
+func Test_BearerWithOptions(t *testing.T) {
+	logger := &recordingLogger{}
+	m := negroni.New(NewBearerWithOptions(MockTokenStore{"t0ken": "foo"}, Options{
+		Realm:      func(*http.Request) string { return "api" },
+		RetryAfter: time.Minute,
+		Logger:     logger,
+	}))
+
+	var optionstests = []struct {
+		header     string
+		code       int
+		challenge  string
+		retryAfter string
+	}{
+		{"", http.StatusUnauthorized, `Bearer realm="api"`, ""},
+		{"Bearer unknown", http.StatusUnauthorized, `Bearer realm="api", error="invalid_token", error_description="The access token is invalid"`, ""},
+		{"Bearer broken", http.StatusServiceUnavailable, "", "60"},
+	}
+	for _, tt := range optionstests {
+		r, _ := http.NewRequest("GET", "foo", nil)
+		if tt.header != "" {
+			r.Header.Set("Authorization", tt.header)
+		}
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, r)
+
+		if recorder.Code != tt.code {
+			t.Errorf("Expected %d for %q, got %d", tt.code, tt.header, recorder.Code)
+		}
+		if got := recorder.Header().Get("WWW-Authenticate"); got != tt.challenge {
+			t.Errorf("Expected challenge %s for %q, got %s", tt.challenge, tt.header, got)
+		}
+		if got := recorder.Header().Get("Retry-After"); got != tt.retryAfter {
+			t.Errorf("Expected Retry-After %q for %q, got %q", tt.retryAfter, tt.header, got)
+		}
+	}
+	if len(logger.lines) != 1 || !strings.Contains(logger.lines[0], "token store error") {
+		t.Errorf("Expected the token store error to be logged by Options.Logger, got %q", logger.lines)
+	}
+}
@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
+)
+
+// bloomFilter is a set of SHA-256 hashes which may report false positives but no false negatives.
+// It is not safe for concurrent modification; build it, then only query it.
+type bloomFilter struct {
+	bits []uint64
+	k    uint64
+}
+
+// newBloomFilter returns an empty *bloomFilter sized for n hashes at the false positive rate p.
+func newBloomFilter(n int, p float64) *bloomFilter {
+	if n < 1 {
+		n = 1
+	}
+	m := math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2))
+	k := math.Round(m / float64(n) * math.Ln2)
+	if k < 1 {
+		k = 1
+	}
+	return &bloomFilter{
+		bits: make([]uint64, (uint64(m)+63)/64),
+		k:    uint64(k),
+	}
+}
+
+// add adds h to the filter.
+func (f *bloomFilter) add(h [sha256.Size]byte) {
+	m := uint64(len(f.bits)) * 64
+	h1, h2 := bloomHashes(h)
+	for i := uint64(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % m
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+// mayContain returns false if h was never added.
+func (f *bloomFilter) mayContain(h [sha256.Size]byte) bool {
+	m := uint64(len(f.bits)) * 64
+	h1, h2 := bloomHashes(h)
+	for i := uint64(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % m
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// bloomHashes splits h into the two hashes combined into the k indexes of the filter.
+// h is already uniformly distributed, so no further hashing is needed.
+func bloomHashes(h [sha256.Size]byte) (uint64, uint64) {
+	return binary.LittleEndian.Uint64(h[0:8]), binary.LittleEndian.Uint64(h[8:16]) | 1
+}
@@ -0,0 +1,33 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"strconv"
+	"testing"
+)
+
+func Test_BloomFilter(t *testing.T) {
+	f := newBloomFilter(1000, 0.01)
+	for i := 0; i < 1000; i++ {
+		f.add(sha256.Sum256([]byte(strconv.Itoa(i))))
+	}
+
+	for i := 0; i < 1000; i++ {
+		if !f.mayContain(sha256.Sum256([]byte(strconv.Itoa(i)))) {
+			t.Fatalf("Expected %d to be in the filter", i)
+		}
+	}
+	positives := 0
+	for i := 1000; i < 11000; i++ {
+		if f.mayContain(sha256.Sum256([]byte(strconv.Itoa(i)))) {
+			positives++
+		}
+	}
+	if positives > 300 {
+		t.Errorf("Expected about 1%% false positives, got %d in 10000", positives)
+	}
+
+	if newBloomFilter(0, 0.01).mayContain(sha256.Sum256([]byte("foo"))) {
+		t.Error("Expected an empty filter to contain nothing")
+	}
+}
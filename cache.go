@@ -0,0 +1,366 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/codegangsta/negroni"
+	"github.com/pmylund/go-cache"
+
+	"github.com/nabeken/negroni-auth/datastore"
+)
+
+const (
+	defaultCacheExpireTime = 10 * time.Minute
+	defaultCachePurgeTime  = 60 * time.Second
+)
+
+// validateCacheTimes checks that cached entries expire and are purged no less often than they expire.
+// A cachePurgeTime of 0 disables the background purging.
+func validateCacheTimes(cacheExpireTime, cachePurgeTime time.Duration) error {
+	switch {
+	case cacheExpireTime <= 0:
+		return errors.New("auth: cache expire time must be positive")
+	case cachePurgeTime < 0:
+		return errors.New("auth: cache purge time must not be negative")
+	case cachePurgeTime > cacheExpireTime:
+		return errors.New("auth: cache purge time must not exceed cache expire time")
+	}
+	return nil
+}
+
+// Cache is an interface for the cache of authenticated credentials used by CachedBasic,
+// e.g. backed by Redis or a bounded in-memory cache. Keys are opaque hex digests, an
+// HMAC-SHA256 of the tenant, the version and the credential, so credentials never reach the
+// cache; values tell which userids authenticated recently, so a shared cache must still be trusted.
+// Set stores value for ttl. Get returns false for missing and expired keys.
+type Cache interface {
+	Get(key string) (value interface{}, found bool)
+	Set(key string, value interface{}, ttl time.Duration)
+	Delete(key string)
+}
+
+// CacheEntry is the value CachedBasic stores in its Cache for a successful authentication.
+// A shared cache must return it as stored; values of other types are treated as cache misses.
+type CacheEntry struct {
+	// UserId is the authenticated userid, which may differ from the userid of the credential
+	// if an IdentityVerifier resolved it.
+	UserId string
+	// Expires is when the entry expires, for caches which don't expire entries exactly.
+	Expires time.Time
+	// Version is the version of the userid in a datastore.Versioned data store.
+	Version uint64
+	// UpgradeRecommended is true if the stored hash was weak, for Options.UpgradeAdvisory.
+	UpgradeRecommended bool
+	// Deadline, if set, is when the entry expires however recently it was used. Expires is
+	// then pushed forward by Options.CacheIdleTimeout on each use, up to Deadline.
+	Deadline time.Time
+}
+
+// expirer is an optional interface for caches which must be told to drop expired entries.
+type expirer interface {
+	DeleteExpired()
+}
+
+// CachedBasic is a negroni.Handler that authenticates via Basic auth using cache.
+// Expired cache entries are purged by a background goroutine which runs until Close is called.
+type CachedBasic struct {
+	basic           *basicAuth
+	opts            Options
+	cache           Cache
+	cacheExpireTime time.Duration
+
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewCachedBasic returns *CachedBasic that authenticates via Basic auth using data store
+// configured by opts. Successful authentications are cached for cacheExpireTime, and
+// expired entries are purged every cachePurgeTime until Close is called.
+// cacheExpireTime must be positive. cachePurgeTime must be between 0 and cacheExpireTime;
+// 0 disables purging, so expired entries are only dropped when looked up again.
+// If opts.CacheMaxEntries or opts.CacheMaxBytes is set, the least recently used entries are
+// evicted when the cache is full.
+// If opts.Cache is set, it's used instead of the in-memory cache, and only purged if it has a
+// DeleteExpired method.
+func NewCachedBasic(datastore datastore.Datastore, cacheExpireTime, cachePurgeTime time.Duration, opts Options) (*CachedBasic, error) {
+	if err := validateCacheTimes(cacheExpireTime, cachePurgeTime); err != nil {
+		return nil, err
+	}
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	b := &CachedBasic{
+		basic:           newBasicAuth(datastore, opts),
+		opts:            opts,
+		cacheExpireTime: cacheExpireTime,
+		stop:            make(chan struct{}),
+	}
+
+	switch {
+	case opts.Cache != nil:
+		b.cache = opts.Cache
+	case opts.CacheMaxEntries > 0 || opts.CacheMaxBytes > 0:
+		b.cache = newLRUCache(opts.CacheMaxEntries, opts.CacheMaxBytes, cacheExpireTime)
+	default:
+		// go-cache's own janitor can only be stopped by the garbage collector,
+		// so purge from a goroutine we control instead.
+		b.cache = cache.New(cacheExpireTime, 0)
+	}
+
+	if e, ok := b.cache.(expirer); ok && cachePurgeTime > 0 {
+		go b.purge(e, cachePurgeTime)
+	}
+
+	return b, nil
+}
+
+// purge deletes expired cache entries every interval until b is closed.
+func (b *CachedBasic) purge(e expirer, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			e.DeleteExpired()
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+// CachedBasic.Close stops the background purging and the recording of logins.
+// It implements io.Closer.
+func (b *CachedBasic) Close() error {
+	b.closeOnce.Do(func() {
+		close(b.stop)
+	})
+	return b.basic.Close()
+}
+
+// CachedBasic.ServeHTTP implements negroni.Handler.
+// Writes a http.StatusUnauthorized if authentication fails.
+func (b *CachedBasic) ServeHTTP(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+	if b.opts.bypassCache(req) {
+		b.basic.ServeHTTP(w, req, next)
+		return
+	}
+	if b.opts.AllowPreflight && isPreflight(req) {
+		next(w, req)
+		return
+	}
+	if b.opts.insecure(w, req) || b.opts.Lockdown.reject(w, req, b.opts.RetryAfter) {
+		return
+	}
+	b.opts.vary(w)
+
+	// Get authentication status by credential.
+	// The span ends before the next handler is called, which isn't part of it.
+	ctx, span := b.opts.startSpan(req.Context(), SpanCache)
+	credential, version := b.cacheKey(req)
+	if entry, ok := b.cached(req, credential, version, 0); ok {
+		span.SetAttribute(AttrCache, "hit")
+		span.End()
+		b.touch(credential, entry)
+		b.basic.succeed(w, b.advise(req, entry), entry.UserId, next)
+		return
+	}
+	span.SetAttribute(AttrCache, "miss")
+
+	// Cache miss. Unauthenticated.
+	// A valid session cookie substitutes for the credential and is not cached.
+	if userId, ok := b.basic.cookieUserId(req); ok {
+		span.End()
+		b.basic.resume(w, req, userId, next)
+		return
+	}
+
+	userId, hashedPassword, err := b.basic.authenticate(req.WithContext(ctx))
+	span.End()
+	if err != nil {
+		if entry, ok := b.stale(req, credential, version, err); ok {
+			b.basic.succeed(w, b.advise(req, entry), entry.UserId, next)
+			return
+		}
+		b.basic.fail(w, req, err)
+		return
+	}
+
+	// Password correct.
+	req, upgrade := b.basic.advise(req, hashedPassword)
+	if ttl := b.ttl(req, userId, hashedPassword); ttl >= 0 {
+		if b.opts.CacheMaxLifetime > 0 && ttl > b.opts.CacheMaxLifetime {
+			ttl = b.opts.CacheMaxLifetime
+		}
+		now := time.Now()
+		entry := CacheEntry{UserId: userId, Expires: now.Add(ttl), Version: version, UpgradeRecommended: upgrade}
+		if idle := b.opts.CacheIdleTimeout; idle > 0 {
+			entry.Deadline = entry.Expires
+			if idle < ttl {
+				entry.Expires = now.Add(idle)
+			}
+		}
+		b.cache.Set(credential, entry, ttl+b.staleGrace())
+	}
+	b.basic.succeed(w, req, userId, next)
+}
+
+// cacheKey returns the key of the cached authentication of the credential of req and the
+// version of its userid. Credentials are cached per tenant and, if the data store is
+// versioned, per version of the userid.
+func (b *CachedBasic) cacheKey(req *http.Request) (string, uint64) {
+	credential, _ := authorization(req, "Basic")
+	tenant := b.opts.tenant(req)
+	var version uint64
+	if ds, ok := b.basic.datastore.(datastore.Versioned); ok {
+		if userId, _, reason := getCred(req); reason == ReasonNone {
+			_, version, _ = ds.GetWithVersion(tenantUserId(tenant, userId))
+		}
+	}
+	return cacheKeyDigest(b.opts.CacheKeySecret, tenant, version, credential), version
+}
+
+// processCacheKeySecret keys the cache keys unless Options.CacheKeySecret is set. Being random
+// per process, digests of credentials stored in a shared cache can't be brute-forced offline.
+var processCacheKeySecret = func() []byte {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic("auth: reading random cache key secret: " + err.Error())
+	}
+	return secret
+}()
+
+// cacheKeyDigest returns the hex encoded HMAC-SHA256 of tenant, version and credential keyed
+// with secret, or with processCacheKeySecret if secret is empty.
+func cacheKeyDigest(secret []byte, tenant string, version uint64, credential string) string {
+	if len(secret) == 0 {
+		secret = processCacheKeySecret
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(tenant + "\x00" + strconv.FormatUint(version, 10) + "\x00" + credential))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// cached returns the entry of key for version, if any, unless it expired more than grace ago.
+func (b *CachedBasic) cached(req *http.Request, key string, version uint64, grace time.Duration) (CacheEntry, bool) {
+	value, found := b.cache.Get(key)
+	if !found {
+		return CacheEntry{}, false
+	}
+	entry, ok := value.(CacheEntry)
+	if !ok {
+		// Another writer of a shared cache, or a cache which can't restore the type.
+		b.opts.logf(req.Context(), "negroni-auth: unexpected cache value of type %T, ignoring it", value)
+		return CacheEntry{}, false
+	}
+	if entry.UserId == "" || entry.Version != version || !time.Now().Before(entry.Expires.Add(grace)) {
+		return CacheEntry{}, false
+	}
+	return entry, true
+}
+
+// advise returns req marked for the advisory of Options.UpgradeAdvisory if entry recommends it.
+func (b *CachedBasic) advise(req *http.Request, entry CacheEntry) *http.Request {
+	if !b.opts.UpgradeAdvisory || !entry.UpgradeRecommended {
+		return req
+	}
+	return withUpgradeRecommended(req)
+}
+
+// touch pushes the expiry of the idle timeout of entry, a hit of key, forward, up to its deadline.
+func (b *CachedBasic) touch(key string, entry CacheEntry) {
+	idle := b.opts.CacheIdleTimeout
+	if idle <= 0 || entry.Deadline.IsZero() {
+		return
+	}
+	now := time.Now()
+	expires := now.Add(idle)
+	if expires.After(entry.Deadline) {
+		expires = entry.Deadline
+	}
+	if !expires.After(entry.Expires) {
+		return
+	}
+	entry.Expires = expires
+	b.cache.Set(key, entry, entry.Deadline.Sub(now)+b.staleGrace())
+}
+
+// stale returns the recently expired entry of key for version, if any, when the authentication
+// failed with err because the backend is failing and opts.StaleCacheGrace allows it.
+func (b *CachedBasic) stale(req *http.Request, key string, version uint64, err error) (CacheEntry, bool) {
+	grace := b.staleGrace()
+	if grace == 0 || errors.Is(err, errUnauthenticated) || errors.Is(err, ErrPasswordMismatch) || errors.Is(err, ErrOverloaded) {
+		return CacheEntry{}, false
+	}
+	entry, ok := b.cached(req, key, version, grace)
+	if ok {
+		b.opts.logf(req.Context(), "negroni-auth: authentication backend error, serving stale cached authentication of %q: %v", entry.UserId, err)
+	}
+	return entry, ok
+}
+
+func (b *CachedBasic) staleGrace() time.Duration {
+	if b.opts.StaleCacheGrace < 0 {
+		return 0
+	}
+	return b.opts.StaleCacheGrace
+}
+
+// ttl returns the lifetime of the cache entry for the authentication of userId
+// with hashedPassword, or a negative duration if it must not be cached.
+func (b *CachedBasic) ttl(req *http.Request, userId string, hashedPassword []byte) time.Duration {
+	// Caching would make single-use credentials reusable.
+	if _, ok := b.basic.datastore.(datastore.Consumable); ok {
+		return -1
+	}
+
+	ttl := b.opts.cacheTTL(req, userId, b.cacheExpireTime)
+
+	// Encourage migration away from hashes below the target cost.
+	if b.opts.WeakHashCacheTTL != 0 {
+		if b.opts.belowTargetCost(req.Context(), hashedPassword) {
+			if b.opts.WeakHashCacheTTL < 0 {
+				return -1
+			}
+			if b.opts.WeakHashCacheTTL < ttl {
+				return b.opts.WeakHashCacheTTL
+			}
+		}
+	}
+
+	return ttl
+}
+
+// CacheBasic returns a negroni.HandlerFunc that authenticates via Basic auth using cache.
+// Writes a http.StatusUnauthorized if authentication fails.
+// It panics if the cache times are invalid; see NewCachedBasic.
+func CacheBasic(datastore datastore.Datastore, cacheExpireTime, cachePurgeTime time.Duration) negroni.HandlerFunc {
+	return CacheBasicWithOptions(datastore, cacheExpireTime, cachePurgeTime, Options{})
+}
+
+// CacheBasicWithOptions returns a negroni.HandlerFunc that authenticates via Basic auth using cache
+// configured by opts. Writes a http.StatusUnauthorized if authentication fails.
+// The background purging lives as long as the process; use NewCachedBasic to be able to stop it.
+// It panics if the cache times are invalid; see NewCachedBasic.
+func CacheBasicWithOptions(datastore datastore.Datastore, cacheExpireTime, cachePurgeTime time.Duration, opts Options) negroni.HandlerFunc {
+	b, err := NewCachedBasic(datastore, cacheExpireTime, cachePurgeTime, opts)
+	if err != nil {
+		panic(err)
+	}
+	return b.ServeHTTP
+}
+
+// CacheBasicDefault returns a negroni.HandlerFunc that authenticates via Basic auth using cache.
+// with default cache configuration. Writes a http.StatusUnauthorized if authentication fails.
+func CacheBasicDefault(datastore datastore.Datastore) negroni.HandlerFunc {
+	return CacheBasic(datastore, defaultCacheExpireTime, defaultCachePurgeTime)
+}
@@ -0,0 +1,474 @@
+package auth
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codegangsta/negroni"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/nabeken/negroni-auth/datastore"
+)
+
+func Test_CachedBasicClose(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	cb, err := NewCachedBasic(&MockDataStore{}, time.Minute, time.Millisecond, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b io.Closer = cb
+	if runtime.NumGoroutine() <= before {
+		t.Error("Expected a purge goroutine to be started")
+	}
+
+	if err := b.Close(); err != nil {
+		t.Error("Close failed: ", err)
+	}
+	// Closing twice is harmless.
+	if err := b.Close(); err != nil {
+		t.Error("Second Close failed: ", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if runtime.NumGoroutine() > before {
+		t.Error("Purge goroutine leaked after Close")
+	}
+}
+
+type countingDataStore struct {
+	MockDataStore
+	gets int
+}
+
+func (ds *countingDataStore) Get(key string) ([]byte, bool) {
+	ds.gets++
+	return ds.MockDataStore.Get(key)
+}
+
+func Test_CachedBasicWeakHashCacheTTL(t *testing.T) {
+	weak, err := bcrypt.GenerateFromPassword([]byte("bar"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var weakhashtests = []struct {
+		weakHashCacheTTL time.Duration
+		gets             int
+	}{
+		{0, 1},
+		{-1, 3},
+		{time.Nanosecond, 3},
+	}
+	for _, tt := range weakhashtests {
+		ds := &countingDataStore{MockDataStore: MockDataStore{weak}}
+		b, err := NewCachedBasic(ds, time.Minute, 0, Options{WeakHashCacheTTL: tt.weakHashCacheTTL})
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := negroni.New(b)
+
+		for i := 0; i < 3; i++ {
+			r, _ := http.NewRequest("GET", "foo", nil)
+			r.SetBasicAuth("foo", "bar")
+			recorder := httptest.NewRecorder()
+			m.ServeHTTP(recorder, r)
+
+			if recorder.Code == 401 {
+				t.Error("Response is 401")
+			}
+			time.Sleep(time.Millisecond)
+		}
+
+		if ds.gets != tt.gets {
+			t.Errorf("Expected %d lookups with WeakHashCacheTTL %v, got %d", tt.gets, tt.weakHashCacheTTL, ds.gets)
+		}
+		b.Close()
+	}
+}
+
+func Test_CachedBasicMaxEntries(t *testing.T) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte("bar"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewCachedBasic(&MockDataStore{hashedPassword}, time.Minute, 0, Options{CacheMaxEntries: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	m := negroni.New(b)
+
+	for _, userId := range []string{"a", "b", "c", "d"} {
+		r, _ := http.NewRequest("GET", "foo", nil)
+		r.SetBasicAuth(userId, "bar")
+		m.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	if n := b.cache.(*lruCache).Len(); n != 2 {
+		t.Errorf("Expected 2 cached entries, got %d", n)
+	}
+}
+
+func Test_NewCachedBasicInvalidTimes(t *testing.T) {
+	var cachetimetests = []struct {
+		expire time.Duration
+		purge  time.Duration
+		valid  bool
+	}{
+		{time.Minute, time.Second, true},
+		{time.Minute, time.Minute, true},
+		{time.Minute, 0, true},
+		{0, 0, false},
+		{-time.Minute, time.Second, false},
+		{time.Minute, -time.Second, false},
+		{time.Second, time.Minute, false},
+	}
+	for _, tt := range cachetimetests {
+		b, err := NewCachedBasic(&MockDataStore{}, tt.expire, tt.purge, Options{})
+		if tt.valid != (err == nil) {
+			t.Errorf("Expected valid %v for expire %v and purge %v, got %v", tt.valid, tt.expire, tt.purge, err)
+		}
+		if b != nil {
+			b.Close()
+		}
+	}
+}
+
+// mapCache is a Cache without expiry.
+type mapCache map[string]interface{}
+
+func (c mapCache) Get(key string) (interface{}, bool) {
+	value, found := c[key]
+	return value, found
+}
+
+func (c mapCache) Set(key string, value interface{}, ttl time.Duration) { c[key] = value }
+
+func (c mapCache) Delete(key string) { delete(c, key) }
+
+func Test_CachedBasicCustomCache(t *testing.T) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte("bar"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := mapCache{}
+	ds := &countingDataStore{MockDataStore: MockDataStore{hashedPassword}}
+	b, err := NewCachedBasic(ds, time.Minute, time.Second, Options{Cache: c})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	m := negroni.New(b)
+
+	for i := 0; i < 3; i++ {
+		r, _ := http.NewRequest("GET", "foo", nil)
+		r.SetBasicAuth("foo", "bar")
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, r)
+
+		if recorder.Code != http.StatusOK {
+			t.Errorf("Expected 200, got %d", recorder.Code)
+		}
+	}
+
+	if ds.gets != 1 {
+		t.Errorf("Expected 1 lookup, got %d", ds.gets)
+	}
+	if len(c) != 1 {
+		t.Errorf("Expected 1 entry in the custom cache, got %d", len(c))
+	}
+}
+
+func Test_CachedBasicUnexpectedCacheValue(t *testing.T) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte("bar"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, _ := http.NewRequest("GET", "foo", nil)
+	r.SetBasicAuth("foo", "bar")
+	key := cacheKeyDigest(nil, "", 0, r.Header.Get("Authorization"))
+
+	var unexpectedtests = []struct {
+		value  interface{}
+		logged bool
+	}{
+		// Written by another code path sharing the cache.
+		{"true", true},
+		{CacheEntry{UserId: "mallory", Expires: time.Now().Add(-time.Second)}, false},
+		{CacheEntry{UserId: "mallory", Expires: time.Now().Add(time.Minute), Version: 1}, false},
+	}
+	for _, tt := range unexpectedtests {
+		c := mapCache{key: tt.value}
+		logger := &recordingLogger{}
+		ds := &countingDataStore{MockDataStore: MockDataStore{hashedPassword}}
+		b, err := NewCachedBasic(ds, time.Minute, 0, Options{Cache: c, Logger: logger})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var userId string
+		m := negroni.New(b)
+		m.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userId, _ = UserIdFromContext(r.Context())
+		}))
+
+		for i := 0; i < 2; i++ {
+			recorder := httptest.NewRecorder()
+			m.ServeHTTP(recorder, r)
+			if recorder.Code != http.StatusOK || userId != "foo" {
+				t.Errorf("Expected 200 for foo with %#v cached, got %d for %q", tt.value, recorder.Code, userId)
+			}
+		}
+
+		// The entry is replaced and used by the second request.
+		if ds.gets != 1 {
+			t.Errorf("Expected 1 lookup with %#v cached, got %d", tt.value, ds.gets)
+		}
+		if logged := len(logger.lines) > 0; logged != tt.logged {
+			t.Errorf("Expected logged %v with %#v cached, got %q", tt.logged, tt.value, logger.lines)
+		}
+		b.Close()
+	}
+}
+
+func Test_CachedBasicBypassMethods(t *testing.T) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte("bar"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var bypasstests = []struct {
+		method string
+		gets   int
+	}{
+		{"GET", 1},
+		{"HEAD", 1},
+		{"POST", 3},
+		{"DELETE", 3},
+	}
+	for _, tt := range bypasstests {
+		ds := &countingDataStore{MockDataStore: MockDataStore{hashedPassword}}
+		b, err := NewCachedBasic(ds, time.Minute, 0, Options{CacheBypassMethods: []string{"POST", "PUT", "DELETE"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := negroni.New(b)
+
+		for i := 0; i < 3; i++ {
+			r, _ := http.NewRequest(tt.method, "foo", nil)
+			r.SetBasicAuth("foo", "bar")
+			recorder := httptest.NewRecorder()
+			m.ServeHTTP(recorder, r)
+
+			if recorder.Code != http.StatusOK {
+				t.Errorf("Expected 200 for %s, got %d", tt.method, recorder.Code)
+			}
+		}
+
+		if ds.gets != tt.gets {
+			t.Errorf("Expected %d lookups for %s, got %d", tt.gets, tt.method, ds.gets)
+		}
+		b.Close()
+	}
+}
+
+func Test_CachedBasicVersionedStore(t *testing.T) {
+	hash := func(password string) []byte {
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hashedPassword
+	}
+	ds := datastore.NewMap(nil)
+	ds.Set("foo", hash("bar"))
+	ds.Set("qux", hash("quux"))
+
+	b, err := NewCachedBasic(ds, time.Minute, 0, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	m := negroni.New(b)
+
+	serve := func(userId, password string) int {
+		r, _ := http.NewRequest("GET", "foo", nil)
+		r.SetBasicAuth(userId, password)
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, r)
+		return recorder.Code
+	}
+
+	if code := serve("foo", "bar"); code != http.StatusOK {
+		t.Errorf("Expected 200, got %d", code)
+	}
+	if code := serve("qux", "quux"); code != http.StatusOK {
+		t.Errorf("Expected 200, got %d", code)
+	}
+
+	// Rotating the password invalidates the cached authentication of foo only.
+	ds.Set("foo", hash("baz"))
+	if code := serve("foo", "bar"); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for the old password, got %d", code)
+	}
+	if code := serve("foo", "baz"); code != http.StatusOK {
+		t.Errorf("Expected 200 for the new password, got %d", code)
+	}
+	ds.Set("qux", nil)
+	if code := serve("qux", "quux"); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 after removing the password, got %d", code)
+	}
+}
+
+func Test_CachedBasicStaleCacheGrace(t *testing.T) {
+	// The password of foo was changed since it was cached.
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte("baz"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, _ := http.NewRequest("GET", "foo", nil)
+	r.SetBasicAuth("foo", "bar")
+	key := cacheKeyDigest(nil, "", 0, r.Header.Get("Authorization"))
+
+	var staletests = []struct {
+		grace   time.Duration
+		expired time.Duration
+		err     error
+		code    int
+	}{
+		{time.Minute, 10 * time.Second, errors.New("unavailable"), http.StatusOK},
+		// Opt-in only.
+		{0, 10 * time.Second, errors.New("unavailable"), http.StatusServiceUnavailable},
+		{time.Minute, 2 * time.Minute, errors.New("unavailable"), http.StatusServiceUnavailable},
+		// A working store which rejects the credential overrides the stale entry.
+		{time.Minute, 10 * time.Second, nil, http.StatusUnauthorized},
+	}
+	for _, tt := range staletests {
+		c := mapCache{key: CacheEntry{UserId: "foo", Expires: time.Now().Add(-tt.expired)}}
+		ds := &mockErrDataStore{HashedPassword: hashedPassword, Err: tt.err}
+		b, err := NewCachedBasic(ds, time.Minute, 0, Options{Cache: c, StaleCacheGrace: tt.grace, Logger: &recordingLogger{}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := negroni.New(b)
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, r)
+		if recorder.Code != tt.code {
+			t.Errorf("Expected %d with grace %v for an entry expired %v ago and %v, got %d", tt.code, tt.grace, tt.expired, tt.err, recorder.Code)
+		}
+		b.Close()
+	}
+}
+
+func Test_CachedBasicIdleTimeout(t *testing.T) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte("bar"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, _ := http.NewRequest("GET", "foo", nil)
+	r.SetBasicAuth("foo", "bar")
+	key := cacheKeyDigest(nil, "", 0, r.Header.Get("Authorization"))
+
+	c := mapCache{}
+	ds := &countingDataStore{MockDataStore: MockDataStore{hashedPassword}}
+	b, err := NewCachedBasic(ds, time.Hour, 0, Options{Cache: c, CacheIdleTimeout: time.Minute, CacheMaxLifetime: 30 * time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	m := negroni.New(b)
+	serve := func() {
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, r)
+		if recorder.Code != http.StatusOK {
+			t.Errorf("Expected 200, got %d", recorder.Code)
+		}
+	}
+	near := func(got time.Time, d time.Duration) bool {
+		want := time.Now().Add(d)
+		return got.After(want.Add(-time.Second)) && !got.After(want)
+	}
+
+	serve()
+	entry := c[key].(CacheEntry)
+	if !near(entry.Expires, time.Minute) || !near(entry.Deadline, 30*time.Minute) {
+		t.Errorf("Expected expiry in 1m and deadline in 30m, got %v and %v", time.Until(entry.Expires), time.Until(entry.Deadline))
+	}
+
+	var idletests = []struct {
+		expires  time.Duration
+		deadline time.Duration
+		lookups  int
+		pushed   time.Duration
+	}{
+		// Used within the idle timeout, the window slides.
+		{time.Second, 10 * time.Minute, 1, time.Minute},
+		// Up to the deadline only.
+		{time.Second, 10 * time.Second, 1, 10 * time.Second},
+		// Unused for the idle timeout, the credential is verified again.
+		{-time.Second, 10 * time.Minute, 2, time.Minute},
+	}
+	for _, tt := range idletests {
+		c[key] = CacheEntry{UserId: "foo", Expires: time.Now().Add(tt.expires), Deadline: time.Now().Add(tt.deadline)}
+		ds.gets = 1
+		serve()
+		if ds.gets != tt.lookups {
+			t.Errorf("Expected %d lookups for an entry expiring in %v, got %d", tt.lookups, tt.expires, ds.gets)
+		}
+		if entry := c[key].(CacheEntry); !near(entry.Expires, tt.pushed) {
+			t.Errorf("Expected an entry expiring in %v to expire in %v, got %v", tt.expires, tt.pushed, time.Until(entry.Expires))
+		}
+	}
+}
+
+func Test_CachedBasicCacheKeyOpaque(t *testing.T) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte("bar"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, _ := http.NewRequest("GET", "foo", nil)
+	r.SetBasicAuth("foo", "bar")
+	header := r.Header.Get("Authorization")
+	secret := []byte("0123456789abcdef0123456789abcdef")
+
+	// With the per-process secret or a configured one, instances find each other's entries.
+	var keytests = []struct {
+		secret []byte
+	}{
+		{nil},
+		{secret},
+	}
+	for _, tt := range keytests {
+		c := mapCache{}
+		for i := 0; i < 2; i++ {
+			b, err := NewCachedBasic(&MockDataStore{hashedPassword}, time.Minute, 0, Options{Cache: c, CacheKeySecret: tt.secret})
+			if err != nil {
+				t.Fatal(err)
+			}
+			recorder := httptest.NewRecorder()
+			negroni.New(b).ServeHTTP(recorder, r)
+			b.Close()
+		}
+		if len(c) != 1 {
+			t.Errorf("Expected instances with secret %q to share 1 key, got %d", tt.secret, len(c))
+		}
+		for key := range c {
+			if strings.Contains(key, header) || strings.Contains(key, "bar") {
+				t.Errorf("Expected an opaque key, got %q", key)
+			}
+		}
+	}
+
+	if cacheKeyDigest(secret, "", 0, header) == cacheKeyDigest(nil, "", 0, header) {
+		t.Error("Expected the digest to depend on the secret")
+	}
+}
@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"crypto/x509"
+	"net/http"
+
+	"github.com/codegangsta/negroni"
+)
+
+// NewClientCert returns a negroni.HandlerFunc that authenticates via TLS client certificates,
+// for mTLS deployments. mapper derives the userid from the verified client certificate,
+// e.g. ClientCertCommonName, and returns false to reject it.
+// Only certificates verified by the TLS server count, so tls.Config.ClientAuth must verify them
+// (tls.VerifyClientCertIfGiven or tls.RequireAndVerifyClientCert); requests over plain HTTP,
+// without a certificate or with an unverified one are rejected.
+// Writes a http.StatusUnauthorized if authentication fails. The response carries no challenge,
+// since there is no authentication scheme for TLS; combined with NewBasic in Any, clients receive
+// Basic's challenge.
+func NewClientCert(mapper func(cert *x509.Certificate) (userId string, ok bool)) negroni.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+		cert := verifiedClientCert(req)
+		if cert == nil {
+			rejectUpgrade(w, req)
+			http.Error(w, "Not Authorized", http.StatusUnauthorized)
+			return
+		}
+
+		userId, ok := mapper(cert)
+		if !ok || userId == "" {
+			rejectUpgrade(w, req)
+			http.Error(w, "Not Authorized", http.StatusUnauthorized)
+			return
+		}
+
+		pass(w, withUserId(req, userId), stdLogger{}, next)
+	}
+}
+
+// verifiedClientCert returns the leaf certificate of the first verified chain of req, or nil.
+func verifiedClientCert(req *http.Request) *x509.Certificate {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return req.TLS.VerifiedChains[0][0]
+}
+
+// ClientCertCommonName is a mapper for NewClientCert using the common name of the subject as userid.
+func ClientCertCommonName(cert *x509.Certificate) (string, bool) {
+	return cert.Subject.CommonName, cert.Subject.CommonName != ""
+}
+
+// ClientCertDNSName is a mapper for NewClientCert using the first DNS name of the
+// subject alternative names as userid.
+func ClientCertDNSName(cert *x509.Certificate) (string, bool) {
+	if len(cert.DNSNames) == 0 {
+		return "", false
+	}
+	return cert.DNSNames[0], true
+}
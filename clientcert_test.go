@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/codegangsta/negroni"
+)
+
+func Test_ClientCert(t *testing.T) {
+	alice := &x509.Certificate{Subject: pkix.Name{CommonName: "alice"}}
+	anonymous := &x509.Certificate{DNSNames: []string{"svc.example.com"}}
+
+	var clientcerttests = []struct {
+		name  string
+		tls   *tls.ConnectionState
+		basic bool
+		code  int
+		body  string
+	}{
+		{"plain HTTP", nil, false, 401, "Not Authorized\n"},
+		{"no certificate", &tls.ConnectionState{}, false, 401, "Not Authorized\n"},
+		{"unverified", &tls.ConnectionState{PeerCertificates: []*x509.Certificate{alice}}, false, 401, "Not Authorized\n"},
+		{"verified", &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{alice}}}, false, 200, "hello alice"},
+		{"no common name", &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{anonymous}}}, false, 401, "Not Authorized\n"},
+		{"Basic fallback", nil, true, 200, "hello foo"},
+	}
+	for _, tt := range clientcerttests {
+		m := negroni.New(Any(NewClientCert(ClientCertCommonName), Basic("foo", "bar")))
+		m.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userId, _ := UserIdFromContext(r.Context())
+			w.Write([]byte("hello " + userId))
+		}))
+
+		r, _ := http.NewRequest("GET", "foo", nil)
+		r.TLS = tt.tls
+		if tt.basic {
+			r.SetBasicAuth("foo", "bar")
+		}
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, r)
+
+		if recorder.Code != tt.code || recorder.Body.String() != tt.body {
+			t.Errorf("Expected %d %q for %s, got %d %q", tt.code, tt.body, tt.name, recorder.Code, recorder.Body.String())
+		}
+		if tt.code == 401 && recorder.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("Expected the Basic challenge for %s", tt.name)
+		}
+	}
+}
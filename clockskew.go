@@ -0,0 +1,38 @@
+package auth
+
+import (
+	"errors"
+	"time"
+)
+
+// Errors of time-based checks tolerating a maximum clock skew, e.g. the MaxClockSkew of
+// NonceBasic and SSHKeyAuth or the Leeway of OIDCTokenStore. The requests they reject get them
+// as "X-Auth-Reason: expired" or "X-Auth-Reason: not-yet-valid" where the scheme can tell.
+var (
+	errExpired     = errors.New("auth: expired")
+	errNotYetValid = errors.New("auth: not yet valid")
+)
+
+// checkValidity returns errExpired if now is after expires, or errNotYetValid if now is before
+// notBefore, by more than skew in either case. A zero notBefore isn't checked.
+// Servers and clients with drifting clocks disagree about both by up to their skew.
+func checkValidity(now, notBefore, expires time.Time, skew time.Duration) error {
+	if now.After(expires.Add(skew)) {
+		return errExpired
+	}
+	if !notBefore.IsZero() && now.Add(skew).Before(notBefore) {
+		return errNotYetValid
+	}
+	return nil
+}
+
+// validityReason returns the X-Auth-Reason of err returned by checkValidity, or "" for other errors.
+func validityReason(err error) string {
+	switch err {
+	case errExpired:
+		return "expired"
+	case errNotYetValid:
+		return "not-yet-valid"
+	}
+	return ""
+}
@@ -0,0 +1,64 @@
+// Command negroni-auth-hash reads a password from standard input and prints its bcrypt hash,
+// for putting in config files or data stores used with negroni-auth.
+//
+// Usage:
+//
+//	negroni-auth-hash [-cost 12] < password.txt
+//
+// When standard input is a terminal, the password is prompted for without echoing it.
+// Otherwise only the first line of the input is used, without its line ending. Prefer piping
+// the password from a file or a secret manager over passing it in the shell history.
+// An empty password is refused.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+
+	"github.com/nabeken/negroni-auth"
+)
+
+func main() {
+	cost := flag.Int("cost", 12, "bcrypt cost")
+	flag.Parse()
+
+	password, err := readPassword()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "negroni-auth-hash: reading password:", err)
+		os.Exit(1)
+	}
+	if password == "" {
+		fmt.Fprintln(os.Stderr, "negroni-auth-hash: empty password")
+		os.Exit(1)
+	}
+
+	hash, err := auth.HashPassword(password, *cost)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "negroni-auth-hash:", err)
+		os.Exit(1)
+	}
+	fmt.Println(hash)
+}
+
+// readPassword prompts for the password without echo on a terminal, or reads the first line
+// of standard input otherwise.
+func readPassword() (string, error) {
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		fmt.Fprint(os.Stderr, "Password: ")
+		password, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		return string(password), err
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/codegangsta/negroni"
+)
+
+var wwwAuthenticate = http.CanonicalHeaderKey("WWW-Authenticate")
+
+// probeWriter is a negroni.ResponseWriter which captures the response of an
+// authentication handler instead of sending it, so it can be replayed or discarded.
+type probeWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newProbeWriter() *probeWriter {
+	return &probeWriter{header: make(http.Header)}
+}
+
+func (p *probeWriter) Header() http.Header { return p.header }
+
+func (p *probeWriter) WriteHeader(status int) {
+	if p.status == 0 {
+		p.status = status
+	}
+}
+
+func (p *probeWriter) Write(b []byte) (int, error) {
+	if p.status == 0 {
+		p.status = http.StatusOK
+	}
+	return p.body.Write(b)
+}
+
+func (p *probeWriter) Status() int                         { return p.status }
+func (p *probeWriter) Written() bool                       { return p.status != 0 }
+func (p *probeWriter) Size() int                           { return p.body.Len() }
+func (p *probeWriter) Before(func(negroni.ResponseWriter)) {}
+func (p *probeWriter) Flush()                              {}
+
+// copyHeader adds the headers captured by p to w.
+func (p *probeWriter) copyHeader(w http.ResponseWriter) {
+	for k, vv := range p.header {
+		for _, v := range vv {
+			if k == "Vary" {
+				addVary(w.Header(), v)
+				continue
+			}
+			w.Header().Add(k, v)
+		}
+	}
+}
+
+// replay sends the captured response to w.
+// A handler which neither wrote a response nor called next is a failed authentication.
+func (p *probeWriter) replay(w http.ResponseWriter) {
+	if p.status == 0 {
+		p.status = http.StatusUnauthorized
+	}
+	p.copyHeader(w)
+	w.WriteHeader(p.status)
+	w.Write(p.body.Bytes())
+}
+
+// probe runs handler against a probeWriter. It returns the request passed to next,
+// or nil if handler didn't call next, i.e. authentication failed.
+func probe(handler negroni.HandlerFunc, req *http.Request) (*probeWriter, *http.Request) {
+	p := newProbeWriter()
+	var passed *http.Request
+	handler(p, req, func(w http.ResponseWriter, r *http.Request) {
+		passed = r
+	})
+	return p, passed
+}
+
+// pass calls next with req unless a failure status has already been written to w, as every
+// authentication middleware does once it has authenticated req.
+func pass(w http.ResponseWriter, req *http.Request, logger Logger, next http.HandlerFunc) {
+	if denied(w) {
+		logContext(logger, req.Context(), "negroni-auth: response already written with a failure status, not calling next handler")
+		return
+	}
+	next(w, req)
+}
+
+// All returns a negroni.HandlerFunc that calls next only if every handler authenticates the request.
+// Handlers run in order, each seeing the request (and context) passed on by the previous one.
+// The response of the first failing handler is sent and the remaining handlers are skipped.
+// All without handlers rejects every request.
+func All(handlers ...negroni.HandlerFunc) negroni.HandlerFunc {
+	return AllWithOptions(Options{}, handlers...)
+}
+
+// AllWithOptions returns a negroni.HandlerFunc like All whose own responses and logs are
+// configured by opts, i.e. opts.Realm, opts.ChallengeDecision and opts.Logger.
+func AllWithOptions(opts Options, handlers ...negroni.HandlerFunc) negroni.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+		if len(handlers) == 0 {
+			requireAuth(w, req, opts.challengeKind(req), opts.realm(req), "", opts.logger(), false)
+			return
+		}
+
+		var probes []*probeWriter
+		for _, h := range handlers {
+			p, passed := probe(h, req)
+			if passed == nil {
+				p.replay(w)
+				return
+			}
+			probes = append(probes, p)
+			req = passed
+		}
+
+		// Keep headers set on success, e.g. session cookies.
+		for _, p := range probes {
+			p.copyHeader(w)
+		}
+		pass(w, req, opts.logger(), next)
+	}
+}
+
+// Any returns a negroni.HandlerFunc that calls next if any handler authenticates the request.
+// Handlers run in order until one succeeds; failures of the others are discarded.
+// If every handler fails, the response of the first failing handler is sent with the
+// WWW-Authenticate challenges of all handlers, so clients learn every accepted scheme.
+// A failure other than http.StatusUnauthorized (e.g. a backend outage) takes precedence.
+func Any(handlers ...negroni.HandlerFunc) negroni.HandlerFunc {
+	return AnyWithOptions(Options{}, handlers...)
+}
+
+// AnyWithOptions returns a negroni.HandlerFunc like Any whose own responses and logs are
+// configured by opts, i.e. opts.Realm, opts.ChallengeDecision and opts.Logger.
+func AnyWithOptions(opts Options, handlers ...negroni.HandlerFunc) negroni.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+		var failure *probeWriter
+		var challenges []string
+		for _, h := range handlers {
+			p, passed := probe(h, req)
+			if passed != nil {
+				p.copyHeader(w)
+				pass(w, passed, opts.logger(), next)
+				return
+			}
+			challenges = append(challenges, p.header[wwwAuthenticate]...)
+			if failure == nil || (failure.status == http.StatusUnauthorized && p.status != http.StatusUnauthorized && p.status != 0) {
+				failure = p
+			}
+		}
+
+		if failure == nil {
+			requireAuth(w, req, opts.challengeKind(req), opts.realm(req), "", opts.logger(), false)
+			return
+		}
+		if failure.status == http.StatusUnauthorized || failure.status == 0 {
+			failure.header[wwwAuthenticate] = challenges
+		}
+		failure.replay(w)
+	}
+}
@@ -0,0 +1,236 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/codegangsta/negroni"
+
+	"github.com/nabeken/negroni-auth/datastore"
+)
+
+// testAPIKey authenticates requests carrying the X-API-Key header.
+func testAPIKey(key string) negroni.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+		if req.Header.Get("X-API-Key") != key {
+			w.Header().Set("WWW-Authenticate", `APIKey realm="api"`)
+			http.Error(w, "Not Authorized", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("X-API-Key-Accepted", "1")
+		next(w, req)
+	}
+}
+
+var combinatortests = []struct {
+	apiKey   bool
+	basic    bool
+	all, any int
+}{
+	{false, false, 401, 401},
+	{true, false, 401, 200},
+	{false, true, 401, 200},
+	{true, true, 200, 200},
+}
+
+func Test_AllAny(t *testing.T) {
+	h := http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		userId, _ := UserIdFromContext(req.Context())
+		res.Write([]byte("hello " + userId))
+	})
+
+	for _, tt := range combinatortests {
+		for _, combinator := range []struct {
+			name    string
+			handler negroni.HandlerFunc
+			code    int
+		}{
+			{"All", All(testAPIKey("k3y"), Basic("foo", "bar")), tt.all},
+			{"Any", Any(testAPIKey("k3y"), Basic("foo", "bar")), tt.any},
+		} {
+			m := negroni.New()
+			m.Use(combinator.handler)
+			m.UseHandler(h)
+
+			r, _ := http.NewRequest("GET", "foo", nil)
+			if tt.apiKey {
+				r.Header.Set("X-API-Key", "k3y")
+			}
+			if tt.basic {
+				r.SetBasicAuth("foo", "bar")
+			}
+			recorder := httptest.NewRecorder()
+			m.ServeHTTP(recorder, r)
+
+			if recorder.Code != combinator.code {
+				t.Errorf("%s: expected %d with api key %v, basic %v, got %d", combinator.name, combinator.code, tt.apiKey, tt.basic, recorder.Code)
+			}
+
+			switch {
+			case recorder.Code == 401 && recorder.Body.String() != "Not Authorized\n":
+				t.Errorf("%s: unexpected body %q", combinator.name, recorder.Body.String())
+			case recorder.Code == 200 && tt.basic && (combinator.name == "All" || !tt.apiKey) && recorder.Body.String() != "hello foo":
+				t.Errorf("%s: expected userid in context, got %q", combinator.name, recorder.Body.String())
+			case recorder.Code == 200 && tt.apiKey && combinator.name == "All" && recorder.Header().Get("X-API-Key-Accepted") == "":
+				t.Errorf("%s: header set on success was dropped", combinator.name)
+			}
+		}
+	}
+}
+
+func Test_AnyChallenges(t *testing.T) {
+	m := negroni.New()
+	m.Use(Any(testAPIKey("k3y"), Basic("foo", "bar")))
+
+	r, _ := http.NewRequest("GET", "foo", nil)
+	recorder := httptest.NewRecorder()
+	m.ServeHTTP(recorder, r)
+
+	if challenges := recorder.Header()[wwwAuthenticate]; len(challenges) != 2 {
+		t.Error("Expected a challenge per scheme, got: ", challenges)
+	}
+}
+
+func Test_AllWithoutHandlers(t *testing.T) {
+	m := negroni.New()
+	m.Use(All())
+
+	r, _ := http.NewRequest("GET", "foo", nil)
+	recorder := httptest.NewRecorder()
+	m.ServeHTTP(recorder, r)
+
+	if recorder.Code != 401 {
+		t.Error("Expected All() to reject, got: ", recorder.Code)
+	}
+}
+
+func Test_AnyMultipleAuthorizationHeaders(t *testing.T) {
+	h := http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		userId, _ := UserIdFromContext(req.Context())
+		res.Write([]byte("hello " + userId))
+	})
+
+	var multiheadertests = []struct {
+		headers []string
+		code    int
+		body    string
+	}{
+		{[]string{"Bearer unknown", basicHeader("foo", "bar")}, 200, "hello foo"},
+		{[]string{basicHeader("foo", "wrong"), "Bearer t0ken"}, 200, "hello baz"},
+		{[]string{basicHeader("foo", "wrong"), basicHeader("foo", "bar")}, 401, "Not Authorized\n"},
+		{[]string{"Bearer unknown", "Bearer t0ken"}, 401, "Not Authorized\n"},
+	}
+	for _, tt := range multiheadertests {
+		m := negroni.New()
+		m.Use(Any(Basic("foo", "bar"), NewBearer(MockTokenStore{"t0ken": "baz"})))
+		m.UseHandler(h)
+
+		r, _ := http.NewRequest("GET", "foo", nil)
+		for _, v := range tt.headers {
+			r.Header.Add("Authorization", v)
+		}
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, r)
+
+		if recorder.Code != tt.code || recorder.Body.String() != tt.body {
+			t.Errorf("Expected %d %q for %v, got %d %q", tt.code, tt.body, tt.headers, recorder.Code, recorder.Body.String())
+		}
+	}
+}
+
+func basicHeader(userId, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(userId+":"+password))
+}
+
+func Test_MiddlewareDenied(t *testing.T) {
+	nonce := NewNonceBasic(&datastore.Simple{Key: "foo", Value: []byte(NonceSecret("bar"))}, []byte("server key server key server key"), NewMemoryNonceStore())
+	signer := newTestSigner(t)
+	keys, err := NewAuthorizedKeysStore([]byte(authorizedKey(signer, "alice@example.com")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshKey := NewSSHKeyAuth(keys, []byte("server key server key server key"), NewMemoryNonceStore())
+	alice := &x509.Certificate{Subject: pkix.Name{CommonName: "alice"}}
+
+	// Each request authenticates, but an earlier handler has already denied it.
+	var deniedtests = []struct {
+		name string
+		mw   negroni.Handler
+		set  func(r *http.Request)
+	}{
+		{"All", All(testAPIKey("k")), func(r *http.Request) { r.Header.Set("X-API-Key", "k") }},
+		{"Any", Any(testAPIKey("k")), func(r *http.Request) { r.Header.Set("X-API-Key", "k") }},
+		{"NewBearer", NewBearer(MockTokenStore{"t0ken": "foo"}), func(r *http.Request) { r.Header.Set("Authorization", "Bearer t0ken") }},
+		{"NewClientCert", NewClientCert(ClientCertCommonName), func(r *http.Request) {
+			r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{alice}}}
+		}},
+		{"NewNonceBasic", nonce, func(r *http.Request) { SetNonceAuth(r, "foo", "bar", nonce.issue()) }},
+		{"NewSSHKeyAuth", sshKey, func(r *http.Request) {
+			if err := SetSSHAuth(r, signer, issueNonce(sshKey.Key, sshKey.clock(), sshKey.TTL)); err != nil {
+				t.Fatal(err)
+			}
+		}},
+	}
+	for _, tt := range deniedtests {
+		called := false
+		m := negroni.New()
+		m.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		}))
+		m.Use(tt.mw)
+		m.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			called = true
+		}))
+
+		r, _ := http.NewRequest("GET", "foo", nil)
+		tt.set(r)
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, r)
+
+		if called || recorder.Code != http.StatusForbidden {
+			t.Errorf("Expected %s to stop a denied request, got %d (next called %v)", tt.name, recorder.Code, called)
+		}
+	}
+}
+
+func Test_AllAnyWithOptions(t *testing.T) {
+	logger := &recordingLogger{}
+	opts := Options{Realm: func(*http.Request) string { return "api" }, Logger: logger}
+
+	var optionstests = []struct {
+		name string
+		mw   negroni.HandlerFunc
+	}{
+		{"AllWithOptions", AllWithOptions(opts)},
+		{"AnyWithOptions", AnyWithOptions(opts)},
+	}
+	for _, tt := range optionstests {
+		r, _ := http.NewRequest("GET", "foo", nil)
+		recorder := httptest.NewRecorder()
+		negroni.New(tt.mw).ServeHTTP(recorder, r)
+
+		if got := recorder.Header().Get("WWW-Authenticate"); recorder.Code != 401 || got != `Basic realm="api"` {
+			t.Errorf("Expected %s without handlers to challenge for realm api, got %d %s", tt.name, recorder.Code, got)
+		}
+	}
+
+	// A denied request is logged by Options.Logger.
+	m := negroni.New()
+	m.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	}))
+	m.Use(AnyWithOptions(opts, testAPIKey("k")))
+	r, _ := http.NewRequest("GET", "foo", nil)
+	r.Header.Set("X-API-Key", "k")
+	m.ServeHTTP(httptest.NewRecorder(), r)
+
+	if len(logger.lines) != 1 || !strings.Contains(logger.lines[0], "not calling next handler") {
+		t.Errorf("Expected the denied request to be logged by Options.Logger, got %q", logger.lines)
+	}
+}
@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/codegangsta/negroni"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/nabeken/negroni-auth/datastore"
+)
+
+// Duration is a time.Duration read from and written as a string like "10m" or "1h30m",
+// for config files.
+type Duration time.Duration
+
+// Duration.MarshalText implements encoding.TextMarshaler.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// Duration.UnmarshalText implements encoding.TextUnmarshaler.
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return fmt.Errorf("auth: invalid duration %q", text)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Config configures the Basic auth middleware declaratively, e.g. from a JSON or YAML file
+// read with encoding/json or gopkg.in/yaml.v3:
+//
+//	{
+//	  "realm": "Admin",
+//	  "cache_expire": "10m",
+//	  "cache_purge": "1m",
+//	  "users": {"alice": "$2a$12$..."},
+//	  "require_tls": true,
+//	  "lockout_threshold": 5,
+//	  "lockout_duration": "15m"
+//	}
+//
+// See NewFromConfig.
+type Config struct {
+	// Realm of the authentication challenge; "Authorization Required" if empty.
+	Realm string `json:"realm,omitempty" yaml:"realm,omitempty"`
+	// Cost is the bcrypt cost passwords are hashed with, and the target cost; 12 if zero.
+	Cost int `json:"cost,omitempty" yaml:"cost,omitempty"`
+	// CacheExpire, if set, caches successful authentications as CacheBasic does.
+	CacheExpire Duration `json:"cache_expire,omitempty" yaml:"cache_expire,omitempty"`
+	// CachePurge is the interval expired cache entries are purged at; 0 disables purging.
+	CachePurge Duration `json:"cache_purge,omitempty" yaml:"cache_purge,omitempty"`
+	// Users maps userids to bcrypt hashes of their passwords. Values which aren't bcrypt hashes
+	// are taken as plaintext passwords and hashed at load time.
+	Users map[string]string `json:"users" yaml:"users"`
+	// RequireTLS sets Options.RequireTLS.
+	RequireTLS bool `json:"require_tls,omitempty" yaml:"require_tls,omitempty"`
+	// LockoutThreshold and LockoutDuration set the Options of the same name. Both or neither must be set.
+	LockoutThreshold int      `json:"lockout_threshold,omitempty" yaml:"lockout_threshold,omitempty"`
+	LockoutDuration  Duration `json:"lockout_duration,omitempty" yaml:"lockout_duration,omitempty"`
+	// PasswordPolicy, if set, is the policy the plaintext passwords of Users must meet.
+	// Bcrypt hashes can't be checked.
+	PasswordPolicy *PasswordPolicy `json:"password_policy,omitempty" yaml:"password_policy,omitempty"`
+}
+
+// Config.options returns the Options configured by c.
+func (c Config) options() (Options, error) {
+	switch {
+	case c.LockoutThreshold < 0:
+		return Options{}, errors.New("auth: config: lockout_threshold must not be negative")
+	case c.LockoutDuration < 0:
+		return Options{}, errors.New("auth: config: lockout_duration must not be negative")
+	case c.LockoutThreshold == 0 && c.LockoutDuration > 0:
+		return Options{}, errors.New("auth: config: lockout_duration is set but lockout_threshold is 0")
+	case c.LockoutThreshold > 0 && c.LockoutDuration == 0:
+		return Options{}, errors.New("auth: config: lockout_threshold is set but lockout_duration is 0")
+	case c.CacheExpire == 0 && c.CachePurge != 0:
+		return Options{}, errors.New("auth: config: cache_purge is set but cache_expire is not")
+	}
+
+	opts := []Option{}
+	if c.Realm != "" {
+		opts = append(opts, WithRealm(c.Realm))
+	}
+	if c.Cost != 0 {
+		opts = append(opts, WithCost(c.Cost))
+	}
+	o, err := Options{
+		RequireTLS:       c.RequireTLS,
+		LockoutThreshold: c.LockoutThreshold,
+		LockoutDuration:  time.Duration(c.LockoutDuration),
+	}.apply(opts)
+	if err != nil {
+		return Options{}, fmt.Errorf("auth: config: %w", err)
+	}
+	return o, nil
+}
+
+// NewFromConfig returns a negroni.HandlerFunc that authenticates via Basic auth as configured by c,
+// and an io.Closer stopping its background work, e.g. the cache purging if c.CacheExpire is set.
+// Returns a descriptive error for an invalid config, e.g. no users, an empty userid or password,
+// or a lockout threshold without duration.
+func NewFromConfig(c Config) (negroni.HandlerFunc, io.Closer, error) {
+	opts, err := c.options()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(c.Users) == 0 {
+		return nil, nil, errors.New("auth: config: no users")
+	}
+
+	userIds := make([]string, 0, len(c.Users))
+	for userId := range c.Users {
+		userIds = append(userIds, userId)
+	}
+	sort.Strings(userIds)
+
+	// Hash plaintext passwords, keeping bcrypt hashes as they are.
+	var plain []string
+	hashes := make(map[string][]byte, len(userIds))
+	for _, userId := range userIds {
+		password := c.Users[userId]
+		switch {
+		case userId == "":
+			return nil, nil, errors.New("auth: config: empty userid")
+		case strings.Contains(userId, ":"):
+			return nil, nil, fmt.Errorf("auth: config: userid %q contains a colon", userId)
+		case password == "":
+			return nil, nil, fmt.Errorf("auth: config: empty password for userid %q", userId)
+		}
+		if _, err := bcrypt.Cost([]byte(password)); err == nil {
+			hashes[userId] = []byte(password)
+			continue
+		}
+		if c.PasswordPolicy != nil {
+			if err := c.PasswordPolicy.Check(password); err != nil {
+				return nil, nil, fmt.Errorf("auth: config: userid %q: %w", userId, err)
+			}
+		}
+		plain = append(plain, userId)
+	}
+	cost := opts.cost()
+	hashed, err := hashPasswords(c.Users, plain, func(string) int { return cost }, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("auth: config: %w", err)
+	}
+	for userId, hashedPassword := range hashed {
+		hashes[userId] = hashedPassword
+	}
+	ds := datastore.NewMap(hashes)
+
+	if c.CacheExpire == 0 {
+		a := newBasicAuth(ds, opts)
+		return a.ServeHTTP, a, nil
+	}
+	b, err := NewCachedBasic(ds, time.Duration(c.CacheExpire), time.Duration(c.CachePurge), opts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("auth: config: %w", err)
+	}
+	return b.ServeHTTP, b, nil
+}
@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codegangsta/negroni"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func Test_NewFromConfig(t *testing.T) {
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("bar"), bcrypt.MinCost)
+
+	var c Config
+	err := json.Unmarshal([]byte(`{
+		"realm": "Admin",
+		"cost": 4,
+		"cache_expire": "10m",
+		"cache_purge": "1m",
+		"users": {"foo": "`+string(hashedPassword)+`", "baz": "qux"},
+		"require_tls": true,
+		"lockout_threshold": 5,
+		"lockout_duration": "15m"
+	}`), &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.CacheExpire != Duration(10*time.Minute) || c.LockoutDuration != Duration(15*time.Minute) {
+		t.Errorf("Expected durations to be parsed, got %v and %v", c.CacheExpire, c.LockoutDuration)
+	}
+
+	h, closer, err := NewFromConfig(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer.Close()
+	if _, ok := closer.(*CachedBasic); !ok {
+		t.Errorf("Expected the closer of a cached config to be *CachedBasic, got %T", closer)
+	}
+	m := negroni.New(h)
+
+	var configtests = []struct {
+		userId   string
+		password string
+		tls      bool
+		code     int
+	}{
+		{"foo", "bar", true, http.StatusOK},
+		{"baz", "qux", true, http.StatusOK},
+		{"foo", "qux", true, http.StatusUnauthorized},
+		{"foo", "bar", false, http.StatusForbidden},
+	}
+	for _, tt := range configtests {
+		r, _ := http.NewRequest("GET", "foo", nil)
+		r.SetBasicAuth(tt.userId, tt.password)
+		if tt.tls {
+			r.TLS = &tls.ConnectionState{}
+		}
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, r)
+
+		if recorder.Code != tt.code {
+			t.Errorf("Expected %d for %s:%s with TLS %v, got %d", tt.code, tt.userId, tt.password, tt.tls, recorder.Code)
+		}
+		if tt.code == http.StatusUnauthorized && recorder.Header().Get("WWW-Authenticate") != `Basic realm="Admin"` {
+			t.Errorf("Expected the configured realm, got %q", recorder.Header().Get("WWW-Authenticate"))
+		}
+	}
+}
+
+func Test_NewFromConfigUncached(t *testing.T) {
+	h, closer, err := NewFromConfig(Config{Users: map[string]string{"foo": "bar"}, Cost: bcrypt.MinCost})
+	if err != nil || h == nil {
+		t.Fatal(err)
+	}
+	if err := closer.Close(); err != nil {
+		t.Error("Expected the closer of an uncached config to succeed, got: ", err)
+	}
+}
+
+func Test_NewFromConfigInvalid(t *testing.T) {
+	users := map[string]string{"foo": "bar"}
+
+	var invalidconfigtests = []struct {
+		c    Config
+		want string
+	}{
+		{Config{}, "no users"},
+		{Config{Users: map[string]string{"": "bar"}}, "empty userid"},
+		{Config{Users: map[string]string{"foo:bar": "baz"}}, "contains a colon"},
+		{Config{Users: map[string]string{"foo": ""}}, "empty password"},
+		{Config{Users: users, Cost: 100}, "bcrypt cost"},
+		{Config{Users: users, LockoutDuration: Duration(time.Minute)}, "lockout_threshold is 0"},
+		{Config{Users: users, LockoutThreshold: 5}, "lockout_duration is 0"},
+		{Config{Users: users, LockoutThreshold: -1}, "lockout_threshold must not be negative"},
+		{Config{Users: users, CachePurge: Duration(time.Minute)}, "cache_expire is not"},
+		{Config{Users: users, CacheExpire: Duration(time.Second), CachePurge: Duration(time.Minute)}, "purge time must not exceed"},
+	}
+	for _, tt := range invalidconfigtests {
+		_, _, err := NewFromConfig(tt.c)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Expected an error containing %q for %+v, got %v", tt.want, tt.c, err)
+		}
+	}
+
+	var d Duration
+	if err := json.Unmarshal([]byte(`"ten minutes"`), &d); err == nil {
+		t.Error("Expected an invalid duration to be rejected")
+	}
+}
@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+)
+
+type contextKey int
+
+const (
+	userIdKey contextKey = iota
+	tenantKey
+	costKey
+	scopesKey
+	upgradeKey
+)
+
+// UserIdFromContext returns the userid authenticated by the middleware.
+func UserIdFromContext(ctx context.Context) (string, bool) {
+	userId, ok := ctx.Value(userIdKey).(string)
+	return userId, ok
+}
+
+// withUserId returns a shallow copy of req carrying userId in its context.
+func withUserId(req *http.Request, userId string) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), userIdKey, userId))
+}
+
+// WithTargetCost returns a copy of ctx overriding the target bcrypt cost of the requests carrying it,
+// e.g. to lower the cost of the dummy hashes of unknown userids during a load test. The cost of
+// stored hashes is unaffected. It's ignored unless Options.AllowCostOverride is set.
+func WithTargetCost(ctx context.Context, cost int) context.Context {
+	return context.WithValue(ctx, costKey, cost)
+}
+
+// targetCostFromContext returns the target cost set by WithTargetCost.
+func targetCostFromContext(ctx context.Context) (int, bool) {
+	cost, ok := ctx.Value(costKey).(int)
+	return cost, ok
+}
+
+// TenantFromContext returns the tenant the userid was authenticated in.
+func TenantFromContext(ctx context.Context) (string, bool) {
+	tenant, ok := ctx.Value(tenantKey).(string)
+	return tenant, ok
+}
+
+// withTenant returns a shallow copy of req carrying tenant in its context.
+func withTenant(req *http.Request, tenant string) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), tenantKey, tenant))
+}
+
+// ScopesFromContext returns the scopes granted to the authenticated userid by a datastore.Scoped
+// data store, e.g. datastore.ScopedKeyStore.
+func ScopesFromContext(ctx context.Context) ([]string, bool) {
+	scopes, ok := ctx.Value(scopesKey).([]string)
+	return scopes, ok
+}
+
+// HasScope returns true if scope is granted to the authenticated userid.
+func HasScope(ctx context.Context, scope string) bool {
+	scopes, _ := ScopesFromContext(ctx)
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// withScopes returns a shallow copy of req carrying scopes in its context.
+func withScopes(req *http.Request, scopes []string) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), scopesKey, scopes))
+}
+
+// UpgradeRecommended returns true if the authenticated userid should upgrade their password
+// because its stored hash is weak. It's only set with Options.UpgradeAdvisory.
+func UpgradeRecommended(ctx context.Context) bool {
+	recommended, _ := ctx.Value(upgradeKey).(bool)
+	return recommended
+}
+
+// withUpgradeRecommended returns a shallow copy of req telling that its userid should upgrade their password.
+func withUpgradeRecommended(req *http.Request) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), upgradeKey, true))
+}
@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultCookieName   = "negroni-auth"
+	defaultCookieMaxAge = 15 * time.Minute
+)
+
+// SessionCookie configures a short-lived HMAC-signed cookie issued after a successful
+// Basic authentication. Requests carrying a valid cookie are authenticated without the
+// Authorization header, so browsers aren't prompted again until the cookie expires.
+type SessionCookie struct {
+	// Key signs the cookie. It must be kept secret and be at least 32 random bytes; the
+	// middleware constructors refuse shorter keys.
+	Key []byte
+	// Name of the cookie; "negroni-auth" if empty.
+	Name string
+	// Path of the cookie; "/" if empty.
+	Path string
+	// MaxAge is the lifetime of the cookie; 15 minutes if zero.
+	MaxAge time.Duration
+
+	now func() time.Time
+}
+
+func (c *SessionCookie) name() string {
+	if c.Name == "" {
+		return defaultCookieName
+	}
+	return c.Name
+}
+
+func (c *SessionCookie) clock() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
+
+// sign returns the MAC of payload issued in tenant.
+func (c *SessionCookie) sign(payload, tenant string) []byte {
+	mac := hmac.New(sha256.New, c.Key)
+	mac.Write([]byte(tenant))
+	mac.Write([]byte{0})
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// set writes a cookie authenticating userId in tenant to w.
+func (c *SessionCookie) set(w http.ResponseWriter, userId, tenant string) {
+	maxAge := c.MaxAge
+	if maxAge <= 0 {
+		maxAge = defaultCookieMaxAge
+	}
+	path := c.Path
+	if path == "" {
+		path = "/"
+	}
+
+	expires := c.clock().Add(maxAge)
+	payload := base64.RawURLEncoding.EncodeToString([]byte(userId)) + "." + strconv.FormatInt(expires.Unix(), 10)
+	http.SetCookie(w, &http.Cookie{
+		Name:     c.name(),
+		Value:    payload + "." + base64.RawURLEncoding.EncodeToString(c.sign(payload, tenant)),
+		Path:     path,
+		Expires:  expires,
+		MaxAge:   int(maxAge / time.Second),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// userId returns the userid of a valid, unexpired cookie of req issued in tenant.
+func (c *SessionCookie) userId(req *http.Request, tenant string) (string, bool) {
+	cookie, err := req.Cookie(c.name())
+	if err != nil {
+		return "", false
+	}
+
+	i := strings.LastIndex(cookie.Value, ".")
+	if i < 0 {
+		return "", false
+	}
+	payload := cookie.Value[:i]
+	sig, err := base64.RawURLEncoding.DecodeString(cookie.Value[i+1:])
+	if err != nil || !hmac.Equal(sig, c.sign(payload, tenant)) {
+		return "", false
+	}
+
+	parts := strings.SplitN(payload, ".", 2)
+	if len(parts) != 2 {
+		return "", false
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || c.clock().Unix() >= expires {
+		return "", false
+	}
+	userId, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || len(userId) == 0 {
+		return "", false
+	}
+
+	return string(userId), true
+}
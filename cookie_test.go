@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/codegangsta/negroni"
+)
+
+func Test_SessionCookie(t *testing.T) {
+	auth := "Basic " + base64.StdEncoding.EncodeToString([]byte("foo:bar"))
+	ds, err := NewSimpleBasic("foo", "bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	sc := &SessionCookie{Key: []byte("0123456789abcdef0123456789abcdef"), MaxAge: time.Minute}
+	sc.now = func() time.Time { return now }
+
+	h := http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		userId, _ := UserIdFromContext(req.Context())
+		res.Write([]byte("hello " + userId))
+	})
+	m := negroni.New()
+	m.Use(NewBasicWithOptions(ds, Options{SessionCookie: sc}))
+	m.UseHandler(h)
+
+	// Successful Basic auth issues the cookie.
+	r, _ := http.NewRequest("GET", "foo", nil)
+	r.Header.Set("Authorization", auth)
+	recorder := httptest.NewRecorder()
+	m.ServeHTTP(recorder, r)
+
+	cookies := recorder.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatal("Expected a session cookie, got: ", cookies)
+	}
+	cookie := cookies[0]
+	if !cookie.HttpOnly || !cookie.Secure {
+		t.Error("Expected HttpOnly, Secure cookie")
+	}
+
+	// Cookie alone authenticates.
+	r, _ = http.NewRequest("GET", "foo", nil)
+	r.AddCookie(cookie)
+	recorder = httptest.NewRecorder()
+	m.ServeHTTP(recorder, r)
+
+	if recorder.Body.String() != "hello foo" {
+		t.Error("Cookie auth failed, got: ", recorder.Body.String())
+	}
+
+	// Tampered cookie is rejected.
+	tampered := *cookie
+	tampered.Value = base64.RawURLEncoding.EncodeToString([]byte("admin")) + tampered.Value[len("Zm9v"):]
+	r, _ = http.NewRequest("GET", "foo", nil)
+	r.AddCookie(&tampered)
+	recorder = httptest.NewRecorder()
+	m.ServeHTTP(recorder, r)
+
+	if recorder.Code != 401 {
+		t.Error("Tampered cookie accepted")
+	}
+
+	// Expired cookie is rejected.
+	now = now.Add(2 * time.Minute)
+	r, _ = http.NewRequest("GET", "foo", nil)
+	r.AddCookie(cookie)
+	recorder = httptest.NewRecorder()
+	m.ServeHTTP(recorder, r)
+
+	if recorder.Code != 401 {
+		t.Error("Expired cookie accepted")
+	}
+}
+
+func Test_SessionCookieShortKey(t *testing.T) {
+	var keytests = []struct {
+		key   []byte
+		valid bool
+	}{
+		{nil, false},
+		{[]byte("0123456789abcdef0123456789abcde"), false},
+		{[]byte("0123456789abcdef0123456789abcdef"), true},
+	}
+	for _, tt := range keytests {
+		opts := Options{SessionCookie: &SessionCookie{Key: tt.key}}
+
+		b, err := NewCachedBasic(&MockDataStore{}, time.Minute, time.Minute, opts)
+		if tt.valid != (err == nil) {
+			t.Errorf("Expected valid %v for a key of %d bytes, got %v", tt.valid, len(tt.key), err)
+		}
+		if b != nil {
+			b.Close()
+		}
+
+		func() {
+			defer func() {
+				if r := recover(); tt.valid != (r == nil) {
+					t.Errorf("Expected valid %v for a key of %d bytes, got panic %v", tt.valid, len(tt.key), r)
+				}
+			}()
+			NewBasicWithOptions(&MockDataStore{}, opts)
+		}()
+	}
+}
+
+func Test_SessionCookieDenied(t *testing.T) {
+	ds, err := NewSimpleBasic("foo", "bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc := &SessionCookie{Key: []byte("0123456789abcdef0123456789abcdef")}
+	issued := httptest.NewRecorder()
+	sc.set(issued, "foo", "")
+	cookie := issued.Result().Cookies()[0]
+
+	opts := Options{SessionCookie: sc, Logger: &recordingLogger{}}
+	cached, err := NewCachedBasic(ds, time.Minute, time.Minute, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cached.Close()
+
+	var deniedtests = []struct {
+		name string
+		mw   negroni.Handler
+	}{
+		{"basic", NewBasicWithOptions(ds, opts)},
+		{"cached", cached},
+	}
+	for _, tt := range deniedtests {
+		called := false
+		m := negroni.New()
+		m.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		}))
+		m.Use(tt.mw)
+		m.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			called = true
+		}))
+
+		r, _ := http.NewRequest("GET", "foo", nil)
+		r.AddCookie(cookie)
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, r)
+
+		if called || recorder.Code != http.StatusForbidden {
+			t.Errorf("Expected %s to stop a denied request with a valid cookie, got %d (next called %v)", tt.name, recorder.Code, called)
+		}
+		if cookies := recorder.Result().Cookies(); len(cookies) != 0 {
+			t.Errorf("Expected %s not to set the cookie again, got %v", tt.name, cookies)
+		}
+	}
+}
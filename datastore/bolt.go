@@ -0,0 +1,140 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordCost is the bcrypt cost of hashes written by SetPassword, the same as auth.Hash.
+const passwordCost = 12
+
+// BoltStore is a data store persisting password hashes in a bucket of a local bbolt database,
+// for single-binary deployments without an external service.
+// The database is opened once and reused. Reads run in concurrent read-only transactions,
+// so occasional writes don't block request-time lookups for long.
+// This struct implement Datastore and ErrDatastore interface and is safe for concurrent use.
+type BoltStore struct {
+	db     *bolt.DB
+	bucket []byte
+}
+
+// NewBoltStore opens (or creates) the bbolt database at path and the bucket in it.
+// bbolt locks the file, so only one process can open it at a time; NewBoltStore gives up
+// after a second if another holds the lock.
+func NewBoltStore(path, bucket string) (*BoltStore, error) {
+	if bucket == "" {
+		return nil, errors.New("datastore: empty bucket name")
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	s := &BoltStore{db: db, bucket: []byte(bucket)}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(s.bucket)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// BoltStore.GetContext returns value using key.
+func (s *BoltStore) GetContext(ctx context.Context, key string) ([]byte, bool, error) {
+	var value []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		// Values are only valid within the transaction.
+		if v := tx.Bucket(s.bucket).Get([]byte(key)); v != nil {
+			value = append([]byte(nil), v...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return value, value != nil, nil
+}
+
+// BoltStore.Get returns value using key, treating database errors as not found.
+func (s *BoltStore) Get(key string) ([]byte, bool) {
+	value, found, err := s.GetContext(context.Background(), key)
+	if err != nil {
+		return nil, false
+	}
+	return value, found
+}
+
+// BoltStore.Set stores value, e.g. a bcrypt hash, as the value of key.
+func (s *BoltStore) Set(key string, value []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(s.bucket).Put([]byte(key), value)
+	})
+}
+
+// BoltStore.SetPassword stores the bcrypt hash of password as the value of userId.
+func (s *BoltStore) SetPassword(userId, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), passwordCost)
+	if err != nil {
+		return err
+	}
+	return s.Set(userId, hashedPassword)
+}
+
+// BoltStore.Import stores the users read from r, e.g. to onboard thousands of users at once.
+// r holds either CSV rows of userid and password, with an optional "userId,password" header,
+// or JSONL objects with "userId" and "password". Passwords which are bcrypt hashes are stored
+// as is; others are hashed like SetPassword. Records are written in transactions of
+// 100 records. Users whose stored hash already matches are skipped, so an interrupted
+// import can be run again. Returns the number of users written and, if some records
+// were skipped as malformed, an ImportError.
+func (s *BoltStore) Import(r io.Reader) (int, error) {
+	return importInto(r, s.Get, func(records []importRecord) error {
+		return s.db.Update(func(tx *bolt.Tx) error {
+			b := tx.Bucket(s.bucket)
+			for _, rec := range records {
+				if err := b.Put([]byte(rec.userId), rec.value); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+}
+
+// BoltStore.Delete removes key.
+func (s *BoltStore) Delete(key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(s.bucket).Delete([]byte(key))
+	})
+}
+
+// BoltStore.UserIds returns the keys of the bucket in sorted order, or nil if the database fails.
+func (s *BoltStore) UserIds() []string {
+	var keys []string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		// Keys are only valid within the transaction.
+		return tx.Bucket(s.bucket).ForEach(func(k, v []byte) error {
+			keys = append(keys, string(k))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// BoltStore.Close closes the database. It implements io.Closer.
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
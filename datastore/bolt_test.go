@@ -0,0 +1,67 @@
+package datastore
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func Test_BoltStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth.db")
+
+	s, err := NewBoltStore(path, "users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetPassword("foo", "bar"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set("baz", []byte("hash")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Values survive reopening.
+	s, err = NewBoltStore(path, "users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	value, found := s.Get("foo")
+	if !found {
+		t.Fatal("Expected foo to be found")
+	}
+	if err := bcrypt.CompareHashAndPassword(value, []byte("bar")); err != nil {
+		t.Error("Expected a bcrypt hash of the password: ", err)
+	}
+
+	if err := s.Delete("baz"); err != nil {
+		t.Fatal(err)
+	}
+	var boltstoretests = []struct {
+		key   string
+		found bool
+	}{
+		{"foo", true},
+		{"baz", false},
+		{"qux", false},
+	}
+	for _, tt := range boltstoretests {
+		if _, found := s.Get(tt.key); found != tt.found {
+			t.Errorf("Expected found %v for %s, got %v", tt.found, tt.key, found)
+		}
+	}
+
+	if userIds := s.UserIds(); !reflect.DeepEqual(userIds, []string{"foo"}) {
+		t.Errorf("Expected [foo], got %q", userIds)
+	}
+
+	if _, err := NewBoltStore(filepath.Join(t.TempDir(), "auth.db"), ""); err == nil {
+		t.Error("Expected an error for an empty bucket name")
+	}
+}
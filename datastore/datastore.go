@@ -1,11 +1,94 @@
 // Package datastore implements datastore (key, value pair) interface.
 package datastore
 
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"sort"
+)
+
 // Datastore is an interface for retrieving value using key.
 type Datastore interface {
 	Get(key string) (value []byte, found bool)
 }
 
+// ErrDatastore is an interface for retrieving value using key from data stores which can fail,
+// e.g. network-backed stores. Lookups should give up when ctx is done.
+// An error means the store couldn't tell whether key exists; it is not a "not found".
+type ErrDatastore interface {
+	GetContext(ctx context.Context, key string) (value []byte, found bool, err error)
+}
+
+// TenantDatastore is an interface for retrieving value using key within a tenant,
+// so the same key can exist under different tenants.
+type TenantDatastore interface {
+	GetTenant(tenant, key string) (value []byte, found bool)
+}
+
+// RateLimit is a token bucket spec: Rate requests per second are allowed on average,
+// with bursts of up to Burst requests. The zero RateLimit allows no requests.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// RateLimited is an optional interface for data stores which keep a request budget
+// per key, e.g. to give service accounts a larger one than people.
+// found is false for keys without a limit.
+type RateLimited interface {
+	RateLimit(key string) (limit RateLimit, found bool)
+}
+
+// Versioned is an optional interface for data stores which keep a version per key that changes
+// whenever the value of the key does, e.g. when a password is rotated. Caches of authentications
+// include the version in their keys, so a rotation invalidates all cached authentications of the key.
+type Versioned interface {
+	GetWithVersion(key string) (value []byte, version uint64, found bool)
+}
+
+// Scoped is an optional interface for data stores granting scopes to keys, e.g. "read" or "write"
+// of an API key. The middleware puts the scopes of the authenticated key into the request context.
+type Scoped interface {
+	Scopes(key string) []string
+}
+
+// Lister is an optional interface for data stores which can list their keys, e.g. for an admin UI
+// showing who can authenticate. UserIds returns a sorted copy; values are never exposed.
+type Lister interface {
+	UserIds() []string
+}
+
+// sortedKeys returns the keys of values in sorted order.
+func sortedKeys(values map[string][]byte) []string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Counted is an optional interface for data stores which know how many keys they hold,
+// e.g. to warn about a store loaded from an empty or mis-pathed config.
+type Counted interface {
+	Len() int
+}
+
+// Validatable is an optional interface for data stores which can check their
+// configuration or connectivity, e.g. at startup.
+type Validatable interface {
+	Validate() error
+}
+
+// HealthChecker is an optional interface for network-backed data stores, password verifiers
+// and token stores which can tell whether their backend is reachable, e.g. for a readiness endpoint.
+// HealthCheck should give up when ctx is done.
+type HealthChecker interface {
+	HealthCheck(ctx context.Context) error
+}
+
 // Simple is a simple struct stores only one key, value pair.
 // This struct implement Datastore interface.
 type Simple struct {
@@ -13,10 +96,29 @@ type Simple struct {
 	Value []byte
 }
 
-// Simple.Get returns value using key.
+// Simple.Get returns value using key. The keys are compared with Simple.Match.
 func (d *Simple) Get(key string) ([]byte, bool) {
-	if key == d.Key {
+	if d.Match(key) {
 		return d.Value, true
 	}
 	return nil, false
 }
+
+// Simple.Match returns true if key is the key of d. It takes the same time wherever key differs,
+// and comparing SHA-256 digests hides the length of the key too, so the time taken for a wrong
+// userid tells nothing about the right one.
+func (d *Simple) Match(key string) bool {
+	got, want := sha256.Sum256([]byte(key)), sha256.Sum256([]byte(d.Key))
+	return subtle.ConstantTimeCompare(got[:], want[:]) == 1
+}
+
+// Simple.Validate returns an error if the key or the value is empty.
+func (d *Simple) Validate() error {
+	if d.Key == "" {
+		return errors.New("datastore: empty key")
+	}
+	if len(d.Value) == 0 {
+		return errors.New("datastore: empty value")
+	}
+	return nil
+}
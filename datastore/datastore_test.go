@@ -0,0 +1,89 @@
+package datastore
+
+import (
+	"reflect"
+	"testing"
+)
+
+var simplevalidatetests = []struct {
+	ds  Simple
+	val bool
+}{
+	{Simple{"foo", []byte("hash")}, true},
+	{Simple{"", []byte("hash")}, false},
+	{Simple{"foo", nil}, false},
+}
+
+func Test_SimpleValidate(t *testing.T) {
+	for _, tt := range simplevalidatetests {
+		if err := tt.ds.Validate(); (err == nil) != tt.val {
+			t.Errorf("Expected Validate() of %+v to succeed %v, got %v", tt.ds, tt.val, err)
+		}
+	}
+}
+
+var simplegettests = []struct {
+	key   string
+	found bool
+}{
+	{"foo", true},
+	{"fo", false},
+	{"foobar", false},
+	{"", false},
+}
+
+func Test_SimpleGet(t *testing.T) {
+	d := &Simple{"foo", []byte("hash")}
+	for _, tt := range simplegettests {
+		if value, found := d.Get(tt.key); found != tt.found || (found && string(value) != "hash") {
+			t.Errorf("Expected found %v for %q, got %v with %q", tt.found, tt.key, found, value)
+		}
+	}
+}
+
+func Test_Map(t *testing.T) {
+	values := map[string][]byte{"foo": []byte("hash")}
+	m := NewMap(values)
+	// The map is copied.
+	values["bar"] = []byte("hash")
+
+	if _, found := m.Get("bar"); found {
+		t.Error("Expected bar not to be found")
+	}
+	m.Set("baz", []byte("hash2"))
+	if value, found := m.Get("baz"); !found || string(value) != "hash2" {
+		t.Errorf("Expected hash2 for baz, got %q", value)
+	}
+	m.Set("baz", []byte("hash3"))
+	if value, version, found := m.GetWithVersion("baz"); !found || string(value) != "hash3" || version != 2 {
+		t.Errorf("Expected hash3 at version 2 for baz, got %q at version %d", value, version)
+	}
+	if _, version, _ := m.GetWithVersion("foo"); version != 0 {
+		t.Errorf("Expected version 0 for foo, got %d", version)
+	}
+
+	// The listed userids are a copy.
+	userIds := m.UserIds()
+	if !reflect.DeepEqual(userIds, []string{"baz", "foo"}) {
+		t.Errorf("Expected [baz foo], got %q", userIds)
+	}
+	userIds[0] = "qux"
+	if _, found := m.Get("qux"); found || m.UserIds()[0] != "baz" {
+		t.Error("Expected the listed userids not to alias the map")
+	}
+
+	var mapvalidatetests = []struct {
+		ds  *Map
+		val bool
+	}{
+		{m, true},
+		{NewMap(nil), false},
+		{NewMap(map[string][]byte{"": []byte("hash")}), false},
+		{NewMap(map[string][]byte{"foo": nil}), false},
+	}
+	for _, tt := range mapvalidatetests {
+		if err := tt.ds.Validate(); (err == nil) != tt.val {
+			t.Errorf("Expected Validate() to succeed %v, got %v", tt.val, err)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package datastore
+
+import (
+	"context"
+	"fmt"
+)
+
+// Fallback is a data store which consults a fallback store only when the primary store fails,
+// e.g. a read replica or a local copy behind DynamoDB. A "not found" from the primary store is
+// final, so deleted users aren't granted access by a stale fallback.
+// This struct implement Datastore, ErrDatastore and HealthChecker interface.
+type Fallback struct {
+	Primary  ErrDatastore
+	Fallback ErrDatastore
+}
+
+// NewFallbackStore returns *Fallback consulting fallback when primary fails.
+func NewFallbackStore(primary, fallback ErrDatastore) *Fallback {
+	return &Fallback{
+		Primary:  primary,
+		Fallback: fallback,
+	}
+}
+
+// Fallback.GetContext returns value using key from the primary store, or from the fallback
+// store if the primary store fails. Returns an error if both fail.
+func (d *Fallback) GetContext(ctx context.Context, key string) ([]byte, bool, error) {
+	value, found, err := d.Primary.GetContext(ctx, key)
+	if err == nil {
+		return value, found, nil
+	}
+
+	value, found, fallbackErr := d.Fallback.GetContext(ctx, key)
+	if fallbackErr != nil {
+		return nil, false, fmt.Errorf("datastore: primary: %v, fallback: %w", err, fallbackErr)
+	}
+	return value, found, nil
+}
+
+// Fallback.Get returns value using key, treating errors as not found.
+func (d *Fallback) Get(key string) ([]byte, bool) {
+	value, found, err := d.GetContext(context.Background(), key)
+	if err != nil {
+		return nil, false
+	}
+	return value, found
+}
+
+// Fallback.HealthCheck checks the stores implementing HealthChecker. The store is healthy
+// as long as one of them is, since the fallback store covers outages of the primary store.
+func (d *Fallback) HealthCheck(ctx context.Context) error {
+	err := healthCheck(ctx, d.Primary)
+	if err == nil {
+		return nil
+	}
+	if fallbackErr := healthCheck(ctx, d.Fallback); fallbackErr != nil {
+		return fmt.Errorf("datastore: primary: %v, fallback: %w", err, fallbackErr)
+	}
+	return nil
+}
+
+// healthCheck checks ds if it implements HealthChecker.
+func healthCheck(ctx context.Context, ds interface{}) error {
+	if h, ok := ds.(HealthChecker); ok {
+		return h.HealthCheck(ctx)
+	}
+	return nil
+}
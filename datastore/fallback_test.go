@@ -0,0 +1,78 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// mockErrDatastore returns Err, or the value of key from Values.
+type mockErrDatastore struct {
+	Values map[string][]byte
+	Err    error
+	calls  int
+}
+
+func (d *mockErrDatastore) GetContext(ctx context.Context, key string) ([]byte, bool, error) {
+	d.calls++
+	if d.Err != nil {
+		return nil, false, d.Err
+	}
+	value, found := d.Values[key]
+	return value, found, nil
+}
+
+// HealthCheck fails with Err.
+func (d *mockErrDatastore) HealthCheck(ctx context.Context) error {
+	return d.Err
+}
+
+func Test_FallbackHealthCheck(t *testing.T) {
+	down := errors.New("down")
+
+	var fallbackhealthtests = []struct {
+		primary, fallback *mockErrDatastore
+		healthy           bool
+	}{
+		{&mockErrDatastore{}, &mockErrDatastore{}, true},
+		{&mockErrDatastore{Err: down}, &mockErrDatastore{}, true},
+		{&mockErrDatastore{}, &mockErrDatastore{Err: down}, true},
+		{&mockErrDatastore{Err: down}, &mockErrDatastore{Err: down}, false},
+	}
+	for i, tt := range fallbackhealthtests {
+		err := NewFallbackStore(tt.primary, tt.fallback).HealthCheck(context.Background())
+		if tt.healthy != (err == nil) {
+			t.Errorf("Expected healthy %v in case %d, got %v", tt.healthy, i, err)
+		}
+	}
+}
+
+func Test_Fallback(t *testing.T) {
+	down := errors.New("down")
+
+	var fallbacktests = []struct {
+		primary, fallback *mockErrDatastore
+		value             string
+		found, err        bool
+		fallbackCalls     int
+	}{
+		// Primary answers, fallback untouched.
+		{&mockErrDatastore{Values: map[string][]byte{"foo": []byte("p")}}, &mockErrDatastore{Values: map[string][]byte{"foo": []byte("f")}}, "p", true, false, 0},
+		// Not found in primary is final.
+		{&mockErrDatastore{}, &mockErrDatastore{Values: map[string][]byte{"foo": []byte("f")}}, "", false, false, 0},
+		// Primary fails, fallback answers.
+		{&mockErrDatastore{Err: down}, &mockErrDatastore{Values: map[string][]byte{"foo": []byte("f")}}, "f", true, false, 1},
+		// Both fail.
+		{&mockErrDatastore{Err: down}, &mockErrDatastore{Err: down}, "", false, true, 1},
+	}
+	for i, tt := range fallbacktests {
+		d := NewFallbackStore(tt.primary, tt.fallback)
+		value, found, err := d.GetContext(context.Background(), "foo")
+		if string(value) != tt.value || found != tt.found || (err != nil) != tt.err {
+			t.Errorf("%d: expected %q, %v, error %v but got %q, %v, %v", i, tt.value, tt.found, tt.err, value, found, err)
+		}
+		if tt.fallback.calls != tt.fallbackCalls {
+			t.Errorf("%d: expected %d fallback calls, got %d", i, tt.fallbackCalls, tt.fallback.calls)
+		}
+	}
+}
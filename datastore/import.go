@@ -0,0 +1,216 @@
+package datastore
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// importBatchSize is the number of records hashed concurrently and written at once by Import.
+const importBatchSize = 100
+
+// ImportError combines the errors of the records Import skipped.
+type ImportError []error
+
+func (e ImportError) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return "datastore: import: " + strings.Join(msgs, "; ")
+}
+
+// importRecord is a userid and its password or bcrypt hash read by Import.
+type importRecord struct {
+	line     int
+	userId   string
+	password string
+	value    []byte
+}
+
+// importJSON is a JSONL record of Import.
+type importJSON struct {
+	UserId   string `json:"userId"`
+	Password string `json:"password"`
+}
+
+// importInto reads records from r, hashes plaintext passwords and writes them in batches with put.
+// Records whose stored value, looked up with get, already matches are skipped so that an
+// interrupted import can be run again. Returns the number of records written.
+func importInto(r io.Reader, get func(key string) ([]byte, bool), put func(records []importRecord) error) (int, error) {
+	var imported int
+	var failed ImportError
+
+	batch := make([]importRecord, 0, importBatchSize)
+	flush := func() error {
+		for _, err := range hashRecords(batch, get) {
+			failed = append(failed, err)
+		}
+		changed := batch[:0]
+		for _, rec := range batch {
+			if rec.value != nil {
+				changed = append(changed, rec)
+			}
+		}
+		if len(changed) > 0 {
+			if err := put(changed); err != nil {
+				return err
+			}
+		}
+		imported += len(changed)
+		batch = batch[:0]
+		return nil
+	}
+
+	err := readImport(r, func(rec importRecord, err error) error {
+		if err != nil {
+			failed = append(failed, err)
+			return nil
+		}
+		if batch = append(batch, rec); len(batch) == importBatchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		return imported, fmt.Errorf("datastore: import: %w", err)
+	}
+	if len(failed) > 0 {
+		return imported, failed
+	}
+	return imported, nil
+}
+
+// hashRecords sets the value of each record to write concurrently, leaving it nil if the
+// stored value already matches or the record fails; it returns the errors of failed records.
+func hashRecords(batch []importRecord, get func(key string) ([]byte, bool)) []error {
+	errs := make([]error, len(batch))
+	sem := make(chan struct{}, runtime.GOMAXPROCS(0))
+	var wg sync.WaitGroup
+	for i := range batch {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(rec *importRecord, errp *error) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			stored, found := get(rec.userId)
+			if _, err := bcrypt.Cost([]byte(rec.password)); err == nil {
+				if !found || !bytes.Equal(stored, []byte(rec.password)) {
+					rec.value = []byte(rec.password)
+				}
+				return
+			}
+			if found && bcrypt.CompareHashAndPassword(stored, []byte(rec.password)) == nil {
+				return
+			}
+			hashedPassword, err := bcrypt.GenerateFromPassword([]byte(rec.password), passwordCost)
+			if err != nil {
+				*errp = fmt.Errorf("line %d: %q: %w", rec.line, rec.userId, err)
+				return
+			}
+			rec.value = hashedPassword
+		}(&batch[i], &errs[i])
+	}
+	wg.Wait()
+
+	var failed []error
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	return failed
+}
+
+// readImport calls fn with each record of r, which holds either JSONL objects with "userId" and
+// "password" or CSV rows of userid and password with an optional "userId,password" header.
+// Malformed records are passed as errors; reading stops if fn or r fails.
+func readImport(r io.Reader, fn func(importRecord, error) error) error {
+	// Detect the format by the first byte which isn't white space, without consuming
+	// leading lines so that line numbers stay right.
+	br := bufio.NewReader(r)
+	for n := 1; ; n++ {
+		b, err := br.Peek(n)
+		if len(b) < n {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		switch b[n-1] {
+		case ' ', '\t', '\r', '\n':
+			continue
+		case '{':
+			return readImportJSON(br, fn)
+		}
+		return readImportCSV(br, fn)
+	}
+}
+
+func readImportJSON(r io.Reader, fn func(importRecord, error) error) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var rec importJSON
+		err := json.Unmarshal(scanner.Bytes(), &rec)
+		if err == nil && (rec.UserId == "" || rec.Password == "") {
+			err = errors.New("empty userId or password")
+		}
+		if err != nil {
+			err = fmt.Errorf("line %d: %w", line, err)
+		}
+		if err := fn(importRecord{line: line, userId: rec.UserId, password: rec.Password}, err); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func readImportCSV(r io.Reader, fn func(importRecord, error) error) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	for first := true; ; first = false {
+		fields, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		var parseErr *csv.ParseError
+		if err != nil && !errors.As(err, &parseErr) {
+			return err
+		}
+		var line int
+		if err == nil {
+			line, _ = cr.FieldPos(0)
+			switch {
+			case first && len(fields) == 2 && strings.EqualFold(fields[0], "userId") && strings.EqualFold(fields[1], "password"):
+				continue
+			case len(fields) != 2:
+				err = fmt.Errorf("line %d: expected 2 fields, got %d", line, len(fields))
+			case fields[0] == "" || fields[1] == "":
+				err = fmt.Errorf("line %d: empty userId or password", line)
+			}
+		}
+		rec := importRecord{line: line}
+		if err == nil {
+			rec.userId, rec.password = fields[0], fields[1]
+		}
+		if err := fn(rec, err); err != nil {
+			return err
+		}
+	}
+}
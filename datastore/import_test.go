@@ -0,0 +1,87 @@
+package datastore
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func Test_Import(t *testing.T) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := string(hashedPassword)
+
+	var importtests = []struct {
+		input    string
+		imported int
+		failed   int
+	}{
+		{"userId,password\nfoo,bar\nbaz," + hash + "\n", 2, 0},
+		{"\n  {\"userId\":\"foo\",\"password\":\"bar\"}\n\n{\"userId\":\"baz\",\"password\":\"" + hash + "\"}\n", 2, 0},
+		{"foo,bar\nbaz\n,qux\n\"quux,\n", 1, 3},
+		{"{\"userId\":\"foo\",\"password\":\"bar\"}\n{\"userId\":\"baz\"}\n{\n", 1, 2},
+		{"", 0, 0},
+	}
+	for _, tt := range importtests {
+		m := NewMap(nil)
+		imported, err := m.Import(strings.NewReader(tt.input))
+		if imported != tt.imported {
+			t.Errorf("Expected %d imported for %q, got %d", tt.imported, tt.input, imported)
+		}
+		var failed ImportError
+		if errors.As(err, &failed) {
+			if len(failed) != tt.failed {
+				t.Errorf("Expected %d failed records for %q, got %v", tt.failed, tt.input, err)
+			}
+		} else if err != nil || tt.failed != 0 {
+			t.Errorf("Expected %d failed records for %q, got %v", tt.failed, tt.input, err)
+		}
+
+		if tt.input == "" {
+			continue
+		}
+		if value, found := m.Get("foo"); !found || bcrypt.CompareHashAndPassword(value, []byte("bar")) != nil {
+			t.Errorf("Expected foo to be imported from %q, got %q", tt.input, value)
+		}
+		if value, found := m.Get("baz"); tt.failed == 0 && (!found || string(value) != hash) {
+			t.Errorf("Expected the hash of baz to be imported as is from %q, got %q", tt.input, value)
+		}
+
+		// Running the import again doesn't rewrite unchanged users.
+		if imported, _ := m.Import(strings.NewReader(tt.input)); imported != 0 {
+			t.Errorf("Expected nothing to be imported again from %q, got %d", tt.input, imported)
+		}
+	}
+}
+
+func Test_BoltStoreImport(t *testing.T) {
+	s, err := NewBoltStore(filepath.Join(t.TempDir(), "auth.db"), "users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if err := s.SetPassword("foo", "old"); err != nil {
+		t.Fatal(err)
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := "foo,bar\nbaz," + string(hashedPassword) + "\n"
+
+	if imported, err := s.Import(strings.NewReader(input)); imported != 2 || err != nil {
+		t.Errorf("Expected 2 imported, got %d, %v", imported, err)
+	}
+	if value, _ := s.Get("foo"); bcrypt.CompareHashAndPassword(value, []byte("bar")) != nil {
+		t.Error("Expected the password of foo to be replaced")
+	}
+	if imported, err := s.Import(strings.NewReader(input)); imported != 0 || err != nil {
+		t.Errorf("Expected nothing to be imported again, got %d, %v", imported, err)
+	}
+}
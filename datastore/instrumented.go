@@ -0,0 +1,82 @@
+package datastore
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// OutcomeHit is the outcome of a lookup which found the key.
+	OutcomeHit = "hit"
+	// OutcomeMiss is the outcome of a lookup which didn't find the key.
+	OutcomeMiss = "miss"
+	// OutcomeError is the outcome of a lookup which failed.
+	OutcomeError = "error"
+)
+
+// Metrics is an interface for recording lookups of instrumented data stores, e.g. with
+// Prometheus counters and histograms labelled by store and outcome.
+// ObserveLookup is called once per lookup with the name of the store, OutcomeHit, OutcomeMiss
+// or OutcomeError and the duration of the lookup. It may be called concurrently.
+type Metrics interface {
+	ObserveLookup(store, outcome string, duration time.Duration)
+}
+
+// Instrumented is a data store recording the lookups of an inner store to Metrics, so each layer
+// of stacked stores, e.g. a fallback store and the stores behind it, can be observed separately.
+// Lookups, their context and errors are passed through unchanged.
+// This struct implement Datastore, ErrDatastore and HealthChecker interface.
+type Instrumented struct {
+	Inner   ErrDatastore
+	Name    string
+	Metrics Metrics
+
+	now func() time.Time
+}
+
+// NewInstrumentedStore returns *Instrumented recording lookups of inner as name to m.
+func NewInstrumentedStore(inner ErrDatastore, name string, m Metrics) *Instrumented {
+	return &Instrumented{
+		Inner:   inner,
+		Name:    name,
+		Metrics: m,
+	}
+}
+
+func (d *Instrumented) clock() time.Time {
+	if d.now != nil {
+		return d.now()
+	}
+	return time.Now()
+}
+
+// Instrumented.GetContext returns value using key from the inner store and records the lookup.
+func (d *Instrumented) GetContext(ctx context.Context, key string) ([]byte, bool, error) {
+	start := d.clock()
+	value, found, err := d.Inner.GetContext(ctx, key)
+
+	outcome := OutcomeMiss
+	switch {
+	case err != nil:
+		outcome = OutcomeError
+	case found:
+		outcome = OutcomeHit
+	}
+	d.Metrics.ObserveLookup(d.Name, outcome, d.clock().Sub(start))
+
+	return value, found, err
+}
+
+// Instrumented.Get returns value using key, treating errors as not found.
+func (d *Instrumented) Get(key string) ([]byte, bool) {
+	value, found, err := d.GetContext(context.Background(), key)
+	if err != nil {
+		return nil, false
+	}
+	return value, found
+}
+
+// Instrumented.HealthCheck checks the inner store if it implements HealthChecker.
+func (d *Instrumented) HealthCheck(ctx context.Context) error {
+	return healthCheck(ctx, d.Inner)
+}
@@ -0,0 +1,78 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// recordingMetrics records the observed lookups as "store outcome duration".
+type recordingMetrics []string
+
+func (m *recordingMetrics) ObserveLookup(store, outcome string, duration time.Duration) {
+	*m = append(*m, store+" "+outcome+" "+duration.String())
+}
+
+type ctxKey struct{}
+
+// ctxDatastore fails unless the context carries ctxKey.
+type ctxDatastore struct{}
+
+func (ctxDatastore) GetContext(ctx context.Context, key string) ([]byte, bool, error) {
+	if ctx.Value(ctxKey{}) == nil {
+		return nil, false, errors.New("context lost")
+	}
+	return nil, false, nil
+}
+
+func Test_Instrumented(t *testing.T) {
+	down := errors.New("down")
+	m := &recordingMetrics{}
+
+	now := time.Unix(1000, 0)
+	tick := func() time.Time {
+		now = now.Add(time.Millisecond)
+		return now
+	}
+
+	primary := NewInstrumentedStore(&mockErrDatastore{Err: down}, "dynamodb", m)
+	primary.now = tick
+	replica := NewInstrumentedStore(&mockErrDatastore{Values: map[string][]byte{"foo": []byte("f")}}, "replica", m)
+	replica.now = tick
+	ds := NewInstrumentedStore(NewFallbackStore(primary, replica), "fallback", m)
+	ds.now = tick
+
+	var instrumentedtests = []struct {
+		key   string
+		value string
+		found bool
+	}{
+		{"foo", "f", true},
+		{"bar", "", false},
+	}
+	for _, tt := range instrumentedtests {
+		value, found, err := ds.GetContext(context.Background(), tt.key)
+		if err != nil || found != tt.found || string(value) != tt.value {
+			t.Errorf("Expected %q, %v for %s, got %q, %v, %v", tt.value, tt.found, tt.key, value, found, err)
+		}
+	}
+
+	want := recordingMetrics{
+		"dynamodb error 1ms", "replica hit 1ms", "fallback hit 5ms",
+		"dynamodb error 1ms", "replica miss 1ms", "fallback miss 5ms",
+	}
+	if !reflect.DeepEqual(*m, want) {
+		t.Errorf("Expected lookups %q, got %q", want, *m)
+	}
+
+	// Errors and the context are passed through.
+	if _, _, err := primary.GetContext(context.Background(), "foo"); err != down {
+		t.Errorf("Expected the error of the inner store, got %v", err)
+	}
+	ctx := context.WithValue(context.Background(), ctxKey{}, true)
+	if _, _, err := NewInstrumentedStore(ctxDatastore{}, "ctx", m).GetContext(ctx, "foo"); err != nil {
+		t.Errorf("Expected the context to be passed through, got %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+package datastore
+
+import (
+	"context"
+)
+
+const defaultLDAPPasswordAttribute = "userPassword"
+
+// LDAPDirectory is an interface for reading attribute values of a directory entry.
+// It's implemented on top of an LDAP client, typically by binding as a read-only service
+// account and searching for the entry of userId, e.g. with go-ldap:
+//
+//	req := ldap.NewSearchRequest(baseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+//		"(uid="+ldap.EscapeFilter(userId)+")", []string{attribute}, nil)
+//	res, err := conn.Search(req)
+//	...
+//	return res.Entries[0].GetRawAttributeValues(attribute), nil
+//
+// Attribute returns no values and no error if the entry doesn't exist.
+type LDAPDirectory interface {
+	Attribute(ctx context.Context, userId, attribute string) ([][]byte, error)
+}
+
+// LDAPHashStore is a data store reading password hashes from a directory attribute,
+// e.g. "{SSHA}..." or "{CRYPT}..." values of userPassword, for directories where binding
+// as the user isn't permitted. Pair it with auth.LDAPVerifier.
+// This struct implement Datastore, ErrDatastore and HealthChecker interface.
+type LDAPHashStore struct {
+	Directory LDAPDirectory
+	// Attribute holding the password hash; "userPassword" if empty.
+	Attribute string
+}
+
+// NewLDAPHashStore returns *LDAPHashStore reading userPassword from directory.
+func NewLDAPHashStore(directory LDAPDirectory) *LDAPHashStore {
+	return &LDAPHashStore{
+		Directory: directory,
+		Attribute: defaultLDAPPasswordAttribute,
+	}
+}
+
+// LDAPHashStore.GetContext returns the first value of the password attribute of key.
+func (s *LDAPHashStore) GetContext(ctx context.Context, key string) ([]byte, bool, error) {
+	attribute := s.Attribute
+	if attribute == "" {
+		attribute = defaultLDAPPasswordAttribute
+	}
+
+	values, err := s.Directory.Attribute(ctx, key, attribute)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(values) == 0 {
+		return nil, false, nil
+	}
+	return values[0], true, nil
+}
+
+// LDAPHashStore.Get returns the password hash of key, treating directory errors as not found.
+// Prefer GetContext, which lets the middleware tell errors apart.
+func (s *LDAPHashStore) Get(key string) ([]byte, bool) {
+	value, found, err := s.GetContext(context.Background(), key)
+	if err != nil {
+		return nil, false
+	}
+	return value, found
+}
+
+// LDAPHashStore.HealthCheck checks the directory if it implements HealthChecker.
+func (s *LDAPHashStore) HealthCheck(ctx context.Context) error {
+	return healthCheck(ctx, s.Directory)
+}
@@ -0,0 +1,39 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type mockLDAPDirectory map[string]map[string][][]byte
+
+func (d mockLDAPDirectory) Attribute(ctx context.Context, userId, attribute string) ([][]byte, error) {
+	if userId == "down" {
+		return nil, errors.New("ldap: connection refused")
+	}
+	return d[userId][attribute], nil
+}
+
+func Test_LDAPHashStore(t *testing.T) {
+	s := NewLDAPHashStore(mockLDAPDirectory{
+		"foo": {"userPassword": {[]byte("{SSHA}hash")}},
+		"bar": {"mail": {[]byte("bar@example.com")}},
+	})
+	ctx := context.Background()
+
+	if v, found, err := s.GetContext(ctx, "foo"); err != nil || !found || string(v) != "{SSHA}hash" {
+		t.Errorf("Expected hash of foo, got %q, %v, %v", v, found, err)
+	}
+	for _, key := range []string{"bar", "baz"} {
+		if _, found, err := s.GetContext(ctx, key); err != nil || found {
+			t.Errorf("Expected %s not to be found, got %v, %v", key, found, err)
+		}
+	}
+	if _, _, err := s.GetContext(ctx, "down"); err == nil {
+		t.Error("Expected directory error")
+	}
+	if _, found := s.Get("down"); found {
+		t.Error("Expected Get to treat errors as not found")
+	}
+}
@@ -0,0 +1,103 @@
+package datastore
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// Map is a data store holding key, value pairs in memory, e.g. a few users of a small app.
+// This struct implement Datastore and Versioned interface and is safe for concurrent use.
+type Map struct {
+	mu       sync.RWMutex
+	values   map[string][]byte
+	versions map[string]uint64
+}
+
+// NewMap returns *Map holding a copy of values.
+func NewMap(values map[string][]byte) *Map {
+	m := &Map{values: make(map[string][]byte, len(values))}
+	for k, v := range values {
+		m.values[k] = v
+	}
+	return m
+}
+
+// Map.Get returns value using key.
+func (m *Map) Get(key string) ([]byte, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	value, found := m.values[key]
+	return value, found
+}
+
+// Map.GetWithVersion returns value using key and the number of times key has been Set.
+func (m *Map) GetWithVersion(key string) ([]byte, uint64, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	value, found := m.values[key]
+	return value, m.versions[key], found
+}
+
+// Map.Set stores value as the value of key and bumps the version of key.
+func (m *Map) Set(key string, value []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.setLocked(key, value)
+}
+
+func (m *Map) setLocked(key string, value []byte) {
+	if m.values == nil {
+		m.values = make(map[string][]byte)
+	}
+	if m.versions == nil {
+		m.versions = make(map[string]uint64)
+	}
+	m.values[key] = value
+	m.versions[key]++
+}
+
+// Map.Import stores the users read from r, which holds CSV rows of userid and password
+// or JSONL objects with "userId" and "password"; see BoltStore.Import.
+func (m *Map) Import(r io.Reader) (int, error) {
+	return importInto(r, m.Get, func(records []importRecord) error {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		for _, rec := range records {
+			m.setLocked(rec.userId, rec.value)
+		}
+		return nil
+	})
+}
+
+// Map.UserIds returns the keys in sorted order.
+func (m *Map) UserIds() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return sortedKeys(m.values)
+}
+
+// Map.Len returns the number of keys.
+func (m *Map) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.values)
+}
+
+// Map.Validate returns an error if the map is empty or holds an empty key or value.
+func (m *Map) Validate() error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if len(m.values) == 0 {
+		return errors.New("datastore: empty map")
+	}
+	for k, v := range m.values {
+		if k == "" {
+			return errors.New("datastore: empty key")
+		}
+		if len(v) == 0 {
+			return errors.New("datastore: empty value")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package datastore
+
+import (
+	"bytes"
+	"sync"
+	"time"
+)
+
+// Consumable is an optional interface for data stores of single-use credentials.
+// The middleware calls Consume after value of key matched; it must atomically remove
+// the credential and return true only if it was still there, so that of concurrent
+// requests with the same credential exactly one succeeds.
+type Consumable interface {
+	Consume(key string, value []byte) bool
+}
+
+type oneTimeEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+// OneTime is a data store of single-use credentials, e.g. hashes of password reset tokens.
+// A credential is deleted by the first successful authentication and expires after its
+// lifetime if unused. Expired credentials are deleted while adding new ones.
+// This struct implement Datastore and Consumable interface and is safe for concurrent use.
+type OneTime struct {
+	mu        sync.Mutex
+	entries   map[string]oneTimeEntry
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewOneTimeStore returns an empty *OneTime.
+func NewOneTimeStore() *OneTime {
+	return &OneTime{
+		entries: make(map[string]oneTimeEntry),
+		now:     time.Now,
+	}
+}
+
+// OneTime.Add stores value, e.g. a bcrypt hash of a token, as the single-use value of key
+// for ttl, replacing a previous one.
+func (d *OneTime) Add(key string, value []byte, ttl time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	if now.Sub(d.lastSweep) >= time.Minute {
+		d.deleteExpired(now)
+		d.lastSweep = now
+	}
+	d.entries[key] = oneTimeEntry{value: value, expires: now.Add(ttl)}
+}
+
+// OneTime.Get returns value using key unless it expired.
+func (d *OneTime) Get(key string) ([]byte, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	e, found := d.entries[key]
+	if !found || !d.now().Before(e.expires) {
+		return nil, false
+	}
+	return e.value, true
+}
+
+// OneTime.Consume deletes key if its value is still value and returns whether it did.
+func (d *OneTime) Consume(key string, value []byte) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	e, found := d.entries[key]
+	if !found || !d.now().Before(e.expires) || !bytes.Equal(e.value, value) {
+		return false
+	}
+	delete(d.entries, key)
+	return true
+}
+
+// OneTime.DeleteExpired deletes all expired values.
+func (d *OneTime) DeleteExpired() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.deleteExpired(d.now())
+}
+
+func (d *OneTime) deleteExpired(now time.Time) {
+	for k, e := range d.entries {
+		if !now.Before(e.expires) {
+			delete(d.entries, k)
+		}
+	}
+}
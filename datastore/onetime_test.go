@@ -0,0 +1,45 @@
+package datastore
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_OneTime(t *testing.T) {
+	now := time.Unix(0, 0)
+	d := NewOneTimeStore()
+	d.now = func() time.Time { return now }
+
+	d.Add("foo", []byte("hash"), time.Minute)
+	d.Add("bar", []byte("hash"), time.Second)
+
+	if _, found := d.Get("foo"); !found {
+		t.Error("Expected foo to be found")
+	}
+	if d.Consume("foo", []byte("other")) {
+		t.Error("Expected a different value not to be consumed")
+	}
+	if !d.Consume("foo", []byte("hash")) {
+		t.Error("Expected foo to be consumed")
+	}
+	if d.Consume("foo", []byte("hash")) {
+		t.Error("Expected foo to be consumed only once")
+	}
+	if _, found := d.Get("foo"); found {
+		t.Error("Expected consumed foo not to be found")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, found := d.Get("bar"); found {
+		t.Error("Expected expired bar not to be found")
+	}
+	if d.Consume("bar", []byte("hash")) {
+		t.Error("Expected expired bar not to be consumed")
+	}
+
+	// Adding sweeps expired values.
+	d.Add("baz", []byte("hash"), time.Minute)
+	if n := len(d.entries); n != 1 {
+		t.Errorf("Expected 1 entry after sweeping, got %d", n)
+	}
+}
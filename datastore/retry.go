@@ -0,0 +1,94 @@
+package datastore
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// maxRetryBackoff bounds the exponential backoff between two attempts of Retry.
+const maxRetryBackoff = 10 * time.Second
+
+// Retry is a data store retrying the lookups of an inner store which fail, e.g. with transient
+// network errors, up to Attempts times in total. The backoff starts at Backoff and doubles after
+// each attempt, with jitter so that retrying clients don't synchronize. A "not found" is final.
+// Retries stop when the context is done or its deadline would pass during the backoff.
+// Wrap a circuit breaker around Retry rather than inside it, so that a lookup exhausting its
+// attempts counts as one failure.
+// This struct implement Datastore, ErrDatastore and HealthChecker interface.
+type Retry struct {
+	Inner    ErrDatastore
+	Attempts int
+	Backoff  time.Duration
+
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// NewRetryStore returns *Retry making up to attempts lookups of inner, backing off from backoff.
+func NewRetryStore(inner ErrDatastore, attempts int, backoff time.Duration) *Retry {
+	return &Retry{
+		Inner:    inner,
+		Attempts: attempts,
+		Backoff:  backoff,
+	}
+}
+
+// Retry.GetContext returns value using key from the inner store, retrying errors.
+// Returns the last error, wrapped, if all attempts fail.
+func (d *Retry) GetContext(ctx context.Context, key string) ([]byte, bool, error) {
+	backoff := d.Backoff
+	for attempt := 1; ; attempt++ {
+		value, found, err := d.Inner.GetContext(ctx, key)
+		if err == nil {
+			return value, found, nil
+		}
+		if attempt >= d.Attempts || ctx.Err() != nil {
+			return nil, false, fmt.Errorf("datastore: %d attempts: %w", attempt, err)
+		}
+
+		// Sleep between half and all of the backoff.
+		wait := backoff
+		if wait > 0 {
+			wait = backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return nil, false, fmt.Errorf("datastore: %d attempts: %w", attempt, err)
+		}
+		if sleepErr := d.sleepContext(ctx, wait); sleepErr != nil {
+			return nil, false, fmt.Errorf("datastore: %d attempts: %w", attempt, err)
+		}
+		if backoff *= 2; backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
+
+// Retry.Get returns value using key, treating errors as not found.
+func (d *Retry) Get(key string) ([]byte, bool) {
+	value, found, err := d.GetContext(context.Background(), key)
+	if err != nil {
+		return nil, false
+	}
+	return value, found
+}
+
+// Retry.HealthCheck checks the inner store if it implements HealthChecker, without retrying.
+func (d *Retry) HealthCheck(ctx context.Context) error {
+	return healthCheck(ctx, d.Inner)
+}
+
+// sleepContext waits for d or until ctx is done.
+func (d *Retry) sleepContext(ctx context.Context, wait time.Duration) error {
+	if d.sleep != nil {
+		return d.sleep(ctx, wait)
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,74 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// flakyDatastore fails the first Failures lookups, then returns the value of key from Values.
+type flakyDatastore struct {
+	Values   map[string][]byte
+	Failures int
+	calls    int
+}
+
+var errFlaky = errors.New("connection reset")
+
+func (d *flakyDatastore) GetContext(ctx context.Context, key string) ([]byte, bool, error) {
+	d.calls++
+	if d.calls <= d.Failures {
+		return nil, false, errFlaky
+	}
+	value, found := d.Values[key]
+	return value, found, nil
+}
+
+func Test_Retry(t *testing.T) {
+	var retrytests = []struct {
+		failures int
+		key      string
+		found    bool
+		err      bool
+		calls    int
+	}{
+		{0, "foo", true, false, 1},
+		{2, "foo", true, false, 3},
+		// A "not found" isn't retried.
+		{0, "bar", false, false, 1},
+		{5, "foo", false, true, 3},
+	}
+	for _, tt := range retrytests {
+		inner := &flakyDatastore{Values: map[string][]byte{"foo": []byte("hash")}, Failures: tt.failures}
+		d := NewRetryStore(inner, 3, 100*time.Millisecond)
+		var waits []time.Duration
+		d.sleep = func(ctx context.Context, wait time.Duration) error {
+			waits = append(waits, wait)
+			return nil
+		}
+
+		_, found, err := d.GetContext(context.Background(), tt.key)
+		if found != tt.found || (err != nil) != tt.err || inner.calls != tt.calls {
+			t.Errorf("Expected found %v, error %v after %d calls with %d failures, got %v, %v after %d", tt.found, tt.err, tt.calls, tt.failures, found, err, inner.calls)
+		}
+		if tt.err && !errors.Is(err, errFlaky) {
+			t.Errorf("Expected the last error to be wrapped, got %v", err)
+		}
+		// The backoff doubles, with jitter.
+		for i, wait := range waits {
+			backoff := 100 * time.Millisecond << i
+			if wait < backoff/2 || wait > backoff {
+				t.Errorf("Expected wait %d between %v and %v, got %v", i, backoff/2, backoff, wait)
+			}
+		}
+	}
+
+	// Retries stop when the deadline would pass during the backoff.
+	inner := &flakyDatastore{Failures: 5}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, _, err := NewRetryStore(inner, 3, time.Second).GetContext(ctx, "foo"); err == nil || inner.calls != 1 {
+		t.Errorf("Expected an error after 1 call, got %v after %d", err, inner.calls)
+	}
+}
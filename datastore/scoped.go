@@ -0,0 +1,84 @@
+package datastore
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// ScopedKeyStore is a data store of API keys with scopes, e.g. issued for a public API.
+// This struct implement Datastore and Scoped interface and is safe for concurrent use.
+type ScopedKeyStore struct {
+	keys map[string]scopedKey
+}
+
+type scopedKey struct {
+	hash   []byte
+	scopes []string
+}
+
+// NewScopedKeyStore returns *ScopedKeyStore read from CSV rows of key id, hash of the secret and
+// scopes separated by spaces, e.g. `key1,$2a$12$...,read write`. A "keyId,secretHash,scopes"
+// header and lines starting with "#" are skipped. Returns an error naming the line of the
+// first invalid row.
+func NewScopedKeyStore(r io.Reader) (*ScopedKeyStore, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = 3
+
+	s := &ScopedKeyStore{keys: make(map[string]scopedKey)}
+	for first := true; ; first = false {
+		fields, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("datastore: scoped keys: %w", err)
+		}
+		if first && strings.EqualFold(fields[0], "keyId") && strings.EqualFold(fields[1], "secretHash") {
+			continue
+		}
+
+		line, _ := cr.FieldPos(0)
+		switch {
+		case fields[0] == "" || fields[1] == "":
+			return nil, fmt.Errorf("datastore: scoped keys: line %d: empty key id or hash", line)
+		case s.keys[fields[0]].hash != nil:
+			return nil, fmt.Errorf("datastore: scoped keys: line %d: duplicate key id %q", line, fields[0])
+		}
+		s.keys[fields[0]] = scopedKey{hash: []byte(fields[1]), scopes: strings.Fields(fields[2])}
+	}
+	if len(s.keys) == 0 {
+		return nil, errors.New("datastore: scoped keys: no keys")
+	}
+	return s, nil
+}
+
+// ScopedKeyStore.Get returns the hash of the secret of key.
+func (s *ScopedKeyStore) Get(key string) ([]byte, bool) {
+	k, found := s.keys[key]
+	return k.hash, found
+}
+
+// ScopedKeyStore.UserIds returns the key ids in sorted order.
+func (s *ScopedKeyStore) UserIds() []string {
+	keyIds := make([]string, 0, len(s.keys))
+	for keyId := range s.keys {
+		keyIds = append(keyIds, keyId)
+	}
+	sort.Strings(keyIds)
+	return keyIds
+}
+
+// ScopedKeyStore.Len returns the number of keys.
+func (s *ScopedKeyStore) Len() int {
+	return len(s.keys)
+}
+
+// ScopedKeyStore.Scopes returns the scopes granted to key.
+func (s *ScopedKeyStore) Scopes(key string) []string {
+	return s.keys[key].scopes
+}
@@ -0,0 +1,46 @@
+package datastore
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_ScopedKeyStore(t *testing.T) {
+	s, err := NewScopedKeyStore(strings.NewReader("keyId,secretHash,scopes\n# revoked: key0\nkey1,hash1,read write\nkey2,hash2,\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var scopedtests = []struct {
+		key    string
+		hash   string
+		found  bool
+		scopes []string
+	}{
+		{"key1", "hash1", true, []string{"read", "write"}},
+		{"key2", "hash2", true, []string{}},
+		{"key0", "", false, nil},
+	}
+	for _, tt := range scopedtests {
+		hash, found := s.Get(tt.key)
+		if string(hash) != tt.hash || found != tt.found {
+			t.Errorf("Expected %q, %v for %s, got %q, %v", tt.hash, tt.found, tt.key, hash, found)
+		}
+		if scopes := s.Scopes(tt.key); !reflect.DeepEqual(scopes, tt.scopes) {
+			t.Errorf("Expected scopes %q for %s, got %q", tt.scopes, tt.key, scopes)
+		}
+	}
+
+	var invalidtests = []string{
+		"",
+		"key1,hash1\n",
+		"key1,,read\n",
+		"key1,hash1,read\nkey1,hash2,write\n",
+	}
+	for _, input := range invalidtests {
+		if _, err := NewScopedKeyStore(strings.NewReader(input)); err == nil {
+			t.Errorf("Expected an error for %q", input)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package datastore
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// kubernetesDataDir is the symlink Kubernetes atomically swaps when a mounted secret is updated.
+const kubernetesDataDir = "..data"
+
+// SecretsDir is a data store backed by a directory with one file per key, as Docker and
+// Kubernetes mount secrets. The file name is the key (userid) and its content the value
+// (bcrypt hash). Files are read into memory by Reload, so lookups never touch the file system.
+// This struct implement Datastore interface and is safe for concurrent use.
+type SecretsDir struct {
+	dir string
+
+	mu     sync.RWMutex
+	values map[string][]byte
+
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewSecretsDir returns *SecretsDir loaded from dir.
+func NewSecretsDir(dir string) (*SecretsDir, error) {
+	d := &SecretsDir{
+		dir:  dir,
+		stop: make(chan struct{}),
+	}
+	if err := d.Reload(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// SecretsDir.Get returns value using key.
+func (d *SecretsDir) Get(key string) ([]byte, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	value, found := d.values[key]
+	return value, found
+}
+
+// SecretsDir.Reload reads the directory again and atomically replaces the loaded values.
+// Kubernetes' "..data" symlink is resolved once, so the values come from a single
+// version of the secret even if it's swapped while reading.
+// On error, the previously loaded values are kept.
+func (d *SecretsDir) Reload() error {
+	dir := d.dir
+	if resolved, err := filepath.EvalSymlinks(filepath.Join(d.dir, kubernetesDataDir)); err == nil {
+		dir = resolved
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	values := make(map[string][]byte, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		// Skip Kubernetes' bookkeeping entries and other hidden files.
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		// ReadFile follows symlinks, which Kubernetes uses for every key.
+		path := filepath.Join(dir, name)
+		value, err := os.ReadFile(path)
+		if err != nil {
+			if fi, statErr := os.Stat(path); statErr == nil && fi.IsDir() {
+				continue
+			}
+			return err
+		}
+		values[name] = bytes.TrimSpace(value)
+	}
+
+	d.mu.Lock()
+	d.values = values
+	d.mu.Unlock()
+	return nil
+}
+
+// SecretsDir.Watch reloads the directory every interval in the background until Close is called,
+// to pick up rotated secrets without restart.
+func (d *SecretsDir) Watch(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				// Keep serving the last good values if the directory is unreadable.
+				d.Reload()
+			case <-d.stop:
+				return
+			}
+		}
+	}()
+}
+
+// SecretsDir.Close stops watching the directory. It implements io.Closer.
+func (d *SecretsDir) Close() error {
+	d.closeOnce.Do(func() {
+		close(d.stop)
+	})
+	return nil
+}
+
+// SecretsDir.UserIds returns the loaded keys in sorted order.
+func (d *SecretsDir) UserIds() []string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return sortedKeys(d.values)
+}
+
+// SecretsDir.Len returns the number of loaded keys.
+func (d *SecretsDir) Len() int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return len(d.values)
+}
+
+// SecretsDir.Validate returns an error if no key is loaded.
+func (d *SecretsDir) Validate() error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if len(d.values) == 0 {
+		return errors.New("datastore: no secrets in " + d.dir)
+	}
+	return nil
+}
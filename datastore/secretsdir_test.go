@@ -0,0 +1,93 @@
+package datastore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeSecretVersion lays out secrets the way Kubernetes does and atomically swaps them in.
+func writeSecretVersion(t *testing.T, dir, version string, secrets map[string]string) {
+	versionDir := filepath.Join(dir, version)
+	if err := os.Mkdir(versionDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range secrets {
+		if err := os.WriteFile(filepath.Join(versionDir, k), []byte(v), 0644); err != nil {
+			t.Fatal(err)
+		}
+		link := filepath.Join(dir, k)
+		if _, err := os.Lstat(link); os.IsNotExist(err) {
+			if err := os.Symlink(filepath.Join(kubernetesDataDir, k), link); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	tmp := filepath.Join(dir, "..data_tmp")
+	if err := os.Symlink(version, tmp); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, kubernetesDataDir)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_SecretsDir(t *testing.T) {
+	dir := t.TempDir()
+	writeSecretVersion(t, dir, "..v1", map[string]string{"foo": "hash1\n", "bar": "hash2"})
+
+	d, err := NewSecretsDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	if v, found := d.Get("foo"); !found || string(v) != "hash1" {
+		t.Errorf("Expected hash1, got %q", v)
+	}
+	if _, found := d.Get(kubernetesDataDir); found {
+		t.Error("Expected bookkeeping entries to be skipped")
+	}
+	if err := d.Validate(); err != nil {
+		t.Error("Validate failed: ", err)
+	}
+
+	writeSecretVersion(t, dir, "..v2", map[string]string{"foo": "hash3", "bar": "hash2"})
+	d.Watch(time.Millisecond)
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if v, _ := d.Get("foo"); string(v) == "hash3" {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Error("Rotated secret not picked up")
+}
+
+func Test_SecretsDirPlain(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "foo"), []byte("hash1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := NewSecretsDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, found := d.Get("foo"); !found || string(v) != "hash1" {
+		t.Errorf("Expected hash1, got %q", v)
+	}
+	if _, found := d.Get("sub"); found {
+		t.Error("Expected directories to be skipped")
+	}
+
+	if _, err := NewSecretsDir(filepath.Join(dir, "missing")); err == nil {
+		t.Error("Expected missing directory to fail")
+	}
+}
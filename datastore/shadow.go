@@ -0,0 +1,71 @@
+package datastore
+
+import (
+	"context"
+)
+
+// Shadowed is an optional interface for data stores running a shadow store alongside,
+// e.g. while migrating to it. The middleware verifies the password against the value
+// of ShadowGetContext too and calls Mismatch when its decision differs from the one
+// made with the value of GetContext, which alone decides.
+type Shadowed interface {
+	ShadowGetContext(ctx context.Context, key string) (value []byte, found bool, err error)
+	Mismatch(key string, authOK, shadowOK bool)
+}
+
+// Shadow is a data store whose authoritative store drives authentication while the shadow store
+// is consulted in parallel and discrepancies are reported, to de-risk a migration between stores.
+// The shadow verification runs in the background, so it doesn't add latency, but it doubles
+// the verification work, e.g. bcrypt comparisons. Only a few shadow verifications run at once;
+// the middleware drops the others rather than queue them behind a slow shadow store.
+// This struct implement Datastore, ErrDatastore, Shadowed and HealthChecker interface.
+type Shadow struct {
+	Authoritative ErrDatastore
+	Shadow        ErrDatastore
+	// OnMismatch is called with the userid (the data store key, so prefixed by the tenant if any)
+	// and both decisions when they differ.
+	// A shadow store error counts as a failed authentication. It may be called concurrently.
+	OnMismatch func(userId string, authOK, shadowOK bool)
+}
+
+// NewShadowStore returns *Shadow deciding with authoritative and reporting decisions of
+// shadow which differ to onMismatch.
+func NewShadowStore(authoritative, shadow ErrDatastore, onMismatch func(userId string, authOK, shadowOK bool)) *Shadow {
+	return &Shadow{
+		Authoritative: authoritative,
+		Shadow:        shadow,
+		OnMismatch:    onMismatch,
+	}
+}
+
+// Shadow.GetContext returns value using key from the authoritative store.
+func (d *Shadow) GetContext(ctx context.Context, key string) ([]byte, bool, error) {
+	return d.Authoritative.GetContext(ctx, key)
+}
+
+// Shadow.Get returns value using key from the authoritative store, treating errors as not found.
+func (d *Shadow) Get(key string) ([]byte, bool) {
+	value, found, err := d.GetContext(context.Background(), key)
+	if err != nil {
+		return nil, false
+	}
+	return value, found
+}
+
+// Shadow.HealthCheck checks the authoritative store if it implements HealthChecker.
+// The shadow store doesn't affect authentication, so its health doesn't count.
+func (d *Shadow) HealthCheck(ctx context.Context) error {
+	return healthCheck(ctx, d.Authoritative)
+}
+
+// Shadow.ShadowGetContext returns value using key from the shadow store.
+func (d *Shadow) ShadowGetContext(ctx context.Context, key string) ([]byte, bool, error) {
+	return d.Shadow.GetContext(ctx, key)
+}
+
+// Shadow.Mismatch reports differing decisions to OnMismatch.
+func (d *Shadow) Mismatch(key string, authOK, shadowOK bool) {
+	if d.OnMismatch != nil {
+		d.OnMismatch(key, authOK, shadowOK)
+	}
+}
@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/codegangsta/negroni"
+)
+
+// defaultMaxErrorBodyBytes bounds the body written by Options.UnauthorizedHandler if
+// Options.MaxErrorBodyBytes is zero.
+const defaultMaxErrorBodyBytes = 1024
+
+// errErrorBodyTooLarge is returned to Options.UnauthorizedHandler by writes past the limit.
+var errErrorBodyTooLarge = errors.New("auth: error response body too large")
+
+// limitedErrorWriter is the http.ResponseWriter passed to Options.UnauthorizedHandler.
+// It forces the status of the response and truncates its body after max bytes.
+type limitedErrorWriter struct {
+	w           http.ResponseWriter
+	status      int
+	remaining   int
+	wroteHeader bool
+	truncated   bool
+}
+
+func (lw *limitedErrorWriter) Header() http.Header {
+	return lw.w.Header()
+}
+
+// limitedErrorWriter.WriteHeader writes the forced status whatever code is.
+// A Content-Length beyond the limit is dropped as it would be wrong after truncation.
+func (lw *limitedErrorWriter) WriteHeader(code int) {
+	if lw.wroteHeader {
+		return
+	}
+	lw.wroteHeader = true
+	if n, err := strconv.Atoi(lw.w.Header().Get("Content-Length")); err == nil && n > lw.remaining {
+		lw.w.Header().Del("Content-Length")
+	}
+	lw.w.WriteHeader(lw.status)
+}
+
+func (lw *limitedErrorWriter) Write(p []byte) (int, error) {
+	lw.WriteHeader(lw.status)
+	if len(p) <= lw.remaining {
+		n, err := lw.w.Write(p)
+		lw.remaining -= n
+		return n, err
+	}
+	n, err := lw.w.Write(p[:lw.remaining])
+	lw.remaining -= n
+	lw.truncated = true
+	if err == nil {
+		err = errErrorBodyTooLarge
+	}
+	return n, err
+}
+
+// unauthorized writes a http.StatusUnauthorized with the challenge headers and the body of
+// o.UnauthorizedHandler, truncated at o.MaxErrorBodyBytes.
+func (o *Options) unauthorized(w http.ResponseWriter, req *http.Request) {
+	if r, ok := w.(negroni.ResponseWriter); ok && r.Written() {
+		o.logf(req.Context(), "negroni-auth: response already written (status %d), skipping authentication challenge", r.Status())
+		return
+	}
+	if o.LoginURL != "" {
+		w.Header().Set("X-Login-URL", o.LoginURL)
+	}
+	if o.challengeKind(req) == ChallengeBrowser {
+		w.Header().Set("WWW-Authenticate", challenge("Basic", "realm", o.realm(req)))
+	}
+
+	max := o.MaxErrorBodyBytes
+	if max <= 0 {
+		max = defaultMaxErrorBodyBytes
+	}
+	lw := &limitedErrorWriter{w: w, status: http.StatusUnauthorized, remaining: max}
+	o.UnauthorizedHandler.ServeHTTP(lw, req)
+	lw.WriteHeader(http.StatusUnauthorized)
+	if lw.truncated {
+		o.logf(req.Context(), "negroni-auth: unauthorized handler wrote more than %d bytes, body truncated", max)
+	}
+}
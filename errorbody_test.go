@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/codegangsta/negroni"
+)
+
+func Test_OptionsUnauthorizedHandler(t *testing.T) {
+	var unauthorizedtests = []struct {
+		handler http.HandlerFunc
+		max     int
+		body    string
+		logged  bool
+	}{
+		{func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<p>Please log in</p>"))
+		}, 0, "<p>Please log in</p>", false},
+		{func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(strings.Repeat("x", 2000)))
+		}, 0, strings.Repeat("x", 1024), true},
+		{func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Length", "12")
+			for i := 0; i < 3; i++ {
+				w.Write([]byte("abcd"))
+			}
+		}, 10, "abcdabcdab", true},
+		// The status can't be changed.
+		{func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}, 0, "", false},
+	}
+	for i, tt := range unauthorizedtests {
+		logger := &recordingLogger{}
+		m := negroni.New(NewBasicWithOptions(&MockDataStore{}, Options{
+			UnauthorizedHandler: tt.handler,
+			MaxErrorBodyBytes:   tt.max,
+			Logger:              logger,
+		}))
+		r, _ := http.NewRequest("GET", "foo", nil)
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, r)
+
+		if recorder.Code != http.StatusUnauthorized {
+			t.Errorf("Expected 401 for handler %d, got %d", i, recorder.Code)
+		}
+		if recorder.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("Expected a challenge for handler %d", i)
+		}
+		if got := recorder.Body.String(); got != tt.body {
+			t.Errorf("Expected a body of %d bytes for handler %d, got %d", len(tt.body), i, len(got))
+		}
+		// A Content-Length beyond the limit is dropped.
+		if cl := recorder.Header().Get("Content-Length"); cl != "" {
+			t.Errorf("Expected no Content-Length for handler %d, got %q", i, cl)
+		}
+		if logged := len(logger.lines) > 0; logged != tt.logged {
+			t.Errorf("Expected logged %v for handler %d, got %q", tt.logged, i, logger.lines)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package auth_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/codegangsta/negroni"
+	"github.com/nabeken/negroni-auth"
+)
+
+type requestIdKey struct{}
+
+// requestIdLogger logs the request ID a tracing middleware put into the context.
+type requestIdLogger struct{}
+
+func (requestIdLogger) Printf(format string, v ...interface{}) {
+	fmt.Printf(format+"\n", v...)
+}
+
+func (requestIdLogger) PrintfContext(ctx context.Context, format string, v ...interface{}) {
+	requestId, _ := ctx.Value(requestIdKey{}).(string)
+	fmt.Printf("request_id=%s "+format+"\n", append([]interface{}{requestId}, v...)...)
+}
+
+// unavailableStore is a data store whose backend is down.
+type unavailableStore struct{}
+
+func (unavailableStore) Get(key string) ([]byte, bool) { return nil, false }
+
+func (unavailableStore) GetContext(ctx context.Context, key string) ([]byte, bool, error) {
+	return nil, false, errors.New("connection refused")
+}
+
+func ExampleContextLogger() {
+	m := negroni.New()
+	m.Use(negroni.HandlerFunc(func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		next(w, r.WithContext(context.WithValue(r.Context(), requestIdKey{}, r.Header.Get("X-Request-Id"))))
+	}))
+	m.Use(auth.NewBasicWithOptions(unavailableStore{}, auth.Options{Logger: requestIdLogger{}}))
+
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Request-Id", "4bf92f35")
+	r.SetBasicAuth("foo", "bar")
+	m.ServeHTTP(httptest.NewRecorder(), r)
+	// Output: request_id=4bf92f35 negroni-auth: authentication backend error: auth: data store error: connection refused
+}
@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"strconv"
+	"time"
+
+	"github.com/nabeken/negroni-auth/datastore"
+)
+
+// failureCacheMaxEntries bounds the failed credentials remembered for Options.FailureCacheTTL,
+// so a flood of distinct wrong credentials can't exhaust memory; beyond it they aren't remembered.
+const failureCacheMaxEntries = 10000
+
+// failureCache remembers recently failed credentials by their SHA-256 hash, so that replaying
+// one is denied without a data store lookup or a password verification.
+type failureCache struct {
+	ttl   time.Duration
+	cache tokenCache
+	now   func() time.Time
+}
+
+// newFailureCache returns *failureCache remembering failures for ttl, or nil if ttl isn't positive.
+func newFailureCache(ttl time.Duration) *failureCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &failureCache{ttl: ttl, now: time.Now}
+}
+
+// key returns the key of the Basic credential header of userId within tenant. If ds is versioned,
+// the key includes the version of userId, so that changing its password, e.g. to a credential
+// which failed before, forgets its failures.
+func (c *failureCache) key(ds datastore.Datastore, tenant, userId, header string) [sha256.Size]byte {
+	var version uint64
+	if v, ok := ds.(datastore.Versioned); ok {
+		_, version, _ = v.GetWithVersion(tenantUserId(tenant, userId))
+	}
+	return sha256.Sum256([]byte(tenant + "\x00" + strconv.FormatUint(version, 10) + "\x00" + header))
+}
+
+// failed returns true if the credential of key failed within the TTL. c may be nil.
+func (c *failureCache) failed(key [sha256.Size]byte) bool {
+	if c == nil {
+		return false
+	}
+	_, found := c.cache.get(key, c.now())
+	return found
+}
+
+// fail remembers that the credential of key failed. c may be nil.
+func (c *failureCache) fail(key [sha256.Size]byte) {
+	if c == nil || c.cache.len() >= failureCacheMaxEntries {
+		return
+	}
+	now := c.now()
+	c.cache.set(key, tokenCacheEntry{expires: now.Add(c.ttl)}, now)
+}
@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/codegangsta/negroni"
+	"github.com/nabeken/negroni-auth/datastore"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// countingVerifier is a BcryptVerifier counting its verifications.
+type countingVerifier struct {
+	calls int
+}
+
+func (v *countingVerifier) Verify(ctx context.Context, userId string, hashedPassword, password []byte) error {
+	v.calls++
+	return BcryptVerifier{}.Verify(ctx, userId, hashedPassword, password)
+}
+
+func Test_BasicFailureCache(t *testing.T) {
+	bar, _ := bcrypt.GenerateFromPassword([]byte("bar"), bcrypt.MinCost)
+	baz, _ := bcrypt.GenerateFromPassword([]byte("baz"), bcrypt.MinCost)
+	ds := datastore.NewMap(map[string][]byte{"foo": bar})
+	v := &countingVerifier{}
+	a := newBasicAuth(ds, Options{Verifier: v, FailureCacheTTL: 10 * time.Second, DummyCost: bcrypt.MinCost})
+	now := time.Now()
+	a.failures.now = func() time.Time { return now }
+	m := negroni.New(negroni.HandlerFunc(a.ServeHTTP))
+
+	serve := func(userId, password string) int {
+		r, _ := http.NewRequest("GET", "foo", nil)
+		r.SetBasicAuth(userId, password)
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, r)
+		return recorder.Code
+	}
+
+	var failurecachetests = []struct {
+		userId   string
+		password string
+		advance  time.Duration
+		setBaz   bool
+		code     int
+		verified bool
+	}{
+		{"foo", "baz", 0, false, http.StatusUnauthorized, true},
+		// The replay is denied without verification.
+		{"foo", "baz", 0, false, http.StatusUnauthorized, false},
+		{"foo", "bar", 0, false, http.StatusOK, true},
+		{"qux", "baz", 0, false, http.StatusUnauthorized, true},
+		{"qux", "baz", 0, false, http.StatusUnauthorized, false},
+		// Changing the password forgets the failures.
+		{"foo", "baz", 0, true, http.StatusOK, true},
+		{"foo", "bar", 0, false, http.StatusUnauthorized, true},
+		{"foo", "bar", 0, false, http.StatusUnauthorized, false},
+		{"foo", "bar", 10 * time.Second, false, http.StatusUnauthorized, true},
+	}
+	for i, tt := range failurecachetests {
+		now = now.Add(tt.advance)
+		if tt.setBaz {
+			ds.Set("foo", baz)
+		}
+		calls := v.calls
+		if code := serve(tt.userId, tt.password); code != tt.code {
+			t.Errorf("%d: Expected %d for %s:%s, got %d", i, tt.code, tt.userId, tt.password, code)
+		}
+		if verified := v.calls > calls; verified != tt.verified {
+			t.Errorf("%d: Expected verification %v for %s:%s, got %v", i, tt.verified, tt.userId, tt.password, verified)
+		}
+	}
+}
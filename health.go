@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/nabeken/negroni-auth/datastore"
+)
+
+// healthError combines the errors of failed health checks.
+type healthError []error
+
+func (e healthError) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return "auth: unhealthy: " + strings.Join(msgs, "; ")
+}
+
+// CheckHealth checks the data stores, password verifiers and token stores among components
+// implementing datastore.HealthChecker concurrently, e.g. for a readiness endpoint.
+// Other components are skipped. Returns an error combining the errors of all failed checks.
+func CheckHealth(ctx context.Context, components ...interface{}) error {
+	errs := make([]error, len(components))
+	var wg sync.WaitGroup
+	for i, c := range components {
+		h, ok := c.(datastore.HealthChecker)
+		if !ok {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, h datastore.HealthChecker) {
+			defer wg.Done()
+			errs[i] = h.HealthCheck(ctx)
+		}(i, h)
+	}
+	wg.Wait()
+
+	var failed healthError
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return failed
+}
@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nabeken/negroni-auth/datastore"
+)
+
+func Test_CheckHealth(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The verification endpoint only accepts POST.
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}))
+	defer up.Close()
+	sealed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/sys/health" || r.URL.Query().Get("standbyok") != "true" {
+			t.Errorf("Unexpected health request %s", r.URL)
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer sealed.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	var healthtests = []struct {
+		name       string
+		components []interface{}
+		want       []string
+	}{
+		{"none", nil, nil},
+		{"no health check", []interface{}{datastore.NewMap(nil), BcryptVerifier{}}, nil},
+		{"healthy", []interface{}{NewRemoteVerifier(up.URL, nil)}, nil},
+		{"sealed", []interface{}{NewRemoteVerifier(up.URL, nil), NewVaultVerifier(sealed.URL)}, []string{"vault health: unexpected status 503"}},
+		{"both down", []interface{}{NewVaultVerifier(sealed.URL), NewRemoteVerifier(down.URL, nil)}, []string{"vault", "remote verifier"}},
+	}
+	for _, tt := range healthtests {
+		err := CheckHealth(context.Background(), tt.components...)
+		if len(tt.want) == 0 {
+			if err != nil {
+				t.Errorf("Expected %s to be healthy, got %v", tt.name, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("Expected %s to be unhealthy", tt.name)
+			continue
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("Expected the error of %s to contain %q, got %v", tt.name, want, err)
+			}
+		}
+	}
+}
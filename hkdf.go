@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"sort"
+	"sync"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// hkdfKeySize is the size of the keys derived by HKDFKey before hex encoding.
+const hkdfKeySize = 32
+
+// HKDFKey returns the hex encoded key of userId derived with HKDF-SHA256 from master,
+// with userId as the info. Clients holding the key send it as their password.
+func HKDFKey(master []byte, userId string) string {
+	key := make([]byte, hkdfKeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, master, nil, []byte(userId)), key); err != nil {
+		// HKDF-SHA256 only fails beyond 255 blocks.
+		panic(err)
+	}
+	return hex.EncodeToString(key)
+}
+
+// HKDFVerifier is a PasswordVerifier for keys derived per userid with HKDFKey. The expected key
+// is derived from Master and the userid on every request and compared with the password in
+// constant time, so it costs a few HMACs and must not be used for human-chosen passwords.
+// The value in the data store is not compared; it only tells whether the userid exists.
+// With Options.Tenant set, keys are derived from the tenant-qualified userid "tenant:userid",
+// so issue them with HKDFKey(master, tenant+":"+userId).
+type HKDFVerifier struct {
+	// Master is the master secret the keys are derived from.
+	Master []byte
+}
+
+// HKDFVerifier.Verify compares password with the key of userId derived from Master in constant time.
+func (v HKDFVerifier) Verify(ctx context.Context, userId string, hashedPassword, password []byte) error {
+	if !hmac.Equal([]byte(HKDFKey(v.Master, userId)), password) {
+		return ErrPasswordMismatch
+	}
+	return nil
+}
+
+// HKDFStore is a data store of the userids whose keys are derived with HKDFKey from a master
+// secret; no per-user secret is kept. Use it with its Verifier.
+// This struct implement datastore.Datastore interface and is safe for concurrent use.
+type HKDFStore struct {
+	master  []byte
+	mu      sync.RWMutex
+	userIds map[string]struct{}
+}
+
+// NewHKDFStore returns *HKDFStore deriving the keys of userIds from master.
+func NewHKDFStore(master []byte, userIds ...string) *HKDFStore {
+	s := &HKDFStore{
+		master:  master,
+		userIds: make(map[string]struct{}),
+	}
+	for _, userId := range userIds {
+		s.Add(userId)
+	}
+	return s
+}
+
+// HKDFStore.Add lets userId authenticate with its derived key.
+func (s *HKDFStore) Add(userId string) {
+	s.mu.Lock()
+	s.userIds[userId] = struct{}{}
+	s.mu.Unlock()
+}
+
+// HKDFStore.Remove revokes the key of userId. Its key stays the same if it's added again.
+func (s *HKDFStore) Remove(userId string) {
+	s.mu.Lock()
+	delete(s.userIds, userId)
+	s.mu.Unlock()
+}
+
+// HKDFStore.Get returns the derived key of userId if it was added.
+func (s *HKDFStore) Get(userId string) ([]byte, bool) {
+	s.mu.RLock()
+	_, found := s.userIds[userId]
+	s.mu.RUnlock()
+	if !found {
+		return nil, false
+	}
+	return []byte(HKDFKey(s.master, userId)), true
+}
+
+// HKDFStore.UserIds returns the added userids in sorted order.
+func (s *HKDFStore) UserIds() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	userIds := make([]string, 0, len(s.userIds))
+	for userId := range s.userIds {
+		userIds = append(userIds, userId)
+	}
+	sort.Strings(userIds)
+	return userIds
+}
+
+// HKDFStore.Verifier returns the PasswordVerifier matching the store's master secret.
+func (s *HKDFStore) Verifier() PasswordVerifier {
+	return HKDFVerifier{Master: s.master}
+}
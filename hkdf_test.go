@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/codegangsta/negroni"
+)
+
+func Test_HKDFStore(t *testing.T) {
+	master := []byte("master-secret")
+	store := NewHKDFStore(master, "user1", "user2")
+	store.Remove("user2")
+
+	if HKDFKey(master, "user1") == HKDFKey(master, "user2") || HKDFKey(master, "user1") == HKDFKey([]byte("other"), "user1") {
+		t.Error("Expected keys to depend on the userid and the master secret")
+	}
+
+	m := negroni.New()
+	m.Use(NewBasicWithOptions(store, Options{Verifier: store.Verifier()}))
+	m.UseHandler(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte("hello"))
+	}))
+
+	var hkdftests = []struct {
+		userId string
+		key    string
+		code   int
+	}{
+		{"user1", HKDFKey(master, "user1"), http.StatusOK},
+		{"user1", HKDFKey([]byte("other"), "user1"), http.StatusUnauthorized},
+		{"user1", "master-secret", http.StatusUnauthorized},
+		// Removed userids are rejected even with their key.
+		{"user2", HKDFKey(master, "user2"), http.StatusUnauthorized},
+		{"user3", HKDFKey(master, "user3"), http.StatusUnauthorized},
+	}
+	for _, tt := range hkdftests {
+		r, _ := http.NewRequest("GET", "foo", nil)
+		r.SetBasicAuth(tt.userId, tt.key)
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, r)
+
+		if recorder.Code != tt.code {
+			t.Errorf("Expected %d for %s:%s, got %d", tt.code, tt.userId, tt.key, recorder.Code)
+		}
+	}
+}
+
+func Test_HKDFStoreTenant(t *testing.T) {
+	master := []byte("master-secret")
+	store := NewHKDFStore(master, "acme:user1")
+	m := negroni.New(NewBasicWithOptions(store, Options{Verifier: store.Verifier(), Tenant: func(req *http.Request) string { return req.Host }}))
+
+	// Keys are derived from the tenant-qualified userid.
+	var tenanttests = []struct {
+		key  string
+		code int
+	}{
+		{HKDFKey(master, "acme:user1"), http.StatusOK},
+		{HKDFKey(master, "user1"), http.StatusUnauthorized},
+	}
+	for _, tt := range tenanttests {
+		r, _ := http.NewRequest("GET", "foo", nil)
+		r.Host = "acme"
+		r.SetBasicAuth("user1", tt.key)
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, r)
+
+		if recorder.Code != tt.code {
+			t.Errorf("Expected %d for user1 in acme with %s, got %d", tt.code, tt.key, recorder.Code)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/codegangsta/negroni"
+)
+
+func Test_HMACSecretStore(t *testing.T) {
+	key := []byte("server-key")
+	store := NewHMACSecretStore(key)
+	store.SetSecret("key1", "s3cr3t")
+	store.Set("key2", HMACSecret(key, "an0ther"))
+
+	if mac, _ := store.Get("key1"); string(mac) == "s3cr3t" {
+		t.Error("Secret stored in plaintext")
+	}
+
+	h := http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte("hello"))
+	})
+	m := negroni.New()
+	m.Use(NewBasicWithOptions(store, Options{Verifier: store.Verifier()}))
+	m.UseHandler(h)
+
+	var hmactests = []struct {
+		keyId  string
+		secret string
+		code   int
+	}{
+		{"key1", "s3cr3t", http.StatusOK},
+		{"key2", "an0ther", http.StatusOK},
+		{"key1", "an0ther", http.StatusUnauthorized},
+		{"key3", "s3cr3t", http.StatusUnauthorized},
+	}
+	for _, tt := range hmactests {
+		r, _ := http.NewRequest("GET", "foo", nil)
+		r.SetBasicAuth(tt.keyId, tt.secret)
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, r)
+
+		if recorder.Code != tt.code {
+			t.Errorf("Expected %d for %s:%s, got %d", tt.code, tt.keyId, tt.secret, recorder.Code)
+		}
+	}
+}
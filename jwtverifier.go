@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/nabeken/negroni-auth/datastore"
+)
+
+// JWTVerifier is a PasswordVerifier for clients sending a JWT as the password of Basic auth,
+// e.g. "Authorization: Basic base64(user:<jwt>)" set by SDKs which only support Basic auth.
+// The token is validated by Tokens, typically an *OIDCTokenStore checking its signature and
+// claims, and the resolved subject must equal the userid of the credential. With Options.Tenant
+// set, that's the tenant-qualified userid "tenant:userid", so the issuer must put it in "sub".
+// The stored hash is ignored, so it is typically used with a nil data store.
+// CacheBasic caches a successful verification regardless of the token's expiry; keep
+// its expire time below the lifetime of the tokens.
+type JWTVerifier struct {
+	Tokens TokenStore
+}
+
+// NewJWTVerifier returns *JWTVerifier validating tokens with tokens.
+func NewJWTVerifier(tokens TokenStore) *JWTVerifier {
+	return &JWTVerifier{Tokens: tokens}
+}
+
+// JWTVerifier.Verify validates password as a JWT whose subject is userId.
+func (v *JWTVerifier) Verify(ctx context.Context, userId string, hashedPassword, password []byte) error {
+	subject, err := v.Tokens.Get(ctx, string(password))
+	switch {
+	case errors.Is(err, ErrInvalidToken) || errors.Is(err, ErrTokenExpired):
+		return ErrPasswordMismatch
+	case err != nil:
+		return fmt.Errorf("auth: jwt verifier: %w", err)
+	case subject == "" || subject != userId:
+		return ErrPasswordMismatch
+	}
+	return nil
+}
+
+// JWTVerifier.HealthCheck checks the health of Tokens, if it can tell.
+func (v *JWTVerifier) HealthCheck(ctx context.Context) error {
+	if hc, ok := v.Tokens.(datastore.HealthChecker); ok {
+		return hc.HealthCheck(ctx)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/codegangsta/negroni"
+)
+
+func Test_JWTVerifier(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{testJWK("k1", key)}})
+	}))
+	defer srv.Close()
+
+	store := NewOIDCTokenStore("https://issuer", "api", srv.URL)
+	store.RefreshInterval = 0
+	v := NewJWTVerifier(store)
+	exp := time.Now().Add(time.Hour).Unix()
+	valid := signTestJWT(t, key, "k1", map[string]interface{}{"iss": "https://issuer", "aud": "api", "sub": "foo", "exp": exp})
+
+	var jwtverifiertests = []struct {
+		userId   string
+		password string
+		err      error
+	}{
+		{"foo", valid, nil},
+		// The userid must be the subject.
+		{"bar", valid, ErrPasswordMismatch},
+		{"foo", signTestJWT(t, key, "k1", map[string]interface{}{"iss": "https://issuer", "aud": "api", "sub": "foo", "exp": time.Now().Add(-time.Hour).Unix()}), ErrPasswordMismatch},
+		{"foo", "password", ErrPasswordMismatch},
+	}
+	for _, tt := range jwtverifiertests {
+		if err := v.Verify(context.Background(), tt.userId, nil, []byte(tt.password)); !errors.Is(err, tt.err) {
+			t.Errorf("Expected %v for %q, got %v", tt.err, tt.userId, err)
+		}
+	}
+
+	m := negroni.New(NewBasicWithOptions(nil, Options{Verifier: v}))
+	var basictests = []struct {
+		userId string
+		code   int
+	}{
+		{"foo", http.StatusOK},
+		{"bar", http.StatusUnauthorized},
+	}
+	for _, tt := range basictests {
+		r, _ := http.NewRequest("GET", "foo", nil)
+		r.SetBasicAuth(tt.userId, valid)
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, r)
+		if recorder.Code != tt.code {
+			t.Errorf("Expected %d for %q, got %d", tt.code, tt.userId, recorder.Code)
+		}
+	}
+
+	// With Options.Tenant, the subject is the tenant-qualified userid.
+	tenanted := negroni.New(NewBasicWithOptions(nil, Options{Verifier: v, Tenant: func(req *http.Request) string { return req.Host }}))
+	var tenanttests = []struct {
+		subject string
+		code    int
+	}{
+		{"acme:foo", http.StatusOK},
+		{"foo", http.StatusUnauthorized},
+		{"globex:foo", http.StatusUnauthorized},
+	}
+	for _, tt := range tenanttests {
+		r, _ := http.NewRequest("GET", "foo", nil)
+		r.Host = "acme"
+		r.SetBasicAuth("foo", signTestJWT(t, key, "k1", map[string]interface{}{"iss": "https://issuer", "aud": "api", "sub": tt.subject, "exp": exp}))
+		recorder := httptest.NewRecorder()
+		tenanted.ServeHTTP(recorder, r)
+		if recorder.Code != tt.code {
+			t.Errorf("Expected %d for subject %q in acme, got %d", tt.code, tt.subject, recorder.Code)
+		}
+	}
+
+	// A key set which can't be fetched is a backend failure, not a wrong password.
+	srv.Close()
+	other := signTestJWT(t, key, "k2", map[string]interface{}{"iss": "https://issuer", "aud": "api", "sub": "foo", "exp": exp})
+	if err := v.Verify(context.Background(), "foo", nil, []byte(other)); err == nil || errors.Is(err, ErrPasswordMismatch) {
+		t.Errorf("Expected a backend error, got %v", err)
+	}
+}
@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"strings"
+)
+
+// LDAPVerifier is a PasswordVerifier for RFC 2307 style userPassword values:
+// "{SSHA}" (salted SHA-1), "{SHA}" (SHA-1) and "{CRYPT}" with bcrypt ("$2a$", "$2b$", "$2y$").
+// Other schemes are reported as ErrMalformedHash.
+type LDAPVerifier struct{}
+
+// LDAPVerifier.Verify compares password with the userPassword value hashedPassword.
+func (LDAPVerifier) Verify(ctx context.Context, userId string, hashedPassword, password []byte) error {
+	scheme, value, ok := splitLDAPScheme(string(hashedPassword))
+	if !ok {
+		return ErrMalformedHash
+	}
+
+	switch strings.ToUpper(scheme) {
+	case "SSHA", "SHA":
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil || len(decoded) < sha1.Size {
+			return ErrMalformedHash
+		}
+		digest, salt := decoded[:sha1.Size], decoded[sha1.Size:]
+		if strings.EqualFold(scheme, "SHA") && len(salt) != 0 {
+			return ErrMalformedHash
+		}
+		sum := sha1.Sum(append(append([]byte{}, password...), salt...))
+		if subtle.ConstantTimeCompare(sum[:], digest) != 1 {
+			return ErrPasswordMismatch
+		}
+		return nil
+	case "CRYPT":
+		if !strings.HasPrefix(value, "$2") {
+			return ErrMalformedHash
+		}
+		return BcryptVerifier{}.Verify(ctx, userId, []byte(value), password)
+	}
+
+	return ErrMalformedHash
+}
+
+// splitLDAPScheme splits "{SCHEME}value".
+func splitLDAPScheme(s string) (string, string, bool) {
+	if !strings.HasPrefix(s, "{") {
+		return "", "", false
+	}
+	i := strings.Index(s, "}")
+	if i < 0 {
+		return "", "", false
+	}
+	return s[1:i], s[i+1:], true
+}
@@ -0,0 +1,32 @@
+package auth
+
+import (
+	"context"
+	"testing"
+)
+
+var ldapverifiertests = []struct {
+	hash     string
+	password string
+	err      error
+}{
+	{"{SSHA}Wcm1xEisNjqp921ALcHfuQ7avFdzYWx0MTIzNA==", "secret", nil},
+	{"{ssha}Wcm1xEisNjqp921ALcHfuQ7avFdzYWx0MTIzNA==", "secret", nil},
+	{"{SSHA}Wcm1xEisNjqp921ALcHfuQ7avFdzYWx0MTIzNA==", "wrong", ErrPasswordMismatch},
+	{"{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=", "secret", nil},
+	{"{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=", "wrong", ErrPasswordMismatch},
+	{"{CRYPT}$2y$04$abcdefghijklmnopqrstuu2r9OfJnfCsdneAXAGHnS4UpFFP8WIrW", "secret", nil},
+	{"{CRYPT}$2y$04$abcdefghijklmnopqrstuu2r9OfJnfCsdneAXAGHnS4UpFFP8WIrW", "wrong", ErrPasswordMismatch},
+	{"{CRYPT}$6$salt$hash", "secret", ErrMalformedHash},
+	{"{SSHA}!!!", "secret", ErrMalformedHash},
+	{"{MD5}Xr4ilOzQ4PCOq3aQ0qbuaQ==", "secret", ErrMalformedHash},
+	{"secret", "secret", ErrMalformedHash},
+}
+
+func Test_LDAPVerifier(t *testing.T) {
+	for _, tt := range ldapverifiertests {
+		if err := (LDAPVerifier{}).Verify(context.Background(), "foo", []byte(tt.hash), []byte(tt.password)); err != tt.err {
+			t.Errorf("Expected Verify(%s, %s) to return %v but got %v", tt.hash, tt.password, tt.err, err)
+		}
+	}
+}
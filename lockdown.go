@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Lockdown is a switch rejecting all Basic authentications of the middlewares it's passed to
+// via Options.Lockdown, e.g. during an incident, without redeploying. While it's enabled,
+// requests get a http.StatusServiceUnavailable without their credentials being verified,
+// except those of BreakGlassUserId, which are verified as usual. Cached authentications and
+// session cookies are rejected too. It is safe for concurrent use, e.g. toggled by an admin endpoint.
+type Lockdown struct {
+	rejected uint64 // first for 64-bit alignment of atomic operations
+	enabled  int32
+
+	// BreakGlassUserId, if set, is the userid still allowed to authenticate during a lockdown,
+	// in any tenant.
+	BreakGlassUserId string
+	// Logger logs toggles; the standard logger of package log if nil.
+	Logger Logger
+}
+
+// NewLockdown returns a disabled *Lockdown letting breakGlassUserId authenticate when enabled.
+func NewLockdown(breakGlassUserId string) *Lockdown {
+	return &Lockdown{BreakGlassUserId: breakGlassUserId}
+}
+
+// Lockdown.SetLockdown enables or disables the lockdown.
+func (l *Lockdown) SetLockdown(enabled bool) {
+	var v int32
+	state := "disabled"
+	if enabled {
+		v, state = 1, "enabled"
+	}
+	if atomic.SwapInt32(&l.enabled, v) != v {
+		logger := l.Logger
+		if logger == nil {
+			logger = stdLogger{}
+		}
+		logger.Printf("negroni-auth: lockdown %s", state)
+	}
+}
+
+// Lockdown.Enabled returns true if the lockdown is enabled. l may be nil.
+func (l *Lockdown) Enabled() bool {
+	return l != nil && atomic.LoadInt32(&l.enabled) == 1
+}
+
+// Lockdown.Rejected returns the number of requests rejected by the lockdown so far, e.g. for metrics.
+func (l *Lockdown) Rejected() uint64 {
+	return atomic.LoadUint64(&l.rejected)
+}
+
+// reject writes a http.StatusServiceUnavailable and returns true if the lockdown is enabled and
+// req isn't made by the break-glass userid. l may be nil.
+func (l *Lockdown) reject(w http.ResponseWriter, req *http.Request, retryAfter time.Duration) bool {
+	if !l.Enabled() {
+		return false
+	}
+	if userId, _, reason := getCred(req); reason == ReasonNone && l.BreakGlassUserId != "" && userId == l.BreakGlassUserId {
+		return false
+	}
+	atomic.AddUint64(&l.rejected, 1)
+	rejectUpgrade(w, req)
+	serviceUnavailable(w, retryAfter)
+	return true
+}
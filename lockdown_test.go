@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/codegangsta/negroni"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func Test_Lockdown(t *testing.T) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte("bar"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds := mockMapDataStore{"foo": hashedPassword, "admin": hashedPassword}
+	lockdown := NewLockdown("admin")
+	lockdown.Logger = &recordingLogger{}
+	opts := Options{Lockdown: lockdown}
+	cached, err := NewCachedBasic(ds, time.Minute, 0, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cached.Close()
+
+	var lockdowntests = []struct {
+		enabled  bool
+		userId   string
+		password string
+		code     int
+	}{
+		{false, "foo", "bar", http.StatusOK},
+		{true, "foo", "bar", http.StatusServiceUnavailable},
+		{true, "admin", "bar", http.StatusOK},
+		{true, "admin", "wrong", http.StatusUnauthorized},
+		{false, "foo", "bar", http.StatusOK},
+	}
+	for _, handler := range []negroni.Handler{NewBasicWithOptions(ds, opts), cached} {
+		m := negroni.New(handler)
+		for _, tt := range lockdowntests {
+			lockdown.SetLockdown(tt.enabled)
+			r, _ := http.NewRequest("GET", "foo", nil)
+			r.SetBasicAuth(tt.userId, tt.password)
+			recorder := httptest.NewRecorder()
+			m.ServeHTTP(recorder, r)
+
+			if recorder.Code != tt.code {
+				t.Errorf("Expected %d for %s with lockdown %v, got %d", tt.code, tt.userId, tt.enabled, recorder.Code)
+			}
+			if tt.code == http.StatusServiceUnavailable && recorder.Header().Get("Retry-After") == "" {
+				t.Error("Expected Retry-After during a lockdown")
+			}
+		}
+	}
+
+	if n := lockdown.Rejected(); n != 2 {
+		t.Errorf("Expected 2 rejected requests, got %d", n)
+	}
+	if lines := lockdown.Logger.(*recordingLogger).lines; len(lines) != 4 {
+		t.Errorf("Expected toggles to be logged, got %q", lines)
+	}
+
+	var disabled *Lockdown
+	if disabled.Enabled() {
+		t.Error("Expected a nil lockdown to be disabled")
+	}
+}
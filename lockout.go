@@ -0,0 +1,176 @@
+package auth
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const defaultLockoutSweepInterval = 1 * time.Minute
+
+// AttemptCounter is an interface for counting failed authentications per key, e.g. per userid
+// or per remote IP. A counter shared by all instances, e.g. backed by Redis INCR and PEXPIRE or
+// a DynamoDB atomic counter with a TTL attribute, makes the lockout hold across a load-balanced
+// fleet. The count of a key expires after the window of the counter without attempts.
+// IncrAttempt increments the count of key and returns it with the time left until it expires.
+// Attempts returns the same without incrementing it; 0 for an expired or unknown key.
+// Reset forgets the count of key.
+type AttemptCounter interface {
+	IncrAttempt(key string) (count int, ttl time.Duration)
+	Attempts(key string) (count int, ttl time.Duration)
+	Reset(key string)
+}
+
+type attemptEntry struct {
+	count   int
+	expires time.Time
+}
+
+// MemoryAttemptCounter is an AttemptCounter keeping counts in memory, so they are per instance.
+// This is the default counter. It is safe for concurrent use.
+type MemoryAttemptCounter struct {
+	window time.Duration
+
+	mu        sync.Mutex
+	entries   map[string]*attemptEntry
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewMemoryAttemptCounter returns *MemoryAttemptCounter whose counts expire after window without attempts.
+func NewMemoryAttemptCounter(window time.Duration) *MemoryAttemptCounter {
+	return &MemoryAttemptCounter{
+		window:  window,
+		entries: make(map[string]*attemptEntry),
+		now:     time.Now,
+	}
+}
+
+// MemoryAttemptCounter.IncrAttempt increments the count of key and restarts its window.
+func (c *MemoryAttemptCounter) IncrAttempt(key string) (int, time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	c.sweep(now)
+
+	e, found := c.entries[key]
+	if !found || !now.Before(e.expires) {
+		e = &attemptEntry{}
+		c.entries[key] = e
+	}
+	e.count++
+	e.expires = now.Add(c.window)
+	return e.count, c.window
+}
+
+// MemoryAttemptCounter.Attempts returns the count of key and the time left until it expires.
+func (c *MemoryAttemptCounter) Attempts(key string) (int, time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, found := c.entries[key]
+	if !found {
+		return 0, 0
+	}
+	ttl := e.expires.Sub(c.now())
+	if ttl <= 0 {
+		return 0, 0
+	}
+	return e.count, ttl
+}
+
+// MemoryAttemptCounter.Reset forgets the count of key.
+func (c *MemoryAttemptCounter) Reset(key string) {
+	c.mu.Lock()
+	delete(c.entries, key)
+	c.mu.Unlock()
+}
+
+// sweep drops expired entries, at most once a minute. c.mu must be held.
+func (c *MemoryAttemptCounter) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < defaultLockoutSweepInterval {
+		return
+	}
+	c.lastSweep = now
+	for key, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// lockout counts failed authentications per userid and per remote IP with counter, and locks
+// a userid, or an IP, out once its threshold is reached, until its count expires.
+// A threshold of 0 disables the lockout of its kind. A nil *lockout never locks out.
+type lockout struct {
+	threshold   int
+	ipThreshold int
+	counter     AttemptCounter
+}
+
+func newLockout(threshold, ipThreshold int, counter AttemptCounter) *lockout {
+	return &lockout{
+		threshold:   threshold,
+		ipThreshold: ipThreshold,
+		counter:     counter,
+	}
+}
+
+// locked returns true if the userid key or the remote IP of req is locked out.
+func (l *lockout) locked(req *http.Request, key string) bool {
+	if l == nil {
+		return false
+	}
+	if l.threshold > 0 {
+		if count, _ := l.counter.Attempts(lockoutUserKey(key)); count >= l.threshold {
+			return true
+		}
+	}
+	if l.ipThreshold > 0 {
+		if count, _ := l.counter.Attempts(lockoutIPKey(req)); count >= l.ipThreshold {
+			return true
+		}
+	}
+	return false
+}
+
+// fail records a failed authentication of the userid key from the remote IP of req.
+func (l *lockout) fail(req *http.Request, key string) {
+	if l == nil {
+		return
+	}
+	if l.threshold > 0 {
+		l.counter.IncrAttempt(lockoutUserKey(key))
+	}
+	if l.ipThreshold > 0 {
+		l.counter.IncrAttempt(lockoutIPKey(req))
+	}
+}
+
+// reset forgets the failures of the userid key after a successful authentication.
+// The failures of the IP are kept, so a valid account doesn't let its owner guess others.
+func (l *lockout) reset(key string) {
+	if l == nil || l.threshold <= 0 {
+		return
+	}
+	l.counter.Reset(lockoutUserKey(key))
+}
+
+func lockoutUserKey(key string) string {
+	return "user:" + key
+}
+
+func lockoutIPKey(req *http.Request) string {
+	return "ip:" + remoteIP(req)
+}
+
+// remoteIP returns the IP of the client of req, or its RemoteAddr if it has no port.
+func remoteIP(req *http.Request) string {
+	ip, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return ip
+}
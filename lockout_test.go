@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/codegangsta/negroni"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func Test_MemoryAttemptCounter(t *testing.T) {
+	c := NewMemoryAttemptCounter(time.Minute)
+	now := time.Unix(1000, 0)
+	c.now = func() time.Time { return now }
+
+	var countertests = []struct {
+		advance time.Duration
+		incr    bool
+		reset   bool
+		count   int
+		ttl     time.Duration
+	}{
+		{0, false, false, 0, 0},
+		{0, true, false, 1, time.Minute},
+		{30 * time.Second, false, false, 1, 30 * time.Second},
+		// Each attempt restarts the window.
+		{0, true, false, 2, time.Minute},
+		{59 * time.Second, false, false, 2, time.Second},
+		{time.Second, false, false, 0, 0},
+		{0, true, false, 1, time.Minute},
+		{0, false, true, 0, 0},
+	}
+	for i, tt := range countertests {
+		now = now.Add(tt.advance)
+		if tt.reset {
+			c.Reset("foo")
+		}
+		var count int
+		var ttl time.Duration
+		if tt.incr {
+			count, ttl = c.IncrAttempt("foo")
+		} else {
+			count, ttl = c.Attempts("foo")
+		}
+		if count != tt.count || ttl != tt.ttl {
+			t.Errorf("Expected %d for %v at step %d, got %d for %v", tt.count, tt.ttl, i, count, ttl)
+		}
+	}
+}
+
+func Test_OptionsIPLockout(t *testing.T) {
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("bar"), bcrypt.MinCost)
+	ds := mockMapDataStore{"foo": hashedPassword, "qux": hashedPassword}
+
+	// Two instances sharing a counter, as behind a load balancer.
+	counter := NewMemoryAttemptCounter(time.Minute)
+	now := time.Unix(1000, 0)
+	counter.now = func() time.Time { return now }
+	opts := Options{IPLockoutThreshold: 3, LockoutDuration: time.Minute, AttemptCounter: counter}
+	instances := []*negroni.Negroni{negroni.New(newBasicAuth(ds, opts)), negroni.New(newBasicAuth(ds, opts))}
+
+	var iplockouttests = []struct {
+		userId   string
+		password string
+		ip       string
+		advance  time.Duration
+		code     int
+	}{
+		{"foo", "wrong", "192.0.2.1", 0, 401},
+		// A success doesn't reset the count of the IP.
+		{"qux", "bar", "192.0.2.1", 0, 200},
+		{"qux", "wrong", "192.0.2.1", 0, 401},
+		{"bar", "wrong", "192.0.2.1", 0, 401},
+		// Locked out, whatever the userid.
+		{"qux", "bar", "192.0.2.1", 0, 401},
+		{"qux", "bar", "192.0.2.2", 0, 200},
+		{"qux", "bar", "192.0.2.1", 2 * time.Minute, 200},
+	}
+	for i, tt := range iplockouttests {
+		now = now.Add(tt.advance)
+		r, _ := http.NewRequest("GET", "foo", nil)
+		r.RemoteAddr = tt.ip + ":1234"
+		r.SetBasicAuth(tt.userId, tt.password)
+		recorder := httptest.NewRecorder()
+		instances[i%2].ServeHTTP(recorder, r)
+
+		if recorder.Code != tt.code {
+			t.Errorf("Expected %d for attempt %d of %q from %s, got %d", tt.code, i, tt.userId, tt.ip, recorder.Code)
+		}
+	}
+}
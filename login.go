@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// loginQueueSize bounds the logins waiting to be recorded.
+const loginQueueSize = 1024
+
+// LoginRecorder is an interface for recording successful logins, e.g. for a "last login"
+// dashboard. Record is called from a background goroutine, one login at a time.
+type LoginRecorder interface {
+	Record(userId, ip string, t time.Time)
+}
+
+// Login is a successful login recorded by MemoryLoginRecorder.
+type Login struct {
+	IP   string
+	Time time.Time
+}
+
+// MemoryLoginRecorder is a LoginRecorder keeping the last login of each userid in memory.
+// It is safe for concurrent use.
+type MemoryLoginRecorder struct {
+	mu     sync.RWMutex
+	logins map[string]Login
+}
+
+// NewMemoryLoginRecorder returns an empty *MemoryLoginRecorder.
+func NewMemoryLoginRecorder() *MemoryLoginRecorder {
+	return &MemoryLoginRecorder{logins: make(map[string]Login)}
+}
+
+// MemoryLoginRecorder.Record records a login of userId from ip at t unless a later one was recorded.
+func (r *MemoryLoginRecorder) Record(userId, ip string, t time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if last, found := r.logins[userId]; found && last.Time.After(t) {
+		return
+	}
+	r.logins[userId] = Login{IP: ip, Time: t}
+}
+
+// MemoryLoginRecorder.LastLogin returns the last login of userId.
+func (r *MemoryLoginRecorder) LastLogin(userId string) (Login, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	login, found := r.logins[userId]
+	return login, found
+}
+
+type queuedLogin struct {
+	userId string
+	ip     string
+	t      time.Time
+}
+
+// loginQueue passes logins to a LoginRecorder from a background goroutine so that a slow
+// recorder doesn't delay requests. Logins are dropped while the queue is full.
+type loginQueue struct {
+	dropped  int64 // first for 64-bit alignment of atomic operations
+	recorder LoginRecorder
+	logger   Logger
+	logins   chan queuedLogin
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// newLoginQueue returns *loginQueue recording to recorder, or nil if recorder is nil.
+// Its goroutine runs until Close.
+func newLoginQueue(recorder LoginRecorder, logger Logger) *loginQueue {
+	if recorder == nil {
+		return nil
+	}
+	q := &loginQueue{
+		recorder: recorder,
+		logger:   logger,
+		logins:   make(chan queuedLogin, loginQueueSize),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+func (q *loginQueue) run() {
+	defer close(q.done)
+	for {
+		select {
+		case l := <-q.logins:
+			q.recorder.Record(l.userId, l.ip, l.t)
+		case <-q.stop:
+			// Record the logins queued before Close.
+			for {
+				select {
+				case l := <-q.logins:
+					q.recorder.Record(l.userId, l.ip, l.t)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// Close stops the goroutine of q once the queued logins are recorded. Logins of later
+// requests are dropped. q may be nil.
+func (q *loginQueue) Close() {
+	if q == nil {
+		return
+	}
+	q.closeOnce.Do(func() {
+		close(q.stop)
+	})
+	<-q.done
+}
+
+// record queues the login of userId by req without blocking. q may be nil.
+func (q *loginQueue) record(req *http.Request, userId string) {
+	if q == nil {
+		return
+	}
+	select {
+	case <-q.stop:
+		return
+	default:
+	}
+
+	select {
+	case q.logins <- queuedLogin{userId: userId, ip: remoteIP(req), t: time.Now()}:
+	default:
+		if n := atomic.AddInt64(&q.dropped, 1); n == 1 || n%1000 == 0 {
+			q.logger.Printf("negroni-auth: login recorder too slow, %d logins dropped", n)
+		}
+	}
+}
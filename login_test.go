@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/codegangsta/negroni"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func Test_MemoryLoginRecorder(t *testing.T) {
+	r := NewMemoryLoginRecorder()
+	now := time.Unix(1000, 0)
+	r.Record("foo", "192.0.2.1", now)
+	// Logins recorded out of order don't replace later ones.
+	r.Record("foo", "192.0.2.2", now.Add(-time.Second))
+
+	if login, found := r.LastLogin("foo"); !found || login.IP != "192.0.2.1" || !login.Time.Equal(now) {
+		t.Errorf("Expected the login from 192.0.2.1, got %+v", login)
+	}
+	if _, found := r.LastLogin("bar"); found {
+		t.Error("Expected no login of bar")
+	}
+}
+
+func Test_BasicLoginRecorder(t *testing.T) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte("bar"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := NewMemoryLoginRecorder()
+	m := negroni.New(NewBasicWithOptions(mockMapDataStore{"foo": hashedPassword}, Options{LoginRecorder: recorder}))
+
+	for _, password := range []string{"wrong", "bar"} {
+		r, _ := http.NewRequest("GET", "foo", nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		r.SetBasicAuth("foo", password)
+		m.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	// Logins are recorded in the background.
+	deadline := time.Now().Add(time.Second)
+	login, found := recorder.LastLogin("foo")
+	for !found && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+		login, found = recorder.LastLogin("foo")
+	}
+	if !found || login.IP != "192.0.2.1" {
+		t.Errorf("Expected a login from 192.0.2.1, got %+v, %v", login, found)
+	}
+}
+
+// blockingLoginRecorder is a LoginRecorder which blocks until released.
+type blockingLoginRecorder chan struct{}
+
+func (r blockingLoginRecorder) Record(userId, ip string, t time.Time) { <-r }
+
+func Test_LoginQueueFull(t *testing.T) {
+	recorder := make(blockingLoginRecorder)
+	defer close(recorder)
+	logger := &recordingLogger{}
+	q := newLoginQueue(recorder, logger)
+
+	r, _ := http.NewRequest("GET", "foo", nil)
+	start := time.Now()
+	// One login is being recorded, the queue holds loginQueueSize more.
+	for i := 0; i < loginQueueSize+10; i++ {
+		q.record(r, "foo")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected recording not to block, took %v", elapsed)
+	}
+	if q.dropped == 0 || len(logger.lines) != 1 {
+		t.Errorf("Expected dropped logins to be logged once, got %d dropped and %q", q.dropped, logger.lines)
+	}
+}
+
+func Test_LoginQueueClose(t *testing.T) {
+	recorder := NewMemoryLoginRecorder()
+	q := newLoginQueue(recorder, &recordingLogger{})
+
+	r, _ := http.NewRequest("GET", "foo", nil)
+	q.record(r, "foo")
+	q.Close()
+	// The queued login is recorded before Close returns, later ones are dropped.
+	if _, found := recorder.LastLogin("foo"); !found {
+		t.Error("Expected the queued login to be recorded by Close")
+	}
+	q.record(r, "bar")
+	q.Close()
+	if _, found := recorder.LastLogin("bar"); found {
+		t.Error("Expected the login after Close to be dropped")
+	}
+
+	// The closers of the middleware stop the queue.
+	b, err := NewCachedBasic(&MockDataStore{}, time.Minute, time.Minute, Options{LoginRecorder: recorder})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Close()
+	select {
+	case <-b.basic.logins.done:
+	default:
+		t.Error("Expected CachedBasic.Close to stop the login queue")
+	}
+}
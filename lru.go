@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// lruCache is a cache with a maximum number of entries and an approximate maximum size,
+// evicting the least recently used entries when full. It is safe for concurrent use.
+type lruCache struct {
+	mu                sync.Mutex
+	maxEntries        int
+	maxBytes          int
+	bytes             int
+	defaultExpiration time.Duration
+	ll                *list.List
+	items             map[string]*list.Element
+}
+
+type lruEntry struct {
+	key     string
+	value   interface{}
+	size    int
+	expires time.Time
+}
+
+// newLRUCache returns *lruCache holding at most maxEntries entries of at most maxBytes in total,
+// expiring after defaultExpiration unless set with another lifetime. A non-positive limit is no limit.
+func newLRUCache(maxEntries, maxBytes int, defaultExpiration time.Duration) *lruCache {
+	return &lruCache{
+		maxEntries:        maxEntries,
+		maxBytes:          maxBytes,
+		defaultExpiration: defaultExpiration,
+		ll:                list.New(),
+		items:             make(map[string]*list.Element),
+	}
+}
+
+// NewLRUCache returns an in-memory Cache holding at most maxEntries entries and approximately
+// maxBytes, counting the length of keys and of string, []byte and CacheEntry values (by their
+// userid), and evicting the least
+// recently used entries when full. A non-positive limit is no limit. Entries expire after
+// defaultExpiration unless set with another lifetime. Pass it as Options.Cache to several
+// CacheBasic middlewares to share one cache with a hard memory ceiling.
+func NewLRUCache(maxEntries, maxBytes int, defaultExpiration time.Duration) Cache {
+	return newLRUCache(maxEntries, maxBytes, defaultExpiration)
+}
+
+// sizeOf returns the approximate size of an entry.
+func sizeOf(key string, value interface{}) int {
+	switch v := value.(type) {
+	case string:
+		return len(key) + len(v)
+	case []byte:
+		return len(key) + len(v)
+	case CacheEntry:
+		return len(key) + len(v.UserId)
+	}
+	return len(key)
+}
+
+func (c *lruCache) Get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, found := c.items[key]
+	if !found {
+		return nil, false
+	}
+	entry := e.Value.(*lruEntry)
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		c.remove(e)
+		return nil, false
+	}
+	c.ll.MoveToFront(e)
+	return entry.value, true
+}
+
+// Set stores value for key for ttl. A zero ttl uses the default expiration.
+func (c *lruCache) Set(key string, value interface{}, ttl time.Duration) {
+	if ttl == 0 {
+		ttl = c.defaultExpiration
+	}
+	var expires time.Time
+	if ttl > 0 {
+		expires = time.Now().Add(ttl)
+	}
+
+	size := sizeOf(key, value)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, found := c.items[key]; found {
+		entry := e.Value.(*lruEntry)
+		c.bytes += size - entry.size
+		entry.value = value
+		entry.size = size
+		entry.expires = expires
+		c.ll.MoveToFront(e)
+	} else {
+		c.items[key] = c.ll.PushFront(&lruEntry{key: key, value: value, size: size, expires: expires})
+		c.bytes += size
+	}
+
+	// An entry larger than maxBytes evicts everything, itself included.
+	for (c.maxEntries > 0 && c.ll.Len() > c.maxEntries) || (c.maxBytes > 0 && c.bytes > c.maxBytes) {
+		c.remove(c.ll.Back())
+	}
+}
+
+func (c *lruCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, found := c.items[key]; found {
+		c.remove(e)
+	}
+}
+
+func (c *lruCache) DeleteExpired() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for e := c.ll.Back(); e != nil; {
+		prev := e.Prev()
+		if entry := e.Value.(*lruEntry); !entry.expires.IsZero() && now.After(entry.expires) {
+			c.remove(e)
+		}
+		e = prev
+	}
+}
+
+// Len returns the number of entries including expired ones not purged yet.
+func (c *lruCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+// Bytes returns the approximate size of the entries including expired ones not purged yet.
+func (c *lruCache) Bytes() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.bytes
+}
+
+func (c *lruCache) remove(e *list.Element) {
+	entry := e.Value.(*lruEntry)
+	c.ll.Remove(e)
+	delete(c.items, entry.key)
+	c.bytes -= entry.size
+}
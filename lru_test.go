@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func Test_LRUCacheEviction(t *testing.T) {
+	c := newLRUCache(2, 0, time.Minute)
+	c.Set("a", 1, 0)
+	c.Set("b", 2, 0)
+
+	// Touch "a" so that "b" is the least recently used.
+	if _, found := c.Get("a"); !found {
+		t.Error("Expected a to be cached")
+	}
+	c.Set("c", 3, 0)
+
+	if _, found := c.Get("b"); found {
+		t.Error("Expected b to be evicted")
+	}
+	if v, found := c.Get("a"); !found || v != 1 {
+		t.Error("Expected a to survive, got: ", v)
+	}
+	if v, found := c.Get("c"); !found || v != 3 {
+		t.Error("Expected c to be cached, got: ", v)
+	}
+
+	for i := 0; i < 100; i++ {
+		c.Set(strconv.Itoa(i), i, 0)
+	}
+	if n := c.Len(); n != 2 {
+		t.Errorf("Expected 2 entries, got %d", n)
+	}
+}
+
+func Test_LRUCacheExpiration(t *testing.T) {
+	c := newLRUCache(10, 0, time.Minute)
+	c.Set("short", 1, time.Millisecond)
+	c.Set("long", 2, 0)
+	time.Sleep(2 * time.Millisecond)
+
+	if _, found := c.Get("short"); found {
+		t.Error("Expected short to expire")
+	}
+
+	c.Set("short", 1, time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	c.DeleteExpired()
+	if n := c.Len(); n != 1 {
+		t.Errorf("Expected expired entries to be purged, got %d entries", n)
+	}
+
+	c.Delete("long")
+	if _, found := c.Get("long"); found {
+		t.Error("Expected long to be deleted")
+	}
+}
+
+func Test_LRUCacheMaxBytes(t *testing.T) {
+	c := newLRUCache(0, 10, time.Minute)
+	c.Set("a", "1234", 0)
+	c.Set("b", "1234", 0)
+	if n := c.Bytes(); n != 10 {
+		t.Errorf("Expected 10 bytes, got %d", n)
+	}
+
+	// Growing "b" evicts "a".
+	c.Set("b", "12345", 0)
+	if _, found := c.Get("a"); found {
+		t.Error("Expected a to be evicted")
+	}
+	if n := c.Bytes(); n != 6 {
+		t.Errorf("Expected 6 bytes, got %d", n)
+	}
+
+	// An entry larger than the limit is not kept.
+	c.Set("c", "12345678901", 0)
+	if _, found := c.Get("c"); found {
+		t.Error("Expected c not to be cached")
+	}
+	if n := c.Bytes(); n != 0 {
+		t.Errorf("Expected 0 bytes, got %d", n)
+	}
+
+	c.Set("d", "1", 0)
+	c.Delete("d")
+	if n, l := c.Bytes(), c.Len(); n != 0 || l != 0 {
+		t.Errorf("Expected an empty cache, got %d bytes in %d entries", n, l)
+	}
+}
@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/codegangsta/negroni"
+)
+
+const defaultNonceSweepInterval = 1 * time.Minute
+
+// NonceStore is an interface for remembering used nonces, for replay protection.
+// SeenBefore records nonce as used for ttl and returns true if it was already recorded
+// and hasn't expired. The check and the record must be atomic, e.g. SET NX in Redis or a
+// conditional put in DynamoDB, so that concurrent replays can't both pass.
+// Behind a load balancer, every instance must share the same store; otherwise a nonce
+// can be replayed against another instance.
+type NonceStore interface {
+	SeenBefore(nonce string, ttl time.Duration) bool
+}
+
+// MemoryNonceStore is a NonceStore keeping nonces in memory, for single instance deployments.
+// Expired nonces are swept while recording new ones. It is safe for concurrent use.
+type MemoryNonceStore struct {
+	mu        sync.Mutex
+	nonces    map[string]time.Time
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewMemoryNonceStore returns an empty *MemoryNonceStore.
+func NewMemoryNonceStore() *MemoryNonceStore {
+	return &MemoryNonceStore{
+		nonces: make(map[string]time.Time),
+		now:    time.Now,
+	}
+}
+
+// MemoryNonceStore.SeenBefore implements NonceStore.
+func (s *MemoryNonceStore) SeenBefore(nonce string, ttl time.Duration) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if now.Sub(s.lastSweep) >= defaultNonceSweepInterval {
+		for n, expires := range s.nonces {
+			if !now.Before(expires) {
+				delete(s.nonces, n)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	if expires, found := s.nonces[nonce]; found && now.Before(expires) {
+		return true
+	}
+	s.nonces[nonce] = now.Add(ttl)
+	return false
+}
+
+// RejectReplays returns a negroni.HandlerFunc that calls next only for requests whose nonce,
+// read from header, hasn't been seen in the last ttl. Writes a http.StatusBadRequest if the
+// nonce is missing, or a http.StatusForbidden if it was seen before.
+// It's meant to run after a handler authenticating a signature over the nonce, e.g. with All;
+// an unsigned nonce can be replaced by the attacker.
+func RejectReplays(store NonceStore, header string, ttl time.Duration) negroni.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+		nonce := req.Header.Get(header)
+		if nonce == "" {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		if store.SeenBefore(nonce, ttl) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, req)
+	}
+}
@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/codegangsta/negroni"
+)
+
+func Test_MemoryNonceStore(t *testing.T) {
+	now := time.Unix(0, 0)
+	s := NewMemoryNonceStore()
+	s.now = func() time.Time { return now }
+
+	var noncetests = []struct {
+		advance time.Duration
+		nonce   string
+		seen    bool
+	}{
+		{0, "a", false},
+		{0, "a", true},
+		{0, "b", false},
+		{30 * time.Second, "a", true},
+		{30 * time.Second, "a", false},
+		{2 * time.Minute, "b", false},
+	}
+	for i, tt := range noncetests {
+		now = now.Add(tt.advance)
+		if seen := s.SeenBefore(tt.nonce, time.Minute); seen != tt.seen {
+			t.Errorf("Expected seen %v for %s at step %d, got %v", tt.seen, tt.nonce, i, seen)
+		}
+	}
+
+	if n := len(s.nonces); n != 1 {
+		t.Errorf("Expected expired nonces to be swept, got %d nonces", n)
+	}
+}
+
+func Test_RejectReplays(t *testing.T) {
+	m := negroni.New()
+	m.Use(RejectReplays(NewMemoryNonceStore(), "X-Nonce", time.Minute))
+	m.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+
+	var replaytests = []struct {
+		nonce string
+		code  int
+	}{
+		{"", http.StatusBadRequest},
+		{"n1", http.StatusOK},
+		{"n1", http.StatusForbidden},
+		{"n2", http.StatusOK},
+	}
+	for _, tt := range replaytests {
+		r, _ := http.NewRequest("GET", "foo", nil)
+		if tt.nonce != "" {
+			r.Header.Set("X-Nonce", tt.nonce)
+		}
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, r)
+
+		if recorder.Code != tt.code {
+			t.Errorf("Expected %d for nonce %q, got %d", tt.code, tt.nonce, recorder.Code)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nabeken/negroni-auth/datastore"
+)
+
+const (
+	defaultNonceTTL = 1 * time.Minute
+
+	nonceRandomSize = 16
+	nonceMACSize    = 16
+)
+
+// errInvalidNonce is returned by validNonce for a nonce which wasn't issued with the key.
+var errInvalidNonce = errors.New("auth: invalid nonce")
+
+// NonceBasic is a negroni.Handler for a challenge-response variant of Basic auth, for links
+// without TLS. The server issues a signed, single-use nonce in its challenge and the client
+// sends "<userid>:<nonce>:<response>" as Basic credential, where response is NonceResponse
+// of its password and the nonce, so the password itself never crosses the link.
+// The data store holds NonceSecret of each password. Each successful response carries the
+// next nonce in "Authentication-Info: nextnonce=...", so clients don't need a second request.
+//
+// It is much weaker than TLS: requests and responses are neither encrypted nor protected
+// against tampering, an eavesdropper can try to guess weak passwords offline from a captured
+// response, and the stored NonceSecret is password-equivalent, so a leaked data store lets
+// anyone authenticate. Browsers don't speak this protocol. Prefer TLS wherever possible.
+type NonceBasic struct {
+	datastore datastore.Datastore
+	// Key signs the issued nonces. It must be kept secret and be at least 32 random bytes.
+	Key []byte
+	// Store remembers used nonces. It must be shared by all instances behind a load balancer.
+	Store NonceStore
+	// TTL is the lifetime of an issued nonce; 1 minute if zero.
+	TTL time.Duration
+	// MaxClockSkew is the difference tolerated between the clocks of the instances issuing and
+	// checking nonces; a nonce is accepted up to MaxClockSkew before its issue or after its expiry.
+	MaxClockSkew time.Duration
+
+	now func() time.Time
+}
+
+// NewNonceBasic returns *NonceBasic authenticating against datastore, signing nonces with key
+// and remembering used nonces in store. It panics if key is shorter than 32 bytes.
+func NewNonceBasic(datastore datastore.Datastore, key []byte, store NonceStore) *NonceBasic {
+	if err := checkKey("nonce", key); err != nil {
+		panic(err)
+	}
+	return &NonceBasic{
+		datastore: datastore,
+		Key:       key,
+		Store:     store,
+		TTL:       defaultNonceTTL,
+	}
+}
+
+// NonceSecret returns the value a data store used with NonceBasic holds for password.
+func NonceSecret(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+// NonceResponse returns the client's response to nonce with password.
+func NonceResponse(password, nonce string) string {
+	key := sha256.Sum256([]byte(password))
+	return nonceResponse(key[:], nonce)
+}
+
+func nonceResponse(key []byte, nonce string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(nonce))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SetNonceAuth sets the Authorization header of req to the response to nonce, as issued by
+// NonceBasic in its challenge or the Authentication-Info header of the previous response.
+func SetNonceAuth(req *http.Request, userId, password, nonce string) {
+	req.SetBasicAuth(userId, nonce+":"+NonceResponse(password, nonce))
+}
+
+// ResponseNonce returns the nonce to answer in the next request, taken from the
+// Authentication-Info or the WWW-Authenticate header of resp, or "" if there is none.
+// It works for NonceBasic and SSHKeyAuth.
+func ResponseNonce(resp *http.Response) string {
+	if nonce := authParam(resp.Header.Get("Authentication-Info"), "nextnonce"); nonce != "" {
+		return nonce
+	}
+	for _, challenge := range resp.Header.Values("WWW-Authenticate") {
+		_, params := splitAuthorization(challenge)
+		if nonce := authParam(params, "nonce"); nonce != "" {
+			return nonce
+		}
+	}
+	return ""
+}
+
+func (b *NonceBasic) clock() time.Time {
+	if b.now != nil {
+		return b.now()
+	}
+	return time.Now()
+}
+
+// issue returns a new nonce.
+func (b *NonceBasic) issue() string {
+	return issueNonce(b.Key, b.clock(), b.TTL)
+}
+
+// valid returns how long nonce remains valid if it was issued by b and hasn't expired.
+func (b *NonceBasic) valid(nonce string) (time.Duration, error) {
+	return validNonce(b.Key, nonce, b.clock(), b.TTL, b.MaxClockSkew)
+}
+
+// signNonce returns the MAC of the nonce payload with key.
+func signNonce(key, payload []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(payload)
+	return mac.Sum(nil)[:nonceMACSize]
+}
+
+// issueNonce returns a new nonce signed with key, valid for ttl from now; 1 minute if ttl is zero.
+// It holds random bytes and the expiry in the clear, so it needs no server-side state until used.
+func issueNonce(key []byte, now time.Time, ttl time.Duration) string {
+	if ttl <= 0 {
+		ttl = defaultNonceTTL
+	}
+
+	payload := make([]byte, nonceRandomSize+8)
+	if _, err := rand.Read(payload[:nonceRandomSize]); err != nil {
+		panic(err)
+	}
+	binary.BigEndian.PutUint64(payload[nonceRandomSize:], uint64(now.Add(ttl).Unix()))
+	return base64.RawURLEncoding.EncodeToString(append(payload, signNonce(key, payload)...))
+}
+
+// validNonce returns how long nonce remains valid if it was signed with key and is valid at now,
+// tolerating skew between the clocks of the instances which issued and check it. ttl is the
+// lifetime it was issued with; 1 minute if zero. Returns errInvalidNonce if it wasn't signed
+// with key, or the error of checkValidity if it's outside its validity.
+func validNonce(key []byte, nonce string, now time.Time, ttl, skew time.Duration) (time.Duration, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil || len(raw) != nonceRandomSize+8+nonceMACSize {
+		return 0, errInvalidNonce
+	}
+	payload, mac := raw[:nonceRandomSize+8], raw[nonceRandomSize+8:]
+	if !hmac.Equal(mac, signNonce(key, payload)) {
+		return 0, errInvalidNonce
+	}
+
+	if ttl <= 0 {
+		ttl = defaultNonceTTL
+	}
+	expires := time.Unix(int64(binary.BigEndian.Uint64(payload[nonceRandomSize:])), 0)
+	// The nonce is valid until just before its expiry.
+	if err := checkValidity(now, expires.Add(-ttl), expires.Add(-time.Nanosecond), skew); err != nil {
+		return 0, err
+	}
+	return expires.Add(skew).Sub(now), nil
+}
+
+// challenge writes a http.StatusUnauthorized with a new nonce.
+func (b *NonceBasic) challenge(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", challenge("Basic", "realm", defaultRealm, "nonce", b.issue()))
+	http.Error(w, "Not Authorized", http.StatusUnauthorized)
+}
+
+// NonceBasic.ServeHTTP implements negroni.Handler.
+// Writes a http.StatusUnauthorized with a new nonce if authentication fails.
+func (b *NonceBasic) ServeHTTP(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+	addVary(w.Header(), "Authorization")
+
+	userId, credential, _ := getCred(req)
+	s := strings.SplitN(credential, ":", 2)
+	if userId == "" || len(s) != 2 {
+		b.challenge(w)
+		return
+	}
+	nonce, response := s[0], s[1]
+
+	// Every nonce is good for a single attempt, so it can't be used to guess online.
+	remaining, err := b.valid(nonce)
+	if err != nil || b.Store.SeenBefore(nonce, remaining) {
+		if reason := validityReason(err); reason != "" {
+			w.Header().Set("X-Auth-Reason", reason)
+		}
+		b.challenge(w)
+		return
+	}
+
+	secret, found := b.datastore.Get(userId)
+	key, err := hex.DecodeString(string(secret))
+	if !found || err != nil {
+		// Spend the same time as for a known userid.
+		key = make([]byte, sha256.Size)
+	}
+	if !hmac.Equal([]byte(nonceResponse(key, nonce)), []byte(response)) || !found || err != nil {
+		b.challenge(w)
+		return
+	}
+
+	w.Header().Set("Authentication-Info", "nextnonce="+quoteString(b.issue()))
+	pass(w, withUserId(req, userId), stdLogger{}, next)
+}
@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/codegangsta/negroni"
+
+	"github.com/nabeken/negroni-auth/datastore"
+)
+
+func Test_NonceBasic(t *testing.T) {
+	now := time.Unix(1000, 0)
+	ds := &datastore.Simple{Key: "foo", Value: []byte(NonceSecret("bar"))}
+	b := NewNonceBasic(ds, []byte("server key server key server key"), NewMemoryNonceStore())
+	b.now = func() time.Time { return now }
+
+	m := negroni.New(b)
+	m.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userId, _ := UserIdFromContext(r.Context())
+		w.Write([]byte("hello " + userId))
+	}))
+	serve := func(r *http.Request) *http.Response {
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, r)
+		return recorder.Result()
+	}
+
+	// The challenge carries the first nonce.
+	r, _ := http.NewRequest("GET", "foo", nil)
+	resp := serve(r)
+	nonce := ResponseNonce(resp)
+	if resp.StatusCode != http.StatusUnauthorized || nonce == "" {
+		t.Fatalf("Expected a 401 with nonce, got %d %q", resp.StatusCode, resp.Header.Get("WWW-Authenticate"))
+	}
+
+	r, _ = http.NewRequest("GET", "foo", nil)
+	SetNonceAuth(r, "foo", "bar", nonce)
+	replay := r.Header.Get("Authorization")
+	resp = serve(r)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", resp.StatusCode)
+	}
+	next := ResponseNonce(resp)
+	if next == "" || next == nonce {
+		t.Fatalf("Expected a new nonce in Authentication-Info, got %q", resp.Header.Get("Authentication-Info"))
+	}
+
+	var noncebasictests = []struct {
+		name string
+		set  func(r *http.Request)
+		code int
+	}{
+		{"replay", func(r *http.Request) { r.Header.Set("Authorization", replay) }, http.StatusUnauthorized},
+		{"plain password", func(r *http.Request) { r.SetBasicAuth("foo", "bar") }, http.StatusUnauthorized},
+		{"forged nonce", func(r *http.Request) { SetNonceAuth(r, "foo", "bar", "AAAA"+next[4:]) }, http.StatusUnauthorized},
+		{"wrong password", func(r *http.Request) { SetNonceAuth(r, "foo", "baz", b.issue()) }, http.StatusUnauthorized},
+		{"unknown userid", func(r *http.Request) { SetNonceAuth(r, "qux", "bar", b.issue()) }, http.StatusUnauthorized},
+		{"next nonce", func(r *http.Request) { SetNonceAuth(r, "foo", "bar", next) }, http.StatusOK},
+	}
+	for _, tt := range noncebasictests {
+		r, _ := http.NewRequest("GET", "foo", nil)
+		tt.set(r)
+		if resp := serve(r); resp.StatusCode != tt.code {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.code, resp.StatusCode)
+		}
+	}
+
+	// Nonces expire.
+	nonce = b.issue()
+	now = now.Add(2 * defaultNonceTTL)
+	r, _ = http.NewRequest("GET", "foo", nil)
+	SetNonceAuth(r, "foo", "bar", nonce)
+	if resp := serve(r); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected an expired nonce to be rejected, got %d", resp.StatusCode)
+	}
+}
+
+func Test_NonceBasicMaxClockSkew(t *testing.T) {
+	now := time.Unix(1000, 0)
+	ds := &datastore.Simple{Key: "foo", Value: []byte(NonceSecret("bar"))}
+	b := NewNonceBasic(ds, []byte("server key server key server key"), NewMemoryNonceStore())
+	b.MaxClockSkew = 10 * time.Second
+	b.now = func() time.Time { return now }
+
+	// Nonces issued by instances whose clocks are off by drift.
+	var skewtests = []struct {
+		drift  time.Duration
+		code   int
+		reason string
+	}{
+		{0, http.StatusOK, ""},
+		{-defaultNonceTTL - 5*time.Second, http.StatusOK, ""},
+		{-defaultNonceTTL - 15*time.Second, http.StatusUnauthorized, "expired"},
+		{5 * time.Second, http.StatusOK, ""},
+		{15 * time.Second, http.StatusUnauthorized, "not-yet-valid"},
+	}
+	for _, tt := range skewtests {
+		nonce := issueNonce(b.Key, now.Add(tt.drift), b.TTL)
+
+		r, _ := http.NewRequest("GET", "foo", nil)
+		SetNonceAuth(r, "foo", "bar", nonce)
+		recorder := httptest.NewRecorder()
+		negroni.New(b).ServeHTTP(recorder, r)
+		if reason := recorder.Header().Get("X-Auth-Reason"); recorder.Code != tt.code || reason != tt.reason {
+			t.Errorf("Expected %d %q for a drift of %v, got %d %q", tt.code, tt.reason, tt.drift, recorder.Code, reason)
+		}
+	}
+}
+
+func Test_NonceKeyTooShort(t *testing.T) {
+	var constructortests = []struct {
+		name string
+		new  func(key []byte)
+	}{
+		{"NewNonceBasic", func(key []byte) { NewNonceBasic(&MockDataStore{}, key, NewMemoryNonceStore()) }},
+		{"NewSSHKeyAuth", func(key []byte) { NewSSHKeyAuth(&MockDataStore{}, key, NewMemoryNonceStore()) }},
+	}
+	for _, tt := range constructortests {
+		for _, key := range [][]byte{nil, []byte("server key")} {
+			func() {
+				defer func() {
+					if r := recover(); r == nil {
+						t.Errorf("Expected %s to refuse a key of %d bytes", tt.name, len(key))
+					}
+				}()
+				tt.new(key)
+			}()
+		}
+	}
+}
@@ -0,0 +1,387 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256" // register SHA-256 for crypto.Hash
+	_ "crypto/sha512" // register SHA-384, SHA-512 for crypto.Hash
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultJWKSRefreshInterval = 1 * time.Minute
+	defaultJWKSTimeout         = 10 * time.Second
+)
+
+// defaultJWKSClient fetches key sets when OIDCTokenStore.Client is nil, so a hanging
+// provider can't hold up the requests waiting for its keys.
+var defaultJWKSClient = &http.Client{Timeout: defaultJWKSTimeout}
+
+// OIDCTokenStore is a TokenStore that validates OpenID Connect JWT access tokens.
+// The signature is verified against the provider's JSON Web Key Set, and the
+// issuer, audience and expiry claims are checked. The subject becomes the userid.
+// The key set is cached and refetched when a token refers to an unknown key id.
+type OIDCTokenStore struct {
+	// Issuer is the expected "iss" claim.
+	Issuer string
+	// Audience is the expected "aud" claim.
+	Audience string
+	// JWKSURL is the location of the provider's JSON Web Key Set.
+	JWKSURL string
+	// Client is used to fetch the key set; a client with a 10 second timeout if nil.
+	Client *http.Client
+	// Leeway is the maximum clock skew tolerated when checking "exp" and "nbf".
+	Leeway time.Duration
+	// RefreshInterval is the minimum time between two key set fetches.
+	RefreshInterval time.Duration
+
+	mu        sync.RWMutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+	fetch     *jwksFetch
+	now       func() time.Time
+}
+
+// jwksFetch is a key set fetch in progress, shared by the requests waiting for it.
+// err is set before done is closed.
+type jwksFetch struct {
+	done chan struct{}
+	err  error
+}
+
+// NewOIDCTokenStore returns *OIDCTokenStore built from issuer, audience and JWKS URL.
+func NewOIDCTokenStore(issuer, audience, jwksURL string) *OIDCTokenStore {
+	return &OIDCTokenStore{
+		Issuer:          issuer,
+		Audience:        audience,
+		JWKSURL:         jwksURL,
+		RefreshInterval: defaultJWKSRefreshInterval,
+	}
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type jwtClaims struct {
+	Issuer    string      `json:"iss"`
+	Subject   string      `json:"sub"`
+	Audience  jwtAudience `json:"aud"`
+	ExpiresAt int64       `json:"exp"`
+	NotBefore int64       `json:"nbf"`
+}
+
+// jwtAudience accepts both the string and the array form of "aud".
+type jwtAudience []string
+
+func (a *jwtAudience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = jwtAudience{s}
+		return nil
+	}
+	var ss []string
+	if err := json.Unmarshal(b, &ss); err != nil {
+		return err
+	}
+	*a = ss
+	return nil
+}
+
+func (a jwtAudience) contains(aud string) bool {
+	for _, v := range a {
+		if v == aud {
+			return true
+		}
+	}
+	return false
+}
+
+// OIDCTokenStore.Get validates token and returns its subject.
+func (s *OIDCTokenStore) Get(ctx context.Context, token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", ErrInvalidToken
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return "", ErrInvalidToken
+	}
+	hash, ok := jwtHashes[header.Alg]
+	if !ok {
+		return "", ErrInvalidToken
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+
+	key, err := s.key(ctx, header.Kid)
+	if err != nil {
+		return "", err
+	}
+
+	h := hash.New()
+	h.Write([]byte(parts[0] + "." + parts[1]))
+	if !verifySignature(header.Alg, key, hash, h.Sum(nil), sig) {
+		return "", ErrInvalidToken
+	}
+
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return "", ErrInvalidToken
+	}
+	if claims.Issuer != s.Issuer || !claims.Audience.contains(s.Audience) || claims.Subject == "" {
+		return "", ErrInvalidToken
+	}
+
+	if claims.ExpiresAt == 0 {
+		return "", ErrTokenExpired
+	}
+	var notBefore time.Time
+	if claims.NotBefore != 0 {
+		notBefore = time.Unix(claims.NotBefore, 0)
+	}
+	switch checkValidity(s.clock(), notBefore, time.Unix(claims.ExpiresAt, 0), s.Leeway) {
+	case errExpired:
+		return "", ErrTokenExpired
+	case errNotYetValid:
+		return "", ErrInvalidToken
+	}
+
+	return claims.Subject, nil
+}
+
+func (s *OIDCTokenStore) clock() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
+
+// key returns the public key for kid, refetching the key set when kid is unknown.
+func (s *OIDCTokenStore) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	s.mu.RLock()
+	key, found := s.keys[kid]
+	fresh := s.clock().Sub(s.fetchedAt) < s.RefreshInterval
+	s.mu.RUnlock()
+	if found {
+		return key, nil
+	}
+	// Limit refetches so that tokens with random key ids can't hammer the provider.
+	if fresh {
+		return nil, ErrInvalidToken
+	}
+
+	// Fetch outside the lock, so the keys already known keep verifying tokens meanwhile, and
+	// only once for all the requests which need a refetch at the same time.
+	s.mu.Lock()
+	// Another request may have refreshed the key set meanwhile.
+	if key, found := s.keys[kid]; found {
+		s.mu.Unlock()
+		return key, nil
+	}
+	if s.fetch == nil && s.clock().Sub(s.fetchedAt) < s.RefreshInterval {
+		s.mu.Unlock()
+		return nil, ErrInvalidToken
+	}
+	f, leader := s.fetch, false
+	if f == nil {
+		f, leader = &jwksFetch{done: make(chan struct{})}, true
+		s.fetch = f
+	}
+	s.mu.Unlock()
+
+	if leader {
+		keys, err := s.fetchKeys(ctx)
+		s.mu.Lock()
+		if err == nil {
+			s.keys = keys
+			s.fetchedAt = s.clock()
+		}
+		s.fetch = nil
+		s.mu.Unlock()
+		f.err = err
+		close(f.done)
+	} else {
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	s.mu.RLock()
+	key, found = s.keys[kid]
+	s.mu.RUnlock()
+	if found {
+		return key, nil
+	}
+	return nil, ErrInvalidToken
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// OIDCTokenStore.HealthCheck checks that the JSON Web Key Set can be fetched.
+func (s *OIDCTokenStore) HealthCheck(ctx context.Context) error {
+	_, err := s.fetchKeys(ctx)
+	return err
+}
+
+// fetchKeys downloads and parses the JSON Web Key Set.
+func (s *OIDCTokenStore) fetchKeys(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	client := s.Client
+	if client == nil {
+		client = defaultJWKSClient
+	}
+
+	req, err := http.NewRequest("GET", s.JWKSURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("auth: fetching JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("auth: fetching JWKS: unexpected status %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("auth: decoding JWKS: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		// Skip keys we don't understand rather than failing the whole set.
+		if key, err := k.publicKey(); err == nil {
+			keys[k.Kid] = key
+		}
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("auth: invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.New("auth: unsupported curve")
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("auth: invalid EC point")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, errors.New("auth: unsupported key type")
+}
+
+// jwtHashes maps the supported asymmetric JWS algorithms to their hash.
+// Symmetric and "none" algorithms are deliberately absent.
+var jwtHashes = map[string]crypto.Hash{
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+	"ES256": crypto.SHA256,
+	"ES384": crypto.SHA384,
+	"ES512": crypto.SHA512,
+}
+
+func verifySignature(alg string, key crypto.PublicKey, hash crypto.Hash, digest, sig []byte) bool {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if alg[:2] != "RS" {
+			return false
+		}
+		return rsa.VerifyPKCS1v15(k, hash, digest, sig) == nil
+	case *ecdsa.PublicKey:
+		if alg[:2] != "ES" {
+			return false
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		return ecdsa.Verify(k, digest, r, s)
+	}
+	return false
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func signTestJWT(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	h := crypto.SHA256.New()
+	h.Write([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, h.Sum(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func testJWK(kid string, key *rsa.PrivateKey) map[string]string {
+	return map[string]string{
+		"kty": "RSA",
+		"kid": kid,
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func Test_OIDCTokenStore(t *testing.T) {
+	key1, _ := rsa.GenerateKey(rand.Reader, 2048)
+	key2, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	var fetches int32
+	var keys atomic.Value
+	keys.Store([]map[string]string{testJWK("k1", key1)})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys.Load()})
+	}))
+	defer srv.Close()
+
+	store := NewOIDCTokenStore("https://issuer", "api", srv.URL)
+	store.RefreshInterval = 0
+	ctx := context.Background()
+	exp := time.Now().Add(time.Hour).Unix()
+
+	valid := signTestJWT(t, key1, "k1", map[string]interface{}{"iss": "https://issuer", "aud": "api", "sub": "foo", "exp": exp})
+	if userId, err := store.Get(ctx, valid); err != nil || userId != "foo" {
+		t.Errorf("Expected foo, got %q, %v", userId, err)
+	}
+	if _, err := store.Get(ctx, valid); err != nil {
+		t.Error("Cached key lookup failed: ", err)
+	}
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Errorf("Expected JWKS to be fetched once, got %d", n)
+	}
+
+	var invalidtests = []struct {
+		token string
+		err   error
+	}{
+		{signTestJWT(t, key1, "k1", map[string]interface{}{"iss": "https://issuer", "aud": "other", "sub": "foo", "exp": exp}), ErrInvalidToken},
+		{signTestJWT(t, key1, "k1", map[string]interface{}{"iss": "https://evil", "aud": "api", "sub": "foo", "exp": exp}), ErrInvalidToken},
+		{signTestJWT(t, key1, "k1", map[string]interface{}{"iss": "https://issuer", "aud": "api", "sub": "foo", "exp": time.Now().Add(-time.Hour).Unix()}), ErrTokenExpired},
+		{signTestJWT(t, key2, "k1", map[string]interface{}{"iss": "https://issuer", "aud": "api", "sub": "foo", "exp": exp}), ErrInvalidToken},
+		{"not.a.jwt", ErrInvalidToken},
+		{"garbage", ErrInvalidToken},
+	}
+	for _, tt := range invalidtests {
+		if _, err := store.Get(ctx, tt.token); !errors.Is(err, tt.err) {
+			t.Errorf("Expected %v, got %v", tt.err, err)
+		}
+	}
+
+	// Rotate the signing key; the unknown kid triggers a refetch.
+	keys.Store([]map[string]string{testJWK("k1", key1), testJWK("k2", key2)})
+	rotated := signTestJWT(t, key2, "k2", map[string]interface{}{"iss": "https://issuer", "aud": []string{"api"}, "sub": "bar", "exp": exp})
+	if userId, err := store.Get(ctx, rotated); err != nil || userId != "bar" {
+		t.Errorf("Expected bar after key rotation, got %q, %v", userId, err)
+	}
+}
+
+func Test_OIDCTokenStoreConcurrentFetch(t *testing.T) {
+	key1, _ := rsa.GenerateKey(rand.Reader, 2048)
+	key2, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	var fetches int32
+	var keys atomic.Value
+	keys.Store([]map[string]string{testJWK("k1", key1)})
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Only the first fetch answers right away.
+		if atomic.AddInt32(&fetches, 1) > 1 {
+			<-release
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys.Load()})
+	}))
+	defer srv.Close()
+
+	store := NewOIDCTokenStore("https://issuer", "api", srv.URL)
+	store.RefreshInterval = 0
+	ctx := context.Background()
+	exp := time.Now().Add(time.Hour).Unix()
+	valid := signTestJWT(t, key1, "k1", map[string]interface{}{"iss": "https://issuer", "aud": "api", "sub": "foo", "exp": exp})
+	if _, err := store.Get(ctx, valid); err != nil {
+		t.Fatal(err)
+	}
+
+	// Tokens of the rotated key wait for a single refetch.
+	keys.Store([]map[string]string{testJWK("k1", key1), testJWK("k2", key2)})
+	rotated := signTestJWT(t, key2, "k2", map[string]interface{}{"iss": "https://issuer", "aud": "api", "sub": "bar", "exp": exp})
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := store.Get(ctx, rotated)
+			errs <- err
+		}()
+	}
+	for atomic.LoadInt32(&fetches) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The known key keeps verifying while the refetch hangs.
+	done := make(chan error, 1)
+	go func() {
+		_, err := store.Get(ctx, valid)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error("Expected the known key to verify during the refetch, got: ", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected the known key to verify without waiting for the refetch")
+	}
+
+	close(release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error("Expected the rotated key to verify, got: ", err)
+		}
+	}
+	if n := atomic.LoadInt32(&fetches); n != 2 {
+		t.Errorf("Expected JWKS to be fetched twice, got %d", n)
+	}
+}
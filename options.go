@@ -0,0 +1,583 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Logger is an interface for logging warnings of the middleware. *log.Logger implements it.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// ContextLogger is an optional interface for loggers which take the context of the request a
+// message is about, e.g. to log the request or trace ID a tracing middleware put into it.
+// Messages about a request are logged with PrintfContext instead of Printf.
+type ContextLogger interface {
+	Logger
+	PrintfContext(ctx context.Context, format string, v ...interface{})
+}
+
+// logContext logs to l with ctx if l is a ContextLogger.
+func logContext(l Logger, ctx context.Context, format string, v ...interface{}) {
+	if cl, ok := l.(ContextLogger); ok {
+		cl.PrintfContext(ctx, format, v...)
+		return
+	}
+	l.Printf(format, v...)
+}
+
+// stdLogger logs to the standard logger of package log.
+type stdLogger struct{}
+
+func (stdLogger) Printf(format string, v ...interface{}) {
+	log.Printf(format, v...)
+}
+
+// Options configures the optional behavior of the middleware.
+// The zero value preserves the default behavior.
+type Options struct {
+	// CacheTTL returns how long CacheBasic caches a successful authentication of userId
+	// made by req. The result is clamped to MaxCacheTTL. A non-positive result, or a nil
+	// CacheTTL, uses the cache expire time.
+	CacheTTL func(req *http.Request, userId string) time.Duration
+
+	// MaxCacheTTL is the upper bound of CacheTTL. If zero, the cache expire time is the bound,
+	// so CacheTTL may only shorten the lifetime of a cache entry.
+	MaxCacheTTL time.Duration
+
+	// CacheMaxEntries, if positive, bounds the number of entries cached by CacheBasic.
+	// The least recently used entries are evicted when the cache is full, capping memory
+	// regardless of how many distinct credentials are presented.
+	CacheMaxEntries int
+
+	// CacheMaxBytes, if positive, bounds the approximate size of the entries cached by CacheBasic,
+	// counting the lengths of keys and cached userids. It may be combined with CacheMaxEntries.
+	CacheMaxBytes int
+
+	// Cache, if set, replaces the in-memory cache of CacheBasic, e.g. to share cached
+	// authentications between instances. CacheMaxEntries and CacheMaxBytes are ignored then;
+	// use NewLRUCache for a shared in-memory cache with limits.
+	Cache Cache
+
+	// CacheKeySecret keys the HMAC the keys of CacheBasic are derived from; a random secret
+	// per process if empty. Set the same secret of at least 32 random bytes on every instance
+	// sharing a Cache, e.g. the L2 of TieredCache, so they find each other's entries.
+	CacheKeySecret []byte
+
+	// CacheBypassMethods lists request methods, e.g. "POST" and "DELETE", for which CacheBasic
+	// neither uses nor fills the cache, so the credential of every such request is verified
+	// against the data store. Methods are matched case-sensitively, as in net/http.
+	CacheBypassMethods []string
+
+	// WeakHashCacheTTL, if non-zero, bounds how long CacheBasic caches a successful authentication
+	// whose bcrypt hash is below the target cost, so such users are re-verified more often.
+	// A negative value disables caching for them.
+	WeakHashCacheTTL time.Duration
+
+	// CacheIdleTimeout, if positive, expires an authentication cached by CacheBasic once it's
+	// unused for this long, however long it would otherwise be cached. Each use slides the
+	// window, up to the cache expire time, or the CacheTTL, since the authentication.
+	CacheIdleTimeout time.Duration
+
+	// CacheMaxLifetime, if positive, bounds the time since the authentication for which CacheBasic
+	// accepts a cached authentication, whatever the cache expire time, CacheTTL and
+	// CacheIdleTimeout, so the credential is verified again at least that often.
+	CacheMaxLifetime time.Duration
+
+	// StaleCacheGrace, if positive, lets CacheBasic keep serving a cached authentication for up
+	// to this long after it expired while the data store or the verifier fails, instead of
+	// answering http.StatusServiceUnavailable. Only the credential which was cached is accepted,
+	// but a password changed or revoked during an outage stays valid for the grace window.
+	// Entries are kept in the cache for their lifetime plus the grace window.
+	StaleCacheGrace time.Duration
+
+	// UpgradeAdvisory sets "X-Auth-Advisory: password-upgrade-recommended" on the responses to
+	// successful Basic authentications whose stored hash is weak, including cached ones, so a
+	// companion UI can prompt the user to reset their password. It never blocks a request.
+	UpgradeAdvisory bool
+
+	// WeakHash, if set, tells which stored hashes are weak for UpgradeAdvisory, e.g. those of an
+	// old algorithm. By default, bcrypt hashes below the target cost are.
+	WeakHash func(hashedPassword []byte) bool
+
+	// SessionCookie, if set, issues a signed cookie after a successful authentication and
+	// accepts it as an alternative to the Authorization header.
+	SessionCookie *SessionCookie
+
+	// Verifier checks passwords against the stored hashes. BcryptVerifier if nil.
+	Verifier PasswordVerifier
+
+	// Cost is the target bcrypt cost of the stored hashes; 12 if zero. Unknown userids are
+	// verified against a dummy hash of this cost until stored hashes have been seen, and
+	// WeakHashCacheTTL applies to hashes below it.
+	Cost int
+
+	// DummyCost, if positive, is the cost of the dummy hash unknown userids are verified
+	// against, instead of the most common cost among the stored hashes. Set it to the highest
+	// cost of a store mixing costs per class of userids; see NewMapStoreWithCost.
+	DummyCost int
+
+	// StoredHashDecoder, if set, is applied to the value returned by the data store before
+	// verification, e.g. to strip a "{BCRYPT}" prefix or base64-decode hashes of a legacy system.
+	// A decoding error is reported as ErrMalformedHash, so it's a backend failure.
+	StoredHashDecoder func(stored []byte) ([]byte, error)
+
+	// AllowCostOverride honors the target cost set by WithTargetCost on the request context,
+	// which replaces Cost and the cost of the dummy hash for that request. Costs more than 2
+	// above Cost are ignored, so a request can't make the dummy hash take minutes. It's meant
+	// for load tests; never set it in production, where it would let requests weaken the dummy hash.
+	AllowCostOverride bool
+
+	// AuthTimeout, if positive, bounds the time spent looking up and verifying a credential.
+	// The lookup of data stores implementing datastore.ErrDatastore is cancelled, and
+	// a http.StatusServiceUnavailable is written when the deadline is exceeded.
+	AuthTimeout time.Duration
+
+	// PreHash, if set, is applied to the password from the request before verification.
+	// The stored hashes must then be hashes of PreHash(password), e.g. built with
+	// NewSimpleBasic(userId, SHA256PreHash(password)). Clients which pre-hash the password
+	// themselves send the pre-hashed value as password and need no PreHash.
+	PreHash func(password string) string
+
+	// NormalizeUnicode applies Unicode NFC normalization to the userid and password of the
+	// credential before lookup and verification, so a password typed on platforms producing
+	// different normalizations of the same string (NFC or NFD) compares equal, as RFC 7613
+	// recommends. Credentials which aren't valid UTF-8 are rejected as malformed. Userids in
+	// the data store and the passwords hashed into it must then be NFC too.
+	NormalizeUnicode bool
+
+	// OnSuccess is called after a successful authentication of userId, before the next handler.
+	// It may set response headers or return a modified request, e.g. with headers for downstream
+	// services. If it returns nil, the original request is used.
+	OnSuccess func(w http.ResponseWriter, req *http.Request, userId string) *http.Request
+
+	// Authorize, if set, is called after OnSuccess with the request of the authenticated userId,
+	// e.g. to check the scope required by the route with HasScope. The request gets a
+	// http.StatusForbidden instead of reaching the next handler if it returns false.
+	Authorize func(req *http.Request, userId string) bool
+
+	// Realm returns the realm of the authentication challenge sent for req,
+	// e.g. to show different login prompts per section. "Authorization Required" if nil
+	// or if it returns an empty string.
+	Realm func(req *http.Request) string
+
+	// Tenant returns the tenant of req, e.g. from the host or path, in a multi-tenant setup.
+	// Credentials are looked up within the tenant: data stores implementing
+	// datastore.TenantDatastore receive it as is, others receive "<tenant>:<userid>" as key,
+	// which is unambiguous since a userid can't contain a colon. The tenant is also part of
+	// the cache key and the session cookie, so credentials can't be reused across tenants.
+	// An empty tenant disables tenant isolation for req.
+	Tenant func(req *http.Request) string
+
+	// Logger receives warnings, e.g. backend errors. Credentials are never logged.
+	// The standard logger of package log if nil. If it's a ContextLogger, warnings about a
+	// request are logged with its context, like every callback here receives the request.
+	Logger Logger
+
+	// SlowThreshold, if positive, logs a warning with the userid and the duration of
+	// verifications taking longer, e.g. because the bcrypt cost is too high for the load.
+	SlowThreshold time.Duration
+
+	// DisableVary disables adding "Vary: Authorization" to responses, for setups
+	// which already take care that intermediary caches key on credentials.
+	DisableVary bool
+
+	// AllowPreflight passes CORS preflight requests (OPTIONS requests with Origin and
+	// Access-Control-Request-Method headers) to the next handler without authentication,
+	// since browsers never send credentials with them. The CORS middleware downstream is
+	// expected to answer them without calling the protected handler.
+	AllowPreflight bool
+
+	// FailureDelay, if positive, delays the response to a failed authentication by
+	// FailureDelay plus a random duration up to FailureJitter, to slow down credential stuffing.
+	// Requests without credentials, e.g. a browser's first request, aren't delayed.
+	// The delay ends early when the client goes away.
+	FailureDelay  time.Duration
+	FailureJitter time.Duration
+
+	// UserIdHeader, if set, is the name of a response header set to the authenticated userid,
+	// e.g. "X-Auth-User", so access logs of an edge proxy can capture it.
+	// It's only set on success, including cached authentications and session cookies.
+	UserIdHeader string
+
+	// EmptyUnauthorizedBody sends a http.StatusUnauthorized with the WWW-Authenticate header,
+	// "Content-Length: 0" and no body instead of the "Not Authorized" text, for strict APIs.
+	EmptyUnauthorizedBody bool
+
+	// ChallengeDecision, if set, chooses the response to a request failing authentication,
+	// e.g. by its User-Agent, Accept or X-Requested-With header, so browsers get the
+	// WWW-Authenticate prompt and scripts a JSON error on the same endpoint.
+	// ChallengeBrowser, the default, keeps EmptyUnauthorizedBody working.
+	ChallengeDecision func(req *http.Request) ChallengeKind
+
+	// LoginURL, if set, is sent in an X-Login-URL header with every http.StatusUnauthorized,
+	// and in the login_url field of their JSON body, so single-page apps can route to their
+	// login page. Combine it with SPAChallengeDecision to spare them the browser prompt.
+	LoginURL string
+
+	// UnauthorizedHandler, if set, writes the body of the http.StatusUnauthorized of a failed
+	// Basic authentication instead of the built-in one, after the challenge headers are set.
+	// Whatever status it writes, http.StatusUnauthorized is sent, and its body is truncated
+	// at MaxErrorBodyBytes so that a misbehaving handler keeps failures cheap under attack.
+	UnauthorizedHandler http.Handler
+
+	// MaxErrorBodyBytes bounds the body written by UnauthorizedHandler; 1024 if zero.
+	// Writes past it fail and the truncation is logged.
+	MaxErrorBodyBytes int
+
+	// RetryAfter is the Retry-After sent with the http.StatusServiceUnavailable written when the
+	// data store or the password verifier fails; 5 seconds if zero. It's rounded up to seconds.
+	RetryAfter time.Duration
+
+	// MalformedStatus, if set, is the status written without challenge when the Basic credential
+	// can't be decoded, e.g. http.StatusBadRequest for invalid base64 or a missing colon, so API
+	// clients treat it as their bug. Requests without credential or with wrong ones still get
+	// a http.StatusUnauthorized, which is also the default for malformed ones.
+	MalformedStatus int
+
+	// LoginRecorder, if set, records the userid, remote IP and time of each successful Basic
+	// authentication, including cached ones but not session cookies. Logins are recorded in
+	// the background and dropped if the recorder falls more than 1024 logins behind.
+	// Userids of a tenant are recorded as "tenant:userid". The recording goroutine runs until
+	// the middleware is closed, e.g. by CachedBasic.Close or the closer of NewFromConfig.
+	LoginRecorder LoginRecorder
+
+	// FailureCacheTTL, if positive, is how long a Basic credential which failed verification
+	// is denied again without data store lookup nor password verification, so credential
+	// stuffing replaying the same wrong credential costs little. Unlike the lockout, other
+	// credentials of the userid aren't affected; the replays don't count as lockout failures.
+	// If the data store implements datastore.Versioned, changing the password of a userid
+	// forgets its failed credentials; otherwise a credential which becomes correct is denied
+	// for up to FailureCacheTTL, so keep it short, e.g. 10 seconds.
+	FailureCacheTTL time.Duration
+
+	// Tracer, if set, traces the authentication of each request, its data store lookup and its
+	// password verification as spans started from the request context; see SpanAuthenticate.
+	Tracer Tracer
+
+	// MaxConcurrentVerifications, if positive, bounds the password verifications running at a
+	// time; further requests wait for a slot, so bcrypt can't take all CPUs under load.
+	// Cached authentications of CachedBasic don't take a slot and are served immediately.
+	MaxConcurrentVerifications int
+	// VerificationWait, if positive, bounds the wait for a verification slot. A request which
+	// can't get one in time gets a http.StatusServiceUnavailable with RetryAfter instead of
+	// queueing. Without it, requests wait until they are canceled or AuthTimeout is exceeded.
+	VerificationWait time.Duration
+
+	// UnsupportedSchemeReason sets "X-Auth-Reason: unsupported-scheme" on the
+	// http.StatusUnauthorized written for an Authorization header of another scheme than Basic,
+	// e.g. Negotiate or NTLM, and always advertises Basic in WWW-Authenticate for it, even with
+	// ChallengeJSON or ChallengeNone, so clients trying the wrong scheme first can fall back.
+	UnsupportedSchemeReason bool
+
+	// EmptyStoreReason sets "X-Auth-Reason: no-users-configured" on the http.StatusUnauthorized
+	// written while the data store implements datastore.Counted and holds no users, so an empty
+	// or mis-pathed config isn't mistaken for wrong credentials. It tells clients nothing about
+	// particular userids.
+	EmptyStoreReason bool
+
+	// Lockdown, if set, rejects all authentications while it's enabled, except those of its
+	// break-glass userid. Pass the same *Lockdown to several middlewares to switch them together.
+	Lockdown *Lockdown
+
+	// RequireTLS rejects requests not made over TLS with a http.StatusForbidden before looking
+	// at their credentials. Behind a proxy terminating TLS, requests reach the middleware
+	// without TLS, so it must not be set there.
+	RequireTLS bool
+
+	// LockoutThreshold and LockoutDuration, if both positive, lock a userid out for
+	// LockoutDuration after LockoutThreshold consecutive failed authentications: its
+	// authentications fail without verification until then. A successful authentication
+	// resets the count, and failures older than LockoutDuration are forgotten. Credentials
+	// cached by CacheBasic keep working, so an attacker can't lock out a user who has
+	// authenticated recently. Counts are kept per instance unless AttemptCounter is shared.
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+
+	// IPLockoutThreshold, if positive with LockoutDuration, locks a remote IP out for
+	// LockoutDuration after IPLockoutThreshold failed authentications of any userids, e.g.
+	// against password spraying. Successful authentications don't reset its count. The IP is
+	// the one of the connection, so behind a proxy every client shares the proxy's.
+	IPLockoutThreshold int
+
+	// AttemptCounter, if set, counts the failed authentications of the lockouts instead of an
+	// in-memory counter, e.g. in Redis, so the lockouts hold across instances. Its window must
+	// be LockoutDuration. Userids are counted as "user:userid" and IPs as "ip:address".
+	AttemptCounter AttemptCounter
+}
+
+// cacheTTL returns the lifetime of the cache entry for a successful authentication.
+func (o *Options) cacheTTL(req *http.Request, userId string, cacheExpireTime time.Duration) time.Duration {
+	if o.CacheTTL == nil {
+		return cacheExpireTime
+	}
+
+	ttl := o.CacheTTL(req, userId)
+	if ttl <= 0 {
+		return cacheExpireTime
+	}
+
+	max := o.MaxCacheTTL
+	if max <= 0 {
+		max = cacheExpireTime
+	}
+	if ttl > max {
+		return max
+	}
+	return ttl
+}
+
+// challengeKind returns the kind of response to req failing authentication.
+func (o *Options) challengeKind(req *http.Request) ChallengeKind {
+	if o.ChallengeDecision == nil {
+		return ChallengeBrowser
+	}
+	return o.ChallengeDecision(req)
+}
+
+// bypassCache returns true if CacheBasic must verify the credential of req without the cache.
+func (o *Options) bypassCache(req *http.Request) bool {
+	for _, method := range o.CacheBypassMethods {
+		if req.Method == method {
+			return true
+		}
+	}
+	return false
+}
+
+// vary marks the response as depending on the credentials of the request.
+func (o *Options) vary(w http.ResponseWriter) {
+	if o.DisableVary {
+		return
+	}
+	addVary(w.Header(), "Authorization")
+	if o.SessionCookie != nil {
+		addVary(w.Header(), "Cookie")
+	}
+}
+
+// failureDelay waits for the delay of a failed authentication of req.
+func (o *Options) failureDelay(req *http.Request) {
+	if o.FailureDelay <= 0 || req.Header.Get("Authorization") == "" {
+		return
+	}
+
+	delay := o.FailureDelay
+	if o.FailureJitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(o.FailureJitter)))
+	}
+
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-req.Context().Done():
+	}
+}
+
+// insecure writes a http.StatusForbidden and returns true if TLS is required but req isn't made over TLS.
+func (o *Options) insecure(w http.ResponseWriter, req *http.Request) bool {
+	if !o.RequireTLS || req.TLS != nil {
+		return false
+	}
+	rejectUpgrade(w, req)
+	http.Error(w, "TLS Required", http.StatusForbidden)
+	return true
+}
+
+// validate returns an error if o can't be used safely, e.g. a session cookie with a short key.
+func (o *Options) validate() error {
+	if o.SessionCookie != nil {
+		if err := checkKey("session cookie", o.SessionCookie.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lockout returns the lockout of failed authentications, or nil if it's disabled.
+func (o *Options) lockout() *lockout {
+	if (o.LockoutThreshold <= 0 && o.IPLockoutThreshold <= 0) || o.LockoutDuration <= 0 {
+		return nil
+	}
+	counter := o.AttemptCounter
+	if counter == nil {
+		counter = NewMemoryAttemptCounter(o.LockoutDuration)
+	}
+	return newLockout(o.LockoutThreshold, o.IPLockoutThreshold, counter)
+}
+
+// decodeHash returns the stored hash decoded by StoredHashDecoder.
+func (o *Options) decodeHash(stored []byte) ([]byte, error) {
+	if o.StoredHashDecoder == nil {
+		return stored, nil
+	}
+	hashedPassword, err := o.StoredHashDecoder(stored)
+	if err != nil {
+		return nil, fmt.Errorf("%w: decoding: %v", ErrMalformedHash, err)
+	}
+	return hashedPassword, nil
+}
+
+// belowTargetCost returns true if hashedPassword is a bcrypt hash below the target cost for ctx.
+func (o *Options) belowTargetCost(ctx context.Context, hashedPassword []byte) bool {
+	target, _ := o.targetCost(ctx)
+	cost, err := bcrypt.Cost(hashedPassword)
+	return err == nil && cost < target
+}
+
+// weakHash returns true if hashedPassword warrants the advisory of UpgradeAdvisory.
+func (o *Options) weakHash(ctx context.Context, hashedPassword []byte) bool {
+	if o.WeakHash != nil {
+		return o.WeakHash(hashedPassword)
+	}
+	return o.belowTargetCost(ctx, hashedPassword)
+}
+
+// maxCostOverrideRaise is how far WithTargetCost may raise the target cost above Options.Cost.
+const maxCostOverrideRaise = 2
+
+// targetCost returns the target bcrypt cost for ctx and whether it was overridden by WithTargetCost.
+func (o *Options) targetCost(ctx context.Context) (int, bool) {
+	if o.AllowCostOverride {
+		if cost, ok := targetCostFromContext(ctx); ok && cost >= bcrypt.MinCost && cost <= o.cost()+maxCostOverrideRaise && cost <= bcrypt.MaxCost {
+			return cost, true
+		}
+	}
+	return o.cost(), false
+}
+
+// cost returns the target bcrypt cost.
+func (o *Options) cost() int {
+	if o.Cost <= 0 {
+		return bcryptCost
+	}
+	return o.Cost
+}
+
+// logger returns the logger to use.
+func (o *Options) logger() Logger {
+	if o.Logger == nil {
+		return stdLogger{}
+	}
+	return o.Logger
+}
+
+// logf logs a message about the request of ctx.
+func (o *Options) logf(ctx context.Context, format string, v ...interface{}) {
+	logContext(o.logger(), ctx, format, v...)
+}
+
+// realm returns the realm of the authentication challenge for req.
+func (o *Options) realm(req *http.Request) string {
+	if o.Realm != nil {
+		if realm := o.Realm(req); realm != "" {
+			return realm
+		}
+	}
+	return defaultRealm
+}
+
+// tenant returns the tenant of req.
+func (o *Options) tenant(req *http.Request) string {
+	if o.Tenant == nil {
+		return ""
+	}
+	return o.Tenant(req)
+}
+
+// authenticated returns the request passed to the next handler after userId is authenticated.
+func (o *Options) authenticated(w http.ResponseWriter, req *http.Request, userId string) *http.Request {
+	if o.UserIdHeader != "" {
+		w.Header().Set(o.UserIdHeader, userId)
+	}
+	req = withUserId(req, userId)
+	if tenant := o.tenant(req); tenant != "" {
+		req = withTenant(req, tenant)
+	}
+	if o.OnSuccess != nil {
+		if r := o.OnSuccess(w, req, userId); r != nil {
+			req = r
+		}
+	}
+	return req
+}
+
+// Option sets an optional behavior of the middleware, as an alternative to filling Options.
+// It returns an error for an invalid value.
+type Option func(o *Options) error
+
+// apply returns o modified by opts.
+func (o Options) apply(opts []Option) (Options, error) {
+	for _, opt := range opts {
+		if err := opt(&o); err != nil {
+			return Options{}, err
+		}
+	}
+	return o, nil
+}
+
+// WithRealm sets the realm of the authentication challenge.
+func WithRealm(realm string) Option {
+	return func(o *Options) error {
+		if realm == "" {
+			return errors.New("auth: empty realm")
+		}
+		o.Realm = func(*http.Request) string { return realm }
+		return nil
+	}
+}
+
+// WithCost sets Options.Cost. Returns an error if cost is out of bcrypt's range.
+func WithCost(cost int) Option {
+	return func(o *Options) error {
+		if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+			return fmt.Errorf("auth: bcrypt cost must be between %d and %d, got %d", bcrypt.MinCost, bcrypt.MaxCost, cost)
+		}
+		o.Cost = cost
+		return nil
+	}
+}
+
+// WithLogger sets Options.Logger.
+func WithLogger(logger Logger) Option {
+	return func(o *Options) error {
+		o.Logger = logger
+		return nil
+	}
+}
+
+// WithVerifier sets Options.Verifier.
+func WithVerifier(verifier PasswordVerifier) Option {
+	return func(o *Options) error {
+		o.Verifier = verifier
+		return nil
+	}
+}
+
+// WithAuthTimeout sets Options.AuthTimeout.
+func WithAuthTimeout(timeout time.Duration) Option {
+	return func(o *Options) error {
+		if timeout < 0 {
+			return errors.New("auth: negative authentication timeout")
+		}
+		o.AuthTimeout = timeout
+		return nil
+	}
+}
+
+// WithPreHash sets Options.PreHash.
+func WithPreHash(preHash func(password string) string) Option {
+	return func(o *Options) error {
+		o.PreHash = preHash
+		return nil
+	}
+}
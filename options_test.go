@@ -0,0 +1,721 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codegangsta/negroni"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var cachettltests = []struct {
+	ttl  time.Duration
+	max  time.Duration
+	want time.Duration
+}{
+	{0, 0, time.Minute},
+	{time.Second, 0, time.Second},
+	{time.Hour, 0, time.Minute},
+	{time.Hour, 2 * time.Hour, time.Hour},
+	{3 * time.Hour, 2 * time.Hour, 2 * time.Hour},
+	{-time.Second, 2 * time.Hour, time.Minute},
+}
+
+func Test_OptionsCacheTTL(t *testing.T) {
+	r, _ := http.NewRequest("GET", "foo", nil)
+	for _, tt := range cachettltests {
+		ttl := tt.ttl
+		opts := Options{
+			CacheTTL:    func(*http.Request, string) time.Duration { return ttl },
+			MaxCacheTTL: tt.max,
+		}
+		if got := opts.cacheTTL(r, "foo", time.Minute); got != tt.want {
+			t.Errorf("Expected cacheTTL(%v, max %v) to return %v but got %v", tt.ttl, tt.max, tt.want, got)
+		}
+	}
+}
+
+func Test_OptionsOnSuccess(t *testing.T) {
+	ds, err := NewSimpleBasic("foo", "bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte("hello " + req.Header.Get("X-Authenticated-User")))
+	})
+
+	var onsuccesstests = []struct {
+		onSuccess func(http.ResponseWriter, *http.Request, string) *http.Request
+		body      string
+	}{
+		{func(w http.ResponseWriter, req *http.Request, userId string) *http.Request {
+			w.Header().Set("X-Authenticated-User", userId)
+			req.Header.Set("X-Authenticated-User", userId)
+			return req
+		}, "hello foo"},
+		{func(w http.ResponseWriter, req *http.Request, userId string) *http.Request {
+			w.Header().Set("X-Authenticated-User", userId)
+			return nil
+		}, "hello "},
+	}
+	for _, tt := range onsuccesstests {
+		m := negroni.New()
+		m.Use(NewBasicWithOptions(ds, Options{OnSuccess: tt.onSuccess}))
+		m.UseHandler(h)
+
+		r, _ := http.NewRequest("GET", "foo", nil)
+		r.SetBasicAuth("foo", "bar")
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, r)
+
+		if recorder.Body.String() != tt.body {
+			t.Errorf("Expected body %q, got %q", tt.body, recorder.Body.String())
+		}
+		if recorder.Header().Get("X-Authenticated-User") != "foo" {
+			t.Error("Expected response header to be set")
+		}
+	}
+}
+
+func Test_OptionsRealm(t *testing.T) {
+	ds, err := NewSimpleBasic("foo", "bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := Options{
+		Realm: func(req *http.Request) string {
+			if strings.HasPrefix(req.URL.Path, "/admin") {
+				return `Admin "Console"`
+			}
+			return ""
+		},
+	}
+	m := negroni.New()
+	m.Use(NewBasicWithOptions(ds, opts))
+
+	var realmtests = []struct {
+		path      string
+		challenge string
+	}{
+		{"/admin/users", `Basic realm="Admin \"Console\""`},
+		{"/partner", `Basic realm="Authorization Required"`},
+	}
+	for _, tt := range realmtests {
+		r, _ := http.NewRequest("GET", tt.path, nil)
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, r)
+
+		if got := recorder.Header().Get("WWW-Authenticate"); got != tt.challenge {
+			t.Errorf("Expected challenge %s for %s, got %s", tt.challenge, tt.path, got)
+		}
+	}
+}
+
+func Test_OptionsVary(t *testing.T) {
+	ds, err := NewSimpleBasic("foo", "bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var varytests = []struct {
+		opts Options
+		auth bool
+		vary []string
+	}{
+		{Options{}, false, []string{"Authorization"}},
+		{Options{}, true, []string{"Authorization"}},
+		{Options{SessionCookie: &SessionCookie{Key: []byte("0123456789abcdef0123456789abcdef")}}, true, []string{"Authorization", "Cookie"}},
+		{Options{DisableVary: true}, true, nil},
+	}
+	for _, tt := range varytests {
+		m := negroni.New()
+		m.Use(NewBasicWithOptions(ds, tt.opts))
+
+		r, _ := http.NewRequest("GET", "foo", nil)
+		if tt.auth {
+			r.SetBasicAuth("foo", "bar")
+		}
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, r)
+
+		if got := recorder.Header()["Vary"]; !reflect.DeepEqual(got, tt.vary) {
+			t.Errorf("Expected Vary %v, got %v", tt.vary, got)
+		}
+	}
+}
+
+type recordingLogger struct {
+	lines []string
+}
+
+func (l *recordingLogger) Printf(format string, v ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+func Test_OptionsSlowThreshold(t *testing.T) {
+	ds, err := NewSimpleBasic("foo", "bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var slowtests = []struct {
+		threshold time.Duration
+		logged    bool
+	}{
+		{0, false},
+		{time.Nanosecond, true},
+		{time.Hour, false},
+	}
+	for _, tt := range slowtests {
+		logger := &recordingLogger{}
+		m := negroni.New(NewBasicWithOptions(ds, Options{Logger: logger, SlowThreshold: tt.threshold}))
+
+		r, _ := http.NewRequest("GET", "foo", nil)
+		r.SetBasicAuth("foo", "bar")
+		m.ServeHTTP(httptest.NewRecorder(), r)
+
+		if logged := len(logger.lines) > 0; logged != tt.logged {
+			t.Errorf("Expected logging %v with threshold %v, got %v", tt.logged, tt.threshold, logger.lines)
+		}
+		for _, line := range logger.lines {
+			if !strings.Contains(line, `"foo"`) || strings.Contains(line, "bar") {
+				t.Errorf("Expected userid without password in %q", line)
+			}
+		}
+	}
+}
+
+func Test_OptionsAllowPreflight(t *testing.T) {
+	ds, err := NewSimpleBasic("foo", "bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var preflighttests = []struct {
+		allow  bool
+		method string
+		origin string
+		code   int
+	}{
+		{false, "OPTIONS", "https://example.com", http.StatusUnauthorized},
+		{true, "OPTIONS", "https://example.com", http.StatusNoContent},
+		{true, "OPTIONS", "", http.StatusUnauthorized},
+		{true, "GET", "https://example.com", http.StatusUnauthorized},
+	}
+	for _, tt := range preflighttests {
+		opts := Options{AllowPreflight: tt.allow}
+		for _, handler := range []negroni.Handler{
+			NewBasicWithOptions(ds, opts),
+			CacheBasicWithOptions(ds, time.Minute, 0, opts),
+		} {
+			m := negroni.New(handler)
+			m.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			}))
+
+			r, _ := http.NewRequest(tt.method, "foo", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			r.Header.Set("Access-Control-Request-Method", "PUT")
+			recorder := httptest.NewRecorder()
+			m.ServeHTTP(recorder, r)
+
+			if recorder.Code != tt.code {
+				t.Errorf("Expected %d for %s with AllowPreflight %v, got %d", tt.code, tt.method, tt.allow, recorder.Code)
+			}
+		}
+	}
+}
+
+func Test_OptionsFailureDelay(t *testing.T) {
+	opts := Options{FailureDelay: 50 * time.Millisecond, FailureJitter: 20 * time.Millisecond}
+
+	var failuredelaytests = []struct {
+		credential bool
+		cancel     bool
+		delayed    bool
+	}{
+		{true, false, true},
+		{false, false, false},
+		{true, true, false},
+	}
+	for _, tt := range failuredelaytests {
+		ctx, cancel := context.WithCancel(context.Background())
+		if tt.cancel {
+			cancel()
+		}
+		r, _ := http.NewRequest("GET", "foo", nil)
+		r = r.WithContext(ctx)
+		if tt.credential {
+			r.SetBasicAuth("foo", "wrong")
+		}
+
+		start := time.Now()
+		opts.failureDelay(r)
+		elapsed := time.Since(start)
+		cancel()
+
+		if delayed := elapsed >= opts.FailureDelay; delayed != tt.delayed {
+			t.Errorf("Expected delayed %v with credential %v and cancel %v, took %v", tt.delayed, tt.credential, tt.cancel, elapsed)
+		}
+		if elapsed > opts.FailureDelay+opts.FailureJitter+50*time.Millisecond {
+			t.Errorf("Delay of %v exceeds the jitter", elapsed)
+		}
+	}
+}
+
+func Test_OptionsUserIdHeader(t *testing.T) {
+	ds, err := NewSimpleBasic("foo", "bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var useridheadertests = []struct {
+		header   string
+		password string
+		want     string
+	}{
+		{"X-Auth-User", "bar", "foo"},
+		{"X-Auth-User", "wrong", ""},
+		{"", "bar", ""},
+	}
+	for _, tt := range useridheadertests {
+		opts := Options{UserIdHeader: tt.header}
+		m := negroni.New(CacheBasicWithOptions(ds, time.Minute, 0, opts))
+
+		// The second request hits the cache.
+		for i := 0; i < 2; i++ {
+			r, _ := http.NewRequest("GET", "foo", nil)
+			r.SetBasicAuth("foo", tt.password)
+			recorder := httptest.NewRecorder()
+			m.ServeHTTP(recorder, r)
+
+			if got := recorder.Header().Get("X-Auth-User"); got != tt.want {
+				t.Errorf("Expected X-Auth-User %q with header %q and password %s, got %q", tt.want, tt.header, tt.password, got)
+			}
+		}
+	}
+}
+
+func Test_OptionsEmptyUnauthorizedBody(t *testing.T) {
+	ds, err := NewSimpleBasic("foo", "bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var emptybodytests = []struct {
+		empty         bool
+		body          string
+		contentLength string
+	}{
+		{false, "Not Authorized\n", ""},
+		{true, "", "0"},
+	}
+	for _, tt := range emptybodytests {
+		m := negroni.New()
+		m.Use(negroni.HandlerFunc(func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+			// Headers set upstream for a body must not survive an empty 401.
+			w.Header().Set("Content-Type", "application/json")
+			next(w, r)
+		}))
+		m.Use(NewBasicWithOptions(ds, Options{EmptyUnauthorizedBody: tt.empty}))
+
+		r, _ := http.NewRequest("GET", "foo", nil)
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, r)
+
+		if recorder.Code != http.StatusUnauthorized {
+			t.Errorf("Expected 401 with EmptyUnauthorizedBody %v, got %d", tt.empty, recorder.Code)
+		}
+		if recorder.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("Expected a challenge with EmptyUnauthorizedBody %v", tt.empty)
+		}
+		if got := recorder.Body.String(); got != tt.body {
+			t.Errorf("Expected body %q with EmptyUnauthorizedBody %v, got %q", tt.body, tt.empty, got)
+		}
+		if got := recorder.Header().Get("Content-Length"); got != tt.contentLength {
+			t.Errorf("Expected Content-Length %q with EmptyUnauthorizedBody %v, got %q", tt.contentLength, tt.empty, got)
+		}
+		if tt.empty && recorder.Header().Get("Content-Type") != "" {
+			t.Errorf("Expected no Content-Type for an empty body, got %q", recorder.Header().Get("Content-Type"))
+		}
+	}
+}
+
+func Test_OptionsLockout(t *testing.T) {
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("bar"), bcrypt.MinCost)
+	opts := Options{LockoutThreshold: 3, LockoutDuration: time.Minute}
+	a := newBasicAuth(mockMapDataStore{"foo": hashedPassword}, opts)
+	now := time.Unix(1000, 0)
+	a.lockout.counter.(*MemoryAttemptCounter).now = func() time.Time { return now }
+	m := negroni.New(a)
+
+	var lockouttests = []struct {
+		password string
+		advance  time.Duration
+		code     int
+	}{
+		// A success resets the count.
+		{"wrong", 0, 401},
+		{"wrong", 0, 401},
+		{"bar", 0, 200},
+		{"wrong", 0, 401},
+		{"wrong", 0, 401},
+		{"wrong", 0, 401},
+		// Locked out, even with the right password.
+		{"bar", 0, 401},
+		{"bar", 2 * time.Minute, 200},
+	}
+	for i, tt := range lockouttests {
+		now = now.Add(tt.advance)
+		r, _ := http.NewRequest("GET", "foo", nil)
+		r.SetBasicAuth("foo", tt.password)
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, r)
+
+		if recorder.Code != tt.code {
+			t.Errorf("Expected %d for attempt %d with %s, got %d", tt.code, i, tt.password, recorder.Code)
+		}
+	}
+}
+
+func Test_OptionsMalformedStatus(t *testing.T) {
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("bar"), bcrypt.MinCost)
+	ds := mockMapDataStore{"foo": hashedPassword}
+
+	var malformedtests = []struct {
+		status    int
+		header    string
+		code      int
+		challenge bool
+	}{
+		{0, "Basic !!!", 401, true},
+		{http.StatusBadRequest, "Basic !!!", 400, false},
+		{http.StatusBadRequest, "Basic " + base64.StdEncoding.EncodeToString([]byte("foobar")), 400, false},
+		{http.StatusBadRequest, "", 401, true},
+		{http.StatusBadRequest, "Basic " + base64.StdEncoding.EncodeToString([]byte("foo:wrong")), 401, true},
+		{http.StatusBadRequest, "Basic " + base64.StdEncoding.EncodeToString([]byte("foo:bar")), 200, false},
+	}
+	for _, tt := range malformedtests {
+		opts := Options{MalformedStatus: tt.status}
+		for _, handler := range []negroni.HandlerFunc{NewBasicWithOptions(ds, opts), CacheBasicWithOptions(ds, time.Minute, 0, opts)} {
+			m := negroni.New(handler)
+
+			r, _ := http.NewRequest("GET", "foo", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			recorder := httptest.NewRecorder()
+			m.ServeHTTP(recorder, r)
+
+			if recorder.Code != tt.code {
+				t.Errorf("Expected %d for %q with MalformedStatus %d, got %d", tt.code, tt.header, tt.status, recorder.Code)
+			}
+			if got := recorder.Header().Get("WWW-Authenticate") != ""; got != tt.challenge {
+				t.Errorf("Expected challenge %v for %q with MalformedStatus %d, got %v", tt.challenge, tt.header, tt.status, got)
+			}
+		}
+	}
+}
+
+func Test_OptionsStoredHashDecoder(t *testing.T) {
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("bar"), bcrypt.MinCost)
+	ds := mockMapDataStore{
+		"prefixed": []byte("{BCRYPT}" + string(hashedPassword)),
+		"wrapped":  []byte(base64.StdEncoding.EncodeToString(hashedPassword)),
+		"broken":   []byte("{BCRYPT}!!!"),
+	}
+	decoder := func(stored []byte) ([]byte, error) {
+		if s := string(stored); strings.HasPrefix(s, "{BCRYPT}") {
+			stored = []byte(strings.TrimPrefix(s, "{BCRYPT}"))
+			if _, err := bcrypt.Cost(stored); err != nil {
+				return nil, err
+			}
+			return stored, nil
+		}
+		return base64.StdEncoding.DecodeString(string(stored))
+	}
+	logger := &recordingLogger{}
+	m := negroni.New(NewBasicWithOptions(ds, Options{StoredHashDecoder: decoder, Logger: logger}))
+
+	var decodertests = []struct {
+		userId   string
+		password string
+		code     int
+	}{
+		{"prefixed", "bar", http.StatusOK},
+		{"prefixed", "baz", http.StatusUnauthorized},
+		{"wrapped", "bar", http.StatusOK},
+		{"broken", "bar", http.StatusServiceUnavailable},
+	}
+	for _, tt := range decodertests {
+		r, _ := http.NewRequest("GET", "foo", nil)
+		r.SetBasicAuth(tt.userId, tt.password)
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, r)
+
+		if recorder.Code != tt.code {
+			t.Errorf("Expected %d for %s:%s, got %d", tt.code, tt.userId, tt.password, recorder.Code)
+		}
+	}
+	if len(logger.lines) != 1 || !strings.Contains(logger.lines[0], ErrMalformedHash.Error()) {
+		t.Errorf("Expected the malformed hash to be logged, got %q", logger.lines)
+	}
+}
+
+func Test_FunctionalOptions(t *testing.T) {
+	ds, err := NewSimpleBasic("foo", "bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var functionaloptiontests = []struct {
+		opts  []Option
+		valid bool
+	}{
+		{nil, true},
+		{[]Option{WithRealm("api"), WithCost(10), WithLogger(&recordingLogger{}), WithAuthTimeout(time.Second)}, true},
+		{[]Option{WithRealm("")}, false},
+		{[]Option{WithCost(bcrypt.MinCost - 1)}, false},
+		{[]Option{WithCost(bcrypt.MaxCost + 1)}, false},
+		{[]Option{WithAuthTimeout(-time.Second)}, false},
+	}
+	for _, tt := range functionaloptiontests {
+		if _, err := NewBasicValidated(ds, tt.opts...); (err == nil) != tt.valid {
+			t.Errorf("Expected valid %v for %d options, got %v", tt.valid, len(tt.opts), err)
+		}
+	}
+
+	m := negroni.New(NewBasic(ds, WithRealm("api")))
+	r, _ := http.NewRequest("GET", "foo", nil)
+	recorder := httptest.NewRecorder()
+	m.ServeHTTP(recorder, r)
+	if got := recorder.Header().Get("WWW-Authenticate"); got != `Basic realm="api"` {
+		t.Errorf("Expected realm api, got %s", got)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected NewBasic to panic on an invalid option")
+		}
+	}()
+	NewBasic(ds, WithCost(0))
+}
+
+func Test_OptionsChallengeDecision(t *testing.T) {
+	ds, err := NewSimpleBasic("foo", "bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decide := func(r *http.Request) ChallengeKind {
+		switch {
+		case r.Header.Get("X-Requested-With") == "XMLHttpRequest":
+			return ChallengeJSON
+		case strings.HasPrefix(r.UserAgent(), "Prometheus/"):
+			return ChallengeNone
+		}
+		return ChallengeBrowser
+	}
+
+	var challengetests = []struct {
+		header    string
+		value     string
+		challenge bool
+		body      string
+	}{
+		{"User-Agent", "Mozilla/5.0", true, "Not Authorized\n"},
+		{"X-Requested-With", "XMLHttpRequest", false, `{"error":"unauthorized","message":"Not Authorized"}` + "\n"},
+		{"User-Agent", "Prometheus/2.45.0", false, ""},
+	}
+	for _, tt := range challengetests {
+		r, _ := http.NewRequest("GET", "foo", nil)
+		r.Header.Set(tt.header, tt.value)
+		r.SetBasicAuth("foo", "wrong")
+		recorder := httptest.NewRecorder()
+		negroni.New(NewBasicWithOptions(ds, Options{ChallengeDecision: decide})).ServeHTTP(recorder, r)
+
+		if recorder.Code != http.StatusUnauthorized {
+			t.Errorf("Expected 401 for %s %q, got %d", tt.header, tt.value, recorder.Code)
+		}
+		if got := recorder.Header().Get("WWW-Authenticate") != ""; got != tt.challenge {
+			t.Errorf("Expected challenge %v for %s %q, got %v", tt.challenge, tt.header, tt.value, got)
+		}
+		if got := recorder.Body.String(); got != tt.body {
+			t.Errorf("Expected body %q for %s %q, got %q", tt.body, tt.header, tt.value, got)
+		}
+	}
+}
+
+func Test_OptionsUnsupportedSchemeReason(t *testing.T) {
+	ds, err := NewSimpleBasic("foo", "bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var schemetests = []struct {
+		authorization string
+		explain       bool
+		kind          ChallengeKind
+		reason        string
+		challenge     bool
+	}{
+		{"Negotiate YIIB", true, ChallengeBrowser, "unsupported-scheme", true},
+		{"NTLM TlRMTVNTUAAB", true, ChallengeJSON, "unsupported-scheme", true},
+		{"NTLM TlRMTVNTUAAB", false, ChallengeJSON, "", false},
+		{"Negotiate YIIB", false, ChallengeBrowser, "", true},
+		// Wrong Basic credentials get no reason.
+		{"Basic " + base64.StdEncoding.EncodeToString([]byte("foo:wrong")), true, ChallengeNone, "", false},
+	}
+	for _, tt := range schemetests {
+		kind := tt.kind
+		m := negroni.New(NewBasicWithOptions(ds, Options{
+			UnsupportedSchemeReason: tt.explain,
+			ChallengeDecision:       func(*http.Request) ChallengeKind { return kind },
+		}))
+		r, _ := http.NewRequest("GET", "foo", nil)
+		r.Header.Set("Authorization", tt.authorization)
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, r)
+
+		if recorder.Code != http.StatusUnauthorized {
+			t.Errorf("Expected 401 for %q, got %d", tt.authorization, recorder.Code)
+		}
+		if got := recorder.Header().Get("X-Auth-Reason"); got != tt.reason {
+			t.Errorf("Expected reason %q for %q, got %q", tt.reason, tt.authorization, got)
+		}
+		if got := strings.HasPrefix(recorder.Header().Get("WWW-Authenticate"), "Basic "); got != tt.challenge {
+			t.Errorf("Expected Basic challenge %v for %q, got %q", tt.challenge, tt.authorization, recorder.Header().Get("WWW-Authenticate"))
+		}
+	}
+}
+
+func Test_OptionsNormalizeUnicode(t *testing.T) {
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("caf\u00e9"), bcrypt.MinCost)
+	ds := mockMapDataStore{"Jos\u00e9": hashedPassword}
+
+	var normalizetests = []struct {
+		normalize bool
+		userId    string
+		password  string
+		code      int
+	}{
+		{true, "Jos\u00e9", "caf\u00e9", http.StatusOK},
+		// NFD, e.g. typed on macOS.
+		{true, "Jose\u0301", "cafe\u0301", http.StatusOK},
+		{false, "Jos\u00e9", "caf\u00e9", http.StatusOK},
+		{false, "Jose\u0301", "cafe\u0301", http.StatusUnauthorized},
+		{false, "Jos\u00e9", "cafe\u0301", http.StatusUnauthorized},
+		// Invalid UTF-8 is malformed.
+		{true, "Jos\u00e9", "caf\xe9", http.StatusBadRequest},
+		{false, "Jos\u00e9", "caf\xe9", http.StatusUnauthorized},
+	}
+	for _, tt := range normalizetests {
+		m := negroni.New(NewBasicWithOptions(ds, Options{NormalizeUnicode: tt.normalize, MalformedStatus: http.StatusBadRequest}))
+		r, _ := http.NewRequest("GET", "foo", nil)
+		r.SetBasicAuth(tt.userId, tt.password)
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, r)
+
+		if recorder.Code != tt.code {
+			t.Errorf("Expected %d for %q:%q with NormalizeUnicode %v, got %d", tt.code, tt.userId, tt.password, tt.normalize, recorder.Code)
+		}
+	}
+}
+
+func Test_OptionsLoginURL(t *testing.T) {
+	ds, err := NewSimpleBasic("foo", "bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var loginurltests = []struct {
+		loginURL  string
+		header    string
+		value     string
+		challenge bool
+		body      string
+	}{
+		{"/login", "X-Requested-With", "XMLHttpRequest", false, `{"error":"unauthorized","message":"Not Authorized","login_url":"/login"}` + "\n"},
+		{"/login", "Accept", "application/json", false, `{"error":"unauthorized","message":"Not Authorized","login_url":"/login"}` + "\n"},
+		{"/login", "Accept", "text/html,application/json;q=0.9", true, "Not Authorized\n"},
+		{"", "X-Requested-With", "XMLHttpRequest", false, `{"error":"unauthorized","message":"Not Authorized"}` + "\n"},
+	}
+	for _, tt := range loginurltests {
+		m := negroni.New(NewBasicWithOptions(ds, Options{LoginURL: tt.loginURL, ChallengeDecision: SPAChallengeDecision}))
+		r, _ := http.NewRequest("GET", "foo", nil)
+		r.Header.Set(tt.header, tt.value)
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, r)
+
+		if got := recorder.Header().Get("X-Login-URL"); recorder.Code != http.StatusUnauthorized || got != tt.loginURL {
+			t.Errorf("Expected 401 with X-Login-URL %q for %s %q, got %d %q", tt.loginURL, tt.header, tt.value, recorder.Code, got)
+		}
+		if got := recorder.Header().Get("WWW-Authenticate") != ""; got != tt.challenge {
+			t.Errorf("Expected challenge %v for %s %q, got %v", tt.challenge, tt.header, tt.value, got)
+		}
+		if got := recorder.Body.String(); got != tt.body {
+			t.Errorf("Expected body %q for %s %q, got %q", tt.body, tt.header, tt.value, got)
+		}
+	}
+}
+
+func Test_OptionsUpgradeAdvisory(t *testing.T) {
+	weak, err := bcrypt.GenerateFromPassword([]byte("bar"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	strong, err := bcrypt.GenerateFromPassword([]byte("bar"), bcrypt.MinCost+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	isStrong := func(hashedPassword []byte) bool { return string(hashedPassword) == string(strong) }
+
+	var advisorytests = []struct {
+		hashedPassword []byte
+		advisory       bool
+		weakHash       func([]byte) bool
+		password       string
+		header         string
+	}{
+		{weak, true, nil, "bar", "password-upgrade-recommended"},
+		{strong, true, nil, "bar", ""},
+		{weak, false, nil, "bar", ""},
+		{weak, true, nil, "baz", ""},
+		// The old algorithm here is the stronger hash.
+		{strong, true, isStrong, "bar", "password-upgrade-recommended"},
+	}
+	for i, tt := range advisorytests {
+		opts := Options{Cost: bcrypt.MinCost + 1, UpgradeAdvisory: tt.advisory, WeakHash: tt.weakHash}
+		b, err := NewCachedBasic(&MockDataStore{tt.hashedPassword}, time.Minute, 0, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var recommended bool
+		for name, mw := range map[string]negroni.Handler{"basic": NewBasicWithOptions(&MockDataStore{tt.hashedPassword}, opts), "cached": b} {
+			m := negroni.New(mw)
+			m.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				recommended = UpgradeRecommended(r.Context())
+			}))
+			// The second request of cached is a cache hit.
+			for j := 0; j < 2; j++ {
+				recommended = false
+				r, _ := http.NewRequest("GET", "foo", nil)
+				r.SetBasicAuth("foo", tt.password)
+				recorder := httptest.NewRecorder()
+				m.ServeHTTP(recorder, r)
+				if got := recorder.Header().Get("X-Auth-Advisory"); got != tt.header || recommended != (tt.header != "") {
+					t.Errorf("Expected advisory %q for test %d with %s, got %q (%v)", tt.header, i, name, got, recommended)
+				}
+			}
+		}
+		b.Close()
+	}
+}
@@ -0,0 +1,252 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// PHC is a hash in the PHC string format, "$id[$v=version][$param=value(,param=value)*][$salt[$hash]]",
+// with the salt and the hash in base64 without padding.
+type PHC struct {
+	ID string
+	// Version is the value of the "v" segment, if any.
+	Version string
+	Params  map[string]string
+	Salt    []byte
+	Hash    []byte
+}
+
+// ParsePHC parses hashedPassword as a PHC string. Errors wrap ErrMalformedHash.
+// bcrypt hashes, which predate the format, are parsed as their id, e.g. "2a", and a "cost"
+// parameter; their salt and hash, which use another base64 alphabet, are left empty.
+func ParsePHC(hashedPassword []byte) (*PHC, error) {
+	s := string(hashedPassword)
+	if !strings.HasPrefix(s, "$") {
+		return nil, fmt.Errorf("%w: not a PHC string", ErrMalformedHash)
+	}
+	fields := strings.Split(s[1:], "$")
+	p := &PHC{ID: fields[0], Params: map[string]string{}}
+	if !validPHCID(p.ID) {
+		return nil, fmt.Errorf("%w: invalid PHC id %q", ErrMalformedHash, p.ID)
+	}
+	fields = fields[1:]
+
+	if isBcryptID(p.ID) {
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%w: invalid bcrypt hash", ErrMalformedHash)
+		}
+		p.Params["cost"] = fields[0]
+		return p, nil
+	}
+
+	if len(fields) > 0 && strings.HasPrefix(fields[0], "v=") {
+		p.Version, fields = fields[0][len("v="):], fields[1:]
+	}
+	if len(fields) > 0 && strings.Contains(fields[0], "=") {
+		for _, param := range strings.Split(fields[0], ",") {
+			name, value, ok := strings.Cut(param, "=")
+			if !ok || name == "" || value == "" {
+				return nil, fmt.Errorf("%w: invalid PHC parameter %q", ErrMalformedHash, param)
+			}
+			if _, dup := p.Params[name]; dup {
+				return nil, fmt.Errorf("%w: duplicate PHC parameter %q", ErrMalformedHash, name)
+			}
+			p.Params[name] = value
+		}
+		fields = fields[1:]
+	}
+	if len(fields) > 2 {
+		return nil, fmt.Errorf("%w: too many PHC fields", ErrMalformedHash)
+	}
+	var err error
+	if len(fields) > 0 {
+		if p.Salt, err = base64.RawStdEncoding.DecodeString(fields[0]); err != nil {
+			return nil, fmt.Errorf("%w: invalid PHC salt", ErrMalformedHash)
+		}
+	}
+	if len(fields) > 1 {
+		if p.Hash, err = base64.RawStdEncoding.DecodeString(fields[1]); err != nil || len(p.Hash) == 0 {
+			return nil, fmt.Errorf("%w: invalid PHC hash", ErrMalformedHash)
+		}
+	}
+	return p, nil
+}
+
+// PHC.Uint returns the parameter name as an unsigned integer of at most max.
+// Errors wrap ErrMalformedHash.
+func (p *PHC) Uint(name string, max uint64) (uint64, error) {
+	value, ok := p.Params[name]
+	if !ok {
+		return 0, fmt.Errorf("%w: missing %s parameter %q", ErrMalformedHash, p.ID, name)
+	}
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil || n > max {
+		return 0, fmt.Errorf("%w: invalid %s parameter %q", ErrMalformedHash, p.ID, name)
+	}
+	return n, nil
+}
+
+// validPHCID reports whether id only has the characters allowed by the PHC string format.
+func validPHCID(id string) bool {
+	if id == "" || len(id) > 32 {
+		return false
+	}
+	for _, c := range id {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+func isBcryptID(id string) bool {
+	return id == "2a" || id == "2b" || id == "2y"
+}
+
+var phcVerifiers = struct {
+	sync.RWMutex
+	m map[string]PasswordVerifier
+}{m: map[string]PasswordVerifier{
+	"2a":       BcryptVerifier{},
+	"2b":       BcryptVerifier{},
+	"2y":       BcryptVerifier{},
+	"argon2i":  Argon2Verifier{},
+	"argon2id": Argon2Verifier{},
+	"scrypt":   ScryptVerifier{},
+}}
+
+// RegisterVerifier registers v as the verifier of the hashes whose PHC id is id for PHCVerifier,
+// replacing any verifier registered for id, e.g. one of the built-in bcrypt, argon2 and scrypt
+// verifiers. It panics if id isn't a valid PHC id or v is nil.
+func RegisterVerifier(id string, v PasswordVerifier) {
+	if !validPHCID(id) {
+		panic(fmt.Sprintf("auth: invalid PHC id %q", id))
+	}
+	if v == nil {
+		panic("auth: RegisterVerifier with a nil verifier")
+	}
+	phcVerifiers.Lock()
+	defer phcVerifiers.Unlock()
+	phcVerifiers.m[id] = v
+}
+
+// registeredVerifier returns the verifier registered for id.
+func registeredVerifier(id string) (PasswordVerifier, bool) {
+	phcVerifiers.RLock()
+	defer phcVerifiers.RUnlock()
+	v, ok := phcVerifiers.m[id]
+	return v, ok
+}
+
+// PHCVerifier is a PasswordVerifier for data stores holding PHC strings, e.g. of argon2 and
+// of bcrypt, which are verified by the verifier registered for their id with RegisterVerifier.
+// Malformed hashes and unknown ids are reported as ErrMalformedHash.
+type PHCVerifier struct{}
+
+// PHCVerifier.Verify verifies password with the verifier registered for the id of hashedPassword.
+func (PHCVerifier) Verify(ctx context.Context, userId string, hashedPassword, password []byte) error {
+	p, err := ParsePHC(hashedPassword)
+	if err != nil {
+		return err
+	}
+	v, ok := registeredVerifier(p.ID)
+	if !ok {
+		return fmt.Errorf("%w: unknown PHC id %q", ErrMalformedHash, p.ID)
+	}
+	return v.Verify(ctx, userId, hashedPassword, password)
+}
+
+// Argon2Verifier is a PasswordVerifier for argon2id and argon2i hashes in the PHC string format,
+// e.g. "$argon2id$v=19$m=65536,t=3,p=4$salt$hash".
+type Argon2Verifier struct{}
+
+// Argon2Verifier.Verify compares password with the argon2 hashedPassword.
+func (Argon2Verifier) Verify(ctx context.Context, userId string, hashedPassword, password []byte) error {
+	p, err := ParsePHC(hashedPassword)
+	if err != nil {
+		return err
+	}
+	key := argon2.IDKey
+	switch p.ID {
+	case "argon2id":
+	case "argon2i":
+		key = argon2.Key
+	default:
+		return fmt.Errorf("%w: not an argon2 hash", ErrMalformedHash)
+	}
+	if p.Version != "" && p.Version != strconv.Itoa(argon2.Version) {
+		return fmt.Errorf("%w: unsupported argon2 version %q", ErrMalformedHash, p.Version)
+	}
+	if len(p.Hash) == 0 {
+		return fmt.Errorf("%w: missing argon2 hash", ErrMalformedHash)
+	}
+	memory, err := p.Uint("m", math.MaxUint32)
+	if err != nil {
+		return err
+	}
+	iterations, err := p.Uint("t", math.MaxUint32)
+	if err != nil {
+		return err
+	}
+	threads, err := p.Uint("p", math.MaxUint8)
+	if err != nil {
+		return err
+	}
+	if iterations == 0 || threads == 0 {
+		return fmt.Errorf("%w: invalid argon2 parameters", ErrMalformedHash)
+	}
+
+	sum := key(password, p.Salt, uint32(iterations), uint32(memory), uint8(threads), uint32(len(p.Hash)))
+	if subtle.ConstantTimeCompare(sum, p.Hash) != 1 {
+		return ErrPasswordMismatch
+	}
+	return nil
+}
+
+// ScryptVerifier is a PasswordVerifier for scrypt hashes in the PHC string format,
+// e.g. "$scrypt$ln=15,r=8,p=1$salt$hash", where ln is the base-2 logarithm of N.
+type ScryptVerifier struct{}
+
+// ScryptVerifier.Verify compares password with the scrypt hashedPassword.
+func (ScryptVerifier) Verify(ctx context.Context, userId string, hashedPassword, password []byte) error {
+	p, err := ParsePHC(hashedPassword)
+	if err != nil {
+		return err
+	}
+	if p.ID != "scrypt" {
+		return fmt.Errorf("%w: not a scrypt hash", ErrMalformedHash)
+	}
+	if len(p.Hash) == 0 {
+		return fmt.Errorf("%w: missing scrypt hash", ErrMalformedHash)
+	}
+	ln, err := p.Uint("ln", 30)
+	if err != nil {
+		return err
+	}
+	r, err := p.Uint("r", math.MaxInt32)
+	if err != nil {
+		return err
+	}
+	parallelism, err := p.Uint("p", math.MaxInt32)
+	if err != nil {
+		return err
+	}
+
+	sum, err := scrypt.Key(password, p.Salt, 1<<ln, int(r), int(parallelism), len(p.Hash))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrMalformedHash, err)
+	}
+	if subtle.ConstantTimeCompare(sum, p.Hash) != 1 {
+		return ErrPasswordMismatch
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+func Test_ParsePHC(t *testing.T) {
+	var phctests = []struct {
+		hash    string
+		id      string
+		version string
+		params  map[string]string
+		salt    string
+		err     error
+	}{
+		{"$argon2id$v=19$m=65536,t=3,p=4$c2FsdA$aGFzaA", "argon2id", "19", map[string]string{"m": "65536", "t": "3", "p": "4"}, "salt", nil},
+		{"$scrypt$ln=15,r=8,p=1$c2FsdA$aGFzaA", "scrypt", "", map[string]string{"ln": "15", "r": "8", "p": "1"}, "salt", nil},
+		{"$custom", "custom", "", map[string]string{}, "", nil},
+		{"$2a$12$R9h/cIPz0gi.URNNX3kh2OPST9/PgBkqquzi.Ss7KIUgO2t0jWMUW", "2a", "", map[string]string{"cost": "12"}, "", nil},
+		{"argon2id$v=19", "", "", nil, "", ErrMalformedHash},
+		{"$", "", "", nil, "", ErrMalformedHash},
+		{"$Argon2id$m=1", "", "", nil, "", ErrMalformedHash},
+		{"$argon2id$m=1,m=2$c2FsdA$aGFzaA", "", "", nil, "", ErrMalformedHash},
+		{"$argon2id$m=,t=3$c2FsdA$aGFzaA", "", "", nil, "", ErrMalformedHash},
+		{"$argon2id$m=1$c2FsdA$aGFzaA$extra", "", "", nil, "", ErrMalformedHash},
+		{"$argon2id$m=1$!!!$aGFzaA", "", "", nil, "", ErrMalformedHash},
+		{"$2a$12", "", "", nil, "", ErrMalformedHash},
+	}
+	for _, tt := range phctests {
+		p, err := ParsePHC([]byte(tt.hash))
+		if !errors.Is(err, tt.err) {
+			t.Errorf("Expected %v for %q, got %v", tt.err, tt.hash, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if p.ID != tt.id || p.Version != tt.version || fmt.Sprint(p.Params) != fmt.Sprint(tt.params) || string(p.Salt) != tt.salt {
+			t.Errorf("Unexpected %#v for %q", p, tt.hash)
+		}
+	}
+}
+
+type constVerifier struct{ err error }
+
+func (v constVerifier) Verify(ctx context.Context, userId string, hashedPassword, password []byte) error {
+	return v.err
+}
+
+func Test_PHCVerifier(t *testing.T) {
+	b64 := base64.RawStdEncoding.EncodeToString
+	salt := []byte("0123456789abcdef")
+	argon2id := fmt.Sprintf("$argon2id$v=19$m=64,t=1,p=1$%s$%s", b64(salt), b64(argon2.IDKey([]byte("bar"), salt, 1, 64, 1, 32)))
+	argon2i := fmt.Sprintf("$argon2i$v=19$m=64,t=1,p=1$%s$%s", b64(salt), b64(argon2.Key([]byte("bar"), salt, 1, 64, 1, 32)))
+	key, err := scrypt.Key([]byte("bar"), salt, 16, 8, 1, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scryptHash := fmt.Sprintf("$scrypt$ln=4,r=8,p=1$%s$%s", b64(salt), b64(key))
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("bar"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	RegisterVerifier("test-custom", constVerifier{})
+	var verifiertests = []struct {
+		hash     string
+		password string
+		err      error
+	}{
+		{argon2id, "bar", nil},
+		{argon2id, "baz", ErrPasswordMismatch},
+		{argon2i, "bar", nil},
+		{argon2i, "baz", ErrPasswordMismatch},
+		{scryptHash, "bar", nil},
+		{scryptHash, "baz", ErrPasswordMismatch},
+		{string(bcryptHash), "bar", nil},
+		{string(bcryptHash), "baz", ErrPasswordMismatch},
+		{"$test-custom$anything", "baz", nil},
+		{"$unknown$c2FsdA$aGFzaA", "bar", ErrMalformedHash},
+		{"plaintext", "bar", ErrMalformedHash},
+		{"$argon2id$v=19$m=64,t=0,p=1$c2FsdA$aGFzaA", "bar", ErrMalformedHash},
+		{"$argon2id$v=16$m=64,t=1,p=1$c2FsdA$aGFzaA", "bar", ErrMalformedHash},
+		{"$argon2id$v=19$m=64,t=1$c2FsdA$aGFzaA", "bar", ErrMalformedHash},
+		{"$argon2id$v=19$m=64,t=1,p=1$c2FsdA", "bar", ErrMalformedHash},
+		{"$scrypt$ln=3,r=8,p=1$c2FsdA", "bar", ErrMalformedHash},
+		{"$scrypt$ln=0,r=8,p=1$c2FsdA$aGFzaA", "bar", ErrMalformedHash},
+	}
+	for _, tt := range verifiertests {
+		if err := (PHCVerifier{}).Verify(context.Background(), "foo", []byte(tt.hash), []byte(tt.password)); !errors.Is(err, tt.err) {
+			t.Errorf("Expected %v for %q with %q, got %v", tt.err, tt.hash, tt.password, err)
+		}
+	}
+
+	// A registered verifier replaces the built-in one.
+	RegisterVerifier("scrypt", constVerifier{errors.New("unavailable")})
+	defer RegisterVerifier("scrypt", ScryptVerifier{})
+	if err := (PHCVerifier{}).Verify(context.Background(), "foo", []byte(scryptHash), []byte("bar")); err == nil || errors.Is(err, ErrPasswordMismatch) {
+		t.Errorf("Expected the error of the registered verifier, got %v", err)
+	}
+}
+
+func Test_RegisterVerifierInvalid(t *testing.T) {
+	var registertests = []struct {
+		id string
+		v  PasswordVerifier
+	}{
+		{"", BcryptVerifier{}},
+		{"has$dollar", BcryptVerifier{}},
+		{"valid", nil},
+	}
+	for _, tt := range registertests {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected a panic for %q", tt.id)
+				}
+			}()
+			RegisterVerifier(tt.id, tt.v)
+		}()
+	}
+}
@@ -0,0 +1,161 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPlatformTokenCacheTTL = 5 * time.Minute
+	defaultPlatformTokenTimeout  = 5 * time.Second
+)
+
+// PlatformTokenStore is a TokenStore validating personal access tokens of a code hosting platform
+// such as GitHub or GitLab, for internal tools whose users already have an account there.
+// It calls the platform's user endpoint with the token and takes the userid from the
+// UsernameField of the response. A 401 response rejects the token; other failures are
+// backend errors. Resolved usernames are cached for CacheTTL, so revoked tokens keep working
+// until then.
+type PlatformTokenStore struct {
+	// URL of the endpoint describing the authenticated user, e.g. "https://api.github.com/user".
+	URL string
+	// UsernameField is the field of the response holding the username, e.g. "login".
+	UsernameField string
+	// Client used for requests.
+	Client *http.Client
+	// Timeout bounds each request; 5 seconds if zero.
+	Timeout time.Duration
+	// CacheTTL bounds how long a resolved username is cached; 5 minutes if zero.
+	CacheTTL time.Duration
+
+	cache tokenCache
+	now   func() time.Time
+}
+
+// NewGitHubTokenStore returns *PlatformTokenStore for the GitHub API at apiURL, e.g.
+// "https://api.github.com" or "https://github.example.com/api/v3" for GitHub Enterprise Server.
+// The userid is the GitHub login.
+func NewGitHubTokenStore(apiURL string) *PlatformTokenStore {
+	return &PlatformTokenStore{
+		URL:           strings.TrimSuffix(apiURL, "/") + "/user",
+		UsernameField: "login",
+	}
+}
+
+// NewGitLabTokenStore returns *PlatformTokenStore for the GitLab instance at baseURL, e.g.
+// "https://gitlab.com". The userid is the GitLab username.
+func NewGitLabTokenStore(baseURL string) *PlatformTokenStore {
+	return &PlatformTokenStore{
+		URL:           strings.TrimSuffix(baseURL, "/") + "/api/v4/user",
+		UsernameField: "username",
+	}
+}
+
+// PlatformTokenStore.Get returns the username of the owner of token.
+func (s *PlatformTokenStore) Get(ctx context.Context, token string) (string, error) {
+	now := s.clock()
+	key := sha256.Sum256([]byte(token))
+	if userId, found := s.cache.get(key, now); found {
+		return userId, nil
+	}
+
+	userId, err := s.user(ctx, token)
+	if err != nil {
+		return "", err
+	}
+
+	ttl := s.CacheTTL
+	if ttl <= 0 {
+		ttl = defaultPlatformTokenCacheTTL
+	}
+	s.cache.set(key, tokenCacheEntry{userId: userId, expires: now.Add(ttl)}, now)
+	return userId, nil
+}
+
+// user asks the platform who owns token.
+func (s *PlatformTokenStore) user(ctx context.Context, token string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout())
+	defer cancel()
+
+	var user map[string]interface{}
+	status, err := s.do(ctx, token, &user)
+	switch {
+	case err != nil:
+		return "", err
+	case status == http.StatusUnauthorized:
+		return "", ErrInvalidToken
+	case status != http.StatusOK:
+		// 403 is returned for rate limits, which must not reject the token.
+		return "", fmt.Errorf("auth: platform token store: unexpected status %d", status)
+	}
+
+	userId, _ := user[s.UsernameField].(string)
+	if userId == "" {
+		return "", fmt.Errorf("auth: platform token store: no %q in response", s.UsernameField)
+	}
+	return userId, nil
+}
+
+// PlatformTokenStore.HealthCheck checks that the platform answers the user endpoint.
+// The request carries no token, so any status below 500 is healthy.
+func (s *PlatformTokenStore) HealthCheck(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout())
+	defer cancel()
+
+	status, err := s.do(ctx, "", nil)
+	if err != nil {
+		return err
+	}
+	if status >= 500 {
+		return fmt.Errorf("auth: platform token store: unexpected status %d", status)
+	}
+	return nil
+}
+
+// do requests the user endpoint with token, if any, decoding a 200 response into out.
+func (s *PlatformTokenStore) do(ctx context.Context, token string, out interface{}) (int, error) {
+	req, err := http.NewRequest("GET", s.URL, nil)
+	if err != nil {
+		return 0, fmt.Errorf("auth: platform token store: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, fmt.Errorf("auth: platform token store: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK && out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return 0, fmt.Errorf("auth: platform token store: decoding response: %w", err)
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+func (s *PlatformTokenStore) timeout() time.Duration {
+	if s.Timeout > 0 {
+		return s.Timeout
+	}
+	return defaultPlatformTokenTimeout
+}
+
+func (s *PlatformTokenStore) clock() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
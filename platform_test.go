@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_PlatformTokenStore(t *testing.T) {
+	now := time.Unix(1000, 0)
+
+	requests := 0
+	down := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch {
+		case r.URL.Path != "/api/v4/user":
+			http.NotFound(w, r)
+		case down:
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		case r.Header.Get("Authorization") == "Bearer rate-limited":
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		case r.Header.Get("Authorization") != "Bearer glpat-valid":
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		default:
+			w.Write([]byte(`{"id":1,"username":"alice","name":"Alice"}`))
+		}
+	}))
+	defer ts.Close()
+
+	s := NewGitLabTokenStore(ts.URL + "/")
+	s.now = func() time.Time { return now }
+
+	var platformtests = []struct {
+		token    string
+		userId   string
+		err      error
+		requests int
+	}{
+		{"glpat-valid", "alice", nil, 1},
+		// Cached.
+		{"glpat-valid", "alice", nil, 1},
+		{"glpat-invalid", "", ErrInvalidToken, 2},
+	}
+	for _, tt := range platformtests {
+		userId, err := s.Get(context.Background(), tt.token)
+		if userId != tt.userId || !errors.Is(err, tt.err) {
+			t.Errorf("Expected %q, %v, got %q, %v", tt.userId, tt.err, userId, err)
+		}
+		if requests != tt.requests {
+			t.Errorf("Expected %d requests, got %d", tt.requests, requests)
+		}
+	}
+
+	// Rate limits are answered with 403 and must not reject the token.
+	if _, err := s.Get(context.Background(), "rate-limited"); err == nil || errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected a backend error, got %v", err)
+	}
+
+	if err := s.HealthCheck(context.Background()); err != nil {
+		t.Errorf("Expected the platform to be healthy, got %v", err)
+	}
+
+	// The cache entry expires after CacheTTL; a platform failure is a backend error.
+	now = now.Add(defaultPlatformTokenCacheTTL)
+	down = true
+	if _, err := s.Get(context.Background(), "glpat-valid"); err == nil || errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected a backend error, got %v", err)
+	}
+	if err := s.HealthCheck(context.Background()); err == nil {
+		t.Error("Expected the platform to be unhealthy")
+	}
+}
+
+func Test_GitHubTokenStore(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/user" || r.Header.Get("Authorization") != "Bearer ghp_valid" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"login":"octocat","id":1}`))
+	}))
+	defer ts.Close()
+
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer ghp_valid")
+	var userId string
+	NewBearer(NewGitHubTokenStore(ts.URL))(recorder, req, func(w http.ResponseWriter, r *http.Request) {
+		userId, _ = UserIdFromContext(r.Context())
+	})
+	if userId != "octocat" {
+		t.Errorf("Expected octocat, got %q", userId)
+	}
+}
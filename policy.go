@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// PasswordPolicy describes the requirements a plaintext password must meet before it is hashed.
+// It can be set by NewSimpleBasicWithPolicy, NewSimpleBasicFromFilesWithPolicy,
+// MapStoreOptions.Policy and Config.PasswordPolicy.
+type PasswordPolicy struct {
+	// MinLength is the minimum number of characters.
+	MinLength int `json:"min_length,omitempty" yaml:"min_length,omitempty"`
+
+	// Required character classes.
+	RequireUpper  bool `json:"require_upper,omitempty" yaml:"require_upper,omitempty"`
+	RequireLower  bool `json:"require_lower,omitempty" yaml:"require_lower,omitempty"`
+	RequireDigit  bool `json:"require_digit,omitempty" yaml:"require_digit,omitempty"`
+	RequireSymbol bool `json:"require_symbol,omitempty" yaml:"require_symbol,omitempty"`
+
+	// Denylist is a list of passwords which are always rejected, compared case-insensitively.
+	Denylist []string `json:"denylist,omitempty" yaml:"denylist,omitempty"`
+}
+
+// DefaultPasswordPolicy returns a policy rejecting short and very common passwords.
+// Each call returns a new copy, which the caller may modify.
+func DefaultPasswordPolicy() *PasswordPolicy {
+	return &PasswordPolicy{
+		MinLength: 8,
+		Denylist: []string{
+			"password", "passw0rd", "12345678", "123456789", "1234567890",
+			"qwertyui", "qwerty123", "iloveyou", "letmein1", "changeme",
+		},
+	}
+}
+
+// Check returns an error describing the first requirement password doesn't meet.
+func (p *PasswordPolicy) Check(password string) error {
+	if n := len([]rune(password)); n < p.MinLength {
+		return fmt.Errorf("auth: password must be at least %d characters, got %d", p.MinLength, n)
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r):
+			symbol = true
+		}
+	}
+
+	switch {
+	case p.RequireUpper && !upper:
+		return fmt.Errorf("auth: password must contain an upper case letter")
+	case p.RequireLower && !lower:
+		return fmt.Errorf("auth: password must contain a lower case letter")
+	case p.RequireDigit && !digit:
+		return fmt.Errorf("auth: password must contain a digit")
+	case p.RequireSymbol && !symbol:
+		return fmt.Errorf("auth: password must contain a symbol")
+	}
+
+	for _, denied := range p.Denylist {
+		if strings.EqualFold(password, denied) {
+			return fmt.Errorf("auth: password is too common")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var policytests = []struct {
+	policy   PasswordPolicy
+	password string
+	val      bool
+}{
+	{PasswordPolicy{}, "", true},
+	{PasswordPolicy{MinLength: 8}, "short", false},
+	{PasswordPolicy{MinLength: 8}, "longenough", true},
+	{PasswordPolicy{MinLength: 4}, "日本語だ", true},
+	{PasswordPolicy{RequireUpper: true}, "lower", false},
+	{PasswordPolicy{RequireUpper: true}, "Upper", true},
+	{PasswordPolicy{RequireLower: true}, "UPPER", false},
+	{PasswordPolicy{RequireDigit: true}, "nodigit", false},
+	{PasswordPolicy{RequireDigit: true}, "d1git", true},
+	{PasswordPolicy{RequireSymbol: true}, "nosymbol", false},
+	{PasswordPolicy{RequireSymbol: true}, "sym-bol", true},
+	{PasswordPolicy{Denylist: []string{"password"}}, "PassWord", false},
+	{PasswordPolicy{Denylist: []string{"password"}}, "password1", true},
+}
+
+func Test_PasswordPolicy(t *testing.T) {
+	for _, tt := range policytests {
+		if err := tt.policy.Check(tt.password); (err == nil) != tt.val {
+			t.Errorf("Expected Check(%q) with %+v to accept %v, got %v", tt.password, tt.policy, tt.val, err)
+		}
+	}
+}
+
+func Test_NewSimpleBasicWithPolicy(t *testing.T) {
+	if _, err := NewSimpleBasicWithPolicy("foo", "password", DefaultPasswordPolicy()); err == nil {
+		t.Error("Expected weak password to be rejected")
+	}
+
+	ds, err := NewSimpleBasicWithPolicy("foo", "correct horse battery", DefaultPasswordPolicy())
+	if err != nil {
+		t.Fatal("Expected strong password to be accepted: ", err)
+	}
+	if _, found := ds.Get("foo"); !found {
+		t.Error("Expected userid to be stored")
+	}
+}
+
+func Test_DefaultPasswordPolicy(t *testing.T) {
+	p := DefaultPasswordPolicy()
+	p.MinLength = 0
+	p.Denylist[0] = "changed"
+	if q := DefaultPasswordPolicy(); q.MinLength != 8 || q.Denylist[0] != "password" {
+		t.Errorf("Expected a fresh default policy, got %+v", q)
+	}
+}
+
+func Test_PasswordPolicyConstructors(t *testing.T) {
+	policy := DefaultPasswordPolicy()
+	dir := t.TempDir()
+	userIdFile, passwordFile := filepath.Join(dir, "userid"), filepath.Join(dir, "password")
+	writeFile := func(name, content string) {
+		if err := os.WriteFile(name, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(userIdFile, "foo\n")
+
+	var constructortests = []struct {
+		name  string
+		build func(password string) error
+	}{
+		{"NewMapStoreWithOptions", func(password string) error {
+			_, err := NewMapStoreWithOptions(map[string]string{"bar": "correct horse battery", "foo": password}, MapStoreOptions{
+				CostFor: func(string) int { return bcrypt.MinCost },
+				Policy:  policy,
+			})
+			return err
+		}},
+		{"NewSimpleBasicFromFilesWithPolicy", func(password string) error {
+			writeFile(passwordFile, password+"\n")
+			_, err := NewSimpleBasicFromFilesWithPolicy(userIdFile, passwordFile, policy)
+			return err
+		}},
+		{"NewFromConfig", func(password string) error {
+			_, closer, err := NewFromConfig(Config{Users: map[string]string{"foo": password}, Cost: bcrypt.MinCost, PasswordPolicy: policy})
+			if closer != nil {
+				closer.Close()
+			}
+			return err
+		}},
+	}
+	for _, tt := range constructortests {
+		if err := tt.build("password"); err == nil || !strings.Contains(err.Error(), "too common") {
+			t.Errorf("Expected %s to reject a weak password, got %v", tt.name, err)
+		}
+		if err := tt.build("correct horse battery"); err != nil {
+			t.Errorf("Expected %s to accept a strong password, got %v", tt.name, err)
+		}
+	}
+
+	// A bcrypt hash can't be checked and is used as is.
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	writeFile(passwordFile, string(hashedPassword))
+	if _, err := NewSimpleBasicFromFilesWithPolicy(userIdFile, passwordFile, policy); err != nil {
+		t.Error("Expected a bcrypt hash to be accepted, got: ", err)
+	}
+}
@@ -0,0 +1,166 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/nabeken/negroni-auth/datastore"
+)
+
+// quorumPrefix marks the values of QuorumStore, which hold the hashes found in each store.
+var quorumPrefix = []byte("$quorum$")
+
+// QuorumStore is a data store accepting a credential only if its password matches the hashes of
+// at least Quorum of independent Stores, e.g. 2 of 3. Use it with its Verifier, which verifies
+// the password against every hash found. Failing stores count as non-matches and are logged.
+// This struct implement datastore.Datastore and datastore.ErrDatastore interface.
+type QuorumStore struct {
+	Stores []datastore.ErrDatastore
+	// Quorum is the number of stores which must match; at least 1.
+	Quorum int
+	// HashVerifier verifies the password against the hash of each store; BcryptVerifier if nil.
+	HashVerifier PasswordVerifier
+	// Logger logs failing stores; the standard logger if nil.
+	Logger Logger
+}
+
+// NewQuorumStore returns *QuorumStore requiring quorum of stores to match.
+func NewQuorumStore(stores []datastore.ErrDatastore, quorum int) *QuorumStore {
+	return &QuorumStore{Stores: stores, Quorum: quorum}
+}
+
+// QuorumStore.GetContext looks up key in all stores concurrently. key is found if at least
+// Quorum stores have it; the value holds their hashes and is only meant for the store's Verifier.
+// Errors of the stores are logged, not returned, since the other stores may still reach the quorum,
+// unless every store failed, so an outage isn't mistaken for an unknown userid.
+func (s *QuorumStore) GetContext(ctx context.Context, key string) ([]byte, bool, error) {
+	hashes := make([][]byte, len(s.Stores))
+	errs := make([]error, len(s.Stores))
+	var wg sync.WaitGroup
+	for i, ds := range s.Stores {
+		wg.Add(1)
+		go func(i int, ds datastore.ErrDatastore) {
+			defer wg.Done()
+			value, found, err := ds.GetContext(ctx, key)
+			if err != nil {
+				s.logger().Printf("negroni-auth: quorum store %d: %v", i, err)
+				errs[i] = err
+				return
+			}
+			if found {
+				hashes[i] = value
+			}
+		}(i, ds)
+	}
+	wg.Wait()
+
+	failed := 0
+	for _, err := range errs {
+		if err != nil {
+			failed++
+		}
+	}
+	if failed > 0 && failed == len(s.Stores) {
+		return nil, false, fmt.Errorf("auth: no quorum store answered: %w", errs[0])
+	}
+
+	var found [][]byte
+	for _, h := range hashes {
+		if h != nil {
+			found = append(found, h)
+		}
+	}
+	if len(found) < s.quorum() {
+		return nil, false, nil
+	}
+	value, err := json.Marshal(found)
+	if err != nil {
+		return nil, false, err
+	}
+	return append(append([]byte(nil), quorumPrefix...), value...), true, nil
+}
+
+// QuorumStore.Get returns value using key, treating errors as not found.
+func (s *QuorumStore) Get(key string) ([]byte, bool) {
+	value, found, err := s.GetContext(context.Background(), key)
+	if err != nil {
+		return nil, false
+	}
+	return value, found
+}
+
+// QuorumStore.Verifier returns the PasswordVerifier counting the matching hashes of the store's values.
+func (s *QuorumStore) Verifier() PasswordVerifier {
+	return quorumVerifier{s}
+}
+
+func (s *QuorumStore) quorum() int {
+	if s.Quorum < 1 {
+		return 1
+	}
+	return s.Quorum
+}
+
+func (s *QuorumStore) hashVerifier() PasswordVerifier {
+	if s.HashVerifier == nil {
+		return BcryptVerifier{}
+	}
+	return s.HashVerifier
+}
+
+func (s *QuorumStore) logger() Logger {
+	if s.Logger == nil {
+		return stdLogger{}
+	}
+	return s.Logger
+}
+
+// quorumVerifier is the PasswordVerifier of a QuorumStore.
+type quorumVerifier struct {
+	store *QuorumStore
+}
+
+// quorumVerifier.Verify verifies password against every hash in hashedPassword, without stopping
+// at the quorum, and then against the first hash again for each store which didn't have the
+// userid, so it always runs one verification per store and the time taken doesn't tell which
+// stores had or matched it. A value not made by the store, i.e. the dummy hash of an unknown
+// userid, is verified once per store too and never matches.
+func (v quorumVerifier) Verify(ctx context.Context, userId string, hashedPassword, password []byte) error {
+	verifier := v.store.hashVerifier()
+	if !bytes.HasPrefix(hashedPassword, quorumPrefix) {
+		for range v.store.Stores {
+			verifier.Verify(ctx, userId, hashedPassword, password)
+		}
+		return ErrPasswordMismatch
+	}
+
+	var hashes [][]byte
+	if err := json.Unmarshal(hashedPassword[len(quorumPrefix):], &hashes); err != nil {
+		return ErrMalformedHash
+	}
+	matches := 0
+	var backendErr error
+	for _, h := range hashes {
+		err := verifier.Verify(ctx, userId, h, password)
+		switch {
+		case err == nil:
+			matches++
+		case !errors.Is(err, ErrPasswordMismatch) && backendErr == nil:
+			backendErr = err
+		}
+	}
+	for i := len(hashes); i < len(v.store.Stores) && len(hashes) > 0; i++ {
+		verifier.Verify(ctx, userId, hashes[0], password)
+	}
+	if matches >= v.store.quorum() {
+		return nil
+	}
+	if backendErr != nil {
+		return backendErr
+	}
+	return ErrPasswordMismatch
+}
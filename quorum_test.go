@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/codegangsta/negroni"
+	"github.com/nabeken/negroni-auth/datastore"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func Test_QuorumStore(t *testing.T) {
+	bar, _ := bcrypt.GenerateFromPassword([]byte("bar"), bcrypt.MinCost)
+	old, _ := bcrypt.GenerateFromPassword([]byte("old"), bcrypt.MinCost)
+
+	logger := &recordingLogger{}
+	store := NewQuorumStore([]datastore.ErrDatastore{
+		mockCtxMapDataStore{"foo": bar, "baz": bar, "qux": old},
+		mockCtxMapDataStore{"foo": bar, "baz": old, "qux": bar},
+		&mockErrDataStore{Err: errors.New("connection refused")},
+	}, 2)
+	store.Logger = logger
+
+	m := negroni.New()
+	m.Use(NewBasicWithOptions(store, Options{Verifier: store.Verifier(), Logger: logger}))
+	m.UseHandler(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte("hello"))
+	}))
+
+	var quorumtests = []struct {
+		userId   string
+		password string
+		code     int
+	}{
+		{"foo", "bar", http.StatusOK},
+		{"foo", "old", http.StatusUnauthorized},
+		// Only one store matches.
+		{"baz", "bar", http.StatusUnauthorized},
+		{"qux", "old", http.StatusUnauthorized},
+		{"quux", "bar", http.StatusUnauthorized},
+	}
+	for _, tt := range quorumtests {
+		r, _ := http.NewRequest("GET", "foo", nil)
+		r.SetBasicAuth(tt.userId, tt.password)
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, r)
+
+		if recorder.Code != tt.code {
+			t.Errorf("Expected %d for %s:%s, got %d", tt.code, tt.userId, tt.password, recorder.Code)
+		}
+	}
+	if len(logger.lines) != len(quorumtests) {
+		t.Errorf("Expected the failing store to be logged once per request, got %q", logger.lines)
+	}
+}
+
+func Test_QuorumStoreVerifications(t *testing.T) {
+	bar, _ := bcrypt.GenerateFromPassword([]byte("bar"), bcrypt.MinCost)
+	verifier := &countingVerifier{}
+	store := NewQuorumStore([]datastore.ErrDatastore{
+		mockCtxMapDataStore{"foo": bar, "baz": bar},
+		mockCtxMapDataStore{"foo": bar},
+		mockCtxMapDataStore{"foo": bar},
+	}, 1)
+	store.HashVerifier = verifier
+	m := negroni.New(NewBasicWithOptions(store, Options{Verifier: store.Verifier()}))
+
+	// Every request verifies once per store, whichever stores have the userid.
+	for _, userId := range []string{"foo", "baz", "quux"} {
+		verifier.calls = 0
+		r, _ := http.NewRequest("GET", "foo", nil)
+		r.SetBasicAuth(userId, "bar")
+		m.ServeHTTP(httptest.NewRecorder(), r)
+
+		if verifier.calls != len(store.Stores) {
+			t.Errorf("Expected %d verifications for %s, got %d", len(store.Stores), userId, verifier.calls)
+		}
+	}
+}
+
+func Test_QuorumStoreUnavailable(t *testing.T) {
+	store := NewQuorumStore([]datastore.ErrDatastore{
+		&mockErrDataStore{Err: errors.New("connection refused")},
+		&mockErrDataStore{Err: errors.New("connection refused")},
+	}, 1)
+	// Both stores log concurrently.
+	store.Logger = log.New(io.Discard, "", 0)
+
+	if _, _, err := store.GetContext(context.Background(), "foo"); err == nil {
+		t.Error("Expected an error when no store answered")
+	}
+
+	m := negroni.New(NewBasicWithOptions(store, Options{Verifier: store.Verifier(), Logger: store.Logger}))
+	r, _ := http.NewRequest("GET", "foo", nil)
+	r.SetBasicAuth("foo", "bar")
+	recorder := httptest.NewRecorder()
+	m.ServeHTTP(recorder, r)
+
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 when no store answered, got %d", recorder.Code)
+	}
+}
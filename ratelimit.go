@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nabeken/negroni-auth/datastore"
+)
+
+// tokenBucket is the request budget of a single userid.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter keeps a token bucket per userid. It is safe for concurrent use.
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	now     func() time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{
+		buckets: make(map[string]*tokenBucket),
+		now:     time.Now,
+	}
+}
+
+// allow takes a token from the bucket of key filled according to limit.
+// If the bucket is empty it returns false and how long until the next token is available.
+func (l *rateLimiter) allow(key string, limit datastore.RateLimit) (bool, time.Duration) {
+	if limit.Rate <= 0 && limit.Burst <= 0 {
+		return false, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	burst := float64(limit.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+
+	b, found := l.buckets[key]
+	if !found {
+		b = &tokenBucket{tokens: burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	if limit.Rate <= 0 {
+		return false, 0
+	}
+	return false, time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+}
+
+// rateLimited writes a http.StatusTooManyRequests and returns true if the data store
+// limits the requests of userId and its budget is exhausted.
+func (a *basicAuth) rateLimited(w http.ResponseWriter, req *http.Request, userId string) bool {
+	rl, ok := a.datastore.(datastore.RateLimited)
+	if !ok {
+		return false
+	}
+	// The limits of a tenant-unaware store, like its hashed passwords, are keyed within the tenant.
+	key := tenantUserId(a.opts.tenant(req), userId)
+	limit, found := rl.RateLimit(key)
+	if !found {
+		return false
+	}
+
+	allowed, wait := a.limiter.allow(key, limit)
+	if allowed {
+		return false
+	}
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	}
+	http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+	return true
+}
@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/codegangsta/negroni"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/nabeken/negroni-auth/datastore"
+)
+
+type mockRateLimitedDataStore struct {
+	mockMapDataStore
+	limits map[string]datastore.RateLimit
+}
+
+func (ds mockRateLimitedDataStore) RateLimit(key string) (datastore.RateLimit, bool) {
+	limit, found := ds.limits[key]
+	return limit, found
+}
+
+func Test_RateLimiterAllow(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := newRateLimiter()
+	l.now = func() time.Time { return now }
+	limit := datastore.RateLimit{Rate: 1, Burst: 2}
+
+	var allowtests = []struct {
+		advance time.Duration
+		allowed bool
+	}{
+		{0, true},
+		{0, true},
+		{0, false},
+		{500 * time.Millisecond, false},
+		{500 * time.Millisecond, true},
+		{0, false},
+		{time.Hour, true},
+		{0, true},
+		{0, false},
+	}
+	for i, tt := range allowtests {
+		now = now.Add(tt.advance)
+		if allowed, _ := l.allow("foo", limit); allowed != tt.allowed {
+			t.Errorf("Expected allowed %v for request %d, got %v", tt.allowed, i, allowed)
+		}
+	}
+
+	if allowed, _ := l.allow("bar", datastore.RateLimit{}); allowed {
+		t.Error("Expected the zero RateLimit to allow no requests")
+	}
+}
+
+func Test_BasicRateLimit(t *testing.T) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte("bar"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds := mockRateLimitedDataStore{
+		mockMapDataStore: mockMapDataStore{"foo": hashedPassword, "svc": hashedPassword},
+		limits: map[string]datastore.RateLimit{
+			"foo": {Rate: 0.001, Burst: 1},
+		},
+	}
+
+	for _, handler := range []negroni.Handler{
+		NewBasic(ds),
+		CacheBasic(ds, time.Minute, 0),
+	} {
+		m := negroni.New(handler)
+
+		var ratelimittests = []struct {
+			userId string
+			code   int
+		}{
+			{"foo", http.StatusOK},
+			{"foo", http.StatusTooManyRequests},
+			{"svc", http.StatusOK},
+			{"svc", http.StatusOK},
+		}
+		for _, tt := range ratelimittests {
+			r, _ := http.NewRequest("GET", "/", nil)
+			r.SetBasicAuth(tt.userId, "bar")
+			recorder := httptest.NewRecorder()
+			m.ServeHTTP(recorder, r)
+
+			if recorder.Code != tt.code {
+				t.Errorf("Expected %d for %s, got %d", tt.code, tt.userId, recorder.Code)
+			}
+			if tt.code == http.StatusTooManyRequests && recorder.Header().Get("Retry-After") == "" {
+				t.Error("Expected a Retry-After header")
+			}
+		}
+	}
+}
+
+func Test_BasicRateLimitTenant(t *testing.T) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte("bar"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds := mockRateLimitedDataStore{
+		mockMapDataStore: mockMapDataStore{"acme:foo": hashedPassword, "globex:foo": hashedPassword},
+		limits: map[string]datastore.RateLimit{
+			"acme:foo": {Rate: 0.001, Burst: 1},
+		},
+	}
+	opts := Options{Tenant: func(req *http.Request) string { return req.Host }}
+	m := negroni.New(NewBasicWithOptions(ds, opts))
+
+	var tenanttests = []struct {
+		tenant string
+		code   int
+	}{
+		{"acme", http.StatusOK},
+		{"acme", http.StatusTooManyRequests},
+		// The limit of foo in acme doesn't apply to foo in globex.
+		{"globex", http.StatusOK},
+		{"globex", http.StatusOK},
+	}
+	for _, tt := range tenanttests {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Host = tt.tenant
+		r.SetBasicAuth("foo", "bar")
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, r)
+
+		if recorder.Code != tt.code {
+			t.Errorf("Expected %d for foo in %s, got %d", tt.code, tt.tenant, recorder.Code)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultRemoteVerifierTimeout = 5 * time.Second
+)
+
+// RemoteVerifier is a PasswordVerifier which delegates the decision to a remote
+// verification service, so password hashes never have to be exposed to the application.
+// It POSTs {"userId": ..., "password": ...} as JSON to URL and expects a 200 response
+// with {"valid": true|false}. The stored hash is ignored, so it is typically used
+// with a nil data store.
+type RemoteVerifier struct {
+	// URL of the verification endpoint.
+	URL string
+	// Client used for requests. Configure its transport for mutual TLS.
+	Client *http.Client
+	// Timeout bounds each verification; 5 seconds if zero.
+	Timeout time.Duration
+}
+
+// NewRemoteVerifier returns *RemoteVerifier posting to url over a client using tlsConfig,
+// which should carry the client certificate and the service's CA for mutual TLS.
+func NewRemoteVerifier(url string, tlsConfig *tls.Config) *RemoteVerifier {
+	return &RemoteVerifier{
+		URL: url,
+		Client: &http.Client{
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		},
+		Timeout: defaultRemoteVerifierTimeout,
+	}
+}
+
+type remoteVerifyRequest struct {
+	UserId   string `json:"userId"`
+	Password string `json:"password"`
+}
+
+type remoteVerifyResponse struct {
+	Valid bool `json:"valid"`
+}
+
+// RemoteVerifier.Verify asks the verification service whether password is correct for userId.
+func (v *RemoteVerifier) Verify(ctx context.Context, userId string, hashedPassword, password []byte) error {
+	timeout := v.Timeout
+	if timeout <= 0 {
+		timeout = defaultRemoteVerifierTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	body, err := json.Marshal(remoteVerifyRequest{UserId: userId, Password: string(password)})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", v.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := v.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		// The error may embed the URL but never the request body.
+		return fmt.Errorf("auth: remote verifier: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("auth: remote verifier: unexpected status %d", resp.StatusCode)
+	}
+
+	var verdict remoteVerifyResponse
+	if err := json.NewDecoder(resp.Body).Decode(&verdict); err != nil {
+		return fmt.Errorf("auth: remote verifier: decoding response: %w", err)
+	}
+	if !verdict.Valid {
+		return ErrPasswordMismatch
+	}
+	return nil
+}
+
+// RemoteVerifier.HealthCheck checks that the verification service is reachable. Any response
+// below 500 to a GET of URL counts, since the endpoint itself may only accept POST.
+func (v *RemoteVerifier) HealthCheck(ctx context.Context) error {
+	timeout := v.Timeout
+	if timeout <= 0 {
+		timeout = defaultRemoteVerifierTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequest("GET", v.URL, nil)
+	if err != nil {
+		return err
+	}
+	client := v.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("auth: remote verifier: %w", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("auth: remote verifier: unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/codegangsta/negroni"
+)
+
+func newTestVerificationService() *httptest.Server {
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body remoteVerifyRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if body.UserId == "down" {
+			http.Error(w, "unavailable", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(remoteVerifyResponse{Valid: body.UserId == "foo" && body.Password == "bar"})
+	}))
+}
+
+func Test_RemoteVerifier(t *testing.T) {
+	srv := newTestVerificationService()
+	defer srv.Close()
+
+	v := &RemoteVerifier{URL: srv.URL, Client: srv.Client()}
+	ctx := context.Background()
+
+	if err := v.Verify(ctx, "foo", nil, []byte("bar")); err != nil {
+		t.Error("Expected valid credential, got: ", err)
+	}
+	if err := v.Verify(ctx, "foo", nil, []byte("baz")); !errors.Is(err, ErrPasswordMismatch) {
+		t.Error("Expected ErrPasswordMismatch, got: ", err)
+	}
+	if err := v.Verify(ctx, "down", nil, []byte("bar")); err == nil || errors.Is(err, ErrPasswordMismatch) {
+		t.Error("Expected backend error, got: ", err)
+	}
+}
+
+func Test_BasicRemoteVerifier(t *testing.T) {
+	srv := newTestVerificationService()
+	defer srv.Close()
+
+	h := http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte("hello"))
+	})
+	m := negroni.New()
+	m.Use(NewBasicWithOptions(nil, Options{Verifier: &RemoteVerifier{URL: srv.URL, Client: srv.Client()}}))
+	m.UseHandler(h)
+
+	var remotetests = []struct {
+		cred string
+		code int
+	}{
+		{"foo:bar", http.StatusOK},
+		{"foo:baz", http.StatusUnauthorized},
+		{"down:bar", http.StatusServiceUnavailable},
+	}
+	for _, tt := range remotetests {
+		r, _ := http.NewRequest("GET", "foo", nil)
+		r.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(tt.cred)))
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, r)
+
+		if recorder.Code != tt.code {
+			t.Errorf("Expected %d for %q, got %d", tt.code, tt.cred, recorder.Code)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRevocationRefreshInterval = time.Minute
+	defaultRevocationRefreshTimeout  = 30 * time.Second
+
+	// revocationFalsePositiveRate is the rate of non-revoked tokens checked authoritatively.
+	revocationFalsePositiveRate = 0.01
+)
+
+// RevocationList is an interface for a list of revoked bearer tokens, e.g. held by a remote service.
+// Tokens are identified by their SHA-256 hash. Revoked is the authoritative check of a token;
+// RevokedHashes returns all revoked tokens to build the in-memory filter of RevocationCheckedStore.
+type RevocationList interface {
+	Revoked(ctx context.Context, tokenHash [sha256.Size]byte) (bool, error)
+	RevokedHashes(ctx context.Context) ([][sha256.Size]byte, error)
+}
+
+// RevocationCheckedStore is a TokenStore rejecting revoked tokens before validating them with
+// Tokens. It keeps a bloom filter of the revoked tokens of Revocations, refreshed every
+// RefreshInterval, so that the common non-revoked token skips the remote check: only tokens
+// in the filter, i.e. revoked ones and about 1% of the others, are checked with Revocations.
+// Tokens revoked after the last refresh are accepted until the next one; until the first
+// refresh succeeds, every token is checked with Revocations.
+type RevocationCheckedStore struct {
+	Tokens      TokenStore
+	Revocations RevocationList
+	// RefreshInterval is the time between two fetches of the revoked tokens; a minute if zero.
+	RefreshInterval time.Duration
+	// Logger logs failed refreshes; the standard logger if nil.
+	Logger Logger
+
+	mu         sync.RWMutex
+	filter     *bloomFilter
+	refreshed  time.Time
+	refreshing bool
+	now        func() time.Time
+}
+
+// NewRevocationCheckedStore returns *RevocationCheckedStore checking tokens against revocations
+// before validating them with tokens.
+func NewRevocationCheckedStore(tokens TokenStore, revocations RevocationList) *RevocationCheckedStore {
+	return &RevocationCheckedStore{Tokens: tokens, Revocations: revocations}
+}
+
+// RevocationCheckedStore.Get returns ErrInvalidToken if token is revoked, and validates it with Tokens otherwise.
+func (s *RevocationCheckedStore) Get(ctx context.Context, token string) (string, error) {
+	h := sha256.Sum256([]byte(token))
+	if f := s.currentFilter(ctx); f == nil || f.mayContain(h) {
+		revoked, err := s.Revocations.Revoked(ctx, h)
+		if err != nil {
+			return "", fmt.Errorf("auth: revocation list: %w", err)
+		}
+		if revoked {
+			return "", ErrInvalidToken
+		}
+	}
+	return s.Tokens.Get(ctx, token)
+}
+
+// RevocationCheckedStore.Refresh rebuilds the filter from the revoked tokens of Revocations.
+func (s *RevocationCheckedStore) Refresh(ctx context.Context) error {
+	hashes, err := s.Revocations.RevokedHashes(ctx)
+	if err != nil {
+		return fmt.Errorf("auth: revocation list: %w", err)
+	}
+	f := newBloomFilter(len(hashes), revocationFalsePositiveRate)
+	for _, h := range hashes {
+		f.add(h)
+	}
+
+	s.mu.Lock()
+	s.filter, s.refreshed = f, s.clock()
+	s.mu.Unlock()
+	return nil
+}
+
+// currentFilter returns the filter, refreshing it in the background if it's stale.
+// The first refresh is done in the foreground and retried every RefreshInterval while it
+// fails; nil means there is no filter yet.
+func (s *RevocationCheckedStore) currentFilter(ctx context.Context) *bloomFilter {
+	s.mu.RLock()
+	f, refreshed := s.filter, s.refreshed
+	s.mu.RUnlock()
+
+	interval := s.RefreshInterval
+	if interval <= 0 {
+		interval = defaultRevocationRefreshInterval
+	}
+	stale := s.clock().Sub(refreshed) >= interval
+
+	if f == nil {
+		if !stale {
+			// The last attempt failed recently.
+			return nil
+		}
+		if err := s.Refresh(ctx); err != nil {
+			s.mu.Lock()
+			s.refreshed = s.clock()
+			s.mu.Unlock()
+			s.logger().Printf("negroni-auth: refreshing revoked tokens: %v", err)
+			return nil
+		}
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		return s.filter
+	}
+
+	if stale {
+		s.mu.Lock()
+		start := !s.refreshing
+		s.refreshing = true
+		s.mu.Unlock()
+
+		if start {
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), defaultRevocationRefreshTimeout)
+				defer cancel()
+				err := s.Refresh(ctx)
+				s.mu.Lock()
+				if err != nil {
+					// Keep the stale filter and retry after another interval.
+					s.refreshed = s.clock()
+				}
+				s.refreshing = false
+				s.mu.Unlock()
+				if err != nil {
+					s.logger().Printf("negroni-auth: refreshing revoked tokens: %v", err)
+				}
+			}()
+		}
+	}
+	return f
+}
+
+func (s *RevocationCheckedStore) logger() Logger {
+	if s.Logger == nil {
+		return stdLogger{}
+	}
+	return s.Logger
+}
+
+func (s *RevocationCheckedStore) clock() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// staticTokenStore is a TokenStore mapping tokens to userids.
+type staticTokenStore map[string]string
+
+func (s staticTokenStore) Get(ctx context.Context, token string) (string, error) {
+	if userId, found := s[token]; found {
+		return userId, nil
+	}
+	return "", ErrInvalidToken
+}
+
+// countingRevocationList is a RevocationList counting its calls.
+type countingRevocationList struct {
+	mu      sync.Mutex
+	revoked map[[sha256.Size]byte]bool
+	checks  int
+	fetches int
+	err     error
+}
+
+func (l *countingRevocationList) Revoked(ctx context.Context, h [sha256.Size]byte) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.checks++
+	return l.revoked[h], l.err
+}
+
+func (l *countingRevocationList) RevokedHashes(ctx context.Context) ([][sha256.Size]byte, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.fetches++
+	if l.err != nil {
+		return nil, l.err
+	}
+	var hashes [][sha256.Size]byte
+	for h := range l.revoked {
+		hashes = append(hashes, h)
+	}
+	return hashes, nil
+}
+
+func (l *countingRevocationList) counts() (int, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.checks, l.fetches
+}
+
+func Test_RevocationCheckedStore(t *testing.T) {
+	now := time.Unix(1000, 0)
+	list := &countingRevocationList{revoked: map[[sha256.Size]byte]bool{sha256.Sum256([]byte("revoked")): true}}
+	s := NewRevocationCheckedStore(staticTokenStore{"valid": "foo", "revoked": "bar"}, list)
+	s.Logger = &recordingLogger{}
+	var mu sync.Mutex
+	s.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	ctx := context.Background()
+
+	var revocationtests = []struct {
+		token  string
+		userId string
+		err    error
+		checks int
+	}{
+		// Not in the filter: no authoritative check.
+		{"valid", "foo", nil, 0},
+		{"revoked", "", ErrInvalidToken, 1},
+		{"unknown", "", ErrInvalidToken, 1},
+	}
+	for _, tt := range revocationtests {
+		userId, err := s.Get(ctx, tt.token)
+		if userId != tt.userId || !errors.Is(err, tt.err) {
+			t.Errorf("Expected %q, %v for %q, got %q, %v", tt.userId, tt.err, tt.token, userId, err)
+		}
+		if checks, fetches := list.counts(); checks != tt.checks || fetches != 1 {
+			t.Errorf("Expected %d checks and 1 fetch after %q, got %d and %d", tt.checks, tt.token, checks, fetches)
+		}
+	}
+
+	// A stale filter is refreshed in the background and keeps being used meanwhile.
+	list.mu.Lock()
+	list.revoked[sha256.Sum256([]byte("valid"))] = true
+	list.mu.Unlock()
+	mu.Lock()
+	now = now.Add(defaultRevocationRefreshInterval)
+	mu.Unlock()
+	s.Get(ctx, "valid")
+
+	deadline := time.Now().Add(time.Second)
+	for _, fetches := list.counts(); fetches < 2 && time.Now().Before(deadline); _, fetches = list.counts() {
+		time.Sleep(time.Millisecond)
+	}
+	// Wait for the new filter to be installed.
+	for time.Now().Before(deadline) {
+		s.mu.RLock()
+		refreshing := s.refreshing
+		s.mu.RUnlock()
+		if !refreshing {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := s.Get(ctx, "valid"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected the newly revoked token to be rejected, got %v", err)
+	}
+}
+
+func Test_RevocationCheckedStoreUnavailable(t *testing.T) {
+	list := &countingRevocationList{err: errors.New("connection refused")}
+	s := NewRevocationCheckedStore(staticTokenStore{"valid": "foo"}, list)
+	s.Logger = &recordingLogger{}
+
+	// Without a filter every token is checked, so a failing list is a backend error.
+	for i := 0; i < 2; i++ {
+		if _, err := s.Get(context.Background(), "valid"); err == nil || errors.Is(err, ErrInvalidToken) {
+			t.Errorf("Expected a backend error, got %v", err)
+		}
+	}
+	// The failed refresh isn't retried before RefreshInterval.
+	if checks, fetches := list.counts(); checks != 2 || fetches != 1 {
+		t.Errorf("Expected 2 checks and 1 fetch, got %d and %d", checks, fetches)
+	}
+}
@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/codegangsta/negroni"
+	"github.com/nabeken/negroni-auth/datastore"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func Test_BasicScopes(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	keys, err := datastore.NewScopedKeyStore(strings.NewReader("reader," + string(hash) + ",read\nwriter," + string(hash) + ",read write\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := negroni.New(NewBasicWithOptions(keys, Options{
+		Authorize: func(req *http.Request, userId string) bool {
+			return req.Method == "GET" || HasScope(req.Context(), "write")
+		},
+	}))
+	m.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		scopes, _ := ScopesFromContext(req.Context())
+		w.Write([]byte(strings.Join(scopes, " ")))
+	}))
+
+	var scopestests = []struct {
+		method string
+		userId string
+		code   int
+		body   string
+	}{
+		{"GET", "reader", http.StatusOK, "read"},
+		{"POST", "reader", http.StatusForbidden, "Forbidden\n"},
+		{"POST", "writer", http.StatusOK, "read write"},
+	}
+	for _, tt := range scopestests {
+		r, _ := http.NewRequest(tt.method, "foo", nil)
+		r.SetBasicAuth(tt.userId, "secret")
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, r)
+		if recorder.Code != tt.code || recorder.Body.String() != tt.body {
+			t.Errorf("Expected %d %q for %s by %s, got %d %q", tt.code, tt.body, tt.method, tt.userId, recorder.Code, recorder.Body.String())
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrOverloaded is returned when no verification slot could be acquired within
+// Options.VerificationWait. The middleware answers it with a http.StatusServiceUnavailable.
+var ErrOverloaded = errors.New("auth: too many concurrent verifications")
+
+// verifySlots bounds the password verifications running at a time, so that bcrypt can't take
+// all CPUs under load. It is safe for concurrent use.
+type verifySlots struct {
+	slots chan struct{}
+	wait  time.Duration
+	after func(d time.Duration) <-chan time.Time
+}
+
+// newVerifySlots returns *verifySlots allowing n verifications at a time, waiting up to wait
+// for a slot, or nil if n isn't positive.
+func newVerifySlots(n int, wait time.Duration) *verifySlots {
+	if n <= 0 {
+		return nil
+	}
+	return &verifySlots{
+		slots: make(chan struct{}, n),
+		wait:  wait,
+		after: time.After,
+	}
+}
+
+// acquire takes a slot, waiting until ctx is done or, if wait is positive, up to wait.
+// Returns ErrOverloaded if the wait runs out. The slot must be given back with release.
+// s may be nil.
+func (s *verifySlots) acquire(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+	select {
+	case s.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	var deadline <-chan time.Time
+	if s.wait > 0 {
+		deadline = s.after(s.wait)
+	}
+	select {
+	case s.slots <- struct{}{}:
+		return nil
+	case <-deadline:
+		return ErrOverloaded
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release gives back a slot taken by acquire. s may be nil.
+func (s *verifySlots) release() {
+	if s == nil {
+		return
+	}
+	<-s.slots
+}
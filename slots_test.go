@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// blockingVerifier accepts any password once unblock is closed.
+type blockingVerifier struct {
+	started chan struct{}
+	unblock chan struct{}
+}
+
+func (v blockingVerifier) Verify(ctx context.Context, userId string, hashedPassword, password []byte) error {
+	v.started <- struct{}{}
+	<-v.unblock
+	return nil
+}
+
+func Test_BasicVerificationWait(t *testing.T) {
+	ds, err := NewSimpleBasic("foo", "bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := blockingVerifier{started: make(chan struct{}, 3), unblock: make(chan struct{})}
+	a := newBasicAuth(ds, Options{Verifier: v, MaxConcurrentVerifications: 1, VerificationWait: time.Second, RetryAfter: 2 * time.Second})
+
+	waits := make(chan time.Duration, 1)
+	deadline := make(chan time.Time)
+	a.slots.after = func(d time.Duration) <-chan time.Time {
+		waits <- d
+		return deadline
+	}
+
+	serve := func() *httptest.ResponseRecorder {
+		r, _ := http.NewRequest("GET", "foo", nil)
+		r.SetBasicAuth("foo", "bar")
+		recorder := httptest.NewRecorder()
+		a.ServeHTTP(recorder, r, func(w http.ResponseWriter, r *http.Request) {})
+		return recorder
+	}
+
+	// The first request holds the only slot.
+	first := make(chan *httptest.ResponseRecorder)
+	go func() { first <- serve() }()
+	<-v.started
+
+	// The second one waits for it until the deadline passes.
+	second := make(chan *httptest.ResponseRecorder)
+	go func() { second <- serve() }()
+	if d := <-waits; d != time.Second {
+		t.Errorf("Expected to wait 1s for a slot, waited %v", d)
+	}
+	deadline <- time.Time{}
+	if r := <-second; r.Code != http.StatusServiceUnavailable || r.Header().Get("Retry-After") != "2" {
+		t.Errorf("Expected 503 with Retry-After 2, got %d %q", r.Code, r.Header().Get("Retry-After"))
+	}
+
+	close(v.unblock)
+	if r := <-first; r.Code != http.StatusOK {
+		t.Errorf("Expected 200 for the first request, got %d", r.Code)
+	}
+	// The slot is given back.
+	if r := serve(); r.Code != http.StatusOK {
+		t.Errorf("Expected 200 after the slot is given back, got %d", r.Code)
+	}
+}
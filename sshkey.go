@@ -0,0 +1,209 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/nabeken/negroni-auth/datastore"
+)
+
+const sshSignatureScheme = "SSH-Signature"
+
+// sshSignedPrefix separates the signed challenge from other uses of the SSH key.
+const sshSignedPrefix = "negroni-auth SSH-Signature\x00"
+
+// SSHKeyAuth is a negroni.Handler authenticating requests signed with an SSH private key,
+// for tools which already distribute SSH keys. The server issues a signed, single-use nonce in
+// its challenge, like NonceBasic:
+//
+//	WWW-Authenticate: SSH-Signature realm="...", nonce="..."
+//
+// and the client answers with the SHA256 fingerprint of its key and its signature over the nonce,
+// as set by SetSSHAuth:
+//
+//	Authorization: SSH-Signature key="SHA256:...", nonce="...", signature="..."
+//
+// The data store maps fingerprints to authorized_keys lines, e.g. built by NewAuthorizedKeysStore.
+// The comment of the matched key becomes the userid. Each successful response carries the
+// next nonce in "Authentication-Info: nextnonce=...", which ResponseNonce returns.
+type SSHKeyAuth struct {
+	datastore datastore.Datastore
+	// Key signs the issued nonces. It must be kept secret and be at least 32 random bytes.
+	Key []byte
+	// Store remembers used nonces. It must be shared by all instances behind a load balancer.
+	Store NonceStore
+	// TTL is the lifetime of an issued nonce; 1 minute if zero.
+	TTL time.Duration
+	// MaxClockSkew is the difference tolerated between the clocks of the instances issuing and
+	// checking nonces, as for NonceBasic.
+	MaxClockSkew time.Duration
+
+	now func() time.Time
+}
+
+// NewSSHKeyAuth returns *SSHKeyAuth authenticating against the authorized keys in datastore,
+// signing nonces with key and remembering used nonces in store. It panics if key is shorter
+// than 32 bytes.
+func NewSSHKeyAuth(datastore datastore.Datastore, key []byte, store NonceStore) *SSHKeyAuth {
+	if err := checkKey("nonce", key); err != nil {
+		panic(err)
+	}
+	return &SSHKeyAuth{
+		datastore: datastore,
+		Key:       key,
+		Store:     store,
+		TTL:       defaultNonceTTL,
+	}
+}
+
+// NewAuthorizedKeysStore returns *datastore.Map for SSHKeyAuth built from the contents of an
+// authorized_keys file. Each key must have a comment, which is its userid.
+// Returns an error naming the fingerprint of a key without comment or of a duplicate key.
+func NewAuthorizedKeysStore(authorizedKeys []byte) (*datastore.Map, error) {
+	values := make(map[string][]byte)
+	rest := authorizedKeys
+	for len(bytes.TrimSpace(rest)) > 0 {
+		key, comment, _, next, err := ssh.ParseAuthorizedKey(rest)
+		if err != nil {
+			break
+		}
+		rest = next
+
+		fingerprint := ssh.FingerprintSHA256(key)
+		switch {
+		case comment == "":
+			return nil, fmt.Errorf("auth: authorized key %s has no comment", fingerprint)
+		case values[fingerprint] != nil:
+			return nil, fmt.Errorf("auth: authorized key %s is listed twice", fingerprint)
+		}
+		// Options of the line don't apply to HTTP requests, so they are dropped.
+		values[fingerprint] = append(bytes.TrimSpace(ssh.MarshalAuthorizedKey(key)), " "+comment...)
+	}
+	if len(values) == 0 {
+		return nil, errors.New("auth: no authorized keys")
+	}
+	return datastore.NewMap(values), nil
+}
+
+// SetSSHAuth sets the Authorization header of req to the signature of nonce by signer, where
+// nonce is issued by SSHKeyAuth in its challenge or the Authentication-Info header of the
+// previous response.
+func SetSSHAuth(req *http.Request, signer ssh.Signer, nonce string) error {
+	sig, err := signer.Sign(rand.Reader, []byte(sshSignedPrefix+nonce))
+	if err != nil {
+		return fmt.Errorf("auth: signing nonce: %w", err)
+	}
+	req.Header.Set("Authorization", sshSignatureScheme+" "+
+		"key="+quoteString(ssh.FingerprintSHA256(signer.PublicKey()))+
+		", nonce="+quoteString(nonce)+
+		", signature="+quoteString(base64.StdEncoding.EncodeToString(marshalSSHSignature(sig))))
+	return nil
+}
+
+// marshalSSHSignature returns sig in the SSH wire format.
+func marshalSSHSignature(sig *ssh.Signature) []byte {
+	var b bytes.Buffer
+	for _, s := range [][]byte{[]byte(sig.Format), sig.Blob} {
+		binary.Write(&b, binary.BigEndian, uint32(len(s)))
+		b.Write(s)
+	}
+	b.Write(sig.Rest)
+	return b.Bytes()
+}
+
+// parseSSHSignature parses a signature in the SSH wire format.
+func parseSSHSignature(raw []byte) (*ssh.Signature, bool) {
+	var fields [2][]byte
+	for i := range fields {
+		if len(raw) < 4 {
+			return nil, false
+		}
+		n := binary.BigEndian.Uint32(raw)
+		raw = raw[4:]
+		if uint32(len(raw)) < n {
+			return nil, false
+		}
+		fields[i], raw = raw[:n], raw[n:]
+	}
+	sig := &ssh.Signature{Format: string(fields[0]), Blob: fields[1]}
+	if len(raw) > 0 {
+		sig.Rest = raw
+	}
+	return sig, true
+}
+
+func (a *SSHKeyAuth) clock() time.Time {
+	if a.now != nil {
+		return a.now()
+	}
+	return time.Now()
+}
+
+// challenge writes a http.StatusUnauthorized with a new nonce.
+func (a *SSHKeyAuth) challenge(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", challenge(sshSignatureScheme, "realm", defaultRealm, "nonce", issueNonce(a.Key, a.clock(), a.TTL)))
+	http.Error(w, "Not Authorized", http.StatusUnauthorized)
+}
+
+// SSHKeyAuth.ServeHTTP implements negroni.Handler.
+// Writes a http.StatusUnauthorized with a new nonce if authentication fails.
+func (a *SSHKeyAuth) ServeHTTP(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+	addVary(w.Header(), "Authorization")
+
+	header, _ := authorization(req, sshSignatureScheme)
+	_, params := splitAuthorization(header)
+	fingerprint, nonce := authParam(params, "key"), authParam(params, "nonce")
+	raw, err := base64.StdEncoding.DecodeString(authParam(params, "signature"))
+	sig, ok := parseSSHSignature(raw)
+	if fingerprint == "" || nonce == "" || err != nil || !ok {
+		rejectUpgrade(w, req)
+		a.challenge(w)
+		return
+	}
+
+	// Every nonce is good for a single attempt.
+	remaining, err := validNonce(a.Key, nonce, a.clock(), a.TTL, a.MaxClockSkew)
+	if err != nil || a.Store.SeenBefore(nonce, remaining) {
+		if reason := validityReason(err); reason != "" {
+			w.Header().Set("X-Auth-Reason", reason)
+		}
+		rejectUpgrade(w, req)
+		a.challenge(w)
+		return
+	}
+
+	userId, ok := a.verify(fingerprint, nonce, sig)
+	if !ok {
+		rejectUpgrade(w, req)
+		a.challenge(w)
+		return
+	}
+
+	w.Header().Set("Authentication-Info", "nextnonce="+quoteString(issueNonce(a.Key, a.clock(), a.TTL)))
+	pass(w, withUserId(req, userId), stdLogger{}, next)
+}
+
+// verify returns the comment of the authorized key with fingerprint if sig is its signature of nonce.
+func (a *SSHKeyAuth) verify(fingerprint, nonce string, sig *ssh.Signature) (string, bool) {
+	line, found := a.datastore.Get(fingerprint)
+	if !found {
+		return "", false
+	}
+	key, comment, _, _, err := ssh.ParseAuthorizedKey(line)
+	// The store must not map a fingerprint to another key.
+	if err != nil || comment == "" || ssh.FingerprintSHA256(key) != fingerprint {
+		return "", false
+	}
+	if key.Verify([]byte(sshSignedPrefix+nonce), sig) != nil {
+		return "", false
+	}
+	return comment, true
+}
@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codegangsta/negroni"
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestSigner(t *testing.T) ssh.Signer {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func authorizedKey(signer ssh.Signer, comment string) string {
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))) + " " + comment + "\n"
+}
+
+func Test_SSHKeyAuth(t *testing.T) {
+	alice, mallory := newTestSigner(t), newTestSigner(t)
+	ds, err := NewAuthorizedKeysStore([]byte("# team keys\n" + authorizedKey(alice, "alice@example.com")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1000, 0)
+	a := NewSSHKeyAuth(ds, []byte("server key server key server key"), NewMemoryNonceStore())
+	a.now = func() time.Time { return now }
+
+	m := negroni.New(a)
+	m.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userId, _ := UserIdFromContext(r.Context())
+		w.Write([]byte("hello " + userId))
+	}))
+	serve := func(r *http.Request) *http.Response {
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, r)
+		return recorder.Result()
+	}
+
+	// The challenge carries the first nonce.
+	r, _ := http.NewRequest("GET", "foo", nil)
+	resp := serve(r)
+	nonce := ResponseNonce(resp)
+	if resp.StatusCode != http.StatusUnauthorized || nonce == "" || !strings.HasPrefix(resp.Header.Get("WWW-Authenticate"), "SSH-Signature ") {
+		t.Fatalf("Expected a 401 with nonce, got %d %q", resp.StatusCode, resp.Header.Get("WWW-Authenticate"))
+	}
+
+	r, _ = http.NewRequest("GET", "foo", nil)
+	if err := SetSSHAuth(r, alice, nonce); err != nil {
+		t.Fatal(err)
+	}
+	replay := r.Header.Get("Authorization")
+	resp = serve(r)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", resp.StatusCode)
+	}
+	next := ResponseNonce(resp)
+	if next == "" || next == nonce {
+		t.Fatalf("Expected a new nonce in Authentication-Info, got %q", resp.Header.Get("Authentication-Info"))
+	}
+
+	var sshkeytests = []struct {
+		name string
+		set  func(r *http.Request)
+		code int
+		body string
+	}{
+		{"replay", func(r *http.Request) { r.Header.Set("Authorization", replay) }, http.StatusUnauthorized, "Not Authorized\n"},
+		{"unknown key", func(r *http.Request) { SetSSHAuth(r, mallory, issueNonce(a.Key, now, 0)) }, http.StatusUnauthorized, "Not Authorized\n"},
+		{"forged nonce", func(r *http.Request) { SetSSHAuth(r, alice, "AAAA"+next[4:]) }, http.StatusUnauthorized, "Not Authorized\n"},
+		{"foreign signature", func(r *http.Request) {
+			SetSSHAuth(r, mallory, issueNonce(a.Key, now, 0))
+			r.Header.Set("Authorization", strings.Replace(r.Header.Get("Authorization"),
+				ssh.FingerprintSHA256(mallory.PublicKey()), ssh.FingerprintSHA256(alice.PublicKey()), 1))
+		}, http.StatusUnauthorized, "Not Authorized\n"},
+		{"Basic", func(r *http.Request) { r.SetBasicAuth("alice@example.com", "bar") }, http.StatusUnauthorized, "Not Authorized\n"},
+		{"next nonce", func(r *http.Request) { SetSSHAuth(r, alice, next) }, http.StatusOK, "hello alice@example.com"},
+	}
+	for _, tt := range sshkeytests {
+		r, _ := http.NewRequest("GET", "foo", nil)
+		tt.set(r)
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, r)
+
+		if recorder.Code != tt.code || recorder.Body.String() != tt.body {
+			t.Errorf("Expected %d %q for %s, got %d %q", tt.code, tt.body, tt.name, recorder.Code, recorder.Body.String())
+		}
+	}
+
+	// Expired nonces are rejected.
+	expired := issueNonce(a.Key, now, time.Second)
+	now = now.Add(2 * time.Second)
+	r, _ = http.NewRequest("GET", "foo", nil)
+	SetSSHAuth(r, alice, expired)
+	if resp := serve(r); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for an expired nonce, got %d", resp.StatusCode)
+	}
+}
+
+func Test_NewAuthorizedKeysStore(t *testing.T) {
+	alice := newTestSigner(t)
+
+	var authorizedkeystests = []struct {
+		name  string
+		keys  string
+		valid bool
+	}{
+		{"valid", authorizedKey(alice, "alice"), true},
+		{"no comment", authorizedKey(alice, ""), false},
+		{"duplicate", authorizedKey(alice, "alice") + authorizedKey(alice, "bob"), false},
+		{"empty", "# nothing here\n", false},
+	}
+	for _, tt := range authorizedkeystests {
+		if _, err := NewAuthorizedKeysStore([]byte(tt.keys)); tt.valid != (err == nil) {
+			t.Errorf("Expected valid %v for %s, got %v", tt.valid, tt.name, err)
+		}
+	}
+}
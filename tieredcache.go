@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// ErrCache is an optional interface for caches which can fail, e.g. backed by Redis.
+// TieredCache uses it for its L2 tier to tell a failure from a miss.
+type ErrCache interface {
+	Cache
+	GetErr(key string) (value interface{}, found bool, err error)
+	SetErr(key string, value interface{}, ttl time.Duration) error
+}
+
+// TieredCache is a Cache checking an in-memory L1 tier, then a shared L2 tier, e.g. Redis,
+// and populating L1 on L2 hits, so that an instance only asks L2 for credentials it hasn't
+// seen recently. If L2 implements ErrCache, its failures are logged and treated as misses,
+// degrading to L1 only. Entries deleted or invalidated on other instances are served from L1
+// for up to L1TTL. It implements Cache, so it can be passed as Options.Cache.
+type TieredCache struct {
+	errors uint64 // first for 64-bit alignment of atomic operations
+
+	L1 Cache
+	L2 Cache
+	// L1TTL, if positive, bounds the lifetime of entries in L1, which is also how long entries
+	// populated from L2 are kept in L1.
+	L1TTL time.Duration
+	// L2TTL, if positive, bounds the lifetime of entries in L2.
+	L2TTL time.Duration
+	// Logger logs failures of L2; the standard logger if nil.
+	Logger Logger
+}
+
+// NewTieredCache returns *TieredCache checking l1, then l2.
+func NewTieredCache(l1, l2 Cache) *TieredCache {
+	return &TieredCache{L1: l1, L2: l2}
+}
+
+// TieredCache.Get returns the value of key in L1, or else in L2, copying it to L1.
+func (c *TieredCache) Get(key string) (interface{}, bool) {
+	if value, found := c.L1.Get(key); found {
+		return value, true
+	}
+
+	var value interface{}
+	var found bool
+	if ec, ok := c.L2.(ErrCache); ok {
+		var err error
+		if value, found, err = ec.GetErr(key); err != nil {
+			c.fail(err)
+			return nil, false
+		}
+	} else {
+		value, found = c.L2.Get(key)
+	}
+	if !found {
+		return nil, false
+	}
+
+	if ttl := c.l1PopulateTTL(value); ttl > 0 {
+		c.L1.Set(key, value, ttl)
+	}
+	return value, true
+}
+
+// TieredCache.Set stores value in both tiers for ttl, bounded by the TTL of each tier.
+func (c *TieredCache) Set(key string, value interface{}, ttl time.Duration) {
+	c.L1.Set(key, value, boundTTL(ttl, c.L1TTL))
+	if ec, ok := c.L2.(ErrCache); ok {
+		if err := ec.SetErr(key, value, boundTTL(ttl, c.L2TTL)); err != nil {
+			c.fail(err)
+		}
+		return
+	}
+	c.L2.Set(key, value, boundTTL(ttl, c.L2TTL))
+}
+
+// TieredCache.Delete deletes key from both tiers.
+func (c *TieredCache) Delete(key string) {
+	c.L1.Delete(key)
+	c.L2.Delete(key)
+}
+
+// TieredCache.DeleteExpired drops the expired entries of the tiers which must be told to.
+func (c *TieredCache) DeleteExpired() {
+	if e, ok := c.L1.(expirer); ok {
+		e.DeleteExpired()
+	}
+	if e, ok := c.L2.(expirer); ok {
+		e.DeleteExpired()
+	}
+}
+
+// l1PopulateTTL returns how long value found in L2 is kept in L1; L1TTL if set, or else
+// until a CacheEntry expires. Other values aren't copied without L1TTL.
+func (c *TieredCache) l1PopulateTTL(value interface{}) time.Duration {
+	if c.L1TTL > 0 {
+		return c.L1TTL
+	}
+	if entry, ok := value.(CacheEntry); ok {
+		return time.Until(entry.Expires)
+	}
+	return 0
+}
+
+// fail logs the first and then every 1000th failure of L2.
+func (c *TieredCache) fail(err error) {
+	if n := atomic.AddUint64(&c.errors, 1); n == 1 || n%1000 == 0 {
+		logger := c.Logger
+		if logger == nil {
+			logger = stdLogger{}
+		}
+		logger.Printf("negroni-auth: L2 cache failed, %d failures so far, using L1 only: %v", n, err)
+	}
+}
+
+// boundTTL returns ttl bounded by max if max is positive.
+func boundTTL(ttl, max time.Duration) time.Duration {
+	if max > 0 && (ttl <= 0 || ttl > max) {
+		return max
+	}
+	return ttl
+}
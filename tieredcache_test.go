@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/codegangsta/negroni"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type ttlCache struct {
+	mapCache
+	ttls map[string]time.Duration
+}
+
+func newTTLCache() *ttlCache {
+	return &ttlCache{mapCache: mapCache{}, ttls: map[string]time.Duration{}}
+}
+
+func (c *ttlCache) Set(key string, value interface{}, ttl time.Duration) {
+	c.mapCache.Set(key, value, ttl)
+	c.ttls[key] = ttl
+}
+
+type failingCache struct {
+	mapCache
+	err error
+}
+
+func (c failingCache) GetErr(key string) (interface{}, bool, error) {
+	if c.err != nil {
+		return nil, false, c.err
+	}
+	value, found := c.Get(key)
+	return value, found, nil
+}
+
+func (c failingCache) SetErr(key string, value interface{}, ttl time.Duration) error {
+	if c.err != nil {
+		return c.err
+	}
+	c.Set(key, value, ttl)
+	return nil
+}
+
+func Test_TieredCache(t *testing.T) {
+	l1, l2 := newTTLCache(), newTTLCache()
+	c := NewTieredCache(l1, l2)
+	c.L1TTL = time.Minute
+
+	c.Set("foo", "bar", time.Hour)
+	if l1.ttls["foo"] != time.Minute || l2.ttls["foo"] != time.Hour {
+		t.Errorf("Expected TTLs of 1m and 1h, got %v and %v", l1.ttls["foo"], l2.ttls["foo"])
+	}
+
+	// A hit in L2 populates L1.
+	l2.Set("qux", "quux", time.Hour)
+	if value, found := c.Get("qux"); !found || value != "quux" {
+		t.Errorf("Expected quux, got %v", value)
+	}
+	if value, found := l1.Get("qux"); !found || value != "quux" || l1.ttls["qux"] != time.Minute {
+		t.Errorf("Expected quux in L1 for 1m, got %v for %v", value, l1.ttls["qux"])
+	}
+
+	// L1 answers without L2.
+	delete(l2.mapCache, "qux")
+	if _, found := c.Get("qux"); !found {
+		t.Error("Expected qux from L1")
+	}
+
+	c.Delete("foo")
+	if _, found := c.Get("foo"); found {
+		t.Error("Expected foo to be deleted from both tiers")
+	}
+
+	// Without L1TTL, cache entries are kept in L1 until they expire.
+	c.L1TTL = 0
+	l2.Set("entry", CacheEntry{UserId: "foo", Expires: time.Now().Add(time.Hour)}, time.Hour)
+	c.Get("entry")
+	if ttl := l1.ttls["entry"]; ttl <= 59*time.Minute || ttl > time.Hour {
+		t.Errorf("Expected entry in L1 for about 1h, got %v", ttl)
+	}
+}
+
+func Test_TieredCacheL2Failure(t *testing.T) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte("bar"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := &recordingLogger{}
+	c := NewTieredCache(mapCache{}, failingCache{mapCache: mapCache{}, err: errors.New("connection refused")})
+	c.Logger = logger
+
+	ds := &countingDataStore{MockDataStore: MockDataStore{hashedPassword}}
+	b, err := NewCachedBasic(ds, time.Minute, 0, Options{Cache: c})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	m := negroni.New(b)
+
+	for i := 0; i < 3; i++ {
+		r, _ := http.NewRequest("GET", "foo", nil)
+		r.SetBasicAuth("foo", "bar")
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, r)
+		if recorder.Code != http.StatusOK {
+			t.Errorf("Expected 200, got %d", recorder.Code)
+		}
+	}
+
+	// L1 still caches.
+	if ds.gets != 1 {
+		t.Errorf("Expected 1 lookup, got %d", ds.gets)
+	}
+	if len(logger.lines) != 1 {
+		t.Errorf("Expected the first failure only to be logged, got %q", logger.lines)
+	}
+}
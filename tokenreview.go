@@ -0,0 +1,285 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTokenReviewCacheTTL = 5 * time.Minute
+	defaultTokenReviewTimeout  = 5 * time.Second
+
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+)
+
+// TokenReviewStore is a TokenStore validating Kubernetes ServiceAccount tokens with the
+// TokenReview API of the API server, for service-to-service authentication within a cluster.
+// The userid is the Kubernetes username of the token, i.e.
+// "system:serviceaccount:<namespace>:<name>" for ServiceAccount tokens.
+// Positive results are cached until the token expires, but at most for CacheTTL so that
+// tokens of deleted ServiceAccounts are rejected eventually.
+// The application's own account needs permission to create tokenreviews.
+type TokenReviewStore struct {
+	// URL of the API server, e.g. "https://kubernetes.default.svc".
+	URL string
+	// TokenFile holds the bearer token authenticating the store to the API server.
+	// It's read for every review since projected tokens are rotated.
+	TokenFile string
+	// Audiences, if set, are the audiences the token must be issued for.
+	Audiences []string
+	// Client used for requests; configure it to trust the cluster CA.
+	Client *http.Client
+	// Timeout bounds each request to the API server; 5 seconds if zero.
+	Timeout time.Duration
+	// CacheTTL bounds how long a successful review is cached; 5 minutes if zero.
+	CacheTTL time.Duration
+
+	cache tokenCache
+	now   func() time.Time
+}
+
+// tokenCache caches the userids of tokens by their SHA-256 hash until they expire.
+type tokenCache struct {
+	mu        sync.Mutex
+	entries   map[[sha256.Size]byte]tokenCacheEntry
+	lastSweep time.Time
+}
+
+type tokenCacheEntry struct {
+	userId  string
+	expires time.Time
+}
+
+// NewInClusterTokenReviewStore returns *TokenReviewStore for the cluster the application runs in,
+// using the API server address from the environment and the credentials of its ServiceAccount.
+func NewInClusterTokenReviewStore(audiences ...string) (*TokenReviewStore, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("auth: not running in a Kubernetes cluster")
+	}
+
+	ca, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("auth: reading cluster CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("auth: no certificate in cluster CA")
+	}
+
+	return &TokenReviewStore{
+		URL:       "https://" + net.JoinHostPort(host, port),
+		TokenFile: serviceAccountDir + "/token",
+		Audiences: audiences,
+		Client: &http.Client{
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+			Timeout:   defaultTokenReviewTimeout,
+		},
+	}, nil
+}
+
+type tokenReview struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Spec       tokenReviewSpec   `json:"spec"`
+	Status     tokenReviewStatus `json:"status"`
+}
+
+type tokenReviewSpec struct {
+	Token     string   `json:"token"`
+	Audiences []string `json:"audiences,omitempty"`
+}
+
+type tokenReviewStatus struct {
+	Authenticated bool   `json:"authenticated"`
+	Error         string `json:"error"`
+	User          struct {
+		Username string `json:"username"`
+	} `json:"user"`
+}
+
+// TokenReviewStore.Get reviews token and returns its username.
+func (s *TokenReviewStore) Get(ctx context.Context, token string) (string, error) {
+	// Legacy ServiceAccount tokens don't expire; they are cached for CacheTTL.
+	now := s.clock()
+	expires, ok := jwtExpiry(token)
+	if ok && !now.Before(expires) {
+		return "", ErrTokenExpired
+	}
+
+	key := sha256.Sum256([]byte(token))
+	if userId, found := s.cache.get(key, now); found {
+		return userId, nil
+	}
+
+	userId, err := s.review(ctx, token)
+	if err != nil {
+		return "", err
+	}
+
+	ttl := s.CacheTTL
+	if ttl <= 0 {
+		ttl = defaultTokenReviewCacheTTL
+	}
+	if max := now.Add(ttl); !ok || max.Before(expires) {
+		expires = max
+	}
+	s.cache.set(key, tokenCacheEntry{userId: userId, expires: expires}, now)
+	return userId, nil
+}
+
+// review asks the API server whether token is valid.
+func (s *TokenReviewStore) review(ctx context.Context, token string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout())
+	defer cancel()
+
+	credential, err := os.ReadFile(s.TokenFile)
+	if err != nil {
+		return "", fmt.Errorf("auth: reading token file: %w", err)
+	}
+
+	body, err := json.Marshal(tokenReview{
+		APIVersion: "authentication.k8s.io/v1",
+		Kind:       "TokenReview",
+		Spec:       tokenReviewSpec{Token: token, Audiences: s.Audiences},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequest("POST", strings.TrimSuffix(s.URL, "/")+"/apis/authentication.k8s.io/v1/tokenreviews", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(credential)))
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", fmt.Errorf("auth: token review: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("auth: token review: unexpected status %d", resp.StatusCode)
+	}
+
+	var review tokenReview
+	if err := json.NewDecoder(resp.Body).Decode(&review); err != nil {
+		return "", fmt.Errorf("auth: token review: decoding response: %w", err)
+	}
+	if !review.Status.Authenticated || review.Status.User.Username == "" {
+		return "", ErrInvalidToken
+	}
+	return review.Status.User.Username, nil
+}
+
+// TokenReviewStore.HealthCheck checks that the API server is ready, authenticating as the store does.
+func (s *TokenReviewStore) HealthCheck(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout())
+	defer cancel()
+
+	credential, err := os.ReadFile(s.TokenFile)
+	if err != nil {
+		return fmt.Errorf("auth: reading token file: %w", err)
+	}
+
+	req, err := http.NewRequest("GET", strings.TrimSuffix(s.URL, "/")+"/readyz", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(credential)))
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("auth: token review: %w", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("auth: token review: API server not ready, status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (s *TokenReviewStore) timeout() time.Duration {
+	if s.Timeout > 0 {
+		return s.Timeout
+	}
+	return defaultTokenReviewTimeout
+}
+
+func (s *TokenReviewStore) clock() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
+
+func (c *tokenCache) get(key [sha256.Size]byte, now time.Time) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, found := c.entries[key]
+	if !found || !now.Before(e.expires) {
+		return "", false
+	}
+	return e.userId, true
+}
+
+// set caches e, deleting expired entries from time to time.
+func (c *tokenCache) set(key [sha256.Size]byte, e tokenCacheEntry, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[[sha256.Size]byte]tokenCacheEntry)
+	}
+	if now.Sub(c.lastSweep) >= time.Minute {
+		for k, v := range c.entries {
+			if !now.Before(v.expires) {
+				delete(c.entries, k)
+			}
+		}
+		c.lastSweep = now
+	}
+	c.entries[key] = e
+}
+
+// len returns the number of cached entries, including expired ones not deleted yet.
+func (c *tokenCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// jwtExpiry returns the "exp" claim of the JWT token without verifying it, or false if token
+// has none.
+func jwtExpiry(token string) (time.Time, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil || claims.ExpiresAt == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(claims.ExpiresAt, 0), true
+}
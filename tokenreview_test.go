@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testServiceAccountToken(exp int64) string {
+	claims := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"exp":%d}`, exp)))
+	return "eyJhbGciOiJSUzI1NiJ9." + claims + ".c2ln"
+}
+
+func Test_TokenReviewStore(t *testing.T) {
+	now := time.Unix(1000, 0)
+	valid := testServiceAccountToken(now.Add(time.Hour).Unix())
+	expired := testServiceAccountToken(now.Add(-time.Second).Unix())
+
+	reviews := 0
+	down := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/apis/authentication.k8s.io/v1/tokenreviews" || r.Header.Get("Authorization") != "Bearer s3cret" {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if down {
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		reviews++
+
+		var review tokenReview
+		json.NewDecoder(r.Body).Decode(&review)
+		if review.Spec.Token == valid && len(review.Spec.Audiences) == 1 && review.Spec.Audiences[0] == "my-api" {
+			review.Status.Authenticated = true
+			review.Status.User.Username = "system:serviceaccount:default:builder"
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(review)
+	}))
+	defer ts.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s := &TokenReviewStore{URL: ts.URL, TokenFile: tokenFile, Audiences: []string{"my-api"}, now: func() time.Time { return now }}
+
+	var tokenreviewtests = []struct {
+		token   string
+		userId  string
+		err     error
+		reviews int
+	}{
+		{valid, "system:serviceaccount:default:builder", nil, 1},
+		// Cached.
+		{valid, "system:serviceaccount:default:builder", nil, 1},
+		{expired, "", ErrTokenExpired, 1},
+		{testServiceAccountToken(now.Add(time.Hour).Unix() + 1), "", ErrInvalidToken, 2},
+	}
+	for _, tt := range tokenreviewtests {
+		userId, err := s.Get(context.Background(), tt.token)
+		if userId != tt.userId || !errors.Is(err, tt.err) {
+			t.Errorf("Expected %q, %v, got %q, %v", tt.userId, tt.err, userId, err)
+		}
+		if reviews != tt.reviews {
+			t.Errorf("Expected %d reviews, got %d", tt.reviews, reviews)
+		}
+	}
+
+	// The cache entry expires after CacheTTL; an API server failure is a backend error.
+	now = now.Add(defaultTokenReviewCacheTTL)
+	down = true
+	if _, err := s.Get(context.Background(), valid); err == nil || errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected a backend error, got %v", err)
+	}
+}
+
+func Test_TokenReviewStoreTimeout(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s := &TokenReviewStore{URL: ts.URL, TokenFile: tokenFile, Timeout: 10 * time.Millisecond}
+
+	var timeouttests = []struct {
+		name string
+		call func() error
+	}{
+		{"Get", func() error {
+			_, err := s.Get(context.Background(), testServiceAccountToken(time.Now().Add(time.Hour).Unix()))
+			return err
+		}},
+		{"HealthCheck", func() error { return s.HealthCheck(context.Background()) }},
+	}
+	for _, tt := range timeouttests {
+		start := time.Now()
+		err := tt.call()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected %s to time out, got %v", tt.name, err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("Expected %s to give up after Timeout, took %v", tt.name, elapsed)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTOTPDigits = 6
+	defaultTOTPPeriod = 30 * time.Second
+)
+
+// TOTPVerifier is a PasswordVerifier for service integrations whose password is a TOTP code
+// (RFC 6238) rather than a second factor. The data store holds the shared secret of each userid,
+// base32 encoded as in otpauth URIs, and a password is accepted if it's the code of the current
+// period, or of a period within MaxClockSkew. Each code is accepted once per userid, so a
+// captured code can't be replayed within the window; the codes accepted are kept in memory,
+// so run one verifier per process and don't cache authentications made with it.
+// It is safe for concurrent use.
+type TOTPVerifier struct {
+	// Digits is the length of the codes; 6 if zero.
+	Digits int
+	// Period is the lifetime of a code; 30 seconds if zero.
+	Period time.Duration
+	// MaxClockSkew is how far the clocks of the client and the server may drift apart;
+	// one Period if zero, and none if negative.
+	MaxClockSkew time.Duration
+	// Hash is the HMAC hash function; SHA-1 if nil, which most authenticators use.
+	Hash func() hash.Hash
+
+	mu   sync.Mutex
+	used map[string]uint64
+	now  func() time.Time
+}
+
+// NewTOTPVerifier returns *TOTPVerifier with 6-digit codes of 30 seconds.
+func NewTOTPVerifier() *TOTPVerifier {
+	return &TOTPVerifier{}
+}
+
+// TOTPVerifier.Verify checks that password is a valid code of the base32 secret hashedPassword
+// which wasn't accepted before for userId. A secret which can't be decoded is an ErrMalformedHash,
+// except bcrypt hashes, i.e. the dummy hash verified for unknown userids, which just mismatch.
+func (v *TOTPVerifier) Verify(ctx context.Context, userId string, hashedPassword, password []byte) error {
+	if bytes.HasPrefix(hashedPassword, []byte("$2")) {
+		return ErrPasswordMismatch
+	}
+	secret, err := decodeTOTPSecret(string(hashedPassword))
+	if err != nil {
+		return err
+	}
+
+	period := v.period()
+	skew := v.MaxClockSkew
+	if skew == 0 {
+		skew = period
+	}
+	if skew < 0 {
+		skew = 0
+	}
+	now := v.clock()
+	first, last := totpCounter(now.Add(-skew), period), totpCounter(now.Add(skew), period)
+
+	// Check every period of the window so the time taken doesn't tell which one matched.
+	var matched uint64
+	found := false
+	for counter := first; counter <= last; counter++ {
+		if hmac.Equal([]byte(v.code(secret, counter)), password) && !found {
+			matched, found = counter, true
+		}
+	}
+	if !found {
+		return ErrPasswordMismatch
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if used, ok := v.used[userId]; ok && matched <= used {
+		return ErrPasswordMismatch
+	}
+	if v.used == nil {
+		v.used = make(map[string]uint64)
+	}
+	v.used[userId] = matched
+	return nil
+}
+
+// TOTPVerifier.Code returns the code of the base32 secret at t, e.g. for a client or a test.
+func (v *TOTPVerifier) Code(secret string, t time.Time) (string, error) {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return v.code(key, totpCounter(t, v.period())), nil
+}
+
+// code returns the HOTP value (RFC 4226) of counter.
+func (v *TOTPVerifier) code(secret []byte, counter uint64) string {
+	h := v.Hash
+	if h == nil {
+		h = sha1.New
+	}
+	digits := v.Digits
+	if digits <= 0 {
+		digits = defaultTOTPDigits
+	}
+
+	mac := hmac.New(h, secret)
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := uint64(binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff)
+	mod := uint64(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, value%mod)
+}
+
+func (v *TOTPVerifier) period() time.Duration {
+	if v.Period <= 0 {
+		return defaultTOTPPeriod
+	}
+	return v.Period
+}
+
+func (v *TOTPVerifier) clock() time.Time {
+	if v.now != nil {
+		return v.now()
+	}
+	return time.Now()
+}
+
+// totpCounter returns the number of periods between the Unix epoch and t.
+func totpCounter(t time.Time, period time.Duration) uint64 {
+	if t.Unix() < 0 {
+		return 0
+	}
+	return uint64(t.UnixNano() / int64(period))
+}
+
+// decodeTOTPSecret decodes secret as base32, ignoring case, spaces and padding.
+func decodeTOTPSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(secret, "="))
+	if err != nil || len(key) == 0 {
+		return nil, fmt.Errorf("%w: invalid TOTP secret", ErrMalformedHash)
+	}
+	return key, nil
+}
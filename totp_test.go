@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/codegangsta/negroni"
+
+	"github.com/nabeken/negroni-auth/datastore"
+)
+
+// The secret of the test vectors of RFC 6238, "12345678901234567890".
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func Test_TOTPVerifierCode(t *testing.T) {
+	v := &TOTPVerifier{Digits: 8}
+	var codetests = []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+	}
+	for _, tt := range codetests {
+		code, err := v.Code(rfc6238Secret, time.Unix(tt.unix, 0))
+		if err != nil || code != tt.code {
+			t.Errorf("Expected %s at %d, got %s (%v)", tt.code, tt.unix, code, err)
+		}
+	}
+}
+
+func Test_TOTPVerifier(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	code := func(d time.Duration) string {
+		c, _ := NewTOTPVerifier().Code(rfc6238Secret, now.Add(d))
+		return c
+	}
+
+	var totptests = []struct {
+		skew     time.Duration
+		secret   string
+		password string
+		err      error
+	}{
+		{0, rfc6238Secret, code(0), nil},
+		{0, "gezd gnbv gy3t qojq gezd gnbv gy3t qojq", code(0), nil},
+		{0, rfc6238Secret, code(-30 * time.Second), nil},
+		{0, rfc6238Secret, code(30 * time.Second), nil},
+		{0, rfc6238Secret, code(-60 * time.Second), ErrPasswordMismatch},
+		{-1, rfc6238Secret, code(-30 * time.Second), ErrPasswordMismatch},
+		{time.Minute, rfc6238Secret, code(-60 * time.Second), nil},
+		{0, rfc6238Secret, "000000", ErrPasswordMismatch},
+		// The dummy hash of unknown userids.
+		{0, "$2a$10$3ocvQJhbH0VCBVp3pPzGueL6GZRbBrf3lLnzHMTzP5MtUPGAGYzPu", code(0), ErrPasswordMismatch},
+		{0, "not base32!", code(0), ErrMalformedHash},
+	}
+	for i, tt := range totptests {
+		v := NewTOTPVerifier()
+		v.MaxClockSkew = tt.skew
+		v.now = func() time.Time { return now }
+		if err := v.Verify(context.Background(), "foo", []byte(tt.secret), []byte(tt.password)); !errors.Is(err, tt.err) {
+			t.Errorf("Expected %v for test %d, got %v", tt.err, i, err)
+		}
+	}
+
+	// A code is accepted once, and so are the codes of earlier periods after it.
+	v := NewTOTPVerifier()
+	v.now = func() time.Time { return now }
+	var replaytests = []struct {
+		userId   string
+		password string
+		err      error
+	}{
+		{"foo", code(0), nil},
+		{"foo", code(0), ErrPasswordMismatch},
+		{"foo", code(-30 * time.Second), ErrPasswordMismatch},
+		{"foo", code(30 * time.Second), nil},
+		{"bar", code(0), nil},
+	}
+	for _, tt := range replaytests {
+		if err := v.Verify(context.Background(), tt.userId, []byte(rfc6238Secret), []byte(tt.password)); !errors.Is(err, tt.err) {
+			t.Errorf("Expected %v for %q with %s, got %v", tt.err, tt.userId, tt.password, err)
+		}
+	}
+}
+
+func Test_TOTPVerifierBasic(t *testing.T) {
+	v := NewTOTPVerifier()
+	store := datastore.NewMap(map[string][]byte{"foo": []byte(rfc6238Secret)})
+	m := negroni.New(NewBasicWithOptions(store, Options{Verifier: v}))
+
+	code, err := v.Code(rfc6238Secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var basictests = []struct {
+		userId   string
+		password string
+		code     int
+	}{
+		{"foo", code, http.StatusOK},
+		{"foo", code, http.StatusUnauthorized},
+		{"bar", code, http.StatusUnauthorized},
+	}
+	for _, tt := range basictests {
+		r, _ := http.NewRequest("GET", "foo", nil)
+		r.SetBasicAuth(tt.userId, tt.password)
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, r)
+		if recorder.Code != tt.code {
+			t.Errorf("Expected %d for %q, got %d", tt.code, tt.userId, recorder.Code)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"context"
+	"errors"
+)
+
+// Tracer is an interface for starting spans of a distributed tracing system, e.g. an adapter
+// around an OpenTelemetry trace.Tracer, so the middleware doesn't depend on one.
+// Start returns a context carrying the span, from which child spans are started.
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer. Attributes never hold the credential.
+type Span interface {
+	SetAttribute(key, value string)
+	End()
+}
+
+// Names of the spans and attributes set by the middleware.
+const (
+	// SpanAuthenticate covers the authentication of a request, with AttrScheme and AttrOutcome.
+	SpanAuthenticate = "negroni-auth.authenticate"
+	// SpanCache covers a request to CachedBasic, with AttrCache.
+	SpanCache = "negroni-auth.cache"
+	// SpanLookup covers the data store lookup, with AttrOutcome.
+	SpanLookup = "negroni-auth.lookup"
+	// SpanVerify covers the password verification, with AttrOutcome.
+	SpanVerify = "negroni-auth.verify"
+
+	// AttrScheme is the authentication scheme, e.g. "basic".
+	AttrScheme = "auth.scheme"
+	// AttrCache is "hit" or "miss".
+	AttrCache = "auth.cache"
+	// AttrOutcome is "success", "failure" or "error"; "found" or "not-found" for lookups.
+	AttrOutcome = "auth.outcome"
+)
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(key, value string) {}
+func (noopSpan) End()                           {}
+
+// startSpan starts the span name as a child of ctx with the Tracer, if any.
+func (o *Options) startSpan(ctx context.Context, name string) (context.Context, Span) {
+	if o.Tracer == nil {
+		return ctx, noopSpan{}
+	}
+	return o.Tracer.Start(ctx, name)
+}
+
+// outcome returns the AttrOutcome of an authentication or verification failing with err.
+func outcome(err error) string {
+	switch {
+	case err == nil:
+		return "success"
+	case errors.Is(err, errUnauthenticated) || errors.Is(err, ErrPasswordMismatch):
+		return "failure"
+	}
+	return "error"
+}
@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/codegangsta/negroni"
+	"github.com/nabeken/negroni-auth/datastore"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type recordingSpanKey struct{}
+
+// recordingTracer records ended spans as "parent>name attr=value ...".
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []string
+}
+
+type recordingSpan struct {
+	tracer *recordingTracer
+	path   string
+	attrs  []string
+}
+
+func (tr *recordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	path := name
+	if parent, ok := ctx.Value(recordingSpanKey{}).(*recordingSpan); ok {
+		path = parent.path + ">" + name
+	}
+	s := &recordingSpan{tracer: tr, path: path}
+	return context.WithValue(ctx, recordingSpanKey{}, s), s
+}
+
+func (s *recordingSpan) SetAttribute(key, value string) {
+	s.attrs = append(s.attrs, key+"="+value)
+}
+
+func (s *recordingSpan) End() {
+	sort.Strings(s.attrs)
+	s.tracer.mu.Lock()
+	s.tracer.spans = append(s.tracer.spans, strings.Join(append([]string{s.path}, s.attrs...), " "))
+	s.tracer.mu.Unlock()
+}
+
+func Test_OptionsTracer(t *testing.T) {
+	bar, _ := bcrypt.GenerateFromPassword([]byte("bar"), bcrypt.MinCost)
+	ds := datastore.NewMap(map[string][]byte{"foo": bar})
+	tracer := &recordingTracer{}
+	m := negroni.New(CacheBasicWithOptions(ds, time.Minute, 0, Options{Tracer: tracer}))
+
+	var tracertests = []struct {
+		password string
+		spans    []string
+	}{
+		{"bar", []string{
+			"negroni-auth.cache>negroni-auth.authenticate>negroni-auth.lookup auth.outcome=found",
+			"negroni-auth.cache>negroni-auth.authenticate>negroni-auth.verify auth.outcome=success",
+			"negroni-auth.cache>negroni-auth.authenticate auth.outcome=success auth.scheme=basic",
+			"negroni-auth.cache auth.cache=miss",
+		}},
+		{"bar", []string{"negroni-auth.cache auth.cache=hit"}},
+		{"baz", []string{
+			"negroni-auth.cache>negroni-auth.authenticate>negroni-auth.lookup auth.outcome=found",
+			"negroni-auth.cache>negroni-auth.authenticate>negroni-auth.verify auth.outcome=failure",
+			"negroni-auth.cache>negroni-auth.authenticate auth.outcome=failure auth.scheme=basic",
+			"negroni-auth.cache auth.cache=miss",
+		}},
+	}
+	for _, tt := range tracertests {
+		tracer.spans = nil
+		r, _ := http.NewRequest("GET", "foo", nil)
+		r.SetBasicAuth("foo", tt.password)
+		m.ServeHTTP(httptest.NewRecorder(), r)
+
+		if !reflect.DeepEqual(tracer.spans, tt.spans) {
+			t.Errorf("Expected spans %q for %s, got %q", tt.spans, tt.password, tracer.spans)
+		}
+		for _, s := range tracer.spans {
+			if strings.Contains(s, tt.password) {
+				t.Errorf("Span %q leaks the credential", s)
+			}
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/nabeken/negroni-auth/datastore"
+)
+
+// UserIds returns the userids of ds if it implements datastore.Lister. The hashes are never exposed.
+func UserIds(ds datastore.Datastore) ([]string, bool) {
+	l, ok := ds.(datastore.Lister)
+	if !ok {
+		return nil, false
+	}
+	userIds := l.UserIds()
+	if userIds == nil {
+		userIds = []string{}
+	}
+	return userIds, true
+}
+
+// UserIdsHandler returns a http.Handler answering the userids of ds as a JSON array to GET requests,
+// e.g. for an admin UI, or a http.StatusNotImplemented if ds doesn't implement datastore.Lister.
+// It doesn't authenticate anyone itself; mount it behind the authentication of the admins.
+func UserIdsHandler(ds datastore.Datastore) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "GET" && req.Method != "HEAD" {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		userIds, ok := UserIds(ds)
+		if !ok {
+			http.Error(w, "Not Implemented", http.StatusNotImplemented)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(userIds)
+	})
+}
@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nabeken/negroni-auth/datastore"
+)
+
+func Test_UserIdsHandler(t *testing.T) {
+	store := NewHMACSecretStore([]byte("server-key"))
+	store.SetSecret("key2", "s3cr3t")
+	store.SetSecret("key1", "an0ther")
+
+	var useridstests = []struct {
+		ds     datastore.Datastore
+		method string
+		code   int
+		body   string
+	}{
+		{store, "GET", http.StatusOK, `["key1","key2"]` + "\n"},
+		{datastore.NewMap(nil), "GET", http.StatusOK, "[]\n"},
+		{store, "POST", http.StatusMethodNotAllowed, "Method Not Allowed\n"},
+		{&MockDataStore{}, "GET", http.StatusNotImplemented, "Not Implemented\n"},
+	}
+	for _, tt := range useridstests {
+		r, _ := http.NewRequest(tt.method, "/admin/users", nil)
+		recorder := httptest.NewRecorder()
+		UserIdsHandler(tt.ds).ServeHTTP(recorder, r)
+		if recorder.Code != tt.code || recorder.Body.String() != tt.body {
+			t.Errorf("Expected %d %q for %s %T, got %d %q", tt.code, tt.body, tt.method, tt.ds, recorder.Code, recorder.Body.String())
+		}
+	}
+}
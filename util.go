@@ -3,8 +3,17 @@ package auth
 import (
 	"crypto/sha256"
 	"crypto/subtle"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// defaultRetryAfter is how long clients are asked to wait before retrying after a backend failure.
+const defaultRetryAfter = 5 * time.Second
+
 // SecureCompare performs a constant time compare of two strings to limit timing attacks.
 func SecureCompare(given string, actual string) bool {
 	givenSha := sha256.Sum256([]byte(given))
@@ -12,3 +21,194 @@ func SecureCompare(given string, actual string) bool {
 
 	return subtle.ConstantTimeCompare(givenSha[:], actualSha[:]) == 1
 }
+
+// quoteString returns s as an RFC 7230 quoted-string.
+// Control characters, which can't be represented, are dropped.
+func quoteString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case isControl(r):
+			// Drop CR, LF etc. to prevent header injection.
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// challenge returns the RFC 7235 challenge of scheme with the auth-params given as
+// name, value pairs, e.g. `Basic realm="api", charset="UTF-8"`. Values are always sent
+// as quoted-strings, which the grammar allows for any auth-param, so they may contain
+// spaces, commas and quotes. Names must be tokens.
+func challenge(scheme string, params ...string) string {
+	var b strings.Builder
+	b.WriteString(scheme)
+	for i := 0; i+1 < len(params); i += 2 {
+		if i == 0 {
+			b.WriteByte(' ')
+		} else {
+			b.WriteString(", ")
+		}
+		b.WriteString(params[i])
+		b.WriteByte('=')
+		b.WriteString(quoteString(params[i+1]))
+	}
+	return b.String()
+}
+
+// addVary adds field to the Vary header of h unless it's already listed.
+func addVary(h http.Header, field string) {
+	for _, v := range h["Vary"] {
+		for _, f := range strings.Split(v, ",") {
+			if f = strings.TrimSpace(f); f == "*" || strings.EqualFold(f, field) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", field)
+}
+
+// minKeySize is the minimum length of the HMAC keys of session cookies and nonces. A shorter
+// key, let alone an empty one, would let anyone forge them.
+const minKeySize = 32
+
+// checkKey returns an error if key, the HMAC key of what, is shorter than minKeySize.
+func checkKey(what string, key []byte) error {
+	if len(key) < minKeySize {
+		return fmt.Errorf("auth: %s key must be at least %d bytes, got %d", what, minKeySize, len(key))
+	}
+	return nil
+}
+
+// serviceUnavailable writes a http.StatusServiceUnavailable for a backend failure, asking
+// clients to retry after retryAfter. No challenge is sent, since asking for other
+// credentials doesn't help while the backend is down.
+func serviceUnavailable(w http.ResponseWriter, retryAfter time.Duration) {
+	if retryAfter <= 0 {
+		retryAfter = defaultRetryAfter
+	}
+	w.Header().Del("WWW-Authenticate")
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+}
+
+// isWebSocketUpgrade reports whether req is a WebSocket handshake.
+func isWebSocketUpgrade(req *http.Request) bool {
+	return strings.EqualFold(req.Header.Get("Upgrade"), "websocket")
+}
+
+// rejectUpgrade prepares the failure response of a WebSocket handshake. The client
+// gets a plain HTTP response before any upgrade and the connection isn't reused.
+func rejectUpgrade(w http.ResponseWriter, req *http.Request) {
+	if isWebSocketUpgrade(req) {
+		w.Header().Set("Connection", "close")
+	}
+}
+
+// isPreflight returns true if req is a CORS preflight request.
+func isPreflight(req *http.Request) bool {
+	return req.Method == "OPTIONS" &&
+		req.Header.Get("Origin") != "" &&
+		req.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// maskedError is an error whose message has credentials replaced by "***", so errors of
+// data stores and verifiers echoing their input can be logged safely.
+// Unwrap still returns the original error for errors.Is and errors.As.
+type maskedError struct {
+	err     error
+	secrets []string
+}
+
+// mask returns err with every non-empty secret masked in its message.
+func mask(err error, secrets ...string) error {
+	if err == nil {
+		return nil
+	}
+	return &maskedError{err: err, secrets: secrets}
+}
+
+func (e *maskedError) Error() string {
+	msg := e.err.Error()
+	for _, secret := range e.secrets {
+		if secret != "" {
+			msg = strings.ReplaceAll(msg, secret, "***")
+		}
+	}
+	return msg
+}
+
+func (e *maskedError) Unwrap() error { return e.err }
+
+// authorization returns the first Authorization header value of req using scheme, and whether
+// req has any Authorization header value. A request may carry several Authorization headers,
+// e.g. Basic and Bearer credentials. Only the first credential of a scheme is used, so a single
+// request can't try many passwords. The scheme is matched case-insensitively.
+func authorization(req *http.Request, scheme string) (string, bool) {
+	present := false
+	for _, v := range req.Header.Values("Authorization") {
+		if s, _ := splitAuthorization(v); strings.EqualFold(s, scheme) {
+			return v, true
+		}
+		present = present || v != ""
+	}
+	return "", present
+}
+
+// splitAuthorization splits an Authorization header value into the scheme and the credentials,
+// tolerating spaces and tabs around and between them. credentials is empty if there are none.
+func splitAuthorization(header string) (scheme, credentials string) {
+	header = strings.Trim(header, " \t")
+	i := strings.IndexAny(header, " \t")
+	if i < 0 {
+		return header, ""
+	}
+	return header[:i], strings.Trim(header[i:], " \t")
+}
+
+// authParam returns the value of the auth-param name in the comma separated list params,
+// e.g. `realm="api", nonce="abc"`, or "" if it's missing.
+func authParam(params, name string) string {
+	for params != "" {
+		params = strings.TrimLeft(params, " ,")
+		eq := strings.IndexByte(params, '=')
+		if eq < 0 {
+			return ""
+		}
+		key := strings.TrimSpace(params[:eq])
+		params = strings.TrimLeft(params[eq+1:], " ")
+
+		var value string
+		if strings.HasPrefix(params, `"`) {
+			var b strings.Builder
+			i := 1
+			for ; i < len(params) && params[i] != '"'; i++ {
+				if params[i] == '\\' && i+1 < len(params) {
+					i++
+				}
+				b.WriteByte(params[i])
+			}
+			if i < len(params) {
+				i++
+			}
+			value, params = b.String(), params[i:]
+		} else {
+			end := strings.IndexByte(params, ',')
+			if end < 0 {
+				end = len(params)
+			}
+			value, params = strings.TrimSpace(params[:end]), params[end:]
+		}
+
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
+}
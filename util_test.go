@@ -1,6 +1,10 @@
 package auth
 
 import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
 	"testing"
 )
 
@@ -24,3 +28,113 @@ func Test_SecureCompare(t *testing.T) {
 		}
 	}
 }
+
+var quotetests = []struct {
+	s   string
+	val string
+}{
+	{"Authorization Required", `"Authorization Required"`},
+	{"", `""`},
+	{`say "hi"`, `"say \"hi\""`},
+	{`back\slash`, `"back\\slash"`},
+	{"evil\r\nSet-Cookie: x=y", `"evilSet-Cookie: x=y"`},
+}
+
+func Test_QuoteString(t *testing.T) {
+	for _, tt := range quotetests {
+		if got := quoteString(tt.s); got != tt.val {
+			t.Errorf("Expected quoteString(%q) to return %s but got %s", tt.s, tt.val, got)
+		}
+	}
+}
+
+// challengeGrammar matches an RFC 7235 challenge: token [ 1*SP #auth-param ],
+// with quoted-string values and auth-params separated by commas.
+var challengeGrammar = regexp.MustCompile(
+	"^" + tchar + "+(?: " + tchar + "+=" + quotedString + "(?:, " + tchar + "+=" + quotedString + ")*)?$")
+
+const (
+	tchar        = "[!#$%&'*+.^_`|~0-9A-Za-z-]"
+	quotedString = `"(?:[^"\\\x00-\x1f\x7f]|\\.)*"`
+)
+
+var challengetests = []struct {
+	scheme string
+	params []string
+	val    string
+}{
+	{"Basic", nil, `Basic`},
+	{"Basic", []string{"realm", "Authorization Required"}, `Basic realm="Authorization Required"`},
+	{"Basic", []string{"realm", "api", "charset", "UTF-8"}, `Basic realm="api", charset="UTF-8"`},
+	{"Basic", []string{"realm", `a, "b" \ c`}, `Basic realm="a, \"b\" \\ c"`},
+	{"Bearer", []string{"realm", "api", "error", "invalid_token", "error_description", "The access token expired"},
+		`Bearer realm="api", error="invalid_token", error_description="The access token expired"`},
+	{"Basic", []string{"realm", "evil\r\nSet-Cookie: x=y"}, `Basic realm="evilSet-Cookie: x=y"`},
+}
+
+func Test_Challenge(t *testing.T) {
+	for _, tt := range challengetests {
+		got := challenge(tt.scheme, tt.params...)
+		if got != tt.val {
+			t.Errorf("Expected challenge(%q, %q) to return %s but got %s", tt.scheme, tt.params, tt.val, got)
+		}
+		if !challengeGrammar.MatchString(got) {
+			t.Errorf("Challenge %s doesn't match the RFC 7235 grammar", got)
+		}
+		// The values survive a round trip through the parser.
+		_, params := splitAuthorization(got)
+		for i := 0; i+1 < len(tt.params); i += 2 {
+			if strings.ContainsAny(tt.params[i+1], "\r\n") {
+				continue
+			}
+			if v := authParam(params, tt.params[i]); v != tt.params[i+1] {
+				t.Errorf("Expected auth-param %s of %s to be %q, got %q", tt.params[i], got, tt.params[i+1], v)
+			}
+		}
+	}
+}
+
+var varytests = []struct {
+	vary []string
+	val  []string
+}{
+	{nil, []string{"Authorization"}},
+	{[]string{"Accept-Encoding"}, []string{"Accept-Encoding", "Authorization"}},
+	{[]string{"Accept-Encoding, authorization"}, []string{"Accept-Encoding, authorization"}},
+	{[]string{"*"}, []string{"*"}},
+}
+
+func Test_AddVary(t *testing.T) {
+	for _, tt := range varytests {
+		h := http.Header{}
+		if tt.vary != nil {
+			h["Vary"] = tt.vary
+		}
+		addVary(h, "Authorization")
+		if !reflect.DeepEqual(h["Vary"], tt.val) {
+			t.Errorf("Expected addVary to %v to give %v but got %v", tt.vary, tt.val, h["Vary"])
+		}
+	}
+}
+
+var authparamtests = []struct {
+	params string
+	name   string
+	val    string
+}{
+	{`realm="api", nonce="abc"`, "nonce", "abc"},
+	{`realm="a, \"b\"", nonce=abc`, "nonce", "abc"},
+	{`realm="a, \"b\""`, "realm", `a, "b"`},
+	{`nextnonce="abc"`, "NextNonce", "abc"},
+	{`realm="api"`, "nonce", ""},
+	{`realm="unterminated`, "realm", "unterminated"},
+	{``, "nonce", ""},
+}
+
+func Test_AuthParam(t *testing.T) {
+	for _, tt := range authparamtests {
+		if got := authParam(tt.params, tt.name); got != tt.val {
+			t.Errorf("Expected authParam(%q, %q) to return %q but got %q", tt.params, tt.name, tt.val, got)
+		}
+	}
+}
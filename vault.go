@@ -0,0 +1,184 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	defaultVaultMount   = "userpass"
+	defaultVaultTimeout = 5 * time.Second
+)
+
+// VaultVerifier is a PasswordVerifier which logs in to HashiCorp Vault's userpass auth method
+// with the credential, so passwords are managed in Vault. A successful login authenticates
+// the user as the name of its Vault entity. The token issued by the login is only used to read
+// the entity and is revoked right away, so logins don't pile up leases.
+// The userpass role's policies must allow reading the user's own entity, e.g.
+//
+//	path "identity/entity/id/{{identity.entity.id}}" {
+//	  capabilities = ["read"]
+//	}
+//
+// The stored hash is ignored, so it is typically used with a nil data store. Every verification
+// is a round trip to Vault; use it with CacheBasic to cache successful logins.
+type VaultVerifier struct {
+	// Addr is the address of Vault, e.g. "https://vault.example.com:8200".
+	Addr string
+	// Mount is the path the userpass auth method is mounted at; "userpass" if empty.
+	Mount string
+	// Namespace, if set, is the Vault Enterprise namespace to log in to.
+	Namespace string
+	// Client used for requests; http.DefaultClient if nil.
+	Client *http.Client
+	// Timeout bounds each verification; 5 seconds if zero.
+	Timeout time.Duration
+}
+
+// NewVaultVerifier returns *VaultVerifier logging in to the userpass auth method of Vault at addr.
+func NewVaultVerifier(addr string) *VaultVerifier {
+	return &VaultVerifier{
+		Addr:    addr,
+		Mount:   defaultVaultMount,
+		Timeout: defaultVaultTimeout,
+	}
+}
+
+type vaultLoginResponse struct {
+	Auth struct {
+		ClientToken string `json:"client_token"`
+		EntityId    string `json:"entity_id"`
+	} `json:"auth"`
+}
+
+type vaultEntityResponse struct {
+	Data struct {
+		Name string `json:"name"`
+	} `json:"data"`
+}
+
+// VaultVerifier.Verify logs in to Vault as userId with password.
+func (v *VaultVerifier) Verify(ctx context.Context, userId string, hashedPassword, password []byte) error {
+	_, err := v.VerifyIdentity(ctx, userId, hashedPassword, password)
+	return err
+}
+
+// VaultVerifier.VerifyIdentity logs in to Vault as userId with password and returns the name
+// of the user's entity. It implements IdentityVerifier.
+func (v *VaultVerifier) VerifyIdentity(ctx context.Context, userId string, hashedPassword, password []byte) (string, error) {
+	timeout := v.Timeout
+	if timeout <= 0 {
+		timeout = defaultVaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	mount := v.Mount
+	if mount == "" {
+		mount = defaultVaultMount
+	}
+	body, err := json.Marshal(map[string]string{"password": string(password)})
+	if err != nil {
+		return "", err
+	}
+
+	var login vaultLoginResponse
+	status, err := v.do(ctx, "POST", "/v1/auth/"+mount+"/login/"+url.PathEscape(userId), "", body, &login)
+	switch {
+	case err != nil:
+		return "", err
+	// Vault answers a wrong password or an unknown user with 400 and hides which it was.
+	case status == http.StatusBadRequest:
+		return "", ErrPasswordMismatch
+	case status != http.StatusOK:
+		return "", fmt.Errorf("auth: vault login: unexpected status %d", status)
+	case login.Auth.ClientToken == "":
+		return "", fmt.Errorf("auth: vault login: no token")
+	}
+	defer v.revoke(login.Auth.ClientToken)
+
+	if login.Auth.EntityId == "" {
+		return "", fmt.Errorf("auth: vault login: no entity")
+	}
+	var entity vaultEntityResponse
+	status, err = v.do(ctx, "GET", "/v1/identity/entity/id/"+url.PathEscape(login.Auth.EntityId), login.Auth.ClientToken, nil, &entity)
+	switch {
+	case err != nil:
+		return "", err
+	case status != http.StatusOK:
+		return "", fmt.Errorf("auth: vault entity lookup: unexpected status %d", status)
+	case entity.Data.Name == "":
+		return "", fmt.Errorf("auth: vault entity lookup: no name")
+	}
+	return entity.Data.Name, nil
+}
+
+// VaultVerifier.HealthCheck checks that Vault is initialized and unsealed. Standby nodes count
+// as healthy, since they forward logins to the active node.
+func (v *VaultVerifier) HealthCheck(ctx context.Context) error {
+	timeout := v.Timeout
+	if timeout <= 0 {
+		timeout = defaultVaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	status, err := v.do(ctx, "GET", "/v1/sys/health?standbyok=true&perfstandbyok=true", "", nil, nil)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("auth: vault health: unexpected status %d", status)
+	}
+	return nil
+}
+
+// revoke revokes token in the background, so the lease doesn't outlive the verification.
+func (v *VaultVerifier) revoke(token string) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), defaultVaultTimeout)
+		defer cancel()
+		v.do(ctx, "POST", "/v1/auth/token/revoke-self", token, nil, nil)
+	}()
+}
+
+// do sends a request to Vault and decodes a successful response into out.
+// Errors never include the request body, which may hold the password.
+func (v *VaultVerifier) do(ctx context.Context, method, path, token string, body []byte, out interface{}) (int, error) {
+	req, err := http.NewRequest(method, strings.TrimSuffix(v.Addr, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("auth: vault: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if v.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.Namespace)
+	}
+
+	client := v.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, fmt.Errorf("auth: vault: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK && out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return 0, fmt.Errorf("auth: vault: decoding response: %w", err)
+		}
+	}
+	return resp.StatusCode, nil
+}
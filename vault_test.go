@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/codegangsta/negroni"
+)
+
+func newTestVault(revoked chan<- string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth/userpass/login/foo", "/v1/auth/userpass/login/down":
+			if r.URL.Path == "/v1/auth/userpass/login/down" {
+				http.Error(w, "sealed", http.StatusServiceUnavailable)
+				return
+			}
+			var body struct{ Password string }
+			json.NewDecoder(r.Body).Decode(&body)
+			if body.Password != "bar" {
+				http.Error(w, `{"errors":["invalid username or password"]}`, http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`{"auth":{"client_token":"t0ken","entity_id":"e1"}}`))
+		case "/v1/identity/entity/id/e1":
+			if r.Header.Get("X-Vault-Token") != "t0ken" {
+				http.Error(w, "permission denied", http.StatusForbidden)
+				return
+			}
+			w.Write([]byte(`{"data":{"name":"alice"}}`))
+		case "/v1/auth/token/revoke-self":
+			revoked <- r.Header.Get("X-Vault-Token")
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, `{"errors":["invalid username or password"]}`, http.StatusBadRequest)
+		}
+	}))
+}
+
+func Test_VaultVerifier(t *testing.T) {
+	revoked := make(chan string, 10)
+	srv := newTestVault(revoked)
+	defer srv.Close()
+
+	m := negroni.New(NewBasicWithOptions(nil, Options{Verifier: NewVaultVerifier(srv.URL)}))
+	m.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userId, _ := UserIdFromContext(r.Context())
+		w.Write([]byte("hello " + userId))
+	}))
+
+	var vaulttests = []struct {
+		userId   string
+		password string
+		code     int
+		body     string
+	}{
+		{"foo", "bar", http.StatusOK, "hello alice"},
+		{"foo", "baz", http.StatusUnauthorized, "Not Authorized\n"},
+		{"unknown", "bar", http.StatusUnauthorized, "Not Authorized\n"},
+		{"down", "bar", http.StatusServiceUnavailable, "Service Unavailable\n"},
+	}
+	for _, tt := range vaulttests {
+		r, _ := http.NewRequest("GET", "foo", nil)
+		r.SetBasicAuth(tt.userId, tt.password)
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, r)
+
+		if recorder.Code != tt.code || recorder.Body.String() != tt.body {
+			t.Errorf("Expected %d %q for %s:%s, got %d %q", tt.code, tt.body, tt.userId, tt.password, recorder.Code, recorder.Body.String())
+		}
+	}
+
+	select {
+	case token := <-revoked:
+		if token != "t0ken" {
+			t.Errorf("Expected the login token to be revoked, got %q", token)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected the login token to be revoked")
+	}
+}
+
+func Test_CachedBasicIdentity(t *testing.T) {
+	revoked := make(chan string, 10)
+	srv := newTestVault(revoked)
+	defer srv.Close()
+
+	b, err := NewCachedBasic(nil, time.Minute, 0, Options{
+		Verifier:     NewVaultVerifier(srv.URL),
+		UserIdHeader: "X-User",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	m := negroni.New(b)
+
+	// The cache hit must return the resolved identity too.
+	for i := 0; i < 2; i++ {
+		r, _ := http.NewRequest("GET", "foo", nil)
+		r.SetBasicAuth("foo", "bar")
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, r)
+
+		if got := recorder.Header().Get("X-User"); got != "alice" {
+			t.Errorf("Expected userid alice on request %d, got %q", i, got)
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	if n := len(revoked); n != 1 {
+		t.Errorf("Expected Vault to be asked once, got %d logins", n)
+	}
+}
@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// ErrPasswordMismatch is returned by a PasswordVerifier when the password is not correct.
+	ErrPasswordMismatch = errors.New("auth: password mismatch")
+
+	// ErrMalformedHash is returned by a PasswordVerifier when the stored hash can't be parsed
+	// or uses an unsupported scheme. It indicates a data store problem rather than a wrong password.
+	ErrMalformedHash = errors.New("auth: malformed stored hash")
+)
+
+// PasswordVerifier is an interface for checking a password of userId against
+// hashedPassword retrieved from the data store. With Options.Tenant set, userId is
+// qualified with the tenant of the request as "tenant:userid", even for tenant-aware stores.
+// Verify returns ErrPasswordMismatch (possibly wrapped) when the password must be
+// rejected. Any other error is treated as a backend failure.
+type PasswordVerifier interface {
+	Verify(ctx context.Context, userId string, hashedPassword, password []byte) error
+}
+
+// IdentityVerifier is an optional interface for password verifiers which resolve the identity
+// of the user, e.g. a canonical account name held by an identity provider. A non-empty
+// identity replaces the userid of the credential as the authenticated userid.
+type IdentityVerifier interface {
+	PasswordVerifier
+	VerifyIdentity(ctx context.Context, userId string, hashedPassword, password []byte) (identity string, err error)
+}
+
+// BcryptVerifier is a PasswordVerifier for bcrypt hashed passwords.
+// This is the default verifier.
+type BcryptVerifier struct{}
+
+// BcryptVerifier.Verify compares password with bcrypt hashedPassword.
+// Hashes with the "$2a$", "$2b$" and "$2y$" prefixes of different tools are all accepted.
+func (BcryptVerifier) Verify(ctx context.Context, userId string, hashedPassword, password []byte) error {
+	if err := bcrypt.CompareHashAndPassword(normalizeBcrypt(hashedPassword), password); err != nil {
+		return ErrPasswordMismatch
+	}
+	return nil
+}
+
+// normalizeBcrypt returns hashedPassword with a "$2b$" or "$2y$" prefix rewritten to "$2a$".
+// The three variants denote the same algorithm; they only differ in the bugs of the
+// implementations which produced hashes before them. "$2x$" hashes of the buggy
+// crypt_blowfish are left alone, so they keep failing.
+func normalizeBcrypt(hashedPassword []byte) []byte {
+	if len(hashedPassword) < 4 || hashedPassword[0] != '$' || hashedPassword[1] != '2' || hashedPassword[3] != '$' {
+		return hashedPassword
+	}
+	if hashedPassword[2] != 'b' && hashedPassword[2] != 'y' {
+		return hashedPassword
+	}
+
+	normalized := append([]byte(nil), hashedPassword...)
+	normalized[2] = 'a'
+	return normalized
+}
+
+// MultiVerifier is a PasswordVerifier for data stores mixing hash algorithms, e.g. during a
+// migration. Each stored hash is prefixed with a tag naming its algorithm and the Delimiter,
+// e.g. "bcrypt$$2a$12$...", and verified without the tag by the verifier registered for the tag.
+// Unknown tags are reported as ErrMalformedHash. Hashes without a tag, e.g. the dummy bcrypt hash
+// verified for unknown userids, are verified by Untagged.
+type MultiVerifier struct {
+	Verifiers map[string]PasswordVerifier
+	// Delimiter ends the tag; "$" if empty.
+	Delimiter string
+	// Untagged verifies hashes without a tag; BcryptVerifier if nil.
+	Untagged PasswordVerifier
+}
+
+// NewMultiVerifier returns *MultiVerifier dispatching to verifiers by tag, e.g.
+// {"bcrypt": BcryptVerifier{}, "ldap": LDAPVerifier{}}.
+func NewMultiVerifier(verifiers map[string]PasswordVerifier) *MultiVerifier {
+	v := &MultiVerifier{Verifiers: make(map[string]PasswordVerifier, len(verifiers))}
+	for tag, verifier := range verifiers {
+		v.Verifiers[tag] = verifier
+	}
+	return v
+}
+
+// MultiVerifier.Verify verifies password with the verifier registered for the tag of hashedPassword.
+func (v *MultiVerifier) Verify(ctx context.Context, userId string, hashedPassword, password []byte) error {
+	delimiter := v.Delimiter
+	if delimiter == "" {
+		delimiter = "$"
+	}
+
+	i := bytes.Index(hashedPassword, []byte(delimiter))
+	if i <= 0 {
+		untagged := v.Untagged
+		if untagged == nil {
+			untagged = BcryptVerifier{}
+		}
+		return untagged.Verify(ctx, userId, hashedPassword, password)
+	}
+
+	tag := string(hashedPassword[:i])
+	verifier, ok := v.Verifiers[tag]
+	if !ok {
+		return fmt.Errorf("%w: unknown algorithm tag %q", ErrMalformedHash, tag)
+	}
+	return verifier.Verify(ctx, userId, hashedPassword[i+len(delimiter):], password)
+}
@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// Hashes of "bar" with the same salt and cost, as written by different tools.
+var bcryptprefixtests = []struct {
+	hash     string
+	password string
+	err      error
+}{
+	{"$2a$04$abcdefghijklmnopqrstuuEnktNu4dNa/sMz6nY.lAbxawdTTajT2", "bar", nil},
+	{"$2b$04$abcdefghijklmnopqrstuuEnktNu4dNa/sMz6nY.lAbxawdTTajT2", "bar", nil},
+	{"$2y$04$abcdefghijklmnopqrstuuEnktNu4dNa/sMz6nY.lAbxawdTTajT2", "bar", nil},
+	{"$2a$04$abcdefghijklmnopqrstuuEnktNu4dNa/sMz6nY.lAbxawdTTajT2", "baz", ErrPasswordMismatch},
+	{"$2b$04$abcdefghijklmnopqrstuuEnktNu4dNa/sMz6nY.lAbxawdTTajT2", "baz", ErrPasswordMismatch},
+	{"$2y$04$abcdefghijklmnopqrstuuEnktNu4dNa/sMz6nY.lAbxawdTTajT2", "baz", ErrPasswordMismatch},
+}
+
+func Test_BcryptVerifierPrefixes(t *testing.T) {
+	for _, tt := range bcryptprefixtests {
+		err := BcryptVerifier{}.Verify(context.Background(), "foo", []byte(tt.hash), []byte(tt.password))
+		if !errors.Is(err, tt.err) || (tt.err == nil && err != nil) {
+			t.Errorf("Expected %v for %s with %s, got %v", tt.err, tt.hash[:4], tt.password, err)
+		}
+	}
+}
+
+var normalizebcrypttests = []struct {
+	hash string
+	want string
+}{
+	{"$2a$04$abc", "$2a$04$abc"},
+	{"$2b$04$abc", "$2a$04$abc"},
+	{"$2y$04$abc", "$2a$04$abc"},
+	{"$2x$04$abc", "$2x$04$abc"},
+	{"$2$04$abc", "$2$04$abc"},
+	{"{SSHA}abc", "{SSHA}abc"},
+	{"", ""},
+}
+
+func Test_NormalizeBcrypt(t *testing.T) {
+	for _, tt := range normalizebcrypttests {
+		hash := []byte(tt.hash)
+		if got := string(normalizeBcrypt(hash)); got != tt.want {
+			t.Errorf("Expected %q for %q, got %q", tt.want, tt.hash, got)
+		}
+		// The stored hash isn't modified.
+		if string(hash) != tt.hash {
+			t.Errorf("Stored hash %q was modified", tt.hash)
+		}
+	}
+}
+
+func Test_MultiVerifier(t *testing.T) {
+	const bcryptBar = "$2a$04$abcdefghijklmnopqrstuuEnktNu4dNa/sMz6nY.lAbxawdTTajT2"
+	hmacKey := []byte("server-key")
+	v := NewMultiVerifier(map[string]PasswordVerifier{
+		"bcrypt": BcryptVerifier{},
+		"hmac":   HMACVerifier{Key: hmacKey},
+	})
+
+	var multiverifiertests = []struct {
+		delimiter string
+		hash      string
+		password  string
+		err       error
+	}{
+		{"", "bcrypt$" + bcryptBar, "bar", nil},
+		{"", "bcrypt$" + bcryptBar, "baz", ErrPasswordMismatch},
+		{"", "hmac$" + HMACSecret(hmacKey, "bar"), "bar", nil},
+		{"", "hmac$" + HMACSecret(hmacKey, "bar"), "baz", ErrPasswordMismatch},
+		{"", "argon2$abc", "bar", ErrMalformedHash},
+		// Untagged hashes are bcrypt.
+		{"", bcryptBar, "bar", nil},
+		{":", "hmac:" + HMACSecret(hmacKey, "bar"), "bar", nil},
+		{":", "argon2:abc", "bar", ErrMalformedHash},
+		{":", bcryptBar, "bar", nil},
+	}
+	for _, tt := range multiverifiertests {
+		v.Delimiter = tt.delimiter
+		err := v.Verify(context.Background(), "foo", []byte(tt.hash), []byte(tt.password))
+		if !errors.Is(err, tt.err) || (tt.err == nil && err != nil) {
+			t.Errorf("Expected %v for %.10s with %s, got %v", tt.err, tt.hash, tt.password, err)
+		}
+	}
+}